@@ -59,6 +59,9 @@ var DbName = "opensolar.db"
 // OpenSolarIssuerDir is the directory where project escrow seeds are stored
 var OpenSolarIssuerDir = ""
 
+// TariffDir is the directory where tariff schedules used to bill recipients are stored
+var TariffDir = ""
+
 // PlatformSeedFile is the location where PlatformSeedFile is stored and decrypted each time the platform is started
 var PlatformSeedFile string
 
@@ -125,8 +128,9 @@ var LoginRefreshInterval = time.Duration(5 * 60 * time.Second)
 // ProjectReportThreshold is the threshold above which admins are allowed to flag the project
 var ProjectReportThreshold = 10
 
-// TellerListenNum is the number of messages the mqtt client will listen for from the broker
-var TellerListenNum = 100
+// TellerListenNum is the number of messages the mqtt client will listen for from the broker. Zero keeps the platform
+// listening to a project's teller for as long as it runs
+var TellerListenNum = 0
 
 // TellerQos is the quality of service that the mqtt client must expect. Set to 0 (worst). Goes up until 2
 var TellerQos = 0
@@ -137,6 +141,7 @@ func SetTnConsts() {
 	DbDir = HomeDir + "/database/"                   // the directory where the database is stored (project info, user info, etc)
 	OpenSolarIssuerDir = HomeDir + "/projects/"      // the directory where we store opensolar projects' issuer seeds
 	PlatformSeedFile = HomeDir + "/platformseed.hex" // where the platform's seed is stored
+	TariffDir = HomeDir + "/tariffs/"                // the directory where we store utility tariff schedules
 }
 
 // SetMnConsts sets constants that are relevant for staring opensolar on mainnet // THIS IS UNUSED
@@ -145,4 +150,5 @@ func SetMnConsts() {
 	DbDir = HomeDir + "/database/"                   // the directory where the database is stored (project info, user info, etc)
 	OpenSolarIssuerDir = HomeDir + "/projects/"      // the directory where we store opensolar projects' issuer seeds
 	PlatformSeedFile = HomeDir + "/platformseed.hex" // where the platform's seed is stored
+	TariffDir = HomeDir + "/tariffs/"                // the directory where we store utility tariff schedules
 }
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// the amortization types supported by the platform. A project with no amortization type is amortized using level payments
+const (
+	// LevelPayment amortizes the loan with equal payments (principal + interest) each period
+	LevelPayment = "level"
+	// StraightLine amortizes the loan with equal principal payments each period and interest on the outstanding balance
+	StraightLine = "straight"
+	// Balloon amortizes part of the loan with level payments and pays the rest (BalloonPct) with the final installment
+	Balloon = "balloon"
+)
+
+// Installment is a single period in a project's amortization schedule
+type Installment struct {
+	// Period is the number of the period, starting at 1
+	Period int
+
+	// DueDate is the unix time at which the installment is due
+	DueDate int64
+
+	// Payment is the total amount due for this period
+	Payment float64
+
+	// Principal is the principal component of the payment
+	Principal float64
+
+	// Interest is the interest component of the payment
+	Interest float64
+
+	// Balance is the outstanding principal after this installment has been paid
+	Balance float64
+
+	// PrincipalPaid is the amount of principal that has been paid towards this installment
+	PrincipalPaid float64
+
+	// InterestPaid is the amount of interest that has been paid towards this installment
+	InterestPaid float64
+}
+
+// AmortizationParams are the parameters used to generate an amortization schedule
+type AmortizationParams struct {
+	// Type is one of LevelPayment, StraightLine or Balloon
+	Type string
+
+	// Principal is the amount borrowed
+	Principal float64
+
+	// Rate is the interest rate per period
+	Rate float64
+
+	// Periods is the total number of periods including grace periods
+	Periods int
+
+	// GracePeriods is the number of initial interest only periods
+	GracePeriods int
+
+	// BalloonPct is the percentage of the principal paid with the final installment of a Balloon schedule
+	BalloonPct float64
+
+	// Start is the unix time from which the first period starts
+	Start int64
+
+	// Interval is the length of a period in seconds
+	Interval int64
+}
+
+// GenerateSchedule generates an amortization schedule for the given parameters
+func GenerateSchedule(params AmortizationParams) ([]Installment, error) {
+	var schedule []Installment
+
+	if params.Principal <= 0 {
+		return schedule, errors.New("principal must be positive, quitting")
+	}
+	if params.Rate < 0 {
+		return schedule, errors.New("interest rate can't be negative, quitting")
+	}
+	if params.Periods <= 0 || params.GracePeriods < 0 || params.GracePeriods >= params.Periods {
+		return schedule, errors.New("number of periods must be greater than the number of grace periods, quitting")
+	}
+	if params.BalloonPct < 0 || params.BalloonPct > 1 {
+		return schedule, errors.New("balloon percentage must be between 0 and 1, quitting")
+	}
+
+	balance := params.Principal
+	amortizing := params.Periods - params.GracePeriods
+
+	var levelPayment float64
+	var balloon float64
+	switch params.Type {
+	case LevelPayment, "":
+		levelPayment = annuity(params.Principal, params.Rate, amortizing)
+	case StraightLine:
+	case Balloon:
+		balloon = params.Principal * params.BalloonPct
+		levelPayment = annuity(params.Principal-balloon, params.Rate, amortizing)
+	default:
+		return schedule, errors.New("unknown amortization type: " + params.Type)
+	}
+
+	for i := 1; i <= params.Periods; i++ {
+		var x Installment
+		x.Period = i
+		x.DueDate = params.Start + int64(i)*params.Interval
+		x.Interest = balance * params.Rate
+
+		if i > params.GracePeriods {
+			switch params.Type {
+			case StraightLine:
+				x.Principal = params.Principal / float64(amortizing)
+			case Balloon:
+				// interest on the balloon is paid every period, the balloon itself at the end
+				x.Principal = levelPayment - (balance-balloon)*params.Rate
+			default:
+				x.Principal = levelPayment - x.Interest
+			}
+		}
+
+		if i == params.Periods {
+			// clear rounding errors and the balloon with the final payment
+			x.Principal = balance
+		}
+
+		balance -= x.Principal
+		x.Balance = math.Max(balance, 0)
+		x.Payment = x.Principal + x.Interest
+		schedule = append(schedule, x)
+	}
+
+	return schedule, nil
+}
+
+// annuity returns the level payment that amortizes principal over n periods at a given rate
+func annuity(principal float64, rate float64, n int) float64 {
+	if rate == 0 {
+		return principal / float64(n)
+	}
+	return principal * rate / (1 - math.Pow(1+rate, -float64(n)))
+}
+
+// amortizationParams returns the amortization parameters of a project. The loan is the amount carried in
+// BalLeft once the project is funded, amortized over EstimatedAcquisition years paid back every PaybackPeriod weeks
+func (a Project) amortizationParams(start int64) AmortizationParams {
+	var params AmortizationParams
+
+	weeks := int(a.PaybackPeriod)
+	if weeks <= 0 {
+		weeks = 4 // monthly paybacks if the payback period isn't set
+	}
+
+	var rate float64
+	if a.InterestRate != 0 {
+		rate = a.InterestRate
+	} else {
+		rate = 0.05 // 5 % interest rate if rate not defined
+	}
+
+	params.Type = a.AmortizationType
+	params.Principal = a.TotalValue + a.SeedMoneyRaised
+	params.Rate = rate * float64(weeks) / 52
+	params.Periods = a.EstimatedAcquisition * 52 / weeks
+	params.GracePeriods = a.GracePeriods
+	params.BalloonPct = a.BalloonPct
+	params.Start = start
+	params.Interval = int64(weeks) * 604800
+	return params
+}
+
+// GenerateSchedule generates the amortization schedule of a project with the first period starting at start
+func (a Project) GenerateSchedule(start int64) ([]Installment, error) {
+	return GenerateSchedule(a.amortizationParams(start))
+}
+
+// SplitPayback splits a payback into the amount going towards interest and principal. Payments go
+// towards outstanding interest first, then outstanding principal of the earliest unpaid installments.
+// Amounts paid over the schedule are treated as principal.
+func (a Project) SplitPayback(amount float64) (float64, float64) {
+	var principal, interest float64
+	for _, x := range a.Schedule {
+		if amount <= 0 {
+			break
+		}
+		interestDue := math.Max(x.Interest-x.InterestPaid, 0)
+		paid := math.Min(amount, interestDue)
+		interest += paid
+		amount -= paid
+
+		principalDue := math.Max(x.Principal-x.PrincipalPaid, 0)
+		paid = math.Min(amount, principalDue)
+		principal += paid
+		amount -= paid
+	}
+	return principal + amount, interest
+}
+
+// overdue returns the principal and interest of the installments due at or before now that haven't been paid
+func (a Project) overdue(now int64) float64 {
+	var amount float64
+	for _, x := range a.Schedule {
+		if x.DueDate > now {
+			break
+		}
+		amount += math.Max(x.Interest-x.InterestPaid, 0) + math.Max(x.Principal-x.PrincipalPaid, 0)
+	}
+	return amount
+}
+
+// applyToSchedule marks principal and interest as paid against the earliest unpaid installments
+func (a *Project) applyToSchedule(principal float64, interest float64) {
+	for i := range a.Schedule {
+		x := &a.Schedule[i]
+		paid := math.Min(interest, math.Max(x.Interest-x.InterestPaid, 0))
+		x.InterestPaid += paid
+		interest -= paid
+
+		paid = math.Min(principal, math.Max(x.Principal-x.PrincipalPaid, 0))
+		x.PrincipalPaid += paid
+		principal -= paid
+	}
+}
@@ -0,0 +1,89 @@
+// +build all travis
+
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGenerateSchedule(t *testing.T) {
+	var params AmortizationParams
+	params.Principal = 1200
+	params.Rate = 0.01
+	params.Periods = 12
+	params.Interval = 10
+
+	for _, x := range []string{LevelPayment, StraightLine, Balloon} {
+		params.Type = x
+		params.GracePeriods = 2
+		params.BalloonPct = 0.5
+		schedule, err := GenerateSchedule(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(schedule) != 12 {
+			t.Fatalf("%s: schedule has %d periods, expected 12", x, len(schedule))
+		}
+
+		var principal float64
+		for i, installment := range schedule {
+			if i < 2 && installment.Principal != 0 {
+				t.Fatalf("%s: principal paid during grace period", x)
+			}
+			if math.Abs(installment.Payment-installment.Principal-installment.Interest) > 1e-9 {
+				t.Fatalf("%s: payment isn't the sum of principal and interest", x)
+			}
+			principal += installment.Principal
+		}
+		if math.Abs(principal-1200) > 1e-6 || schedule[11].Balance != 0 {
+			t.Fatalf("%s: schedule doesn't pay off the principal, paid: %f", x, principal)
+		}
+		if schedule[11].DueDate != 120 {
+			t.Fatalf("%s: due date of the final installment doesn't match", x)
+		}
+	}
+
+	params.Type = LevelPayment
+	params.GracePeriods = 0
+	schedule, _ := GenerateSchedule(params)
+	if math.Abs(schedule[0].Payment-schedule[5].Payment) > 1e-9 {
+		t.Fatalf("level payments aren't level")
+	}
+
+	params.GracePeriods = 12
+	_, err := GenerateSchedule(params)
+	if err == nil {
+		t.Fatalf("schedule with only grace periods accepted")
+	}
+
+	params.GracePeriods = 0
+	params.Type = "blah"
+	_, err = GenerateSchedule(params)
+	if err == nil {
+		t.Fatalf("invalid amortization type accepted")
+	}
+}
+
+func TestSplitPayback(t *testing.T) {
+	var project Project
+	project.Schedule = []Installment{
+		{Period: 1, Principal: 90, Interest: 10},
+		{Period: 2, Principal: 95, Interest: 5},
+	}
+
+	principal, interest := project.SplitPayback(50)
+	if principal != 40 || interest != 10 {
+		t.Fatalf("split doesn't pay interest first, got %f %f", principal, interest)
+	}
+
+	principal, interest = project.SplitPayback(250)
+	if principal != 235 || interest != 15 {
+		t.Fatalf("overpayment not treated as principal, got %f %f", principal, interest)
+	}
+
+	project.applyToSchedule(90, 12)
+	if project.Schedule[0].PrincipalPaid != 90 || project.Schedule[1].InterestPaid != 2 {
+		t.Fatalf("payment not applied to the earliest installments")
+	}
+}
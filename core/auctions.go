@@ -1,10 +1,835 @@
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
 	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// contractor auctions are procurement auctions: the recipient buys the installation of a project from
+// contractors, so the lowest price wins and the reserve is the highest price the recipient is willing to pay.
+// Blind and vickrey auctions are sealed: contractors commit to a hash of their price during the bidding window
+// and reveal it during the reveal window. English auctions are open: contractors underbid the best price in
+// rounds until a round passes without a new bid. Dutch auctions run a clock: the price offered starts low and
+// rises every round until a contractor accepts it.
+const (
+	// AuctionBlind is a sealed first price auction, the winner is paid their bid
+	AuctionBlind = "blind"
+	// AuctionVickrey is a sealed second price auction, the winner is paid the second lowest bid
+	AuctionVickrey = "vickrey"
+	// AuctionEnglish is an open auction with descending bids, the winner is paid their bid
+	AuctionEnglish = "english"
+	// AuctionDutch is a clock auction with an ascending price, the first contractor to accept is paid the price
+	AuctionDutch = "dutch"
 )
 
-// SelectContractBlind selects the winning bid based on blind auction rules (in a blind auction, the bid with the highest price wins)
+// the states an auction can be in
+const (
+	// AuctionOpen is an auction that hasn't been closed yet
+	AuctionOpen = "open"
+	// AuctionClosed is an auction that has been closed with a winner
+	AuctionClosed = "closed"
+	// AuctionFailed is an auction that has been closed without a valid bid
+	AuctionFailed = "failed"
+)
+
+// AuctionCheckInterval is the interval in seconds at which the scheduler tries to close an auction that
+// has run past its end
+var AuctionCheckInterval = int64(60)
+
+// Auction is an auction among contractors for the installation of a project
+type Auction struct {
+	// Index is the index of the auction in the auctions bucket
+	Index int
+
+	// ProjIndex is the index of the project being auctioned
+	ProjIndex int
+
+	// Type is the type of the auction (AuctionBlind, AuctionVickrey, AuctionEnglish, AuctionDutch)
+	Type string
+
+	// Reserve is the highest price the recipient is willing to pay, zero if there is no reserve
+	Reserve float64
+
+	// Window is the length of the bidding window in seconds (blind, vickrey, english)
+	Window int64
+
+	// RevealWindow is the length of the reveal window in seconds following the bidding window (blind, vickrey)
+	RevealWindow int64
+
+	// RoundLength is the length of a round in seconds. An english auction ends once a round passes without a new
+	// bid and the price of a dutch auction rises every round
+	RoundLength int64
+
+	// StartPrice is the price a dutch auction starts at
+	StartPrice float64
+
+	// Step is the minimum amount a bid must beat the best bid by (english) or the amount the price rises by
+	// every round (dutch)
+	Step float64
+
+	// Start is the unix time at which the auction was opened
+	Start int64
+
+	// BidEnd is the unix time at which bidding ends. It moves with every bid in an english auction
+	BidEnd int64
+
+	// RevealEnd is the unix time at which the reveal window ends (blind, vickrey)
+	RevealEnd int64
+
+	// Status is the status of the auction (AuctionOpen, AuctionClosed, AuctionFailed)
+	Status string
+
+	// Weights are the weights bids are scored with when the auction is closed, price alone if none are set
+	Weights ScoringWeights
+
+	// Result is the result of the auction once it has been closed
+	Result ContractAuction
+}
+
+// Bid is a contractor's bid in an auction
+type Bid struct {
+	// Index is the index of the bid in the bids bucket
+	Index int
+
+	// AuctionIndex is the index of the auction the bid was placed in
+	AuctionIndex int
+
+	// ContractorIndex is the index of the contractor who placed the bid
+	ContractorIndex int
+
+	// ContractIndex is the index of the contract the contractor proposed for the project (if any)
+	ContractIndex int
+
+	// Commitment is the hash of the price and salt committed to in a sealed auction
+	Commitment string
+
+	// Price is the price bid. In sealed auctions it is only known once the bid is revealed
+	Price float64
+
+	// Salt is the salt the price was committed with, stored once the bid is revealed
+	Salt string
+
+	// Revealed is set once the price of the bid is known
+	Revealed bool
+
+	// Round is the round of an english or dutch auction the bid was placed in
+	Round int
+
+	// Timestamp is the unix time at which the bid was placed (or last committed to)
+	Timestamp int64
+}
+
+// auctionLock makes sure bids are placed and auctions closed one at a time
+var auctionLock sync.Mutex
+
+// Save saves an Auction's details
+func (a *Auction) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, AuctionsBucket, a, a.Index)
+}
+
+// Save saves a Bid's details
+func (a *Bid) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, BidsBucket, a, a.Index)
+}
+
+// RetrieveAuction retrieves a specific auction from the database
+func RetrieveAuction(key int) (Auction, error) {
+	var auction Auction
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, AuctionsBucket, key)
+	if err != nil {
+		return auction, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &auction)
+	if err != nil {
+		return auction, errors.Wrap(err, "could not unmarshal json")
+	}
+
+	if auction.Index == 0 {
+		return auction, errors.New("auction not found")
+	}
+	return auction, nil
+}
+
+// RetrieveAllAuctions retrieves all auctions from the database
+func RetrieveAllAuctions() ([]Auction, error) {
+	var arr []Auction
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, AuctionsBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Auction
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// RetrieveProjectAuctions retrieves the auctions of a project in the order they were opened
+func RetrieveProjectAuctions(projIndex int) ([]Auction, error) {
+	var arr []Auction
+	auctions, err := RetrieveAllAuctions()
+	if err != nil {
+		return arr, errors.Wrap(err, "couldn't retrieve auctions")
+	}
+
+	for _, auction := range auctions {
+		if auction.ProjIndex == projIndex {
+			arr = append(arr, auction)
+		}
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Index < arr[j].Index
+	})
+	return arr, nil
+}
+
+// RetrieveOpenAuction retrieves the open auction of a project
+func RetrieveOpenAuction(projIndex int) (Auction, error) {
+	var auction Auction
+	auctions, err := RetrieveProjectAuctions(projIndex)
+	if err != nil {
+		return auction, err
+	}
+
+	for _, x := range auctions {
+		if x.Status == AuctionOpen {
+			return x, nil
+		}
+	}
+	return auction, errors.New("project doesn't have an open auction")
+}
+
+// RetrieveAllBids retrieves all bids from the database
+func RetrieveAllBids() ([]Bid, error) {
+	var arr []Bid
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, BidsBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Bid
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// RetrieveAuctionBids retrieves the bids placed in an auction in the order they were placed
+func RetrieveAuctionBids(auctionIndex int) ([]Bid, error) {
+	var arr []Bid
+	bids, err := RetrieveAllBids()
+	if err != nil {
+		return arr, errors.Wrap(err, "couldn't retrieve bids")
+	}
+
+	for _, bid := range bids {
+		if bid.AuctionIndex == auctionIndex {
+			arr = append(arr, bid)
+		}
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Index < arr[j].Index
+	})
+	return arr, nil
+}
+
+// BidCommitment returns the commitment a contractor submits for a price in a sealed auction. The salt should
+// be random and kept secret until the bid is revealed
+func BidCommitment(price float64, salt string) string {
+	hash := sha256.Sum256([]byte(strconv.FormatFloat(price, 'f', -1, 64) + ":" + salt))
+	return hex.EncodeToString(hash[:])
+}
+
+// sealed returns whether bids in the auction are sealed
+func (a Auction) sealed() bool {
+	return a.Type == AuctionBlind || a.Type == AuctionVickrey
+}
+
+// End returns the unix time after which the auction can be closed
+func (a Auction) End() int64 {
+	if a.sealed() {
+		return a.RevealEnd
+	}
+	return a.BidEnd
+}
+
+// validate checks the parameters of an auction before it is opened
+func (a Auction) validate() error {
+	if a.Reserve < 0 {
+		return errors.New("reserve price can't be negative, quitting")
+	}
+	err := a.Weights.validate()
+	if err != nil {
+		return err
+	}
+	switch a.Type {
+	case AuctionBlind, AuctionVickrey:
+		if a.Window <= 0 || a.RevealWindow <= 0 {
+			return errors.New("sealed auctions need a bidding and reveal window, quitting")
+		}
+	case AuctionEnglish:
+		if a.Window <= 0 || a.RoundLength <= 0 || a.Step < 0 {
+			return errors.New("english auctions need a bidding window and round length, quitting")
+		}
+	case AuctionDutch:
+		if a.StartPrice <= 0 || a.Step <= 0 || a.RoundLength <= 0 {
+			return errors.New("dutch auctions need a start price, step and round length, quitting")
+		}
+		if a.Reserve < a.StartPrice {
+			return errors.New("dutch auctions need a reserve above the start price, quitting")
+		}
+		if a.Weights.total() != 0 {
+			return errors.New("dutch auctions are won by the first contractor to accept, they can't be scored, quitting")
+		}
+	default:
+		return errors.New("unknown auction type: " + a.Type)
+	}
+	return nil
+}
+
+// schedule sets the windows of an auction opened at now
+func (a *Auction) schedule(now int64) {
+	a.Start = now
+	switch a.Type {
+	case AuctionBlind, AuctionVickrey:
+		a.BidEnd = now + a.Window
+		a.RevealEnd = a.BidEnd + a.RevealWindow
+	case AuctionEnglish:
+		a.BidEnd = now + a.Window
+	case AuctionDutch:
+		// the clock runs until the price has been at the reserve for a full round
+		rounds := int64(math.Ceil((a.Reserve - a.StartPrice) / a.Step))
+		a.BidEnd = now + (rounds+1)*a.RoundLength
+	}
+}
+
+// round returns the round of an english or dutch auction at now, starting from one
+func (a Auction) round(now int64) int {
+	if a.RoundLength <= 0 || now < a.Start {
+		return 1
+	}
+	return int((now-a.Start)/a.RoundLength) + 1
+}
+
+// DutchPrice returns the price offered by a dutch auction at now
+func (a Auction) DutchPrice(now int64) float64 {
+	price := a.StartPrice + float64(a.round(now)-1)*a.Step
+	if price > a.Reserve {
+		price = a.Reserve
+	}
+	return price
+}
+
+// dutchBidPrice returns the price a bid in a dutch auction is placed at. A price of zero accepts the price
+// currently offered. Contractors can also accept the price offered in the previous round, since the offer can
+// move on between the contractor seeing it and the bid reaching the platform
+func (a Auction) dutchBidPrice(price float64, now int64) (float64, error) {
+	current := a.DutchPrice(now)
+	if price == 0 || math.Abs(price-current) < 1e-7 { // rounding errors in the price offered
+		return current, nil
+	}
+	if a.round(now) > 1 && math.Abs(price-a.DutchPrice(now-a.RoundLength)) < 1e-7 {
+		return price, nil
+	}
+	return 0, errors.New("price doesn't match the price currently offered, quitting")
+}
+
+// bestBid returns the lowest revealed bid, the earliest bid winning ties
+func bestBid(bids []Bid) (Bid, bool) {
+	var best Bid
+	found := false
+	for _, bid := range bids {
+		if !bid.Revealed {
+			continue
+		}
+		if !found || bid.Price < best.Price || (bid.Price == best.Price && bid.Timestamp < best.Timestamp) {
+			best = bid
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Settle returns the result of an auction given its bids and the raw scoring criteria of each bid (keyed by bid
+// index). Unrevealed bids and bids above the reserve are ignored and only the lowest bid of each contractor
+// counts. Bids are ranked by their score under the auction's weights and the best ranked bid wins. The winner is
+// paid its price, except in a vickrey auction where it is paid the price of the runner up if that is higher (or
+// the reserve if there is no runner up)
+func (a Auction) Settle(bids []Bid, criteria map[int]BidScore, now int64) ContractAuction {
+	var result ContractAuction
+	result.AuctionIndex = a.Index
+	result.ProjIndex = a.ProjIndex
+	result.Type = a.Type
+	result.Reserve = a.Reserve
+	result.Weights = a.Weights.effective()
+	result.Bids = bids
+	result.Closed = now
+
+	lowest := make(map[int]Bid)
+	for _, bid := range bids {
+		if !bid.Revealed || (a.Reserve != 0 && bid.Price > a.Reserve) {
+			continue
+		}
+		if x, exists := lowest[bid.ContractorIndex]; !exists || bid.Price < x.Price {
+			lowest[bid.ContractorIndex] = bid
+		}
+	}
+
+	valid := make(map[int]Bid)
+	var scores []BidScore
+	for _, bid := range lowest {
+		score := criteria[bid.Index]
+		score.BidIndex = bid.Index
+		score.ContractorIndex = bid.ContractorIndex
+		score.Price = bid.Price
+		scores = append(scores, score)
+		valid[bid.Index] = bid
+	}
+	if len(scores) == 0 {
+		return result
+	}
+
+	result.Scores = ScoreBids(a.Weights, scores)
+	winner := valid[result.Scores[0].BidIndex]
+	result.WinningBid = winner
+	result.Price = winner.Price
+
+	if a.Type == AuctionVickrey {
+		if len(result.Scores) > 1 {
+			if runnerUp := valid[result.Scores[1].BidIndex]; runnerUp.Price > result.Price {
+				result.Price = runnerUp.Price
+			}
+		} else if a.Reserve != 0 {
+			result.Price = a.Reserve
+		}
+	}
+	return result
+}
+
+// VisibleBids returns the bids of an auction as they can be shown while it is open. The prices and salts of
+// sealed bids are hidden until the auction is closed so contractors can't react to revealed bids
+func (a Auction) VisibleBids(bids []Bid) []Bid {
+	if !a.sealed() || a.Status != AuctionOpen {
+		return bids
+	}
+	visible := make([]Bid, len(bids))
+	for i, bid := range bids {
+		bid.Price = 0
+		bid.Salt = ""
+		visible[i] = bid
+	}
+	return visible
+}
+
+// OpenAuction opens an auction among contractors for a project that doesn't have a contractor yet. The type,
+// reserve and timing of the auction are taken from params
+func OpenAuction(projIndex int, params Auction) (Auction, error) {
+	auction := Auction{
+		ProjIndex:    projIndex,
+		Type:         params.Type,
+		Reserve:      params.Reserve,
+		Window:       params.Window,
+		RevealWindow: params.RevealWindow,
+		RoundLength:  params.RoundLength,
+		StartPrice:   params.StartPrice,
+		Step:         params.Step,
+		Weights:      params.Weights,
+		Status:       AuctionOpen,
+	}
+
+	err := auction.validate()
+	if err != nil {
+		return auction, err
+	}
+
+	auctionLock.Lock()
+	defer auctionLock.Unlock()
+
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if project.Cancelled {
+		return auction, errors.New("project has been cancelled, quitting")
+	}
+	if project.Stage > Stage2.Number || project.ContractorIndex != 0 {
+		return auction, errors.New("project already has a contractor, quitting")
+	}
+
+	auctions, err := RetrieveAllAuctions()
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't retrieve auctions")
+	}
+	for _, x := range auctions {
+		if x.ProjIndex == projIndex && x.Status == AuctionOpen {
+			return auction, errors.New("project already has an open auction, quitting")
+		}
+	}
+
+	now := clock()
+	auction.Index = len(auctions) + 1
+	auction.schedule(now)
+	err = auction.Save()
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't save auction")
+	}
+
+	err = project.SetAuctionType(auction.Type)
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't set auction type")
+	}
+
+	_, err = ScheduleJob(JobAuctionClose, projIndex, auction.End()-now, AuctionCheckInterval)
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't schedule closing the auction")
+	}
+
+	return auction, nil
+}
+
+// retrieveBidder retrieves an open auction and checks that the contractor can bid in it
+func retrieveBidder(auctionIndex int, contractorIndex int, contractIndex int) (Auction, error) {
+	auction, err := RetrieveAuction(auctionIndex)
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't retrieve auction")
+	}
+	if auction.Status != AuctionOpen {
+		return auction, errors.New("auction has been closed, quitting")
+	}
+
+	contractor, err := RetrieveEntity(contractorIndex)
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't retrieve contractor")
+	}
+	if !contractor.Contractor {
+		return auction, errors.New("only contractors can bid in auctions, quitting")
+	}
+
+	if contractIndex != 0 {
+		contract, err := RetrieveProject(contractIndex)
+		if err != nil {
+			return auction, errors.Wrap(err, "couldn't retrieve contract")
+		}
+		if contract.ContractorIndex != contractorIndex {
+			return auction, errors.New("contract wasn't proposed by the contractor, quitting")
+		}
+	}
+	return auction, nil
+}
+
+// contractorBid returns the bid of a contractor in an auction along with the index a new bid should be saved at
+func contractorBid(auctionIndex int, contractorIndex int) (Bid, bool, error) {
+	var bid Bid
+	bids, err := RetrieveAllBids()
+	if err != nil {
+		return bid, false, errors.Wrap(err, "couldn't retrieve bids")
+	}
+
+	for _, x := range bids {
+		if x.AuctionIndex == auctionIndex && x.ContractorIndex == contractorIndex {
+			return x, true, nil
+		}
+	}
+	bid.Index = len(bids) + 1
+	return bid, false, nil
+}
+
+// CommitBid commits a contractor to a price in a sealed auction. The commitment is the BidCommitment of the
+// price and a salt the contractor keeps until the reveal window. Committing again replaces the commitment
+func CommitBid(auctionIndex int, contractorIndex int, contractIndex int, commitment string) (Bid, error) {
+	var bid Bid
+	if commitment == "" {
+		return bid, errors.New("commitment can't be empty, quitting")
+	}
+
+	auctionLock.Lock()
+	defer auctionLock.Unlock()
+
+	auction, err := retrieveBidder(auctionIndex, contractorIndex, contractIndex)
+	if err != nil {
+		return bid, err
+	}
+	if !auction.sealed() {
+		return bid, errors.New("bids can only be committed to in sealed auctions, quitting")
+	}
+
+	now := clock()
+	if now >= auction.BidEnd {
+		return bid, errors.New("bidding window has ended, quitting")
+	}
+
+	bid, _, err = contractorBid(auctionIndex, contractorIndex)
+	if err != nil {
+		return bid, err
+	}
+
+	bid.AuctionIndex = auctionIndex
+	bid.ContractorIndex = contractorIndex
+	bid.ContractIndex = contractIndex
+	bid.Commitment = commitment
+	bid.Timestamp = now
+	return bid, bid.Save()
+}
+
+// RevealBid reveals the price a contractor committed to in a sealed auction during the reveal window
+func RevealBid(auctionIndex int, contractorIndex int, price float64, salt string) (Bid, error) {
+	auctionLock.Lock()
+	defer auctionLock.Unlock()
+
+	bid, found, err := contractorBid(auctionIndex, contractorIndex)
+	if err != nil {
+		return bid, err
+	}
+	if !found {
+		return bid, errors.New("contractor hasn't committed to a bid, quitting")
+	}
+
+	auction, err := retrieveBidder(auctionIndex, contractorIndex, bid.ContractIndex)
+	if err != nil {
+		return bid, err
+	}
+
+	now := clock()
+	if now < auction.BidEnd || now >= auction.RevealEnd {
+		return bid, errors.New("auction isn't in its reveal window, quitting")
+	}
+
+	if BidCommitment(price, salt) != bid.Commitment {
+		return bid, errors.New("price and salt don't match the commitment, quitting")
+	}
+
+	bid.Price = price
+	bid.Salt = salt
+	bid.Revealed = true
+	return bid, bid.Save()
+}
+
+// PlaceBid places an open bid in an english or dutch auction. In an english auction the price has to beat the
+// best bid by the auction's step and extends bidding by a round. In a dutch auction the price has to be the
+// price currently offered (a price of zero accepts whatever is offered), and accepting it closes the auction
+func PlaceBid(auctionIndex int, contractorIndex int, contractIndex int, price float64) (Bid, error) {
+	var bid Bid
+	if price < 0 {
+		return bid, errors.New("price can't be negative, quitting")
+	}
+
+	auctionLock.Lock()
+	defer auctionLock.Unlock()
+
+	auction, err := retrieveBidder(auctionIndex, contractorIndex, contractIndex)
+	if err != nil {
+		return bid, err
+	}
+
+	now := clock()
+	if now >= auction.BidEnd {
+		return bid, errors.New("bidding has ended, quitting")
+	}
+
+	bids, err := RetrieveAllBids()
+	if err != nil {
+		return bid, errors.Wrap(err, "couldn't retrieve bids")
+	}
+
+	var auctionBids []Bid
+	for _, x := range bids {
+		if x.AuctionIndex == auctionIndex {
+			auctionBids = append(auctionBids, x)
+		}
+	}
+
+	switch auction.Type {
+	case AuctionEnglish:
+		if price == 0 {
+			return bid, errors.New("price must be positive, quitting")
+		}
+		if auction.Reserve != 0 && price > auction.Reserve {
+			return bid, errors.New("price is above the reserve, quitting")
+		}
+		if best, found := bestBid(auctionBids); found && price > best.Price-auction.Step {
+			return bid, errors.New("price doesn't beat the best bid by the auction's step, quitting")
+		}
+		bid.Round = len(auctionBids) + 1
+	case AuctionDutch:
+		price, err = auction.dutchBidPrice(price, now)
+		if err != nil {
+			return bid, err
+		}
+		bid.Round = auction.round(now)
+	default:
+		return bid, errors.New("bids in sealed auctions have to be committed to, quitting")
+	}
+
+	bid.Index = len(bids) + 1
+	bid.AuctionIndex = auctionIndex
+	bid.ContractorIndex = contractorIndex
+	bid.ContractIndex = contractIndex
+	bid.Price = price
+	bid.Revealed = true
+	bid.Timestamp = now
+	err = bid.Save()
+	if err != nil {
+		return bid, errors.Wrap(err, "couldn't save bid")
+	}
+
+	if auction.Type == AuctionDutch {
+		_, err = closeAuction(auction, append(auctionBids, bid), now)
+		return bid, err
+	}
+
+	if end := now + auction.RoundLength; end > auction.BidEnd {
+		auction.BidEnd = end
+		err = auction.Save()
+		if err != nil {
+			return bid, errors.Wrap(err, "couldn't save auction")
+		}
+	}
+	return bid, nil
+}
+
+// CloseAuction closes an auction that has run past its end. The winning contractor becomes the project's
+// contractor and is paid the auction's price
+func CloseAuction(auctionIndex int) (Auction, error) {
+	auctionLock.Lock()
+	defer auctionLock.Unlock()
+
+	auction, err := RetrieveAuction(auctionIndex)
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't retrieve auction")
+	}
+	if auction.Status != AuctionOpen {
+		return auction, errors.New("auction has already been closed, quitting")
+	}
+
+	now := clock()
+	if now < auction.End() {
+		return auction, errors.New("auction hasn't ended yet, quitting")
+	}
+
+	bids, err := RetrieveAuctionBids(auctionIndex)
+	if err != nil {
+		return auction, errors.Wrap(err, "couldn't retrieve bids")
+	}
+
+	return closeAuction(auction, bids, now)
+}
+
+// closeAuction settles an auction and records its result. The lock must be held
+func closeAuction(auction Auction, bids []Bid, now int64) (Auction, error) {
+	var result ContractAuction
+	contracts := make(map[int]Project)
+	criteria := make(map[int]BidScore)
+	for _, bid := range bids {
+		var score BidScore
+		contractor, err := RetrieveEntity(bid.ContractorIndex)
+		if err != nil {
+			log.Println("couldn't retrieve bidder: ", bid.ContractorIndex, err)
+			continue
+		}
+		result.AllContractors = append(result.AllContractors, contractor)
+		score.Reputation = contractor.U.Reputation
+		score.Collateral = contractor.Collateral
+		score.Performance = contractor.pastPerformance()
+
+		if bid.ContractIndex != 0 {
+			contract, err := RetrieveProject(bid.ContractIndex)
+			if err != nil {
+				log.Println("couldn't retrieve contract: ", bid.ContractIndex, err)
+			} else {
+				result.AllContracts = append(result.AllContracts, contract)
+				contracts[bid.Index] = contract
+				score.Time = float64(contract.EstimatedAcquisition)
+			}
+		}
+		criteria[bid.Index] = score
+	}
+
+	settled := auction.Settle(bids, criteria, now)
+	settled.AllContractors = result.AllContractors
+	settled.AllContracts = result.AllContracts
+	settled.WinningContract = contracts[settled.WinningBid.Index]
+	result = settled
+
+	auction.Result = result
+	auction.Status = AuctionFailed
+	if result.WinningBid.Index != 0 {
+		auction.Status = AuctionClosed
+
+		project, err := RetrieveProject(auction.ProjIndex)
+		if err != nil {
+			return auction, errors.Wrap(err, "couldn't retrieve project")
+		}
+		err = project.Update("auction closed", map[string]interface{}{
+			"ContractorIndex": result.WinningBid.ContractorIndex,
+			"ContractorFee":   result.Price,
+		})
+		if err != nil {
+			return auction, errors.Wrap(err, "couldn't update project")
+		}
+		// contractors who lost the auction get their collateral back
+		err = project.releaseCollateral(project.ContractorIndex)
+		if err != nil {
+			return auction, errors.Wrap(err, "couldn't release collateral")
+		}
+		if result.WinningContract.Index == 0 {
+			result.WinningContract = project
+			auction.Result = result
+		}
+	}
+
+	log.Println("closed auction: ", auction.Index, " for project: ", auction.ProjIndex, " status: ", auction.Status)
+	return auction, auction.Save()
+}
+
+// checkAuction closes the open auction of the project the job belongs to once it has ended
+func checkAuction(job Job) (bool, error) {
+	auctions, err := RetrieveProjectAuctions(job.ProjIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't retrieve auctions")
+	}
+
+	for _, auction := range auctions {
+		if auction.Status != AuctionOpen {
+			continue
+		}
+		if clock() < auction.End() {
+			return false, nil // english auctions are extended by bids
+		}
+		_, err = CloseAuction(auction.Index)
+		return err == nil, err
+	}
+	return true, nil
+}
+
+// SelectContractBlind selects the winning contract based on blind auction rules (in a blind auction, the contract
+// with the lowest price wins and is paid its price)
 func SelectContractBlind(arr []Project) (Project, error) {
 	var a Project
 	if len(arr) == 0 {
@@ -21,40 +846,36 @@ func SelectContractBlind(arr []Project) (Project, error) {
 	return a, nil
 }
 
-// SelectContractVickrey selects the winning bid based on vickrey auction rules (in a vickrey auction, the bid with the second highest price wins)
-func SelectContractVickrey(arr []Project) (Project, error) {
+// SelectContractVickrey selects the winning contract based on vickrey auction rules (in a vickrey auction, the
+// contract with the lowest price wins and is paid the second lowest price). The winning contract is returned
+// unchanged along with the price it is paid
+func SelectContractVickrey(arr []Project) (Project, float64, error) {
 	var winningContract Project
 	if len(arr) == 0 {
-		return winningContract, errors.New("empty array passed")
+		return winningContract, 0, errors.New("empty array passed")
 	}
 	// array is not empty, min 1 elem
-	winningContract = arr[0]
 	var pos int
+	winningContract = arr[0]
 	for i, elem := range arr {
 		if elem.TotalValue < winningContract.TotalValue {
 			winningContract = elem
 			pos = i
-			continue
 		}
 	}
-	// here we have the highest bidder. Now we need to delete this guy from the array
-	// and get the second highest bidder
-	// delete a[pos] from arr
-	arr = append(arr[:pos], arr[pos+1:]...)
-	if len(arr) == 0 {
+
+	if len(arr) == 1 {
 		// means only one contract was proposed for this project, so fall back to blind auction
-		return winningContract, nil
+		return winningContract, winningContract.TotalValue, nil
 	}
-	vickreyPrice := arr[0].TotalValue
-	for _, elem := range arr {
-		if elem.TotalValue < vickreyPrice {
+
+	vickreyPrice := math.Inf(1)
+	for i, elem := range arr {
+		if i != pos && elem.TotalValue < vickreyPrice {
 			vickreyPrice = elem.TotalValue
 		}
 	}
-	// we have the winner, who's elem and we have the price which is vickreyPrice
-	// overwrite the winning contractor's contract
-	winningContract.TotalValue = vickreyPrice
-	return winningContract, winningContract.Save()
+	return winningContract, vickreyPrice, nil
 }
 
 // SelectContractTime selects the winning contract based on the least time proposed for completion
@@ -77,16 +898,9 @@ func SelectContractTime(arr []Project) (Project, error) {
 // SetAuctionType sets the auction type of a specific project
 func (project *Project) SetAuctionType(auctionType string) error {
 	switch auctionType {
-	case "blind":
-		project.AuctionType = "blind"
-	case "vickrey":
-		project.AuctionType = "vickrey"
-	case "english":
-		project.AuctionType = "english"
-	case "dutch":
-		project.AuctionType = "dutch"
+	case AuctionBlind, AuctionVickrey, AuctionEnglish, AuctionDutch:
 	default:
-		project.AuctionType = "blind"
+		auctionType = AuctionBlind
 	}
-	return project.Save()
+	return project.Update("auction type set", map[string]interface{}{"AuctionType": auctionType})
 }
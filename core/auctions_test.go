@@ -0,0 +1,151 @@
+// +build all travis
+
+package core
+
+import (
+	"testing"
+)
+
+func TestSettleAuction(t *testing.T) {
+	bids := []Bid{
+		{Index: 1, ContractorIndex: 1, Price: 900, Revealed: true, Timestamp: 10},
+		{Index: 2, ContractorIndex: 2, Price: 800, Revealed: true, Timestamp: 11},
+		{Index: 3, ContractorIndex: 3, Price: 500, Timestamp: 12},                  // never revealed
+		{Index: 4, ContractorIndex: 4, Price: 1200, Revealed: true, Timestamp: 13}, // above the reserve
+		{Index: 5, ContractorIndex: 5, Price: 800, Revealed: true, Timestamp: 14},
+	}
+
+	var tests = []struct {
+		auction Auction
+		winner  int
+		price   float64
+	}{
+		{Auction{Type: AuctionBlind, Reserve: 1000}, 2, 800},
+		{Auction{Type: AuctionVickrey, Reserve: 1000}, 2, 800},
+		{Auction{Type: AuctionEnglish, Reserve: 1000}, 2, 800},
+		{Auction{Type: AuctionBlind}, 2, 800},
+	}
+
+	for _, test := range tests {
+		result := test.auction.Settle(bids, nil, 100)
+		if result.WinningBid.Index != test.winner || result.Price != test.price {
+			t.Fatalf("%s auction won by bid %d at %f, expected bid %d at %f", test.auction.Type,
+				result.WinningBid.Index, result.Price, test.winner, test.price)
+		}
+		if len(result.Bids) != len(bids) || result.Closed != 100 {
+			t.Fatalf("auction result doesn't record all bids")
+		}
+	}
+
+	vickrey := Auction{Type: AuctionVickrey, Reserve: 1000}
+	result := vickrey.Settle(bids[:2], nil, 100)
+	if result.WinningBid.Index != 2 || result.Price != 900 {
+		t.Fatalf("vickrey winner not paid the second lowest bid: %+v", result)
+	}
+	result = vickrey.Settle(bids[1:2], nil, 100)
+	if result.Price != 1000 {
+		t.Fatalf("single vickrey bid not paid the reserve: %f", result.Price)
+	}
+
+	result = Auction{Type: AuctionBlind, Reserve: 1000}.Settle(bids[2:4], nil, 100)
+	if result.WinningBid.Index != 0 {
+		t.Fatalf("auction without valid bids has a winner: %+v", result.WinningBid)
+	}
+}
+
+func TestAuctionWindows(t *testing.T) {
+	invalid := []Auction{
+		{Type: "blah", Window: 10},
+		{Type: AuctionBlind, Window: 10},
+		{Type: AuctionEnglish, Window: 10},
+		{Type: AuctionDutch, StartPrice: 100, Step: 10, RoundLength: 10, Reserve: 50},
+		{Type: AuctionVickrey, Window: 10, RevealWindow: 10, Reserve: -1},
+		{Type: AuctionBlind, Window: 10, RevealWindow: 10, Weights: ScoringWeights{Price: -1}},
+		{Type: AuctionDutch, StartPrice: 100, Step: 10, RoundLength: 10, Reserve: 200, Weights: ScoringWeights{Time: 1}},
+	}
+	for _, auction := range invalid {
+		if auction.validate() == nil {
+			t.Fatalf("invalid auction validated: %+v", auction)
+		}
+	}
+
+	sealed := Auction{Type: AuctionVickrey, Window: 100, RevealWindow: 50}
+	sealed.schedule(1000)
+	if sealed.BidEnd != 1100 || sealed.RevealEnd != 1150 || sealed.End() != 1150 {
+		t.Fatalf("sealed auction windows not set: %+v", sealed)
+	}
+
+	dutch := Auction{Type: AuctionDutch, StartPrice: 100, Step: 30, RoundLength: 10, Reserve: 200}
+	if err := dutch.validate(); err != nil {
+		t.Fatal(err)
+	}
+	dutch.schedule(1000)
+	if dutch.DutchPrice(1000) != 100 || dutch.DutchPrice(1015) != 130 || dutch.DutchPrice(1035) != 190 ||
+		dutch.DutchPrice(1045) != 200 {
+		t.Fatalf("dutch clock doesn't rise to the reserve")
+	}
+	// the price reaches the reserve in round 5 and stays there for a round
+	if dutch.End() != 1050 {
+		t.Fatalf("dutch auction ends at %d, expected 1050", dutch.End())
+	}
+
+	// no price accepts the price offered, a price from the previous round is accepted as the offer moves on
+	if price, err := dutch.dutchBidPrice(0, 1015); err != nil || price != 130 {
+		t.Fatalf("price offered not accepted: %f %v", price, err)
+	}
+	if price, err := dutch.dutchBidPrice(130.00000001, 1015); err != nil || price != 130 {
+		t.Fatalf("price offered not accepted within rounding: %f %v", price, err)
+	}
+	if price, err := dutch.dutchBidPrice(100, 1015); err != nil || price != 100 {
+		t.Fatalf("price of the previous round not accepted: %f %v", price, err)
+	}
+	if _, err := dutch.dutchBidPrice(100, 1025); err == nil {
+		t.Fatalf("price of an earlier round accepted")
+	}
+	if _, err := dutch.dutchBidPrice(160, 1015); err == nil {
+		t.Fatalf("price of a later round accepted")
+	}
+}
+
+func TestBidCommitment(t *testing.T) {
+	commitment := BidCommitment(850.5, "salt")
+	if commitment != BidCommitment(850.5, "salt") {
+		t.Fatalf("commitments aren't deterministic")
+	}
+	if commitment == BidCommitment(850.5, "pepper") || commitment == BidCommitment(850, "salt") {
+		t.Fatalf("commitment doesn't bind the price and salt")
+	}
+
+	bids := []Bid{{Index: 1, Commitment: commitment, Price: 850.5, Salt: "salt", Revealed: true}}
+	auction := Auction{Type: AuctionBlind, Status: AuctionOpen}
+	if visible := auction.VisibleBids(bids); visible[0].Price != 0 || visible[0].Salt != "" || bids[0].Price != 850.5 {
+		t.Fatalf("sealed bid visible while auction is open")
+	}
+	auction.Status = AuctionClosed
+	if visible := auction.VisibleBids(bids); visible[0].Price != 850.5 {
+		t.Fatalf("sealed bid hidden after auction closed")
+	}
+}
+
+func TestSelectContractVickrey(t *testing.T) {
+	arr := []Project{{Index: 1, TotalValue: 300}, {Index: 2, TotalValue: 100}, {Index: 3, TotalValue: 200}}
+	winner, price, err := SelectContractVickrey(arr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if winner.Index != 2 || winner.TotalValue != 100 || price != 200 {
+		t.Fatalf("expected contract 2 to win at 200, got %d at %f", winner.Index, price)
+	}
+	if len(arr) != 3 || arr[1].Index != 2 {
+		t.Fatalf("contracts passed to selector modified")
+	}
+
+	_, price, err = SelectContractVickrey(arr[:1])
+	if err != nil || price != 300 {
+		t.Fatalf("single contract not paid its price")
+	}
+	_, _, err = SelectContractVickrey(nil)
+	if err == nil {
+		t.Fatalf("empty array returns choice")
+	}
+}
@@ -0,0 +1,385 @@
+package core
+
+import (
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	utils "github.com/Varunram/essentials/utils"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	notif "github.com/YaleOpenLab/opensolar/notif"
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+// the types of breach rules that can be attached to a stage. Param is interpreted differently by each type
+const (
+	// BreachDeadline is breached if the project has been in the stage for more than Param seconds
+	BreachDeadline = "deadline"
+	// BreachHeartbeat is breached if the project's teller hasn't sent a reading in Param seconds
+	BreachHeartbeat = "heartbeat"
+	// BreachMissedPayments is breached if the recipient hasn't paid back in Param payback periods
+	BreachMissedPayments = "missedpayments"
+	// BreachCollateral is breached if the contractor's collateral falls below Param times the project's value
+	BreachCollateral = "collateral"
+)
+
+// the actions that can be taken when a breach rule is breached. Each action is taken once per breach
+const (
+	// BreachActionNotify lets the project's stakeholders know about the breach
+	BreachActionNotify = "notify"
+	// BreachActionSlash slashes the collateral and reputation of the project's contractor
+	BreachActionSlash = "slash"
+	// BreachActionCoverFirstLoss has the guarantor cover first loss for the amount owed by the recipient
+	BreachActionCoverFirstLoss = "coverfirstloss"
+)
+
+// BreachCheckInterval is the interval in seconds between two evaluations of a project's breach rules
+var BreachCheckInterval = int64(24 * 60 * 60)
+
+// BreachRule is a machine readable breach condition attached to a stage
+type BreachRule struct {
+	// Type is the type of the rule (BreachDeadline, BreachHeartbeat, etc)
+	Type string
+
+	// Param is the threshold of the rule
+	Param float64
+
+	// Actions are the actions taken when the rule is breached
+	Actions []string
+
+	// Description describes the rule to stakeholders
+	Description string
+}
+
+// Breach is a breach of a stage's breach rule by a project
+type Breach struct {
+	// Stage is the stage the project was in when the rule was breached
+	Stage int
+
+	// Rule is the rule that was breached
+	Rule BreachRule
+
+	// Timestamp is the unix time at which the breach was detected
+	Timestamp int64
+
+	// Detail describes how the rule was breached
+	Detail string
+
+	// Actions is an action: done map of the actions associated with the breach. Actions that fail are
+	// retried on the next evaluation until they succeed
+	Actions map[string]bool
+
+	// Resolved is the unix time at which the rule stopped being breached, zero if the breach is open
+	Resolved int64
+}
+
+// BreachState is the state of a project that breach rules are evaluated against
+type BreachState struct {
+	// Now is the unix time of the evaluation
+	Now int64
+
+	// StageEntered is the unix time at which the project entered its current stage, zero if unknown
+	StageEntered int64
+
+	// LastHeartbeat is the unix time of the last reading sent by the project's teller
+	LastHeartbeat int64
+
+	// Factor is the number of payback periods since the recipient last paid back
+	Factor float64
+
+	// Collateral is the collateral put up by the project's contractor, negative if there is no contractor
+	Collateral float64
+
+	// Value is the total value of the project
+	Value float64
+}
+
+// EvaluateRule returns whether a rule is breached given the project's state along with a description of the breach
+func EvaluateRule(rule BreachRule, state BreachState) (bool, string, error) {
+	switch rule.Type {
+	case BreachDeadline:
+		if state.StageEntered == 0 {
+			return false, "", nil // we don't know when the project entered the stage
+		}
+		elapsed := state.Now - state.StageEntered
+		if float64(elapsed) > rule.Param {
+			return true, "project has been in its stage for " + formatDuration(elapsed), nil
+		}
+	case BreachHeartbeat:
+		if state.LastHeartbeat == 0 {
+			return false, "", nil
+		}
+		elapsed := state.Now - state.LastHeartbeat
+		if float64(elapsed) > rule.Param {
+			return true, "teller hasn't sent a reading for " + formatDuration(elapsed), nil
+		}
+	case BreachMissedPayments:
+		if state.Factor >= rule.Param {
+			factor, _ := utils.ToString(state.Factor)
+			return true, "recipient hasn't paid back in " + factor + " payback periods", nil
+		}
+	case BreachCollateral:
+		if state.Collateral < 0 {
+			return false, "", nil
+		}
+		if state.Collateral < rule.Param*state.Value {
+			collateral, _ := utils.ToString(state.Collateral)
+			return true, "contractor collateral has fallen to " + collateral, nil
+		}
+	default:
+		return false, "", errors.New("unknown breach rule type: " + rule.Type)
+	}
+	return false, "", nil
+}
+
+// formatDuration formats a duration in seconds for breach details
+func formatDuration(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// paybackFactor returns the number of payback periods since the recipient last paid back. Projects that haven't
+// been paid back yet are measured from since, zero if since isn't known
+func (a Project) paybackFactor(now int64, since int64) float64 {
+	period := float64(time.Duration(a.PaybackPeriod) * consts.OneWeekInSecond / time.Second) // in seconds
+	if period == 0 {
+		period = 1 // for the test suite
+	}
+	if a.DateLastPaid != 0 {
+		since = a.DateLastPaid
+	}
+	if since == 0 {
+		return 0
+	}
+	return float64(now-since) / period
+}
+
+// breachState retrieves the state of the project breach rules are evaluated against
+func (a Project) breachState(now int64) (BreachState, error) {
+	var state BreachState
+	state.Now = now
+	state.Value = a.TotalValue
+	state.Collateral = -1
+
+	events, err := RetrieveProjectEvents(a.Index)
+	if err != nil {
+		return state, errors.Wrap(err, "couldn't retrieve project events")
+	}
+	var since int64
+	for _, event := range events {
+		if ((event.Type == EventStagePromoted || event.Type == EventStageDemoted) && event.Stage == a.Stage) ||
+			(event.Type == EventProjectCreated && a.Stage == 0) {
+			state.StageEntered = event.Timestamp
+		}
+		if event.Type == EventProjectFunded {
+			since = event.Timestamp
+		}
+	}
+
+	// projects that have never been paid back are measured from when they were funded
+	if since == 0 {
+		since = state.StageEntered
+	}
+	state.Factor = a.paybackFactor(now, since)
+
+	readings, err := RetrieveReadings(a.Index, "", 0, now)
+	if err != nil {
+		return state, errors.Wrap(err, "couldn't retrieve readings")
+	}
+	state.LastHeartbeat = state.StageEntered // the teller should report from the time the stage was entered
+	if len(readings) != 0 && readings[len(readings)-1].Timestamp > state.LastHeartbeat {
+		state.LastHeartbeat = readings[len(readings)-1].Timestamp
+	}
+
+	if a.ContractorIndex != 0 {
+		// the contractor's collateral across projects doesn't back this project, only what is held for it does
+		state.Collateral = a.CollateralHeld[a.ContractorIndex]
+	}
+
+	return state, nil
+}
+
+// openBreach returns the open breach of a rule in the project's current stage
+func (a *Project) openBreach(rule BreachRule) *Breach {
+	for i := range a.Breaches {
+		breach := &a.Breaches[i]
+		if breach.Resolved == 0 && breach.Stage == a.Stage && breach.Rule.Type == rule.Type {
+			return breach
+		}
+	}
+	return nil
+}
+
+// EvaluateBreaches evaluates the breach rules of the project's current stage. New breaches are recorded and
+// their actions taken, breaches that are no longer breached are resolved and breaches of previous stages are
+// resolved once the project moves on. Actions that haven't succeeded yet are retried on every call.
+func (a *Project) EvaluateBreaches(now int64) error {
+	stage, err := RetrieveStage(a.Stage)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve stage")
+	}
+
+	state, err := a.breachState(now)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve breach state")
+	}
+
+	for i := range a.Breaches {
+		if a.Breaches[i].Resolved == 0 && a.Breaches[i].Stage != a.Stage {
+			a.Breaches[i].Resolved = now
+		}
+	}
+
+	for _, rule := range stage.BreachRules {
+		breached, detail, err := EvaluateRule(rule, state)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		breach := a.openBreach(rule)
+		if !breached {
+			if breach != nil {
+				log.Println("project: ", a.Index, " no longer breaches rule: ", rule.Type)
+				breach.Resolved = now
+			}
+			continue
+		}
+
+		if breach == nil {
+			log.Println("project: ", a.Index, " breached rule: ", rule.Type, " ", detail)
+			a.Breaches = append(a.Breaches, Breach{
+				Stage:     a.Stage,
+				Rule:      rule,
+				Timestamp: now,
+				Detail:    detail,
+				Actions:   make(map[string]bool),
+			})
+		}
+	}
+
+	// breaches are saved before actions are taken so reputations recomputed by actions include them
+	err = a.saveBreaches()
+	if err != nil {
+		return err
+	}
+
+	var actionErr error
+	for i := range a.Breaches {
+		breach := &a.Breaches[i]
+		if breach.Resolved != 0 || breach.Stage != a.Stage {
+			continue
+		}
+		for _, action := range breach.Rule.Actions {
+			if breach.Actions[action] {
+				continue
+			}
+			err = a.takeBreachAction(action, *breach)
+			if err != nil {
+				log.Println("couldn't take breach action: ", action, err)
+				actionErr = errors.Wrap(err, "couldn't take breach action "+action)
+				continue
+			}
+			breach.Actions[action] = true
+		}
+	}
+
+	err = a.saveBreaches()
+	if err != nil {
+		return err
+	}
+	return actionErr
+}
+
+// saveBreaches records the project's breaches. Only the breaches are updated since actions (eg. covering first
+// loss) update the stored project
+func (a *Project) saveBreaches() error {
+	project, err := RetrieveProject(a.Index)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+	err = project.Update("breaches evaluated", map[string]interface{}{"Breaches": a.Breaches})
+	if err != nil {
+		return errors.Wrap(err, "couldn't update project")
+	}
+	return nil
+}
+
+// takeBreachAction takes a single breach action
+func (a Project) takeBreachAction(action string, breach Breach) error {
+	switch action {
+	case BreachActionNotify:
+		for _, user := range a.stakeholders() {
+			notif.SendBreachEmail(a.Index, user.Index, user.Email, breach.Rule.Description, breach.Detail)
+		}
+		return notif.SendBreachEmail(a.Index, 0, consts.PlatformEmail, breach.Rule.Description, breach.Detail)
+	case BreachActionSlash:
+		if a.ContractorIndex == 0 {
+			return errors.New("project doesn't have a contractor to slash")
+		}
+		project, err := RetrieveProject(a.Index)
+		if err != nil {
+			return errors.Wrap(err, "couldn't retrieve project")
+		}
+		err = project.slashCollateral(a.ContractorIndex)
+		if err != nil {
+			return errors.Wrap(err, "couldn't slash collateral")
+		}
+		// the breach counts against the contractor's breach history
+		return UpdateReputation(a.ContractorIndex)
+	case BreachActionCoverFirstLoss:
+		if a.GuarantorIndex == 0 {
+			return errors.New("project doesn't have a guarantor to cover first loss")
+		}
+		project, err := RetrieveProject(a.Index)
+		if err != nil {
+			return errors.Wrap(err, "couldn't retrieve project")
+		}
+		if project.AmountOwed <= 0 {
+			log.Println("project: ", a.Index, " doesn't owe anything, not covering first loss")
+			return nil
+		}
+		return CoverFirstLoss(a.Index, a.GuarantorIndex, project.AmountOwed)
+	}
+	return errors.New("unknown breach action: " + action)
+}
+
+// stakeholders returns the project's recipient, entities and the investors who have opted in to notifications
+func (a Project) stakeholders() []openx.User {
+	var users []openx.User
+	recipient, err := RetrieveRecipient(a.RecipientIndex)
+	if err == nil && recipient.U != nil {
+		users = append(users, *recipient.U)
+	}
+
+	for _, index := range []int{a.OriginatorIndex, a.ContractorIndex, a.MainDeveloperIndex, a.GuarantorIndex} {
+		if index == 0 {
+			continue
+		}
+		entity, err := RetrieveEntity(index)
+		if err != nil || entity.U == nil {
+			log.Println("couldn't retrieve entity: ", index, err)
+			continue
+		}
+		users = append(users, *entity.U)
+	}
+
+	return append(users, a.notifiedInvestors()...)
+}
+
+// checkBreaches evaluates the breach rules of the project the job belongs to. Run by the scheduler every
+// BreachCheckInterval seconds once the project has been promoted
+func checkBreaches(job Job) (bool, error) {
+	project, err := RetrieveProject(job.ProjIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if project.Stage >= Stage9.Number || project.Cancelled {
+		// the project has reached end of life or was cancelled, nothing left to evaluate
+		return true, nil
+	}
+
+	return false, project.EvaluateBreaches(clock())
+}
@@ -0,0 +1,151 @@
+// +build all travis
+
+package core
+
+import (
+	"testing"
+)
+
+func TestEvaluateRule(t *testing.T) {
+	state := BreachState{Now: 1000, StageEntered: 100, LastHeartbeat: 900, Factor: 2, Collateral: 50, Value: 1000}
+
+	var tests = []struct {
+		rule     BreachRule
+		breached bool
+	}{
+		{BreachRule{Type: BreachDeadline, Param: 800}, true},
+		{BreachRule{Type: BreachDeadline, Param: 900}, false},
+		{BreachRule{Type: BreachHeartbeat, Param: 50}, true},
+		{BreachRule{Type: BreachHeartbeat, Param: 100}, false},
+		{BreachRule{Type: BreachMissedPayments, Param: 2}, true},
+		{BreachRule{Type: BreachMissedPayments, Param: 4}, false},
+		{BreachRule{Type: BreachCollateral, Param: 0.1}, true},
+		{BreachRule{Type: BreachCollateral, Param: 0.05}, false},
+	}
+
+	for _, test := range tests {
+		breached, _, err := EvaluateRule(test.rule, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if breached != test.breached {
+			t.Fatalf("rule %+v evaluated to %v, expected %v", test.rule, breached, test.breached)
+		}
+	}
+
+	// rules that can't be evaluated aren't breached
+	state = BreachState{Now: 1000, Collateral: -1}
+	for _, rule := range []BreachRule{{Type: BreachDeadline}, {Type: BreachHeartbeat}, {Type: BreachCollateral, Param: 1}} {
+		breached, _, err := EvaluateRule(rule, state)
+		if err != nil || breached {
+			t.Fatalf("rule %+v breached without the state to evaluate it", rule)
+		}
+	}
+
+	_, _, err := EvaluateRule(BreachRule{Type: "blah"}, state)
+	if err == nil {
+		t.Fatalf("unknown rule type evaluated")
+	}
+}
+
+func TestStageBreachRules(t *testing.T) {
+	for _, stage := range Stages {
+		for _, rule := range stage.BreachRules {
+			_, _, err := EvaluateRule(rule, BreachState{})
+			if err != nil {
+				t.Fatalf("stage %d has an invalid breach rule: %v", stage.Number, err)
+			}
+			for _, action := range rule.Actions {
+				switch action {
+				case BreachActionNotify, BreachActionSlash, BreachActionCoverFirstLoss:
+				default:
+					t.Fatalf("stage %d has an unknown breach action: %s", stage.Number, action)
+				}
+			}
+		}
+	}
+
+	var project Project
+	project.Stage = 7
+	project.Breaches = []Breach{{Stage: 7, Rule: BreachRule{Type: BreachHeartbeat}}, {Stage: 6, Rule: BreachRule{Type: BreachDeadline}}}
+	if project.openBreach(BreachRule{Type: BreachHeartbeat}) != &project.Breaches[0] {
+		t.Fatalf("open breach of current stage not found")
+	}
+	if project.openBreach(BreachRule{Type: BreachDeadline}) != nil {
+		t.Fatalf("breach of previous stage returned as open")
+	}
+}
+
+func TestBreachState(t *testing.T) {
+	defer testDb(t)()
+
+	var now int64
+	c := CurrentClock()
+	SetClock(func() int64 { return now })
+	defer SetClock(c)
+
+	project := Project{Index: 1, Stage: 4}
+	err := project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = 100
+	err = project.Record(ProjectEvent{Type: EventProjectFunded, Amount: 1000, Stage: Stage5.Number})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = 200
+	err = project.Record(ProjectEvent{Type: EventStagePromoted, Stage: Stage6.Number})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the recipient has never paid back, so missed payments are counted from funding
+	state, err := project.breachState(400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.StageEntered != 200 || state.Factor != 300 {
+		t.Fatalf("payback factor not measured from funding: %+v", state)
+	}
+	breached, _, err := EvaluateRule(BreachRule{Type: BreachMissedPayments, Param: 3}, state)
+	if err != nil || !breached {
+		t.Fatalf("missed payments not detected for a project that has never paid back")
+	}
+
+	now = 300
+	err = project.Record(ProjectEvent{Type: EventStageDemoted, Stage: Stage5.Number})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err = project.breachState(400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.StageEntered != 300 {
+		t.Fatalf("demotion not counted as entering the stage: %d", state.StageEntered)
+	}
+}
+
+func TestBreachStateCollateral(t *testing.T) {
+	defer testDb(t)()
+
+	project := Project{Index: 1, Stage: 5, TotalValue: 1000, ContractorIndex: 2,
+		CollateralHeld: map[int]float64{2: 50, 3: 500}}
+	err := project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := project.breachState(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Collateral != 50 {
+		t.Fatalf("collateral not taken from the collateral held for the project: %f", state.Collateral)
+	}
+	breached, _, err := EvaluateRule(BreachRule{Type: BreachCollateral, Param: 0.1}, state)
+	if err != nil || !breached {
+		t.Fatalf("collateral breach not detected")
+	}
+}
@@ -0,0 +1,287 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the parties that can be responsible for a stage activity
+const (
+	PartyRecipient  = "recipient"
+	PartyOriginator = "originator"
+	PartyDeveloper  = "developer"
+	PartyContractor = "contractor"
+	PartyGuarantor  = "guarantor"
+	PartyInvestor   = "investor"
+)
+
+// activityTags maps the bracketed tags used in stage activities to the parties responsible for them.
+// Tags not in this map (eg. [Legal] or [Utility]) refer to parties outside the platform, so activities
+// tagged only with them can be ticked by any party to the project
+var activityTags = map[string][]string{
+	"host":            {PartyRecipient},
+	"beneficiary":     {PartyRecipient},
+	"beneficiaries":   {PartyRecipient},
+	"offtaker":        {PartyRecipient},
+	"offtakers":       {PartyRecipient},
+	"off-takers":      {PartyRecipient},
+	"receiver":        {PartyRecipient},
+	"originator":      {PartyOriginator},
+	"solar developer": {PartyDeveloper, PartyOriginator}, // originators may serve as the developer
+	"developer":       {PartyDeveloper},
+	"developers":      {PartyDeveloper},
+	"manager":         {PartyDeveloper},
+	"engineering procurement and construction":  {PartyContractor},
+	"engineering, procurement and construction": {PartyContractor},
+	"operations & maintenance":                  {PartyContractor},
+	"vendor":                                    {PartyContractor},
+	"vendors":                                   {PartyContractor},
+	"investor":                                  {PartyInvestor},
+	"investors":                                 {PartyInvestor},
+	"tax equity investor":                       {PartyInvestor},
+	"insurers":                                  {PartyGuarantor},
+}
+
+// ChecklistEntry records a tick (or untick) of a stage activity along with the evidence supporting it
+type ChecklistEntry struct {
+	// Stage is the number of the stage the activity belongs to
+	Stage int
+
+	// Activity is the index of the activity in the stage's Activities
+	Activity int
+
+	// Done is set if the activity was ticked and unset if it was unticked
+	Done bool
+
+	// UserIndex is the index of the user who ticked the activity
+	UserIndex int
+
+	// Timestamp is the unix time at which the activity was ticked
+	Timestamp int64
+
+	// IPFSHash is the ipfs hash of the evidence supporting the tick
+	IPFSHash string
+
+	// Document is the name or hash of a document supporting the tick
+	Document string
+
+	// Comment is a comment left by the user who ticked the activity
+	Comment string
+}
+
+// ChecklistItem is the state of a single stage activity
+type ChecklistItem struct {
+	Activity int
+	Text     string
+	Parties  []string
+	Done     bool
+	Entries  []ChecklistEntry
+}
+
+// ChecklistStatus is the state of the checklist of a stage along with the items blocking promotion
+type ChecklistStatus struct {
+	ProjIndex int
+	Stage     int
+	Items     []ChecklistItem
+	Blockers  []string
+}
+
+// hasEvidence returns true if the entry has evidence attached to it
+func (a ChecklistEntry) hasEvidence() bool {
+	return a.IPFSHash != "" || a.Document != ""
+}
+
+// ActivityParties returns the parties responsible for a stage activity. An empty slice means any
+// party to the project can tick the activity
+func ActivityParties(activity string) []string {
+	var parties []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(activity, "[")[1:] {
+		end := strings.Index(tag, "]")
+		if end == -1 {
+			continue
+		}
+		for _, name := range strings.Split(tag[:end], "/") {
+			for _, party := range activityTags[strings.ToLower(strings.TrimSpace(name))] {
+				if !seen[party] {
+					seen[party] = true
+					parties = append(parties, party)
+				}
+			}
+		}
+	}
+	return parties
+}
+
+// Parties returns the parties a user is to the project
+func (a Project) Parties(userIndex int) map[string]bool {
+	parties := make(map[string]bool)
+	parties[PartyRecipient] = a.RecipientIndex == userIndex
+	parties[PartyOriginator] = a.OriginatorIndex == userIndex
+	parties[PartyDeveloper] = a.MainDeveloperIndex == userIndex
+	parties[PartyContractor] = a.ContractorIndex == userIndex
+	parties[PartyGuarantor] = a.GuarantorIndex == userIndex
+	parties[PartyInvestor] = containsIndex(a.InvestorIndices, userIndex)
+	return parties
+}
+
+// ResponsibleFor returns true if the user is responsible for an activity of a stage of the project
+func (a Project) ResponsibleFor(stage Stage, activity int, userIndex int) bool {
+	if activity < 0 || activity >= len(stage.Activities) {
+		return false
+	}
+
+	parties := a.Parties(userIndex)
+	responsible := ActivityParties(stage.Activities[activity])
+	if len(responsible) == 0 {
+		for _, isParty := range parties {
+			if isParty {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, party := range responsible {
+		if parties[party] {
+			return true
+		}
+	}
+	return false
+}
+
+// TickActivity ticks (or unticks) an activity of a project's stage and attaches evidence to it. The caller is
+// expected to check that the user is responsible for the activity
+func TickActivity(projIndex int, stageNumber int, activity int, userIndex int, done bool,
+	ipfsHash string, document string, comment string) (ChecklistEntry, error) {
+	var entry ChecklistEntry
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return entry, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	stage, err := RetrieveStage(stageNumber)
+	if err != nil {
+		return entry, errors.Wrap(err, "couldn't retrieve stage")
+	}
+
+	if stageNumber < project.Stage {
+		return entry, errors.New("project has already been promoted past stage " + strconv.Itoa(stageNumber) + ", quitting")
+	}
+
+	if activity < 0 || activity >= len(stage.Activities) {
+		return entry, errors.New("activity index out of bounds, quitting")
+	}
+
+	entry = ChecklistEntry{
+		Stage:     stageNumber,
+		Activity:  activity,
+		Done:      done,
+		UserIndex: userIndex,
+		Timestamp: clock(),
+		IPFSHash:  ipfsHash,
+		Document:  document,
+		Comment:   comment,
+	}
+
+	// the checklist is copied since the update has to differ from the project's checklist
+	checklist := make([]map[string]bool, len(project.StageChecklist))
+	copy(checklist, project.StageChecklist)
+	for len(checklist) <= stageNumber {
+		checklist = append(checklist, nil)
+	}
+	activities := make(map[string]bool)
+	for key, value := range checklist[stageNumber] {
+		activities[key] = value
+	}
+	activities[strconv.Itoa(activity)] = done
+	checklist[stageNumber] = activities
+
+	evidence := append(append([]ChecklistEntry(nil), project.StageEvidence...), entry)
+	return entry, project.Update("checklist updated", map[string]interface{}{
+		"StageChecklist": checklist,
+		"StageEvidence":  evidence,
+	})
+}
+
+// hasStageData returns true if evidence has been attached to a stage. Hashes stored in StageData
+// before checklists could be ticked count as evidence as well
+func (a Project) hasStageData(stage int) bool {
+	for _, entry := range a.StageEvidence {
+		if entry.Stage == stage && entry.Done && entry.hasEvidence() {
+			return true
+		}
+	}
+	return len(a.StageData) > stage && len(a.StageData[stage]) != 0
+}
+
+// ticked returns true if an activity of a stage has been ticked
+func (a Project) ticked(stage int, activity int) bool {
+	if len(a.StageChecklist) <= stage {
+		return false
+	}
+	return a.StageChecklist[stage][strconv.Itoa(activity)]
+}
+
+// StageBlockers returns the items blocking the promotion of the project from its current stage
+func (a Project) StageBlockers() ([]string, error) {
+	stage, err := RetrieveStage(a.Stage)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't retrieve stage")
+	}
+
+	var blockers []string
+	for i, activity := range stage.Activities {
+		if !a.ticked(stage.Number, i) {
+			blockers = append(blockers, "activity "+strconv.Itoa(i)+" not done: "+activity)
+		}
+	}
+
+	if !a.hasStageData(stage.Number) {
+		blockers = append(blockers, "no evidence attached to stage "+strconv.Itoa(stage.Number))
+	}
+
+	return blockers, nil
+}
+
+// RetrieveChecklist returns the state of the checklist of a project's stage
+func RetrieveChecklist(projIndex int, stageNumber int) (ChecklistStatus, error) {
+	var status ChecklistStatus
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return status, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	stage, err := RetrieveStage(stageNumber)
+	if err != nil {
+		return status, errors.Wrap(err, "couldn't retrieve stage")
+	}
+
+	status.ProjIndex = projIndex
+	status.Stage = stageNumber
+	for i, activity := range stage.Activities {
+		item := ChecklistItem{
+			Activity: i,
+			Text:     activity,
+			Parties:  ActivityParties(activity),
+			Done:     project.ticked(stageNumber, i),
+		}
+		for _, entry := range project.StageEvidence {
+			if entry.Stage == stageNumber && entry.Activity == i {
+				item.Entries = append(item.Entries, entry)
+			}
+		}
+		status.Items = append(status.Items, item)
+	}
+
+	if stageNumber == project.Stage {
+		status.Blockers, err = project.StageBlockers()
+		if err != nil {
+			return status, errors.Wrap(err, "couldn't retrieve stage blockers")
+		}
+	}
+
+	return status, nil
+}
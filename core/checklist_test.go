@@ -0,0 +1,82 @@
+// +build all travis
+
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestActivityParties(t *testing.T) {
+	parties := ActivityParties("[Originator][Solar Developer][Offtaker] Post project for RFP")
+	if len(parties) != 3 || parties[0] != PartyOriginator || parties[1] != PartyDeveloper || parties[2] != PartyRecipient {
+		t.Fatalf("parties not parsed from tags: %v", parties)
+	}
+
+	parties = ActivityParties("[Originator/Receiver] Begin negotiation with [Utility]")
+	if len(parties) != 2 || parties[0] != PartyOriginator || parties[1] != PartyRecipient {
+		t.Fatalf("slash separated tags not parsed: %v", parties)
+	}
+
+	parties = ActivityParties("[Engineering, Procurement and Construction] completes installation for [Off-takers]")
+	if len(parties) != 2 || parties[0] != PartyContractor || parties[1] != PartyRecipient {
+		t.Fatalf("tags with punctuation not parsed: %v", parties)
+	}
+
+	if len(ActivityParties("Simple: Automatic calculation (eg. Sunroof style)")) != 0 {
+		t.Fatalf("parties parsed from untagged activity")
+	}
+
+	var project Project
+	project.RecipientIndex = 1
+	project.OriginatorIndex = 2
+	project.ContractorIndex = 3
+	stage := Stage{Activities: []string{"[Host] states ownership", "[Vendors] (Hardware)", "Untagged activity"}}
+	if !project.ResponsibleFor(stage, 0, 1) || project.ResponsibleFor(stage, 0, 3) {
+		t.Fatalf("responsibility for tagged activity not checked")
+	}
+	if !project.ResponsibleFor(stage, 1, 3) || !project.ResponsibleFor(stage, 2, 2) || project.ResponsibleFor(stage, 2, 4) {
+		t.Fatalf("responsibility for activities not checked")
+	}
+	if project.ResponsibleFor(stage, 3, 1) {
+		t.Fatalf("responsible for activity out of bounds")
+	}
+}
+
+func TestStageBlockers(t *testing.T) {
+	var project Project
+	project.Stage = 0
+	blockers, err := project.StageBlockers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blockers) != len(Stage0.Activities)+1 {
+		t.Fatalf("expected every activity and missing evidence to block promotion: %v", blockers)
+	}
+
+	project.StageChecklist = []map[string]bool{make(map[string]bool)}
+	for i := range Stage0.Activities {
+		project.StageChecklist[0][strconv.Itoa(i)] = true
+	}
+	project.StageChecklist[0]["1"] = false
+	project.StageEvidence = append(project.StageEvidence, ChecklistEntry{Stage: 0, Activity: 0, Done: true, IPFSHash: "hash"})
+	blockers, err = project.StageBlockers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blockers) != 1 || blockers[0] != "activity 1 not done: "+Stage0.Activities[1] {
+		t.Fatalf("expected only the unticked activity to block promotion: %v", blockers)
+	}
+
+	project.StageChecklist[0]["1"] = true
+	blockers, err = project.StageBlockers()
+	if err != nil || len(blockers) != 0 {
+		t.Fatalf("promotion blocked with a complete checklist: %v %v", blockers, err)
+	}
+
+	project.Stage = 10
+	_, err = project.StageBlockers()
+	if err == nil {
+		t.Fatalf("blockers retrieved for stage out of bounds")
+	}
+}
@@ -0,0 +1,18 @@
+package core
+
+import (
+	utils "github.com/Varunram/essentials/utils"
+)
+
+// clock returns the current unix time. Simulations replace it with a virtual clock to run core through time
+var clock = utils.Unix
+
+// SetClock sets the function core uses to tell the current unix time
+func SetClock(c func() int64) {
+	clock = c
+}
+
+// CurrentClock returns the function core uses to tell the current unix time
+func CurrentClock() func() int64 {
+	return clock
+}
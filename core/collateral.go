@@ -0,0 +1,225 @@
+package core
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	utils "github.com/Varunram/essentials/utils"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// contractors back the projects they propose with collateral in stablecoin. The collateral is moved from the
+// contractor to an escrow for the project, which holds it apart from the platform's funds until the project
+// completes interconnection and it is released back to the contractor. Collateral is also released if the contractor
+// loses the project's auction or the project is cancelled, and is slashed to the project's escrow if the
+// contractor breaches a rule with a slash action. Every movement is recorded in the project's event log.
+
+// collateralLock prevents collateral from being released or slashed twice
+var collateralLock sync.Mutex
+
+// collateralSignerDir returns the directory where the keys signing for projects' collateral escrows are stored
+func collateralSignerDir() string {
+	return consts.OpenSolarIssuerDir + "collateral/"
+}
+
+// collateralEscrowIndex returns the index the collateral escrow of a project is created with. Escrow keys are
+// stored by index and project escrows use the project's index, so collateral escrows use the negated index
+func collateralEscrowIndex(projIndex int) int {
+	return -projIndex
+}
+
+// collateralEscrow returns the publickey of the project's collateral escrow, creating the escrow if the project
+// doesn't have one yet. The escrow is a 2of2 multisig controlled by the platform and a key the platform creates
+// for the project, so collateral can be released or slashed without the contractor's seed
+func (a *Project) collateralEscrow() (string, error) {
+	if a.CollateralEscrow != "" {
+		return a.CollateralEscrow, nil
+	}
+
+	dir := collateralSignerDir()
+	err := ledger.InitIssuer(dir, a.Index, consts.IssuerSeedPwd)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't create collateral signer")
+	}
+	err = ledger.FundIssuer(dir, a.Index, consts.IssuerSeedPwd, consts.PlatformSeed)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't fund collateral signer")
+	}
+
+	signerPubkey, signerSeed, err := ledger.RetrieveIssuer(dir, a.Index, consts.IssuerSeedPwd)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't retrieve collateral signer")
+	}
+
+	escrowPubkey, err := ledger.InitEscrow(collateralEscrowIndex(a.Index), consts.EscrowPwd, signerPubkey, signerSeed, consts.PlatformSeed)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't create collateral escrow")
+	}
+
+	err = a.Update("collateral escrow created", map[string]interface{}{"CollateralEscrow": escrowPubkey})
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't update project")
+	}
+	return escrowPubkey, nil
+}
+
+// sendCollateral sends collateral out of the project's collateral escrow
+func (a Project) sendCollateral(dest string, amount float64, memo string) error {
+	_, signerSeed, err := ledger.RetrieveIssuer(collateralSignerDir(), a.Index, consts.IssuerSeedPwd)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve collateral signer")
+	}
+	return ledger.SendFundsFromEscrow(a.CollateralEscrow, dest, signerSeed, consts.PlatformSeed, amount, memo)
+}
+
+// PostCollateral moves collateral from a contractor's wallet into escrow for a project that is being proposed
+func PostCollateral(projIndex int, contractorIndex int, amount float64, seedpwd string) (Project, error) {
+	var project Project
+	if amount <= 0 {
+		return project, errors.New("collateral must be positive, quitting")
+	}
+
+	collateralLock.Lock()
+	defer collateralLock.Unlock()
+
+	contractor, err := RetrieveEntity(contractorIndex)
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't retrieve contractor")
+	}
+	if !contractor.Contractor {
+		return project, errors.New("only contractors can post collateral, quitting")
+	}
+
+	project, err = RetrieveProject(projIndex)
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't retrieve project")
+	}
+	if project.Cancelled {
+		return project, errors.New("project has been cancelled, quitting")
+	}
+	if project.Stage > Stage2.Number {
+		return project, errors.New("collateral can only be posted while the project is being proposed, quitting")
+	}
+
+	seed, err := ledger.DecryptSeed(contractor.U.StellarWallet.EncryptedSeed, seedpwd)
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't decrypt seed")
+	}
+
+	projIndexString, err := utils.ToString(projIndex)
+	if err != nil {
+		return project, err
+	}
+
+	escrowPubkey, err := project.collateralEscrow()
+	if err != nil {
+		return project, err
+	}
+
+	code, stablecoinIssuer := platformStablecoin()
+	txhash, err := ledger.SendAsset(code, stablecoinIssuer, escrowPubkey, amount, seed,
+		"Opensolar collateral: "+projIndexString)
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't send collateral to escrow")
+	}
+
+	err = project.Record(ProjectEvent{Type: EventCollateralPosted, UserIndex: contractorIndex, Amount: amount,
+		To: escrowPubkey, TxHash: txhash})
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't record collateral")
+	}
+
+	contractor.Collateral += amount
+	err = contractor.Save()
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't save contractor")
+	}
+
+	log.Println("contractor: ", contractorIndex, " posted collateral: ", amount, " for project: ", projIndex)
+	return project, nil
+}
+
+// releaseCollateral returns the collateral held in escrow for the project to every contractor except keep
+func (a *Project) releaseCollateral(keep int) error {
+	collateralLock.Lock()
+	defer collateralLock.Unlock()
+
+	var indices []int
+	for contractorIndex := range a.CollateralHeld {
+		if contractorIndex != keep {
+			indices = append(indices, contractorIndex)
+		}
+	}
+	sort.Ints(indices)
+
+	for _, contractorIndex := range indices {
+		amount := a.CollateralHeld[contractorIndex]
+		contractor, err := RetrieveEntity(contractorIndex)
+		if err != nil {
+			return errors.Wrap(err, "couldn't retrieve contractor")
+		}
+
+		dest := contractor.U.StellarWallet.PublicKey
+		err = a.sendCollateral(dest, amount, "collateral release")
+		if err != nil {
+			return errors.Wrap(err, "couldn't release collateral to contractor")
+		}
+
+		err = a.Record(ProjectEvent{Type: EventCollateralReleased, UserIndex: contractorIndex, Amount: amount,
+			To: dest})
+		if err != nil {
+			return errors.Wrap(err, "couldn't record collateral release")
+		}
+
+		contractor.Collateral -= amount
+		err = contractor.Save()
+		if err != nil {
+			return errors.Wrap(err, "couldn't save contractor")
+		}
+		log.Println("released collateral: ", amount, " to contractor: ", contractorIndex, " for project: ", a.Index)
+	}
+	return nil
+}
+
+// slashCollateral slashes the collateral a contractor holds in escrow for the project to the project's escrow
+func (a *Project) slashCollateral(contractorIndex int) error {
+	collateralLock.Lock()
+	defer collateralLock.Unlock()
+
+	amount := a.CollateralHeld[contractorIndex]
+	if amount <= 0 {
+		log.Println("contractor: ", contractorIndex, " doesn't hold collateral for project: ", a.Index)
+		return nil
+	}
+	if a.EscrowPubkey == "" {
+		return errors.New("project doesn't have an escrow to slash collateral to")
+	}
+
+	contractor, err := RetrieveEntity(contractorIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve contractor")
+	}
+
+	err = a.sendCollateral(a.EscrowPubkey, amount, "collateral slash")
+	if err != nil {
+		return errors.Wrap(err, "couldn't slash collateral to escrow")
+	}
+
+	err = a.Record(ProjectEvent{Type: EventCollateralSlashed, UserIndex: contractorIndex, Amount: amount,
+		To: a.EscrowPubkey})
+	if err != nil {
+		return errors.Wrap(err, "couldn't record collateral slash")
+	}
+
+	contractor.Collateral -= amount
+	err = contractor.Save()
+	if err != nil {
+		return errors.Wrap(err, "couldn't save contractor")
+	}
+	log.Println("slashed collateral: ", amount, " of contractor: ", contractorIndex, " for project: ", a.Index)
+	return nil
+}
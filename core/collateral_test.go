@@ -0,0 +1,119 @@
+// +build all travis
+
+package core
+
+import (
+	"testing"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+func TestCollateralEvents(t *testing.T) {
+	var project Project
+	for _, event := range []ProjectEvent{
+		{Type: EventCollateralPosted, UserIndex: 1, Amount: 100},
+		{Type: EventCollateralPosted, UserIndex: 2, Amount: 50},
+		{Type: EventCollateralPosted, UserIndex: 1, Amount: 20},
+		{Type: EventCollateralReleased, UserIndex: 2, Amount: 50},
+	} {
+		err := project.Apply(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if project.CollateralHeld[1] != 120 || len(project.CollateralHeld) != 1 {
+		t.Fatalf("collateral movements not applied: %v", project.CollateralHeld)
+	}
+
+	// collateral can't be slashed before the project has an escrow to slash it to
+	if project.slashCollateral(1) == nil {
+		t.Fatalf("collateral slashed without a project escrow")
+	}
+	if project.slashCollateral(2) != nil {
+		t.Fatalf("slashing a contractor without collateral fails")
+	}
+
+	err := project.Apply(ProjectEvent{Type: EventCollateralSlashed, UserIndex: 1, Amount: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.CollateralHeld) != 0 {
+		t.Fatalf("slashed collateral still held: %v", project.CollateralHeld)
+	}
+}
+
+func TestCollateralEscrow(t *testing.T) {
+	defer testDb(t)()
+	l, users, platformPubkey, restore := testLedger(t)
+	defer restore()
+
+	var pubkeys []string
+	for i := 1; i <= 2; i++ {
+		seed, pubkey := l.NewAccount()
+		pubkeys = append(pubkeys, pubkey)
+		user := openx.User{Index: i, StellarWallet: openx.Wallet{PublicKey: pubkey, EncryptedSeed: []byte(seed)}}
+		err := users.Add(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contractor := Entity{U: &user, Contractor: true}
+		err = contractor.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.Mint(pubkey, stablecoinCode(), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the project's own escrow is created with the project's index
+	recpSeed, recpPubkey := l.NewAccount()
+	escrowPubkey, err := l.InitEscrow(1, consts.EscrowPwd, recpPubkey, recpSeed, consts.PlatformSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := Project{Index: 1, Stage: 2, EscrowPubkey: escrowPubkey}
+	err = project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		project, err = PostCollateral(1, i, 60, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	collateralEscrow := project.CollateralEscrow
+	if collateralEscrow == "" || collateralEscrow == platformPubkey ||
+		l.GetAssetBalance(collateralEscrow, stablecoinCode()) != 120 ||
+		l.GetAssetBalance(platformPubkey, stablecoinCode()) != 0 {
+		t.Fatalf("collateral not held in the project's collateral escrow: %+v", project)
+	}
+
+	err = project.releaseCollateral(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.GetAssetBalance(pubkeys[1], stablecoinCode()) != 100 {
+		t.Fatalf("collateral not released to the contractor")
+	}
+
+	err = project.slashCollateral(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.GetAssetBalance(escrowPubkey, stablecoinCode()) != 60 || l.GetAssetBalance(collateralEscrow, stablecoinCode()) != 0 {
+		t.Fatalf("collateral not slashed to the project's escrow")
+	}
+
+	project, err = RetrieveProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.CollateralHeld) != 0 || project.CollateralEscrow != collateralEscrow {
+		t.Fatalf("collateral movements not recorded: %+v", project)
+	}
+}
@@ -6,13 +6,6 @@ import (
 
 	"github.com/pkg/errors"
 
-	utils "github.com/Varunram/essentials/utils"
-	xlm "github.com/Varunram/essentials/xlm"
-	assets "github.com/Varunram/essentials/xlm/assets"
-	escrow "github.com/Varunram/essentials/xlm/escrow"
-	issuer "github.com/Varunram/essentials/xlm/issuer"
-	wallet "github.com/Varunram/essentials/xlm/wallet"
-
 	consts "github.com/YaleOpenLab/opensolar/consts"
 	notif "github.com/YaleOpenLab/opensolar/notif"
 	oracle "github.com/YaleOpenLab/opensolar/oracle"
@@ -87,13 +80,20 @@ func VoteTowardsProposedProject(invIndex int, votes float64, projectIndex int) e
 		return errors.New("You can't vote for a project with stage not equal to 2")
 	}
 
-	project.Votes += votes
-	err = project.Save()
+	votesCast := make(map[int]float64)
+	for index, x := range project.VotesCast {
+		votesCast[index] = x
+	}
+	votesCast[invIndex] += votes
+	err = project.Update("vote cast", map[string]interface{}{
+		"Votes":     project.Votes + votes,
+		"VotesCast": votesCast,
+	})
 	if err != nil {
-		return errors.Wrap(err, "couldn't save project")
+		return errors.Wrap(err, "couldn't update project")
 	}
 
-	err = inv.ChangeVotingBalance(votes)
+	err = inv.ChangeVotingBalance(-votes)
 	if err != nil {
 		return errors.Wrap(err, "error while deducitng voting balance of investor")
 	}
@@ -122,12 +122,12 @@ func preInvestmentCheck(projIndex int, invIndex int, invAmount float64, seed str
 		return project, errors.New("Investor has less balance than what is required to invest in this project")
 	}
 
-	pubkey, err := wallet.ReturnPubkey(seed)
+	pubkey, err := ledger.ReturnPubkey(seed)
 	if err != nil {
 		return project, errors.Wrap(err, "could not get pubkey from seed")
 	}
 
-	if !xlm.AccountExists(pubkey) {
+	if !ledger.AccountExists(pubkey) {
 		return project, errors.New("account doesn't exist yet, quitting")
 	}
 	// check if investment amount is greater than or equal to the project requirements
@@ -135,6 +135,10 @@ func preInvestmentCheck(projIndex int, invIndex int, invAmount float64, seed str
 		return project, errors.New("Investment amount greater than what is required! Adjust your investment")
 	}
 
+	if project.Cancelled {
+		return project, errors.New("this project has been cancelled, quitting")
+	}
+
 	if project.AdminFlagged {
 		return project, errors.New("this proejct has been flagged by an admin. Please wait for their further action before proceeding")
 	}
@@ -144,16 +148,17 @@ func preInvestmentCheck(projIndex int, invIndex int, invAmount float64, seed str
 		if project.SeedAssetCode == "" && project.InvestorAssetCode == "" {
 			// this project does not have an asset issuer associated with it yet since there has been
 			// no seed round nor investment round
-			project.InvestorAssetCode = assets.AssetID(consts.InvestorAssetPrefix + project.Metadata) // creat investor asset
-			err = project.Save()
+			err = project.Update("investor asset created", map[string]interface{}{
+				"InvestorAssetCode": ledger.AssetID(consts.InvestorAssetPrefix + project.Metadata), // creat investor asset
+			})
 			if err != nil {
-				return project, errors.Wrap(err, "couldn't save project")
+				return project, errors.Wrap(err, "couldn't update project")
 			}
-			err = issuer.InitIssuer(consts.OpenSolarIssuerDir, projIndex, consts.IssuerSeedPwd) // start an issuer with the projIndex
+			err = ledger.InitIssuer(consts.OpenSolarIssuerDir, projIndex, consts.IssuerSeedPwd) // start an issuer with the projIndex
 			if err != nil {
 				return project, errors.Wrap(err, "error while initializing issuer")
 			}
-			err = issuer.FundIssuer(consts.OpenSolarIssuerDir, projIndex, consts.IssuerSeedPwd, consts.PlatformSeed) // fund the issuer since it needs to issue assets
+			err = ledger.FundIssuer(consts.OpenSolarIssuerDir, projIndex, consts.IssuerSeedPwd, consts.PlatformSeed) // fund the issuer since it needs to issue assets
 			if err != nil {
 				return project, errors.Wrap(err, "error while funding issuer")
 			}
@@ -189,7 +194,11 @@ func SeedInvest(projIndex int, invIndex int, invAmount float64, invSeed string)
 	if project.Chain == "stellar" || project.Chain == "" {
 		if project.SeedAssetCode == "" {
 			log.Println("assigning a seed asset code")
-			project.SeedAssetCode = "SEEDASSET" // set this to a constant asset for now
+			// set this to a constant asset for now
+			err = project.Update("seed asset assigned", map[string]interface{}{"SeedAssetCode": "SEEDASSET"})
+			if err != nil {
+				return errors.Wrap(err, "couldn't update project")
+			}
 		}
 		err = MunibondInvest(consts.OpenSolarIssuerDir, invIndex, invSeed, invAmount, projIndex,
 			project.SeedAssetCode, project.TotalValue, project.SeedInvestmentFactor, true)
@@ -251,23 +260,17 @@ func Invest(projIndex int, invIndex int, invAmount float64, invSeed string) erro
 // updateAfterInvestment updates project db params after investment
 func (project *Project) updateAfterInvestment(invAmount float64, invIndex int, seed bool) error {
 	var err error
-	project.MoneyRaised += invAmount
-	if seed {
-		project.SeedMoneyRaised += invAmount * (project.SeedInvestmentFactor - 1)
-	}
-	project.InvestorIndices = append(project.InvestorIndices, invIndex)
-
-	err = project.Save()
+	err = project.Record(ProjectEvent{Type: EventInvestmentReceived, UserIndex: invIndex, Amount: invAmount, Seed: seed})
 	if err != nil {
-		return errors.Wrap(err, "couldn't save project")
+		return errors.Wrap(err, "couldn't record investment")
 	}
 
-	if project.MoneyRaised == project.TotalValue {
+	raised := project.MoneyRaised == project.TotalValue
+	if raised {
 		// project has raised the entire amount that it needs. Set lock to true and wait for recipient's response
-		project.Lock = true
-		err = project.Save()
+		err = project.Update("raise completed", map[string]interface{}{"Lock": true})
 		if err != nil {
-			return errors.Wrap(err, "couldn't save project")
+			return errors.Wrap(err, "couldn't update project")
 		}
 
 		// send the recipient a notification that his project has been funded
@@ -275,13 +278,16 @@ func (project *Project) updateAfterInvestment(invAmount float64, invIndex int, s
 		if err != nil {
 			return errors.Wrap(err, "error while sending notifications to recipient")
 		}
+	}
 
-		// start a goroutine that waits for the recipient to unlock the project
-		go sendRecipientAssets(project.Index)
+	investorMap := make(map[string]float64)
+	for pubkey, x := range project.InvestorMap {
+		investorMap[pubkey] = x
 	}
 
-	if len(project.InvestorMap) == 0 {
-		project.InvestorMap = make(map[string]float64)
+	seedInvestorMap := make(map[string]float64)
+	for pubkey, x := range project.SeedInvestorMap {
+		seedInvestorMap[pubkey] = x
 	}
 
 	log.Println("INVESTOR INDICES: ", project.InvestorIndices)
@@ -296,17 +302,27 @@ func (project *Project) updateAfterInvestment(invAmount float64, invIndex int, s
 		var balance1 float64
 		var balance2 float64
 
-		balance1 = xlm.GetAssetBalance(investor.U.StellarWallet.PublicKey, project.InvestorAssetCode)
-		balance2 = xlm.GetAssetBalance(investor.U.StellarWallet.PublicKey, project.SeedAssetCode)
-		balance := balance1 + balance2
-		percentageInvestment := balance / project.TotalValue
-		project.InvestorMap[investor.U.StellarWallet.PublicKey] = percentageInvestment
+		balance1 = ledger.GetAssetBalance(investor.U.StellarWallet.PublicKey, project.InvestorAssetCode)
+		balance2 = ledger.GetAssetBalance(investor.U.StellarWallet.PublicKey, project.SeedAssetCode)
+		// seed investments are tracked separately since seed investors are paid ahead of regular investors
+		investorMap[investor.U.StellarWallet.PublicKey] = balance1 / project.TotalValue
+		if balance2 > 0 {
+			seedInvestorMap[investor.U.StellarWallet.PublicKey] = balance2 / project.TotalValue
+		}
 	}
 
-	err = project.Save()
+	err = project.Update("investor shares updated", map[string]interface{}{
+		"InvestorMap":     investorMap,
+		"SeedInvestorMap": seedInvestorMap,
+	})
 	log.Println("INVESTOR MAP: ", project.InvestorMap)
 	if err != nil {
-		return errors.Wrap(err, "error while saving project, quitting")
+		return errors.Wrap(err, "error while updating project, quitting")
+	}
+
+	if raised {
+		// start a goroutine that waits for the recipient to unlock the project once the investment has been saved
+		go sendRecipientAssets(project.Index)
 	}
 	return nil
 }
@@ -327,7 +343,7 @@ func (project *Project) sendRecipientNotification() error {
 			return errors.Wrap(err, "couldn't retrieve recipient")
 		}
 	}
-	notif.SendUnlockNotifToRecipient(project.Index, recipient.U.Email)
+	notif.SendUnlockNotifToRecipient(project.Index, recipient.U.Index, recipient.U.Email)
 	return nil
 }
 
@@ -348,12 +364,12 @@ func UnlockProject(username string, token string, projIndex int, seedpwd string)
 		return errors.New("recipient Indices don't match, quitting")
 	}
 
-	recpSeed, err := wallet.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, seedpwd)
+	recpSeed, err := ledger.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, seedpwd)
 	if err != nil {
 		return errors.Wrap(err, "error while decrpyting seed")
 	}
 
-	checkPubkey, err := wallet.ReturnPubkey(recpSeed)
+	checkPubkey, err := ledger.ReturnPubkey(recpSeed)
 	if err != nil {
 		return errors.Wrap(err, "couldn't get public key from seed")
 	}
@@ -367,11 +383,11 @@ func UnlockProject(username string, token string, projIndex int, seedpwd string)
 		return errors.New("Project not locked")
 	}
 
+	// the seedpwd is saved with the project but never recorded in the event log
 	project.LockPwd = seedpwd
-	project.Lock = false
-	err = project.Save()
+	err = project.Update("project unlocked", map[string]interface{}{"Lock": false})
 	if err != nil {
-		return errors.Wrap(err, "couldn't save project")
+		return errors.Wrap(err, "couldn't update project")
 	}
 	return nil
 }
@@ -385,13 +401,13 @@ func checkSeedPwd(project Project, pwd string) error {
 		return err
 	}
 
-	recpSeed, err := wallet.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, pwd)
+	recpSeed, err := ledger.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, pwd)
 	if err != nil { // length of a stelalr seed is 56
 		log.Println("error while decrypting using the given seed: ", err)
 		return err
 	}
 
-	checkPubkey, err := wallet.ReturnPubkey(recpSeed)
+	checkPubkey, err := ledger.ReturnPubkey(recpSeed)
 	if err != nil {
 		log.Println("couldn't get recipient's pubkey from seed: ", err)
 		return err
@@ -408,24 +424,24 @@ func checkSeedPwd(project Project, pwd string) error {
 // sendRecipientAssets sends a recipient the debt asset and the payback asset associated with
 // the opensolar platform
 func sendRecipientAssets(projIndex int) error {
-	startTime := utils.Unix()
+	startTime := clock()
 	project, err := RetrieveProject(projIndex)
 	if err != nil {
 		return errors.Wrap(err, "Couldn't retrieve project")
 	}
 
-	var wait bool
+	wait := true
 	if len(project.OneTimeUnlock) != 0 {
 		err := checkSeedPwd(project, project.OneTimeUnlock)
-		if err != nil {
-			wait = true
+		if err == nil {
+			wait = false // the recipient unlocked the project in advance
 		}
 		project.LockPwd = project.OneTimeUnlock
 		project.OneTimeUnlock = "" // set this to nil since this is a one time unlock. LockPwd will be set to nil later
 	}
 
-	if len(project.OneTimeUnlock) == 0 || wait {
-		for utils.Unix()-startTime < consts.LockInterval {
+	if wait {
+		for clock()-startTime < consts.LockInterval {
 			log.Printf("CHECKING IF PROJECT %d HAS BEEN UNLOCKED", projIndex)
 			project, err = RetrieveProject(projIndex)
 			if err != nil {
@@ -433,6 +449,9 @@ func sendRecipientAssets(projIndex int) error {
 				time.Sleep(10 * time.Second)
 				continue
 			}
+			if project.Cancelled || project.Stage < Stage4.Number {
+				return errors.New("project was cancelled or demoted while waiting for the recipient to unlock it")
+			}
 			if !project.Lock {
 				log.Println("Project UNLOCKED IN LOOP")
 				err := checkSeedPwd(project, project.LockPwd)
@@ -454,22 +473,21 @@ func sendRecipientAssets(projIndex int) error {
 		return errors.Wrap(err, "couldn't retrieve recipient")
 	}
 
-	recpSeed, err := wallet.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, project.LockPwd)
+	recpSeed, err := ledger.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, project.LockPwd)
 	if err != nil {
 		return errors.Wrap(err, "couldn't decrypt seed")
 	}
 
 	log.Println("initializing escrow: ", project.Index, consts.EscrowPwd, recipient.U.StellarWallet.PublicKey, recpSeed, consts.PlatformSeed)
-	escrowPubkey, err := escrow.InitEscrow(project.Index, consts.EscrowPwd, recipient.U.StellarWallet.PublicKey, recpSeed, consts.PlatformSeed)
+	escrowPubkey, err := ledger.InitEscrow(project.Index, consts.EscrowPwd, recipient.U.StellarWallet.PublicKey, recpSeed, consts.PlatformSeed)
 	if err != nil {
 		return errors.Wrap(err, "error while initializing issuer")
 	}
 
 	log.Println("successfully setup escrow")
-	project.EscrowPubkey = escrowPubkey
 	// transfer totalValue to the escrow, don't account for SeedMoneyRaised here
-	log.Println("PLATFORM PUBKEY: ", consts.PlatformPublicKey, project.TotalValue, project.Index, project.EscrowPubkey, consts.PlatformSeed)
-	err = escrow.TransferFundsToEscrow(project.TotalValue, project.Index, project.EscrowPubkey, consts.PlatformSeed)
+	log.Println("PLATFORM PUBKEY: ", consts.PlatformPublicKey, project.TotalValue, project.Index, escrowPubkey, consts.PlatformSeed)
+	err = ledger.TransferFundsToEscrow(project.TotalValue, project.Index, escrowPubkey, consts.PlatformSeed)
 	if err != nil {
 		log.Println(err)
 		return errors.Wrap(err, "could not transfer funds to the escrow, quitting!")
@@ -477,9 +495,16 @@ func sendRecipientAssets(projIndex int) error {
 
 	log.Println("Transferred funds to escrow!")
 
-	project.DebtAssetCode = assets.AssetID(consts.DebtAssetPrefix + project.Metadata)
-	project.PaybackAssetCode = assets.AssetID(consts.PaybackAssetPrefix + project.Metadata)
 	project.LockPwd = "" // lockpwd set to empty immediately after use
+	err = project.Update("escrow funded", map[string]interface{}{
+		"Lock":             false,
+		"EscrowPubkey":     escrowPubkey,
+		"DebtAssetCode":    ledger.AssetID(consts.DebtAssetPrefix + project.Metadata),
+		"PaybackAssetCode": ledger.AssetID(consts.PaybackAssetPrefix + project.Metadata),
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldn't update project")
+	}
 
 	// when sending debt and payback assets, account for SeedMoneyRaised
 	err = MunibondReceive(consts.OpenSolarIssuerDir, project.RecipientIndex, projIndex, project.DebtAssetCode,
@@ -499,16 +524,18 @@ func sendRecipientAssets(projIndex int) error {
 // updateProjectAfterAcceptance updates the project after the recipient accepts investment into the project
 func (project *Project) updateProjectAfterAcceptance() error {
 
-	// update balleft with SeedMoneyRaised
-	project.BalLeft = project.TotalValue + project.SeedMoneyRaised // to carry over the extra returns that seed investors get
-	project.Stage = Stage5.Number                                  // set to stage 5 (after the raise is done, we need to wait for people to construct the solar panels)
-
-	err := project.Save()
+	// update balleft with SeedMoneyRaised to carry over the extra returns that seed investors get and set to stage 5
+	// (after the raise is done, we need to wait for people to construct the solar panels)
+	err := project.Record(ProjectEvent{Type: EventProjectFunded, Amount: project.TotalValue + project.SeedMoneyRaised, Stage: Stage5.Number})
 	if err != nil {
-		return errors.Wrap(err, "couldn't save project")
+		return errors.Wrap(err, "couldn't record project funding")
 	}
 
-	go monitorPaybacks(project.RecipientIndex, project.Index)
+	// poll every week to check progress on payments
+	_, err = ScheduleJob(JobPaybackCheck, project.Index, 0, int64(consts.OneWeekInSecond/time.Second))
+	if err != nil {
+		return errors.Wrap(err, "couldn't schedule payback monitoring")
+	}
 	return nil
 }
 
@@ -528,32 +555,30 @@ func Payback(recpIndex int, projIndex int, assetName string, amount float64, rec
 		return errors.New("other investment models are not supported right now, quitting")
 	}
 
-	pct, err := MunibondPayback(consts.OpenSolarIssuerDir, recpIndex, amount,
+	pct, txhash, err := MunibondPayback(consts.OpenSolarIssuerDir, recpIndex, amount,
 		recipientSeed, projIndex, assetName, project.InvestorIndices, project.TotalValue, project.EscrowPubkey)
 	if err != nil {
 		return errors.Wrap(err, "Error while paying back the issuer")
 	}
 
-	project.BalLeft -= (1 - pct) * amount // the balance left should be the percentage paid towards the asset, which is the monthly bill. The rest goes into  ownership
-	project.AmountOwed -= amount          // subtract the amount owed so we can track progress of payments in the monitorPaybacks loop
-	project.OwnershipShift += pct
-	project.DateLastPaid = utils.Unix()
-
-	if project.BalLeft == 0 {
-		log.Println("YOU HAVE PAID OFF THIS ASSET's LOAN, TRANSFERRING FUTURE PAYMENTS AS OWNERSHIP ASSETS OWNERSHIP OF ASSET TO YOU")
-		project.Stage = 9
+	principal, interest := project.SplitPayback(amount)
+	log.Println("payback split into principal: ", principal, " and interest: ", interest)
+	err = project.Record(ProjectEvent{Type: EventPaybackRecorded, UserIndex: recpIndex, Amount: amount, Pct: pct,
+		Principal: principal, Interest: interest, TxHash: txhash})
+	if err != nil {
+		return errors.Wrap(err, "couldn't record payback")
 	}
 
-	if project.OwnershipShift == 1 {
-		// the recipient has paid off the asset completely
+	if project.OwnershipShift >= 1 {
 		log.Println("You now own the asset completely, there is no need to pay money in the future towards this particular project")
-		project.BalLeft = 0
-		project.AmountOwed = 0
 	}
 
-	err = project.Save()
-	if err != nil {
-		return errors.Wrap(err, "coudln't save project")
+	if project.BalLeft == 0 {
+		log.Println("YOU HAVE PAID OFF THIS ASSET's LOAN, TRANSFERRING FUTURE PAYMENTS AS OWNERSHIP ASSETS OWNERSHIP OF ASSET TO YOU")
+		err = project.Record(ProjectEvent{Type: EventStagePromoted, Stage: Stage9.Number})
+		if err != nil {
+			return errors.Wrap(err, "couldn't record stage promotion")
+		}
 	}
 
 	// TODO: we need to distribute funds which were paid back to all the parties involved, but we do so only for the investor here
@@ -565,13 +590,14 @@ func Payback(recpIndex int, projIndex int, assetName string, amount float64, rec
 	return nil
 }
 
-// DistributePayments distributes the return promised as part of the project back to investors and pays the other entities involved in the project
+// DistributePayments distributes the return promised as part of the project back to investors and pays the other entities
+// involved in the project. The distribution is planned over the project's waterfall and recorded before funds are sent out.
 func DistributePayments(recipientSeed string, escrowPubkey string, projIndex int, amount float64) error {
 	// this should act as the service which redistributes payments received out to the parties involved
 	// amount is the amount that we want to give back to the investors and other entities involved
 	project, err := RetrieveProject(projIndex)
 	if err != nil {
-		errors.Wrap(err, "couldn't retrieve project, quitting!")
+		return errors.Wrap(err, "couldn't retrieve project, quitting!")
 	}
 
 	if project.EscrowLock {
@@ -579,22 +605,17 @@ func DistributePayments(recipientSeed string, escrowPubkey string, projIndex int
 		return errors.New("project escrow locked, can't send funds")
 	}
 
-	var fixedRate float64
-	if project.InterestRate != 0 {
-		fixedRate = project.InterestRate
-	} else {
-		fixedRate = 0.05 // 5 % interest rate if rate not defined
+	plan, err := PlanPayments(projIndex, amount)
+	if err != nil {
+		return errors.Wrap(err, "couldn't plan distribution of payments")
 	}
 
-	amountGivenBack := fixedRate * amount
-	for pubkey, percentage := range project.InvestorMap {
-		txAmount := percentage * amountGivenBack
-		// here we send funds from the 2of2 multisig. Platform signs by default
-		err = escrow.SendFundsFromEscrow(project.EscrowPubkey, pubkey, recipientSeed, consts.PlatformSeed, txAmount, "returns")
-		if err != nil {
-			log.Println("Error with payback to pubkey: ", pubkey, err) // if there is an error with one payback, doesn't mean we should stop and wait for the others
-			continue
-		}
+	log.Println("distributing payback of: ", amount, " for project: ", projIndex, " retaining: ", plan.Retained)
+	plan = project.executePlan(plan, recipientSeed)
+
+	err = project.Record(ProjectEvent{Type: EventPaymentsDistributed, Amount: amount, Plan: &plan})
+	if err != nil {
+		return errors.Wrap(err, "couldn't record distribution of payments")
 	}
 	return nil
 }
@@ -606,91 +627,90 @@ func (project Project) CalculatePayback(amount float64) float64 {
 	return amountPB
 }
 
-// monitorPaybacks monitors whether the user is paying back regularly towards the given project
-// thread has to be isolated since if this fails, we stop tracking paybacks by the recipient.
-func monitorPaybacks(recpIndex int, projIndex int) {
-	for {
-		project, err := RetrieveProject(projIndex)
-		if err != nil {
-			log.Println("Couldn't retrieve project")
-			time.Sleep(consts.OneWeekInSecond)
-		}
+// MonthlyBill returns the amount the recipient owes for energy consumed between start and end (unix times)
+// using the tariff schedule in effect for the project during the metering period
+func (project Project) MonthlyBill(energy uint32, start int64, end int64) (float64, error) {
+	return oracle.Bill(project.State, project.Country, project.TariffOverride, float64(energy),
+		time.Unix(start, 0), time.Unix(end, 0))
+}
 
-		recipient, err := RetrieveRecipient(recpIndex)
-		if err != nil {
-			log.Println("Couldn't retrieve recipient")
-			time.Sleep(consts.OneWeekInSecond)
-		}
+// SetTariffOverride sets the tariff schedule the recipient of a project is billed with instead of the one for
+// the project's State / Country. The schedule must exist in the tariff provider, an empty name removes the override
+func SetTariffOverride(projIndex int, name string) error {
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
 
-		guarantor, err := RetrieveEntity(project.GuarantorIndex)
+	if name != "" {
+		_, err = oracle.CurrentProvider().Named(name)
 		if err != nil {
-			log.Println("couldn't retrieve guarantor")
-			time.Sleep(consts.OneWeekInSecond)
+			return errors.Wrap(err, "couldn't find tariff schedule")
 		}
+	}
 
-		period := float64(time.Duration(project.PaybackPeriod) * consts.OneWeekInSecond) // in seconds due to the const
-		if period == 0 {
-			period = 1 // for the test suite
-		}
-		timeElapsed := utils.Unix() - project.DateLastPaid // this would be in seconds (unix time)
-		if project.DateLastPaid == 0 {
-			timeElapsed = 0
-		}
-		factor := float64(timeElapsed) / period
-		project.AmountOwed += factor * oracle.MonthlyBill() * float64(recipient.TellerEnergy) // add the amount owed only if the time elapsed is more than one payback period
-		// Reputation adjustments based on payback history:
-		if factor <= 1 {
-			// don't do anything since the user has been paying back regularly
-			log.Println("User: ", recipient.U.Email, "is on track paying towards order: ", projIndex)
-			// maybe even update reputation here on a fractional basis depending on a user's timely payments
-		} else if factor > NormalThreshold && factor < AlertThreshold {
-			// person has not paid back for one-two consecutive period, send gentle reminder
-			notif.SendNicePaybackAlertEmail(projIndex, recipient.U.Email)
-			time.Sleep(consts.OneWeekInSecond)
-		} else if factor >= SternAlertThreshold && factor < DisconnectionThreshold {
-			// person has not paid back for four consecutive cycles, send reminder
-			notif.SendSternPaybackAlertEmail(projIndex, recipient.U.Email)
-			for _, i := range project.InvestorIndices {
-				// send an email to recipients to assure them that we're on the issue and will be acting
-				// soon if the recipient fails to pay again.
-				investor, err := RetrieveInvestor(i)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				if investor.U.Notification {
-					notif.SendSternPaybackAlertEmailI(projIndex, investor.U.Email)
-				}
-			}
-			notif.SendSternPaybackAlertEmailG(projIndex, guarantor.U.Email)
-			time.Sleep(consts.OneWeekInSecond)
-		} else if factor >= DisconnectionThreshold {
-			// send a disconnection notice to the recipient and let them know we have redirected
-			// power towards the grid.
-			for _, i := range project.InvestorIndices {
-				// send an email to investors on teller disconnection
-				investor, err := RetrieveInvestor(i)
-				if err != nil {
-					log.Println(err)
-					time.Sleep(consts.OneWeekInSecond)
-					continue
-				}
-				if investor.U.Notification {
-					notif.SendDisconnectionEmailI(projIndex, investor.U.Email)
-				}
-			}
-			// we have sent out emails to investors, send an email to the guarantor and cover first losses of investors
-			notif.SendDisconnectionEmailG(projIndex, guarantor.U.Email)
-			err = CoverFirstLoss(project.Index, guarantor.U.Index, project.AmountOwed)
-			if err != nil {
-				log.Println(err)
-				time.Sleep(consts.OneWeekInSecond)
-				continue
-			}
-		}
+	return project.Update("tariff override set", map[string]interface{}{"TariffOverride": name})
+}
+
+// meteringPeriod returns the start and end of the current metering period, which starts at the
+// last payment or one payback period ago if the recipient hasn't paid yet
+func (project Project) meteringPeriod() (int64, int64) {
+	end := clock()
+	start := project.DateLastPaid
+	if start == 0 || start >= end {
+		start = end - int64(time.Duration(project.PaybackPeriod)*consts.OneWeekInSecond/time.Second)
+	}
+	if start >= end {
+		start = end - int64(consts.PaybackInterval) // PaybackPeriod not set, use the default interval
+	}
+	return start, end
+}
 
-		time.Sleep(consts.OneWeekInSecond) // poll every week to check progress on payments
+// checkPayback checks whether the recipient is paying back regularly towards the project the job belongs to.
+// Run by the scheduler every week once the project has been funded
+func checkPayback(job Job) (bool, error) {
+	projIndex := job.ProjIndex
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if project.Stage >= Stage9.Number {
+		// the project has been paid off, nothing left to monitor
+		return true, nil
+	}
+
+	recipient, err := RetrieveRecipient(project.RecipientIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't retrieve recipient")
 	}
+
+	factor := project.paybackFactor(clock(), 0)
+	start, end := project.meteringPeriod()
+	energy := project.EnergyConsumed(start, end, recipient.TellerEnergy)
+	bill, err := project.MonthlyBill(energy, start, end)
+	if err != nil {
+		log.Println("couldn't compute bill from tariff schedule, falling back to default rate: ", err)
+		bill = oracle.MonthlyBill() * float64(energy)
+	}
+	project.AmountOwed += factor * bill // add the amount owed only if the time elapsed is more than one payback period
+	if len(project.Schedule) != 0 {
+		// the recipient owes the installments that are due and haven't been paid
+		project.AmountOwed = project.overdue(clock())
+	}
+	if factor <= NormalThreshold {
+		// don't do anything since the user has been paying back regularly
+		log.Println("User: ", recipient.U.Email, "is on track paying towards order: ", projIndex)
+		// maybe even update reputation here on a fractional basis depending on a user's timely payments
+	}
+
+	// alerts, disconnection and first loss cover are taken care of by the delinquency state machine
+	err = project.UpdateDelinquency(factor, project.AmountOwed)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't update delinquency state")
+	}
+
+	return false, nil
 }
 
 // addWaterfallAccount adds a waterfall account that the recipient must payback towards
@@ -699,11 +719,12 @@ func addWaterfallAccount(projIndex int, pubkey string, amount float64) error {
 	if err != nil {
 		return errors.Wrap(err, "could not retrieve project, quitting")
 	}
-	if project.WaterfallMap == nil {
-		project.WaterfallMap = make(map[string]float64)
+	waterfallMap := make(map[string]float64)
+	for key, x := range project.WaterfallMap {
+		waterfallMap[key] = x
 	}
-	project.WaterfallMap[pubkey] = amount
-	return project.Save()
+	waterfallMap[pubkey] = amount
+	return project.Update("waterfall account added", map[string]interface{}{"WaterfallMap": waterfallMap})
 }
 
 // CoverFirstLoss covers first loss for investors by sending funds from the guarantor's account
@@ -728,7 +749,7 @@ func CoverFirstLoss(projIndex int, entityIndex int, amount float64) error {
 		amount = entity.FirstLossGuaranteeAmt
 	}
 	// we now need to send funds from the gurantor's account to the escrow
-	seed, err := wallet.DecryptSeed(entity.U.StellarWallet.EncryptedSeed, entity.FirstLossGuarantee) //
+	seed, err := ledger.DecryptSeed(entity.U.StellarWallet.EncryptedSeed, entity.FirstLossGuarantee) //
 	if err != nil {
 		return errors.Wrap(err, "could not decrypt seed, quitting!")
 	}
@@ -736,12 +757,12 @@ func CoverFirstLoss(projIndex int, entityIndex int, amount float64) error {
 	var txhash string
 	// we have the escrow's pubkey, transfer funds to the escrow
 	if !consts.Mainnet {
-		_, txhash, err = assets.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey, project.EscrowPubkey, amount, seed, "first loss guarantee")
+		txhash, err = ledger.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey, project.EscrowPubkey, amount, seed, "first loss guarantee")
 		if err != nil {
 			return errors.Wrap(err, "could not transfer asset to escrow, quitting")
 		}
 	} else {
-		_, txhash, err = assets.SendAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, project.EscrowPubkey, amount, seed, "first loss guarantee")
+		txhash, err = ledger.SendAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, project.EscrowPubkey, amount, seed, "first loss guarantee")
 		if err != nil {
 			return errors.Wrap(err, "could not transfer asset to escrow, quitting")
 		}
@@ -749,5 +770,5 @@ func CoverFirstLoss(projIndex int, entityIndex int, amount float64) error {
 
 	log.Println("txhash of guarantor kick in:", txhash)
 
-	return nil
+	return project.Record(ProjectEvent{Type: EventFirstLossCovered, UserIndex: entityIndex, Amount: amount, TxHash: txhash})
 }
@@ -35,23 +35,9 @@ func (contractor *Entity) Propose(panelSize string, totalValue float64, location
 	return pc, err
 }
 
-// AddCollateral adds a collateral that can be used as guarantee in case the contractor reneges on a particular contract
-func (contractor *Entity) AddCollateral(amount float64, data string) error {
-	contractor.Collateral += amount
-	contractor.CollateralData = append(contractor.CollateralData, data)
-	return contractor.Save()
-}
-
-// Slash slashes the contractor's reputation in the event of bad behaviour.
-func (contractor *Entity) Slash(contractValue float64) error {
-	// slash an entity's reputation score if it reneges on an agreed contract
-	contractor.U.Reputation -= contractValue * 0.1
-	return contractor.Save()
-}
-
-// RepInstalledProject automatically adds reputation to the contractor on installation of a project.
+// RepInstalledProject moves a project to stage 5 on installation, which updates the contractor's reputation.
 func RepInstalledProject(contrIndex int, projIndex int) error {
-	contractor, err := RetrieveEntity(contrIndex)
+	_, err := RetrieveEntity(contrIndex)
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve all entities from db")
 	}
@@ -65,7 +51,5 @@ func RepInstalledProject(contrIndex int, projIndex int) error {
 	if err != nil {
 		return errors.Wrap(err, "couldn't set installed project's stage")
 	}
-
-	contractor.U.Reputation += project.TotalValue * ContractorWeight
-	return contractor.Save()
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/boltdb/bolt"
 
 	consts "github.com/YaleOpenLab/opensolar/consts"
+	notif "github.com/YaleOpenLab/opensolar/notif"
 )
 
 // InvestorBucket is the investor bucket
@@ -21,11 +22,52 @@ var ProjectsBucket = []byte("Projects")
 // ContractorBucket is the contractor bucket
 var ContractorBucket = []byte("Contractors")
 
+// EventsBucket is the bucket where project events are stored
+var EventsBucket = []byte("Events")
+
+// EventLogsBucket is the bucket where the indices of each project's events are stored
+var EventLogsBucket = []byte("EventLogs")
+
+// JobsBucket is the bucket where scheduled jobs are stored
+var JobsBucket = []byte("Jobs")
+
+// ReadingsBucket is the bucket where energy readings reported by project devices are stored
+var ReadingsBucket = []byte("Readings")
+
+// StatementsBucket is the bucket where billing statements sent to recipients are stored
+var StatementsBucket = []byte("Statements")
+
+// OrdersBucket is the bucket where secondary market orders are stored
+var OrdersBucket = []byte("Orders")
+
+// TradesBucket is the bucket where secondary market trades are stored
+var TradesBucket = []byte("Trades")
+
+// AuctionsBucket is the bucket where auctions among contractors are stored
+var AuctionsBucket = []byte("Auctions")
+
+// BidsBucket is the bucket where contractor bids in auctions are stored
+var BidsBucket = []byte("Bids")
+
+// FeedbackBucket is the bucket where feedback left by project parties is stored
+var FeedbackBucket = []byte("Feedback")
+
+// WebhooksBucket is the bucket where webhook subscriptions are stored
+var WebhooksBucket = []byte("Webhooks")
+
+// DeliveriesBucket is the bucket where webhook deliveries and their logs are stored
+var DeliveriesBucket = []byte("Deliveries")
+
+// RefundsBucket is the bucket where refunds of investments in cancelled or demoted projects are stored
+var RefundsBucket = []byte("Refunds")
+
 // CreateHomeDir creates a home directory
 func CreateHomeDir() {
-	edb.CreateDirs(consts.HomeDir, consts.DbDir, consts.OpenSolarIssuerDir)
+	edb.CreateDirs(consts.HomeDir, consts.DbDir, consts.OpenSolarIssuerDir, collateralSignerDir(), consts.TariffDir)
 	log.Println("creating db at: ", consts.DbDir+consts.DbName)
-	db, err := edb.CreateDB(consts.DbDir+consts.DbName, ProjectsBucket, InvestorBucket, RecipientBucket, ContractorBucket)
+	db, err := edb.CreateDB(consts.DbDir+consts.DbName, ProjectsBucket, InvestorBucket, RecipientBucket, ContractorBucket, EventsBucket, EventLogsBucket, JobsBucket, ReadingsBucket, StatementsBucket,
+		OrdersBucket, TradesBucket, AuctionsBucket, BidsBucket, FeedbackBucket, notif.OutboxBucket,
+		notif.PreferencesBucket, WebhooksBucket, DeliveriesBucket, RefundsBucket)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"encoding/json"
+	"log"
 
 	"github.com/pkg/errors"
 
@@ -396,8 +397,9 @@ func SaveOriginatorMoU(projIndex int, hash string) error {
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve project")
 	}
-	a.StageData = append(a.StageData, hash)
-	return a.Save()
+	return a.Update("stage data added", map[string]interface{}{
+		"StageData": append(append([]string(nil), a.StageData...), hash),
+	})
 }
 
 // SaveContractHash saves a contract's hash in the database
@@ -406,8 +408,9 @@ func SaveContractHash(projIndex int, hash string) error {
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve project")
 	}
-	a.StageData = append(a.StageData, hash)
-	return a.Save()
+	return a.Update("stage data added", map[string]interface{}{
+		"StageData": append(append([]string(nil), a.StageData...), hash),
+	})
 }
 
 // SaveInvPlatformContract saves the investor-platform contract's hash in the database
@@ -416,8 +419,9 @@ func SaveInvPlatformContract(projIndex int, hash string) error {
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve project")
 	}
-	a.StageData = append(a.StageData, hash)
-	return a.Save()
+	return a.Update("stage data added", map[string]interface{}{
+		"StageData": append(append([]string(nil), a.StageData...), hash),
+	})
 }
 
 // SaveRecPlatformContract saves the recipient-platform contract's hash in the database
@@ -426,8 +430,9 @@ func SaveRecPlatformContract(projIndex int, hash string) error {
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve project")
 	}
-	a.StageData = append(a.StageData, hash)
-	return a.Save()
+	return a.Update("stage data added", map[string]interface{}{
+		"StageData": append(append([]string(nil), a.StageData...), hash),
+	})
 }
 
 // MarkFlagged is used by an admin to mark the project as flagged
@@ -437,13 +442,20 @@ func MarkFlagged(projIndex int, adminIndex int) error {
 		return errors.Wrap(err, "couldn't retrieve project")
 	}
 
-	if a.Reports > consts.ProjectReportThreshold {
-		a.AdminFlagged = true
-		a.FlaggedBy = adminIndex
-	} else {
+	if a.Reports <= consts.ProjectReportThreshold {
 		return errors.New("project hasn't reached report threshold yet")
 	}
-	return a.Save()
+
+	err = a.Update("flagged by admin", map[string]interface{}{"AdminFlagged": true, "FlaggedBy": adminIndex})
+	if err != nil {
+		return err
+	}
+
+	err = fireWebhooks(WebhookProjectFlagged, a, map[string]interface{}{"FlaggedBy": adminIndex, "Reports": a.Reports})
+	if err != nil {
+		log.Println("couldn't fire webhooks for flagged project: ", projIndex, err)
+	}
+	return nil
 }
 
 // UserMarkFlagged is used by users to mark the project as flagged
@@ -453,9 +465,10 @@ func UserMarkFlagged(projIndex int, userIndex int) error {
 		return errors.Wrap(err, "couldn't retrieve project")
 	}
 
-	a.UserFlaggedBy = append(a.UserFlaggedBy, userIndex)
-	a.Reports += 1
-	return a.Save()
+	return a.Update("flagged by user", map[string]interface{}{
+		"UserFlaggedBy": append(append([]int(nil), a.UserFlaggedBy...), userIndex),
+		"Reports":       a.Reports + 1,
+	})
 }
 
 // AddTellerDetails adds teller details to the backend
@@ -465,9 +478,16 @@ func AddTellerDetails(projIndex int, url string, brokerurl string, topic string)
 		return errors.Wrap(err, "couldn't retrieve project")
 	}
 
-	a.TellerUrl = url
-	a.BrokerUrl = brokerurl
-	a.TellerPublishTopic = topic
+	err = a.Update("teller details added", map[string]interface{}{
+		"TellerUrl":          url,
+		"BrokerUrl":          brokerurl,
+		"TellerPublishTopic": topic,
+	})
+	if err != nil {
+		return err
+	}
 
-	return a.Save()
+	// start storing the readings the teller publishes
+	trackIfConfigured(a)
+	return nil
 }
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	notif "github.com/YaleOpenLab/opensolar/notif"
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+// the delinquency states a funded project can be in. The state is decided by the number of payback periods
+// that have passed since the recipient last paid back towards the project
+const (
+	// DelinquencyCurrent means the recipient is paying back regularly
+	DelinquencyCurrent = "current"
+	// DelinquencyGrace means the recipient has missed a payback period (NormalThreshold to AlertThreshold)
+	DelinquencyGrace = "grace"
+	// DelinquencyLate means the recipient is late by AlertThreshold to SternAlertThreshold periods
+	DelinquencyLate = "late"
+	// DelinquencyStern means the recipient is late by SternAlertThreshold to DisconnectionThreshold periods
+	DelinquencyStern = "stern"
+	// DelinquencyDefaulted means the recipient is late by DisconnectionThreshold periods or more
+	DelinquencyDefaulted = "defaulted"
+	// DelinquencyCured means the recipient paid back after being late, stern or defaulted
+	DelinquencyCured = "cured"
+)
+
+// the actions taken when a project moves to a new delinquency state. Each action is taken once per transition
+const (
+	// ActionNiceAlert sends the recipient a gentle reminder
+	ActionNiceAlert = "nicealert"
+	// ActionLateAlert sends the recipient a reminder that multiple paybacks are overdue
+	ActionLateAlert = "latealert"
+	// ActionSternAlert warns the recipient of disconnection and lets investors and the guarantor know
+	ActionSternAlert = "sternalert"
+	// ActionDisconnection lets the recipient, investors and the guarantor know that power has been redirected
+	ActionDisconnection = "disconnection"
+	// ActionCoverFirstLoss has the guarantor cover first loss for the project's investors
+	ActionCoverFirstLoss = "coverfirstloss"
+	// ActionCuredAlert lets the recipient and the guarantor know that the project is back on track
+	ActionCuredAlert = "curedalert"
+)
+
+// DelinquencyTransition is a change in the delinquency state of a project
+type DelinquencyTransition struct {
+	// From is the state the project was in before the transition
+	From string
+
+	// To is the state the project moved to
+	To string
+
+	// Timestamp is the unix time at which the transition happened
+	Timestamp int64
+
+	// Factor is the number of payback periods since the recipient last paid back
+	Factor float64
+
+	// AmountOwed is the amount owed by the recipient at the time of the transition
+	AmountOwed float64
+
+	// Actions is an action: done map of the actions associated with the transition. Actions that fail are
+	// retried on the next check until they succeed
+	Actions map[string]bool
+}
+
+// NextDelinquencyState returns the delinquency state a project in state current should move to given the
+// number of payback periods (factor) since the recipient last paid back
+func NextDelinquencyState(current string, factor float64) string {
+	if factor >= DisconnectionThreshold {
+		return DelinquencyDefaulted
+	} else if factor >= SternAlertThreshold {
+		return DelinquencyStern
+	} else if factor >= AlertThreshold {
+		return DelinquencyLate
+	} else if factor > NormalThreshold {
+		return DelinquencyGrace
+	}
+
+	switch current {
+	case DelinquencyLate, DelinquencyStern, DelinquencyDefaulted:
+		return DelinquencyCured
+	}
+	return DelinquencyCurrent
+}
+
+// DelinquencyActions returns the actions taken when a project moves to the given delinquency state
+func DelinquencyActions(state string) []string {
+	switch state {
+	case DelinquencyGrace:
+		return []string{ActionNiceAlert}
+	case DelinquencyLate:
+		return []string{ActionLateAlert}
+	case DelinquencyStern:
+		return []string{ActionSternAlert}
+	case DelinquencyDefaulted:
+		return []string{ActionDisconnection, ActionCoverFirstLoss}
+	case DelinquencyCured:
+		return []string{ActionCuredAlert}
+	}
+	return nil
+}
+
+// UpdateDelinquency moves the project to the delinquency state corresponding to factor, records the transition
+// and takes the actions associated with it. Actions that haven't succeeded yet are retried on every call, actions
+// that have succeeded are never taken again.
+func (a *Project) UpdateDelinquency(factor float64, amountOwed float64) error {
+	current := a.Delinquency
+	if current == "" {
+		current = DelinquencyCurrent
+	}
+
+	next := NextDelinquencyState(current, factor)
+	if next != current {
+		var transition DelinquencyTransition
+		transition.From = current
+		transition.To = next
+		transition.Timestamp = clock()
+		transition.Factor = factor
+		transition.AmountOwed = amountOwed
+		transition.Actions = make(map[string]bool)
+		for _, action := range DelinquencyActions(next) {
+			transition.Actions[action] = false
+		}
+		a.DelinquencyHistory = append(a.DelinquencyHistory, transition)
+		a.Delinquency = next
+		log.Println("project: ", a.Index, " moved from delinquency state ", current, " to ", next)
+	}
+
+	var actionErr error
+	if len(a.DelinquencyHistory) != 0 {
+		transition := &a.DelinquencyHistory[len(a.DelinquencyHistory)-1]
+		for _, action := range DelinquencyActions(transition.To) {
+			if transition.Actions[action] {
+				continue
+			}
+			err := a.takeDelinquencyAction(action, transition.AmountOwed)
+			if err != nil {
+				log.Println("couldn't take delinquency action: ", action, err)
+				actionErr = errors.Wrap(err, "couldn't take delinquency action "+action)
+				continue
+			}
+			transition.Actions[action] = true
+		}
+	}
+
+	// only the delinquency fields are updated since actions (eg. covering first loss) update the stored project
+	project, err := RetrieveProject(a.Index)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+	err = project.Update("delinquency updated", map[string]interface{}{
+		"Delinquency":        a.Delinquency,
+		"DelinquencyHistory": a.DelinquencyHistory,
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldn't update project")
+	}
+
+	return actionErr
+}
+
+// takeDelinquencyAction takes a single delinquency action
+func (a Project) takeDelinquencyAction(action string, amountOwed float64) error {
+	recipient, err := RetrieveRecipient(a.RecipientIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve recipient")
+	}
+
+	var guarantorIndex int
+	var guarantorEmail string
+	guarantor, err := RetrieveEntity(a.GuarantorIndex)
+	if err != nil || guarantor.U == nil {
+		log.Println("couldn't retrieve guarantor: ", err)
+	} else {
+		guarantorIndex = guarantor.U.Index
+		guarantorEmail = guarantor.U.Email
+	}
+
+	switch action {
+	case ActionNiceAlert:
+		return notif.SendNicePaybackAlertEmail(a.Index, recipient.U.Index, recipient.U.Email)
+	case ActionLateAlert:
+		return notif.SendLatePaybackAlertEmail(a.Index, recipient.U.Index, recipient.U.Email)
+	case ActionSternAlert:
+		for _, investor := range a.notifiedInvestors() {
+			// send an email to investors to assure them that we're on the issue and will be acting
+			// soon if the recipient fails to pay again.
+			notif.SendSternPaybackAlertEmailI(a.Index, investor.Index, investor.Email)
+		}
+		if guarantorEmail != "" {
+			notif.SendSternPaybackAlertEmailG(a.Index, guarantorIndex, guarantorEmail)
+		}
+		return notif.SendSternPaybackAlertEmail(a.Index, recipient.U.Index, recipient.U.Email)
+	case ActionDisconnection:
+		for _, investor := range a.notifiedInvestors() {
+			notif.SendDisconnectionEmailI(a.Index, investor.Index, investor.Email)
+		}
+		if guarantorEmail != "" {
+			notif.SendDisconnectionEmailG(a.Index, guarantorIndex, guarantorEmail)
+		}
+		return notif.SendDisconnectionEmail(a.Index, recipient.U.Index, recipient.U.Email)
+	case ActionCoverFirstLoss:
+		if guarantorIndex == 0 {
+			return errors.New("project doesn't have a guarantor to cover first loss")
+		}
+		return CoverFirstLoss(a.Index, guarantorIndex, amountOwed)
+	case ActionCuredAlert:
+		if guarantorEmail != "" {
+			notif.SendDelinquencyCuredEmail(a.Index, guarantorIndex, guarantorEmail)
+		}
+		return notif.SendDelinquencyCuredEmail(a.Index, recipient.U.Index, recipient.U.Email)
+	}
+	return errors.New("unknown delinquency action: " + action)
+}
+
+// notifiedInvestors returns the project's investors who want notifications
+func (a Project) notifiedInvestors() []openx.User {
+	var users []openx.User
+	for _, i := range a.InvestorIndices {
+		investor, err := RetrieveInvestor(i)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if notifies(*investor.U) {
+			users = append(users, *investor.U)
+		}
+	}
+	return users
+}
@@ -0,0 +1,41 @@
+// +build all travis
+
+package core
+
+import (
+	"testing"
+)
+
+func TestNextDelinquencyState(t *testing.T) {
+	cases := []struct {
+		current string
+		factor  float64
+		next    string
+	}{
+		{DelinquencyCurrent, 0.5, DelinquencyCurrent},
+		{DelinquencyCurrent, 1.5, DelinquencyGrace},
+		{DelinquencyGrace, 3, DelinquencyLate},
+		{DelinquencyLate, 4, DelinquencyStern},
+		{DelinquencyStern, 6, DelinquencyDefaulted},
+		{DelinquencyDefaulted, 7, DelinquencyDefaulted},
+		{DelinquencyGrace, 0, DelinquencyCurrent},
+		{DelinquencyLate, 0, DelinquencyCured},
+		{DelinquencyDefaulted, 0, DelinquencyCured},
+		{DelinquencyCured, 0, DelinquencyCurrent},
+	}
+
+	for _, x := range cases {
+		next := NextDelinquencyState(x.current, x.factor)
+		if next != x.next {
+			t.Fatalf("%s with factor %f moved to %s, expected %s", x.current, x.factor, next, x.next)
+		}
+	}
+
+	actions := DelinquencyActions(DelinquencyDefaulted)
+	if len(actions) != 2 || actions[1] != ActionCoverFirstLoss {
+		t.Fatalf("defaulting doesn't cover first loss")
+	}
+	if len(DelinquencyActions(DelinquencyCurrent)) != 0 {
+		t.Fatalf("actions taken for a current project")
+	}
+}
@@ -6,10 +6,6 @@ import (
 	"github.com/pkg/errors"
 
 	utils "github.com/Varunram/essentials/utils"
-	xlm "github.com/Varunram/essentials/xlm"
-	assets "github.com/Varunram/essentials/xlm/assets"
-	escrow "github.com/Varunram/essentials/xlm/escrow"
-	wallet "github.com/Varunram/essentials/xlm/wallet"
 	consts "github.com/YaleOpenLab/opensolar/consts"
 )
 
@@ -66,14 +62,14 @@ func RequestWaterfallWithdrawal(entityIndex int, projIndex int, amount float64)
 		return err
 	}
 
-	recpSeed, err := wallet.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, project.OneTimeUnlock)
+	recpSeed, err := ledger.DecryptSeed(recipient.U.StellarWallet.EncryptedSeed, project.OneTimeUnlock)
 	if err != nil {
 		log.Println(err)
 		return errors.Wrap(err, "error while decrpyting seed")
 	}
 
 	if consts.Mainnet {
-		susdbalancex := xlm.GetAssetBalance(project.EscrowPubkey, consts.StablecoinCode)
+		susdbalancex := ledger.GetAssetBalance(project.EscrowPubkey, consts.StablecoinCode)
 		susdbalance, err := utils.ToFloat(susdbalancex)
 		if err != nil {
 			log.Println(err)
@@ -86,18 +82,18 @@ func RequestWaterfallWithdrawal(entityIndex int, projIndex int, amount float64)
 		}
 
 		// we do have the required amount of funds, trust asset from developer's end and transfer funds
-		_, err = assets.TrustAsset(consts.StablecoinCode, consts.PlatformPublicKey, amount*2, recpSeed)
+		_, err = ledger.TrustAsset(consts.StablecoinCode, consts.PlatformPublicKey, amount*2, recpSeed)
 		if err != nil {
 			return errors.Wrap(err, "Error while trusting debt asset")
 		}
 
-		err = escrow.SendAssetsFromEscrow(project.EscrowPubkey, entity.U.StellarWallet.PublicKey, recpSeed, consts.PlatformSeed, amount, "withdrawal", consts.StablecoinCode)
+		err = ledger.SendAssetsFromEscrow(project.EscrowPubkey, entity.U.StellarWallet.PublicKey, recpSeed, consts.PlatformSeed, amount, "withdrawal", consts.StablecoinCode)
 		if err != nil {
 			log.Println(err)
 			return err
 		}
 	} else {
-		usdbalancex := xlm.GetAssetBalance(project.EscrowPubkey, consts.AnchorUSDCode)
+		usdbalancex := ledger.GetAssetBalance(project.EscrowPubkey, consts.AnchorUSDCode)
 		usdbalance, err := utils.ToFloat(usdbalancex)
 		if err != nil {
 			log.Println(err)
@@ -109,12 +105,12 @@ func RequestWaterfallWithdrawal(entityIndex int, projIndex int, amount float64)
 			return errors.New("sufficient amount not available in escrow, not transferring funds")
 		}
 
-		_, err = assets.TrustAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, amount*2, recpSeed)
+		_, err = ledger.TrustAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, amount*2, recpSeed)
 		if err != nil {
 			return errors.Wrap(err, "Error while trusting debt asset")
 		}
 
-		err = escrow.SendAssetsFromEscrow(project.EscrowPubkey, entity.U.StellarWallet.PublicKey, recpSeed, consts.PlatformSeed, amount, "withdrawal", consts.AnchorUSDCode)
+		err = ledger.SendAssetsFromEscrow(project.EscrowPubkey, entity.U.StellarWallet.PublicKey, recpSeed, consts.PlatformSeed, amount, "withdrawal", consts.AnchorUSDCode)
 		if err != nil {
 			log.Println(err)
 			return err
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// tellers sign the readings they report with a device key generated when they're installed. The public key is
+// registered along with the teller's device id and the platform only accepts reading batches signed by the
+// registered key whose sequence number is higher than that of the last batch accepted from the teller, so a
+// leaked username and token can't be used to report arbitrary consumption or replay an old batch. Once a key
+// is registered, unsigned readings aren't accepted for the recipient's projects from any device
+
+// TellerBatch is a batch of readings reported by a teller
+type TellerBatch struct {
+	// DeviceID is the id of the teller that reported the batch
+	DeviceID string
+
+	// ProjIndex is the index of the project the teller is installed for
+	ProjIndex int
+
+	// Seq is the sequence number of the batch, which must increase with every batch the teller reports
+	Seq uint64
+
+	// Timestamp is the unix time at which the batch was signed
+	Timestamp int64
+
+	// Energy is the net energy consumed by the recipient since the last batch
+	Energy uint32
+
+	// Readings are the metered readings taken since the last batch (if any)
+	Readings []EnergyReading
+}
+
+// deviceLock makes sure two batches with the same sequence number can't both be accepted. The recipient is
+// retrieved again once the lock is held since the copy passed in could predate the last accepted batch
+var deviceLock sync.Mutex
+
+// reload replaces the recipient with the copy stored in the database. The lock must be held
+func (a *Recipient) reload() error {
+	recipient, err := RetrieveRecipient(a.U.Index)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve recipient")
+	}
+	*a = recipient
+	return nil
+}
+
+// CheckUnsignedReading returns an error if the recipient of a project has registered a device key, in which
+// case readings for the project must be reported in batches signed with the key
+func CheckUnsignedReading(projIndex int) error {
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+	if project.RecipientIndex == 0 {
+		return nil
+	}
+
+	recipient, err := RetrieveRecipient(project.RecipientIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve recipient")
+	}
+	if recipient.DevicePubkey != "" {
+		return errors.New("recipient has registered a device key, readings must be signed with it, quitting")
+	}
+	return nil
+}
+
+// SignBatch returns a batch of readings encoded as json along with the hex encoded signature of the teller's
+// device key over it
+func SignBatch(key ed25519.PrivateKey, batch TellerBatch) ([]byte, string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, "", errors.New("invalid device key, quitting")
+	}
+
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "couldn't marshal reading batch")
+	}
+	return payload, hex.EncodeToString(ed25519.Sign(key, payload)), nil
+}
+
+// RegisterDeviceKey registers the device id and public key of the recipient's teller. A teller that generated
+// a new key can only register it once an admin has reset the key registered before
+func (a *Recipient) RegisterDeviceKey(deviceID string, pubkey string) error {
+	key, err := hex.DecodeString(pubkey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("device key isn't a hex encoded ed25519 public key, quitting")
+	}
+
+	deviceLock.Lock()
+	defer deviceLock.Unlock()
+
+	err = a.reload()
+	if err != nil {
+		return err
+	}
+
+	if a.DevicePubkey != "" && a.DevicePubkey != pubkey {
+		return errors.New("a different device key has already been registered, an admin needs to reset it first")
+	}
+
+	if a.DevicePubkey != pubkey {
+		a.DeviceSeq = 0
+	}
+	a.DeviceId = deviceID
+	a.DevicePubkey = pubkey
+	return a.Save()
+}
+
+// ResetDeviceKey removes the recipient's device key so a reinstalled teller can register a new one
+func (a *Recipient) ResetDeviceKey() error {
+	deviceLock.Lock()
+	defer deviceLock.Unlock()
+
+	err := a.reload()
+	if err != nil {
+		return err
+	}
+
+	a.DevicePubkey = ""
+	a.DeviceSeq = 0
+	return a.Save()
+}
+
+// verifyBatch verifies that a batch was signed by the recipient's teller and hasn't been accepted before. The
+// lock must be held
+func (a Recipient) verifyBatch(payload []byte, signature string) (TellerBatch, error) {
+	var batch TellerBatch
+	if a.DevicePubkey == "" {
+		return batch, errors.New("recipient hasn't registered a device key, quitting")
+	}
+
+	key, err := hex.DecodeString(a.DevicePubkey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return batch, errors.New("registered device key is invalid, quitting")
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), payload, sig) {
+		return batch, errors.New("reading batch isn't signed by the registered device key, quitting")
+	}
+
+	err = json.Unmarshal(payload, &batch)
+	if err != nil {
+		return batch, errors.Wrap(err, "could not unmarshal reading batch")
+	}
+
+	if batch.DeviceID != a.DeviceId {
+		return batch, errors.New("reading batch reported by unregistered device: " + batch.DeviceID)
+	}
+	if batch.Seq <= a.DeviceSeq {
+		return batch, errors.New("reading batch sequence number " + strconv.FormatUint(batch.Seq, 10) +
+			" isn't higher than the last accepted " + strconv.FormatUint(a.DeviceSeq, 10) + ", quitting")
+	}
+	return batch, nil
+}
+
+// recordedPositions returns the positions of the readings of a batch that have already been recorded
+func (a TellerBatch) recordedPositions() (map[int]bool, error) {
+	readings, err := RetrieveReadings(a.ProjIndex, a.DeviceID, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't retrieve readings")
+	}
+
+	recorded := make(map[int]bool)
+	for _, reading := range readings {
+		if reading.Seq == a.Seq {
+			recorded[reading.Position] = true
+		}
+	}
+	return recorded, nil
+}
+
+// AcceptBatch verifies a batch of readings reported by the recipient's teller and stores the energy consumed
+// and the readings in it
+func (a *Recipient) AcceptBatch(payload []byte, signature string) (TellerBatch, error) {
+	var batch TellerBatch
+	deviceLock.Lock()
+	defer deviceLock.Unlock()
+
+	err := a.reload()
+	if err != nil {
+		return batch, err
+	}
+
+	batch, err = a.verifyBatch(payload, signature)
+	if err != nil {
+		return batch, err
+	}
+
+	if len(batch.Readings) != 0 {
+		project, err := RetrieveProject(batch.ProjIndex)
+		if err != nil {
+			return batch, errors.Wrap(err, "couldn't retrieve project")
+		}
+		if project.RecipientIndex != a.U.Index {
+			return batch, errors.New("recipient indices don't match, quitting")
+		}
+	}
+
+	// the readings are recorded before the sequence number is advanced so a batch that fails halfway can be sent
+	// again. Readings are keyed by the batch's sequence number and their position in it, so the retry only
+	// records the readings that are missing
+	recorded, err := batch.recordedPositions()
+	if err != nil {
+		return batch, err
+	}
+	for i, reading := range batch.Readings {
+		if recorded[i] {
+			continue
+		}
+		reading.ProjIndex = batch.ProjIndex
+		reading.DeviceID = batch.DeviceID
+		reading.Source = "teller"
+		reading.Seq = batch.Seq
+		reading.Position = i
+		_, err = RecordReading(reading)
+		if err != nil {
+			return batch, errors.Wrap(err, "couldn't record reading")
+		}
+	}
+
+	a.TellerEnergy = batch.Energy
+	a.DeviceSeq = batch.Seq
+	err = a.Save()
+	if err != nil {
+		return batch, errors.Wrap(err, "couldn't save recipient")
+	}
+	return batch, nil
+}
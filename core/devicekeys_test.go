@@ -0,0 +1,184 @@
+// +build all travis
+
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+func TestVerifyBatch(t *testing.T) {
+	pubkey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipient := Recipient{DeviceId: "TELLER", DeviceSeq: 4}
+	payload, signature, err := SignBatch(key, TellerBatch{DeviceID: "TELLER", Seq: 5, Energy: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recipient.verifyBatch(payload, signature)
+	if err == nil {
+		t.Fatalf("batch accepted from recipient without a device key")
+	}
+
+	recipient.DevicePubkey = hex.EncodeToString(pubkey)
+	batch, err := recipient.verifyBatch(payload, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Energy != 100 || batch.Seq != 5 {
+		t.Fatalf("batch not decoded: %+v", batch)
+	}
+
+	tampered := []byte(string(payload[:len(payload)-1]) + " }")
+	_, err = recipient.verifyBatch(tampered, signature)
+	if err == nil {
+		t.Fatalf("tampered batch accepted")
+	}
+
+	_, forged, err := SignBatch(otherKey, TellerBatch{DeviceID: "TELLER", Seq: 5, Energy: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recipient.verifyBatch(payload, forged)
+	if err == nil {
+		t.Fatalf("batch signed by another key accepted")
+	}
+
+	payload, signature, err = SignBatch(key, TellerBatch{DeviceID: "OTHER", Seq: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recipient.verifyBatch(payload, signature)
+	if err == nil {
+		t.Fatalf("batch from unregistered device accepted")
+	}
+
+	// batches replayed or reported out of order are rejected
+	for _, seq := range []uint64{3, 4} {
+		payload, signature, err = SignBatch(key, TellerBatch{DeviceID: "TELLER", Seq: seq})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = recipient.verifyBatch(payload, signature)
+		if err == nil {
+			t.Fatalf("batch with sequence number %d accepted after 4", seq)
+		}
+	}
+}
+
+func TestDeviceKeys(t *testing.T) {
+	defer testDb(t)()
+
+	_, users, _, restore := testLedger(t)
+	defer restore()
+
+	user := openx.User{Index: 1, Email: "recipient"}
+	err := users.Add(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := Recipient{U: &user}
+	err = recipient.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := Project{Index: 1, RecipientIndex: 1}
+	err = project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reading := []byte(`{"device_id":"meter","consumed":5}`)
+	_, err = IngestReading(1, reading)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubkey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = recipient.RegisterDeviceKey("teller", hex.EncodeToString(pubkey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unsigned readings are rejected from every device once a key is registered
+	_, err = IngestReading(1, reading)
+	if err == nil || CheckUnsignedReading(1) == nil {
+		t.Fatalf("unsigned reading accepted after registering a device key")
+	}
+
+	stale, err := RetrieveRecipient(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, signature, err := SignBatch(key, TellerBatch{DeviceID: "teller", ProjIndex: 1, Seq: 1, Energy: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recipient.AcceptBatch(payload, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy of the recipient retrieved before the batch was accepted can't be used to replay it
+	_, err = stale.AcceptBatch(payload, signature)
+	if err == nil {
+		t.Fatalf("batch replayed with a stale recipient")
+	}
+
+	payload, signature, err = SignBatch(key, TellerBatch{DeviceID: "teller", ProjIndex: 1, Seq: 2, Energy: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stale.AcceptBatch(payload, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipient, err = RetrieveRecipient(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recipient.DeviceSeq != 2 || recipient.TellerEnergy != 20 {
+		t.Fatalf("accepted batch not saved: %d %d", recipient.DeviceSeq, recipient.TellerEnergy)
+	}
+
+	// a batch that failed after recording its first reading is completed when the teller sends it again
+	batch := TellerBatch{DeviceID: "teller", ProjIndex: 1, Seq: 3, Energy: 30, Readings: []EnergyReading{
+		{Timestamp: 100, Consumed: 1},
+		{Timestamp: 200, Consumed: 2},
+	}}
+	_, err = RecordReading(EnergyReading{ProjIndex: 1, DeviceID: "teller", Timestamp: 100, Consumed: 1, Seq: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, err = SignBatch(key, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recipient.AcceptBatch(payload, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := RetrieveReadings(1, "teller", 0, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 2 || readings[1].Position != 1 || readings[1].Consumed != 2 {
+		t.Fatalf("retried batch not recorded exactly once: %+v", readings)
+	}
+}
@@ -8,8 +8,6 @@ import (
 
 	edb "github.com/Varunram/essentials/database"
 	utils "github.com/Varunram/essentials/utils"
-	xlm "github.com/Varunram/essentials/xlm"
-	wallet "github.com/Varunram/essentials/xlm/wallet"
 	openx "github.com/YaleOpenLab/openx/database"
 
 	consts "github.com/YaleOpenLab/opensolar/consts"
@@ -51,7 +49,8 @@ type Entity struct {
 	// PastFeedback contains a list of all feedback on the given entity
 	PastFeedback []Feedback
 
-	// Collateral is the amount the entity is willing to put up as collateral to secure projects
+	// Collateral is the amount the entity is willing to put up as collateral to secure projects, including the
+	// collateral it holds in project escrows
 	Collateral float64
 
 	// CollateralData contains data on the collateral amount that the entity is willing to pledge
@@ -241,7 +240,7 @@ func AgreeToContractConditions(contractHash string, projIndex string,
 		return errors.Wrap(err, "couldn't retrieve user from db")
 	}
 
-	seed, err := wallet.DecryptSeed(user.StellarWallet.EncryptedSeed, seedpwd)
+	seed, err := ledger.DecryptSeed(user.StellarWallet.EncryptedSeed, seedpwd)
 	if err != nil {
 		return errors.Wrap(err, "couldn't decrypt seed")
 	}
@@ -253,35 +252,33 @@ func AgreeToContractConditions(contractHash string, projIndex string,
 	fourthPart := messageHash[84:112]
 	fifthPart := messageHash[112:140]
 
-	timestamp := float64(utils.Unix())
-
-	_, firstHash, err := xlm.SendXLM(user.StellarWallet.PublicKey, timestamp, seed, firstPart)
+	firstHash, err := ledger.AnchorMemo(user.StellarWallet.PublicKey, seed, firstPart)
 	if err != nil {
 		return errors.Wrap(err, "couldn't send tx 1")
 	}
 
-	_, secondHash, err := xlm.SendXLM(user.StellarWallet.PublicKey, timestamp, seed, secondPart)
+	secondHash, err := ledger.AnchorMemo(user.StellarWallet.PublicKey, seed, secondPart)
 	if err != nil {
 		return errors.Wrap(err, "couldn't send tx 2")
 	}
 
-	_, thirdHash, err := xlm.SendXLM(user.StellarWallet.PublicKey, timestamp, seed, thirdPart)
+	thirdHash, err := ledger.AnchorMemo(user.StellarWallet.PublicKey, seed, thirdPart)
 	if err != nil {
 		return errors.Wrap(err, "couldn't send tx 3")
 	}
 
-	_, fourthHash, err := xlm.SendXLM(user.StellarWallet.PublicKey, timestamp, seed, fourthPart)
+	fourthHash, err := ledger.AnchorMemo(user.StellarWallet.PublicKey, seed, fourthPart)
 	if err != nil {
 		return errors.Wrap(err, "couldn't send tx 4")
 	}
 
-	_, fifthHash, err := xlm.SendXLM(user.StellarWallet.PublicKey, timestamp, seed, fifthPart)
+	fifthHash, err := ledger.AnchorMemo(user.StellarWallet.PublicKey, seed, fifthPart)
 	if err != nil {
 		return errors.Wrap(err, "couldn't send tx 5")
 	}
 
-	if user.Notification {
-		notif.SendContractNotification(firstHash, secondHash, thirdHash, fourthHash, fifthHash, user.Email)
+	if notifies(user) {
+		notif.SendContractNotification(firstHash, secondHash, thirdHash, fourthHash, fifthHash, user.Index, user.Email)
 	}
 
 	return nil
@@ -0,0 +1,620 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// the events below are the only ways in which the financial state of a project changes once it has been created.
+// Each event is appended to the project's event log and the current state of the project is the result of
+// replaying all the events in the log on top of the snapshot recorded when the log was started.
+const (
+	// EventProjectCreated stores a snapshot of the project when its event log is started
+	EventProjectCreated = "ProjectCreated"
+	// EventInvestmentReceived is recorded when an investor (seed or regular) invests in a project
+	EventInvestmentReceived = "InvestmentReceived"
+	// EventProjectFunded is recorded when the recipient accepts the investment and paybacks start
+	EventProjectFunded = "ProjectFunded"
+	// EventPaybackRecorded is recorded when the recipient pays back towards the project
+	EventPaybackRecorded = "PaybackRecorded"
+	// EventStagePromoted is recorded when a project moves to a new stage
+	EventStagePromoted = "StagePromoted"
+	// EventFirstLossCovered is recorded when the guarantor covers first loss for the project's investors
+	EventFirstLossCovered = "FirstLossCovered"
+	// EventPaymentsDistributed is recorded when a payback is distributed to the project's stakeholders
+	EventPaymentsDistributed = "PaymentsDistributed"
+	// EventSharesTransferred is recorded when an investor sells investor assets to another investor
+	EventSharesTransferred = "SharesTransferred"
+	// EventProjectCancelled is recorded when a project that hasn't been funded yet is cancelled
+	EventProjectCancelled = "ProjectCancelled"
+	// EventStageDemoted is recorded when a project that hasn't been funded yet moves back to an earlier stage
+	EventStageDemoted = "StageDemoted"
+	// EventInvestmentRefunded is recorded when an investor is refunded their investment in a project
+	EventInvestmentRefunded = "InvestmentRefunded"
+	// EventCollateralPosted is recorded when a contractor moves collateral into escrow for the project
+	EventCollateralPosted = "CollateralPosted"
+	// EventCollateralReleased is recorded when collateral held in escrow is returned to its contractor
+	EventCollateralReleased = "CollateralReleased"
+	// EventCollateralSlashed is recorded when collateral held in escrow is slashed to the project's escrow
+	EventCollateralSlashed = "CollateralSlashed"
+	// EventProjectUpdated is recorded when fields of a project not covered by the events above change
+	EventProjectUpdated = "ProjectUpdated"
+)
+
+// ProjectEvent is an entry in the append only event log of a project
+type ProjectEvent struct {
+	// Index is the index of the event in the event log (across all projects)
+	Index int
+
+	// ProjIndex is the index of the project this event belongs to
+	ProjIndex int
+
+	// Type is the type of the event (EventInvestmentReceived, EventPaybackRecorded, etc)
+	Type string
+
+	// Timestamp is the unix time at which the event was recorded
+	Timestamp int64
+
+	// UserIndex is the index of the investor, recipient or entity that caused the event
+	UserIndex int
+
+	// FromIndex is the index of the investor who sold investor assets in a share transfer
+	FromIndex int
+
+	// From is the publickey of the investor who sold investor assets in a share transfer
+	From string
+
+	// To is the publickey of the investor who bought investor assets in a share transfer or the account
+	// collateral was moved to
+	To string
+
+	// Price is the price per investor asset paid in a share transfer
+	Price float64
+
+	// Amount is the amount of money associated with the event
+	Amount float64
+
+	// Pct is the ownership percentage shifted to the recipient with a payback
+	Pct float64
+
+	// Principal is the part of a payback that goes towards the principal of the project's loan
+	Principal float64
+
+	// Interest is the part of a payback that goes towards interest
+	Interest float64
+
+	// Seed is set if the investment was a seed investment
+	Seed bool
+
+	// Stage is the stage the project was promoted (or demoted) to
+	Stage int
+
+	// Reason is the reason a project was cancelled, demoted or updated
+	Reason string
+
+	// TxHash is the hash of the transaction on the blockchain associated with the event (if any)
+	TxHash string
+
+	// Snapshot is the json encoded project stored with EventProjectCreated or the json encoded fields that
+	// changed stored with EventProjectUpdated
+	Snapshot []byte
+
+	// Plan is the distribution plan stored with EventPaymentsDistributed
+	Plan *DistributionPlan
+}
+
+// Save saves a ProjectEvent's details
+func (a *ProjectEvent) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, EventsBucket, a, a.Index)
+}
+
+// RetrieveAllEvents retrieves all events from the database
+func RetrieveAllEvents() ([]ProjectEvent, error) {
+	var arr []ProjectEvent
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, EventsBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp ProjectEvent
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// RetrieveEvent retrieves a specific event from the database
+func RetrieveEvent(key int) (ProjectEvent, error) {
+	var event ProjectEvent
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, EventsBucket, key)
+	if err != nil {
+		return event, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &event)
+	return event, err
+}
+
+// eventLog contains the indices of a project's events in the order they were recorded
+type eventLog struct {
+	ProjIndex int
+	Events    []int
+}
+
+// Save saves an eventLog's details
+func (a *eventLog) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, EventLogsBucket, a, a.ProjIndex)
+}
+
+// retrieveEventLog retrieves the indices of a project's events. Projects whose events were recorded before the
+// indices were stored have their events looked up once and their indices stored
+func retrieveEventLog(projIndex int) (eventLog, error) {
+	var history eventLog
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, EventLogsBucket, projIndex)
+	if err == nil && len(x) != 0 {
+		err = json.Unmarshal(x, &history)
+		if err != nil {
+			return history, errors.Wrap(err, "could not unmarshal event log")
+		}
+		return history, nil
+	}
+
+	events, err := RetrieveAllEvents()
+	if err != nil {
+		return history, errors.Wrap(err, "couldn't retrieve events")
+	}
+
+	history.ProjIndex = projIndex
+	history.Events = []int{}
+	for _, event := range events {
+		if event.ProjIndex == projIndex {
+			history.Events = append(history.Events, event.Index)
+		}
+	}
+	sort.Ints(history.Events)
+	return history, history.Save()
+}
+
+// RetrieveProjectEvents retrieves the event log of a specific project ordered by the time of recording
+func RetrieveProjectEvents(projIndex int) ([]ProjectEvent, error) {
+	var arr []ProjectEvent
+	history, err := retrieveEventLog(projIndex)
+	if err != nil {
+		return arr, errors.Wrap(err, "couldn't retrieve event log")
+	}
+
+	for _, index := range history.Events {
+		event, err := RetrieveEvent(index)
+		if err != nil {
+			return arr, errors.Wrap(err, "couldn't retrieve event")
+		}
+		arr = append(arr, event)
+	}
+	return arr, nil
+}
+
+var (
+	// eventLock makes sure two events don't get the same index
+	eventLock sync.Mutex
+	// eventCount is the number of events stored, loaded from the database when the first event is appended
+	eventCount int
+	// eventCountDb is the database eventCount was loaded from
+	eventCountDb string
+)
+
+// appendEvent appends an event to the event log
+func appendEvent(event ProjectEvent) (ProjectEvent, error) {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+
+	if eventCountDb != consts.DbDir+consts.DbName {
+		x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, EventsBucket)
+		if err != nil {
+			return event, errors.Wrap(err, "error while retrieving all keys")
+		}
+		eventCount = len(x)
+		eventCountDb = consts.DbDir + consts.DbName
+	}
+
+	history, err := retrieveEventLog(event.ProjIndex)
+	if err != nil {
+		return event, errors.Wrap(err, "couldn't retrieve event log")
+	}
+
+	event.Index = eventCount + 1
+	if event.Timestamp == 0 {
+		event.Timestamp = clock()
+	}
+	err = event.Save()
+	if err != nil {
+		return event, err
+	}
+	eventCount++
+
+	history.Events = append(history.Events, event.Index)
+	return event, history.Save()
+}
+
+var (
+	// projectLocks make sure events are applied to a project one at a time
+	projectLocks = make(map[int]*sync.Mutex)
+	// projectLocksLock protects projectLocks
+	projectLocksLock sync.Mutex
+)
+
+// lockProject locks a project so that no other event can be recorded for it and returns the function that
+// unlocks it
+func lockProject(projIndex int) func() {
+	projectLocksLock.Lock()
+	lock, exists := projectLocks[projIndex]
+	if !exists {
+		lock = &sync.Mutex{}
+		projectLocks[projIndex] = lock
+	}
+	projectLocksLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// reload replaces the project with the stored project, which is the result of replaying its event log, so
+// events aren't applied to a copy retrieved before other events were recorded. Projects that haven't been
+// stored yet are left as they are. The project's lock must be held
+func (a *Project) reload(history eventLog) error {
+	stored, err := RetrieveProject(a.Index)
+	if err != nil {
+		if len(history.Events) != 0 {
+			return errors.Wrap(err, "couldn't retrieve project")
+		}
+		return nil
+	}
+	*a = stored
+	return nil
+}
+
+// Record appends an event to the project's event log, applies it to the stored project and saves the project,
+// which the caller's copy is replaced with. If the project doesn't have an event log yet, a snapshot of the
+// project is recorded first so that replaying the log starts from the state the project was in before this event.
+func (a *Project) Record(event ProjectEvent) error {
+	defer lockProject(a.Index)()
+	return a.record(event)
+}
+
+// record records an event. The project's lock must be held
+func (a *Project) record(event ProjectEvent) error {
+	history, err := retrieveEventLog(a.Index)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve event log")
+	}
+
+	err = a.reload(history)
+	if err != nil {
+		return err
+	}
+
+	if len(history.Events) == 0 {
+		// the recipient's seedpwd is never recorded in the event log
+		project := *a
+		project.LockPwd = ""
+		project.OneTimeUnlock = ""
+		snapshot, err := json.Marshal(project)
+		if err != nil {
+			return errors.Wrap(err, "couldn't marshal project snapshot")
+		}
+		_, err = appendEvent(ProjectEvent{ProjIndex: a.Index, Type: EventProjectCreated, Snapshot: snapshot})
+		if err != nil {
+			return errors.Wrap(err, "couldn't record project snapshot")
+		}
+	}
+
+	event.ProjIndex = a.Index
+	event, err = appendEvent(event)
+	if err != nil {
+		return errors.Wrap(err, "couldn't record event")
+	}
+
+	err = a.Apply(event)
+	if err != nil {
+		return errors.Wrap(err, "couldn't apply event")
+	}
+
+	err = a.Save()
+	if err != nil {
+		return err
+	}
+
+	// webhooks are fired once the event has been recorded, failing to fire them doesn't undo the event
+	if webhookEvent, exists := webhookEventTypes[event.Type]; exists {
+		err = fireWebhooks(webhookEvent, *a, event)
+		if err != nil {
+			log.Println("couldn't fire webhooks for event: ", event.Index, err)
+		}
+	}
+	return nil
+}
+
+// Update records that fields of the project not covered by the other events changed. fields maps the names of
+// the fields to their new values, which are passed instead of being set on the project. Fields whose value
+// doesn't change aren't recorded and if no field changes, no event is recorded
+func (a *Project) Update(reason string, fields map[string]interface{}) error {
+	defer lockProject(a.Index)()
+
+	history, err := retrieveEventLog(a.Index)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve event log")
+	}
+
+	err = a.reload(history)
+	if err != nil {
+		return err
+	}
+
+	current, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal project")
+	}
+	var project map[string]json.RawMessage
+	err = json.Unmarshal(current, &project)
+	if err != nil {
+		return errors.Wrap(err, "couldn't unmarshal project")
+	}
+
+	changed := make(map[string]json.RawMessage)
+	for name, value := range fields {
+		old, exists := project[name]
+		if !exists {
+			return errors.New("project doesn't have a field named " + name)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return errors.Wrap(err, "couldn't marshal field "+name)
+		}
+		if !bytes.Equal(old, data) {
+			changed[name] = data
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(changed)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal updated fields")
+	}
+	return a.record(ProjectEvent{Type: EventProjectUpdated, Reason: reason, Snapshot: patch})
+}
+
+// patch replaces the fields of the project present in a json encoded patch
+func (a *Project) patch(data []byte) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return errors.Wrap(err, "couldn't unmarshal updated fields")
+	}
+
+	current, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal project")
+	}
+	var project map[string]json.RawMessage
+	err = json.Unmarshal(current, &project)
+	if err != nil {
+		return errors.Wrap(err, "couldn't unmarshal project")
+	}
+
+	for name, value := range fields {
+		if _, exists := project[name]; !exists {
+			return errors.New("project doesn't have a field named " + name)
+		}
+		project[name] = value
+	}
+
+	updated, err := json.Marshal(project)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal updated project")
+	}
+	// unmarshal into an empty project so maps in the patch replace the project's maps instead of being merged
+	var x Project
+	err = json.Unmarshal(updated, &x)
+	if err != nil {
+		return errors.Wrap(err, "couldn't unmarshal updated project")
+	}
+	*a = x
+	return nil
+}
+
+// Apply applies an event to the project. Apply doesn't save the project and must be deterministic
+// since it is used to replay the event log.
+func (a *Project) Apply(event ProjectEvent) error {
+	switch event.Type {
+	case EventProjectCreated:
+		var snapshot Project
+		err := json.Unmarshal(event.Snapshot, &snapshot)
+		if err != nil {
+			return err
+		}
+		*a = snapshot
+	case EventProjectUpdated:
+		return a.patch(event.Snapshot)
+	case EventInvestmentReceived:
+		a.MoneyRaised += event.Amount
+		if event.Seed {
+			a.SeedMoneyRaised += event.Amount * (a.SeedInvestmentFactor - 1)
+		}
+		a.InvestorIndices = append(a.InvestorIndices, event.UserIndex)
+		if event.Seed {
+			a.SeedInvestorIndices = append(a.SeedInvestorIndices, event.UserIndex)
+		}
+	case EventProjectFunded:
+		a.BalLeft = event.Amount
+		a.Stage = event.Stage
+		a.DateFunded = time.Unix(event.Timestamp, 0).Format(time.RFC850)
+		schedule, err := a.GenerateSchedule(event.Timestamp)
+		if err != nil {
+			log.Println("couldn't generate amortization schedule for project: ", a.Index, err)
+			break
+		}
+		a.Schedule = schedule
+	case EventPaybackRecorded:
+		if len(a.Schedule) != 0 {
+			a.applyToSchedule(event.Principal, event.Interest)
+			a.BalLeft -= event.Principal // only the principal part of a payback reduces the balance left
+			if a.BalLeft < 0 {
+				a.BalLeft = 0
+			}
+		} else {
+			a.BalLeft -= (1 - event.Pct) * event.Amount // the balance left should be the percentage paid towards the asset, which is the monthly bill. The rest goes into ownership
+		}
+		a.AmountOwed -= event.Amount // subtract the amount owed so we can track progress of payments in the monitorPaybacks loop
+		a.OwnershipShift += event.Pct
+		a.DateLastPaid = event.Timestamp
+		if a.OwnershipShift >= 1 {
+			// the recipient has paid off the asset completely
+			a.BalLeft = 0
+			a.AmountOwed = 0
+		}
+	case EventStagePromoted:
+		a.Stage = event.Stage
+	case EventFirstLossCovered:
+		a.AmountOwed -= event.Amount
+		if a.AmountOwed < 0 {
+			a.AmountOwed = 0
+		}
+	case EventPaymentsDistributed:
+		if event.Plan == nil {
+			return errors.New("distribution event doesn't contain a plan")
+		}
+		if a.WaterfallPaid == nil {
+			a.WaterfallPaid = make(map[string]float64)
+		}
+		if a.WaterfallArrears == nil {
+			a.WaterfallArrears = make(map[string]float64)
+		}
+		if a.WaterfallOwed == nil {
+			a.WaterfallOwed = make(map[string]float64)
+		}
+		for _, payout := range event.Plan.Owed {
+			if !payout.Failed {
+				a.WaterfallOwed[payout.Pubkey] -= payout.Amount
+			}
+		}
+		for _, tier := range event.Plan.Tiers {
+			a.WaterfallPaid[tier.Name] += tier.Paid
+			a.WaterfallArrears[tier.Name] = tier.Arrears
+			for _, payout := range tier.Payouts {
+				if payout.Failed {
+					a.WaterfallOwed[payout.Pubkey] += payout.Amount
+				}
+			}
+		}
+		for pubkey, amount := range a.WaterfallOwed {
+			if amount < 1e-9 {
+				delete(a.WaterfallOwed, pubkey)
+			}
+		}
+	case EventSharesTransferred:
+		// investor assets are issued one per dollar invested so a share is the amount over the project's value
+		share := event.Amount / a.TotalValue
+		if a.InvestorMap == nil {
+			a.InvestorMap = make(map[string]float64)
+		}
+		a.InvestorMap[event.From] -= share
+		a.InvestorMap[event.To] += share
+		if a.InvestorMap[event.From] < 1e-9 { // rounding errors in the share sold
+			delete(a.InvestorMap, event.From)
+			if a.SeedInvestorMap[event.From] <= 0 {
+				a.InvestorIndices = removeIndex(a.InvestorIndices, event.FromIndex)
+			}
+		}
+		if !containsIndex(a.InvestorIndices, event.UserIndex) {
+			a.InvestorIndices = append(a.InvestorIndices, event.UserIndex)
+		}
+	case EventProjectCancelled:
+		a.Cancelled = true
+		a.Lock = false
+	case EventStageDemoted:
+		a.Stage = event.Stage
+		if a.Stage < Stage4.Number {
+			a.Lock = false // the raise is over, stop waiting for the recipient to accept the investment
+		}
+		// the checklists of the stages the project moved back over have to be completed again
+		for i := event.Stage; i < len(a.StageChecklist); i++ {
+			a.StageChecklist[i] = nil
+		}
+	case EventInvestmentRefunded:
+		a.MoneyRaised -= event.Amount
+		if event.Seed {
+			a.SeedMoneyRaised -= event.Amount * (a.SeedInvestmentFactor - 1)
+			delete(a.SeedInvestorMap, event.From)
+			a.SeedInvestorIndices = removeIndex(a.SeedInvestorIndices, event.UserIndex)
+		} else {
+			delete(a.InvestorMap, event.From)
+		}
+		if a.InvestorMap[event.From] <= 0 && a.SeedInvestorMap[event.From] <= 0 {
+			a.InvestorIndices = removeIndex(a.InvestorIndices, event.UserIndex)
+		}
+	case EventCollateralPosted:
+		if a.CollateralHeld == nil {
+			a.CollateralHeld = make(map[int]float64)
+		}
+		a.CollateralHeld[event.UserIndex] += event.Amount
+	case EventCollateralReleased, EventCollateralSlashed:
+		if a.CollateralHeld == nil {
+			a.CollateralHeld = make(map[int]float64)
+		}
+		a.CollateralHeld[event.UserIndex] -= event.Amount
+		if a.CollateralHeld[event.UserIndex] < 1e-9 {
+			delete(a.CollateralHeld, event.UserIndex)
+		}
+	default:
+		return errors.New("unknown event type: " + event.Type)
+	}
+	return nil
+}
+
+// ReplayProject rebuilds the state of a project from its event log as it was at time until (unix time).
+// An until of zero replays the entire log. The recipient's seedpwd (LockPwd and OneTimeUnlock) is never
+// recorded in the log, so it isn't part of the replayed project.
+func ReplayProject(projIndex int, until int64) (Project, error) {
+	var project Project
+	events, err := RetrieveProjectEvents(projIndex)
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't retrieve project events")
+	}
+
+	if len(events) == 0 {
+		log.Println("project ", projIndex, " has no event log, returning stored project")
+		return RetrieveProject(projIndex)
+	}
+
+	if events[0].Type != EventProjectCreated {
+		return project, errors.New("event log doesn't start with a project snapshot, quitting")
+	}
+
+	for _, event := range events {
+		if until != 0 && event.Timestamp > until {
+			break
+		}
+		err = project.Apply(event)
+		if err != nil {
+			return project, errors.Wrap(err, "couldn't replay event")
+		}
+	}
+
+	return project, nil
+}
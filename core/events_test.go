@@ -0,0 +1,175 @@
+// +build all travis
+
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	utils "github.com/Varunram/essentials/utils"
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// testDb points the platform at an empty database in a temporary directory. The returned function removes it
+func testDb(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "opensolar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homeDir, dbDir, issuerDir, tariffDir := consts.HomeDir, consts.DbDir, consts.OpenSolarIssuerDir, consts.TariffDir
+	consts.HomeDir = dir
+	consts.DbDir = dir + "/database/"
+	consts.OpenSolarIssuerDir = dir + "/projects/"
+	consts.TariffDir = dir + "/tariffs/"
+	CreateHomeDir()
+
+	return func() {
+		consts.HomeDir, consts.DbDir, consts.OpenSolarIssuerDir, consts.TariffDir = homeDir, dbDir, issuerDir, tariffDir
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReplayProject(t *testing.T) {
+	defer testDb(t)()
+
+	project := Project{Index: 1, TotalValue: 1000, SeedInvestmentFactor: 1.1, EstimatedAcquisition: 5,
+		PaybackPeriod: 4, Stage: 4, LockPwd: "seedpwd"}
+	err := project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events are recorded after the snapshot taken when the log is started
+	now := utils.Unix() + 100
+	events := []ProjectEvent{
+		{Type: EventInvestmentReceived, UserIndex: 1, Amount: 100, Seed: true, Timestamp: now},
+		{Type: EventInvestmentReceived, UserIndex: 2, Amount: 900, Timestamp: now + 100},
+		{Type: EventProjectFunded, Amount: 1010, Stage: 5, Timestamp: now + 200},
+		{Type: EventStagePromoted, Stage: 6, Timestamp: now + 300},
+		{Type: EventPaybackRecorded, Amount: 50, Pct: 0.01, Principal: 40, Interest: 10, Timestamp: now + 400},
+		{Type: EventSharesTransferred, From: "A", To: "B", FromIndex: 2, UserIndex: 3, Amount: 100, Timestamp: now + 500},
+	}
+	for _, event := range events {
+		err = project.Record(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = project.Update("test", map[string]interface{}{"Votes": 5, "WaterfallMap": map[string]float64{"A": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Votes != 5 || project.WaterfallMap["A"] != 1 {
+		t.Fatalf("update not applied: %v %v", project.Votes, project.WaterfallMap)
+	}
+
+	// updates that don't change anything aren't recorded
+	err = project.Update("test", map[string]interface{}{"Votes": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = project.Update("test", map[string]interface{}{"NotAField": 5})
+	if err == nil {
+		t.Fatalf("update of a field that doesn't exist accepted")
+	}
+
+	log, err := RetrieveProjectEvents(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != len(events)+2 || log[0].Type != EventProjectCreated || log[len(log)-1].Type != EventProjectUpdated {
+		t.Fatalf("event log doesn't match, got %d events", len(log))
+	}
+	for i := 1; i < len(log); i++ {
+		if log[i].Index <= log[i-1].Index {
+			t.Fatalf("event log out of order")
+		}
+	}
+
+	stored, err := RetrieveProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LockPwd != "seedpwd" {
+		t.Fatalf("seedpwd not saved with the project")
+	}
+
+	replayed, err := ReplayProject(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.LockPwd != "" {
+		t.Fatalf("seedpwd recorded in the event log")
+	}
+
+	stored.LockPwd = ""
+	x, _ := json.Marshal(stored)
+	y, _ := json.Marshal(replayed)
+	if string(x) != string(y) {
+		t.Fatalf("replayed project doesn't match stored project:\n%s\n%s", x, y)
+	}
+
+	replayed, err = ReplayProject(1, now+150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.MoneyRaised != 1000 || replayed.Stage != 4 {
+		t.Fatalf("partial replay doesn't match, got: %f %d", replayed.MoneyRaised, replayed.Stage)
+	}
+}
+
+func TestRecordStaleProject(t *testing.T) {
+	defer testDb(t)()
+
+	project := Project{Index: 1, TotalValue: 1000, Stage: 4}
+	err := project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// events recorded from copies retrieved before other events were recorded are applied to the stored project
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		stale, err := RetrieveProject(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := stale.Record(ProjectEvent{Type: EventInvestmentReceived, UserIndex: i, Amount: 10})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stale := project
+	err = stale.Update("test", map[string]interface{}{"Votes": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.MoneyRaised != 100 {
+		t.Fatalf("caller's copy not replaced with the stored project: %f", stale.MoneyRaised)
+	}
+
+	stored, err := RetrieveProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := ReplayProject(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.MoneyRaised != 100 || len(stored.InvestorIndices) != 10 || stored.Votes != 5 ||
+		replayed.MoneyRaised != stored.MoneyRaised || replayed.Votes != stored.Votes {
+		t.Fatalf("events lost: %f %v %f, replayed %f", stored.MoneyRaised, stored.InvestorIndices, stored.Votes,
+			replayed.MoneyRaised)
+	}
+}
@@ -6,9 +6,6 @@ import (
 	"github.com/pkg/errors"
 
 	utils "github.com/Varunram/essentials/utils"
-	xlm "github.com/Varunram/essentials/xlm"
-	assets "github.com/Varunram/essentials/xlm/assets"
-	wallet "github.com/Varunram/essentials/xlm/wallet"
 	consts "github.com/YaleOpenLab/opensolar/consts"
 )
 
@@ -37,7 +34,7 @@ func (a *Entity) RefillEscrowAsset(projIndex int, asset string, amount float64,
 		return err
 	}
 
-	balancex := xlm.GetAssetBalance(a.U.StellarWallet.PublicKey, asset)
+	balancex := ledger.GetAssetBalance(a.U.StellarWallet.PublicKey, asset)
 	balance, err := utils.ToFloat(balancex)
 	if err != nil {
 		log.Println(err)
@@ -49,13 +46,13 @@ func (a *Entity) RefillEscrowAsset(projIndex int, asset string, amount float64,
 		amount = balance - 1.0 // fees
 	}
 
-	seed, err := wallet.DecryptSeed(a.U.StellarWallet.EncryptedSeed, seedpwd)
+	seed, err := ledger.DecryptSeed(a.U.StellarWallet.EncryptedSeed, seedpwd)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	_, txhash, err := assets.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey,
+	txhash, err := ledger.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey,
 		project.EscrowPubkey, amount, seed, "guarantor refund")
 	if err != nil {
 		log.Println(err)
@@ -79,7 +76,7 @@ func (a *Entity) RefillEscrowXLM(projIndex int, amount float64, seedpwd string)
 		return err
 	}
 
-	balancex := xlm.GetNativeBalance(a.U.StellarWallet.PublicKey)
+	balancex := ledger.GetNativeBalance(a.U.StellarWallet.PublicKey)
 	balance, err := utils.ToFloat(balancex)
 	if err != nil {
 		log.Println(err)
@@ -91,13 +88,13 @@ func (a *Entity) RefillEscrowXLM(projIndex int, amount float64, seedpwd string)
 		amount = balance - 1.0 // fees
 	}
 
-	seed, err := wallet.DecryptSeed(a.U.StellarWallet.EncryptedSeed, seedpwd)
+	seed, err := ledger.DecryptSeed(a.U.StellarWallet.EncryptedSeed, seedpwd)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	_, txhash, err := xlm.SendXLM(project.EscrowPubkey, amount, seed, "guarantor refund")
+	txhash, err := ledger.SendNative(project.EscrowPubkey, amount, seed, "guarantor refund")
 	if err != nil {
 		log.Println(err)
 		return err
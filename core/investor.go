@@ -5,7 +5,6 @@ import (
 
 	tickers "github.com/Varunram/essentials/exchangetickers"
 	utils "github.com/Varunram/essentials/utils"
-	xlm "github.com/Varunram/essentials/xlm"
 	openxconsts "github.com/YaleOpenLab/openx/consts"
 	openx "github.com/YaleOpenLab/openx/database"
 
@@ -107,25 +106,25 @@ func (a *Investor) ChangeVotingBalance(votes float64) error {
 func (a *Investor) CanInvest(targetBalance float64) bool {
 	if !consts.Mainnet {
 		// testnet
-		usdBalance := xlm.GetAssetBalance(a.U.StellarWallet.PublicKey, "STABLEUSD")
-		xlmBalance := xlm.GetNativeBalance(a.U.StellarWallet.PublicKey)
+		usdBalance := ledger.GetAssetBalance(a.U.StellarWallet.PublicKey, "STABLEUSD")
+		xlmBalance := ledger.GetNativeBalance(a.U.StellarWallet.PublicKey)
 
 		// if !a.U.Legal {
 		// 	log.Println("user has not accepted terms and conditions associated with the platform")
 		// 	return false
 		// }
 
-		// need to fetch the oracle price here for the order
-		oraclePrice := tickers.ExchangeXLMforUSD(xlmBalance)
-		if usdBalance > targetBalance-1 || oraclePrice > targetBalance {
+		if usdBalance > targetBalance-1 {
 			// return true since the user has enough USD balance to pay for the order
 			return true
 		}
-		return false
+		// need to fetch the oracle price here for the order
+		oraclePrice := tickers.ExchangeXLMforUSD(xlmBalance)
+		return oraclePrice > targetBalance
 	}
 
 	// mainnet
-	usdBalance := xlm.GetAssetBalance(a.U.StellarWallet.PublicKey, openxconsts.AnchorUSDCode)
+	usdBalance := ledger.GetAssetBalance(a.U.StellarWallet.PublicKey, openxconsts.AnchorUSDCode)
 	return usdBalance > targetBalance
 }
 
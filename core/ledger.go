@@ -0,0 +1,264 @@
+package core
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	horizonclient "github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+
+	utils "github.com/Varunram/essentials/utils"
+	xlm "github.com/Varunram/essentials/xlm"
+	assets "github.com/Varunram/essentials/xlm/assets"
+	escrow "github.com/Varunram/essentials/xlm/escrow"
+	issuer "github.com/Varunram/essentials/xlm/issuer"
+	wallet "github.com/Varunram/essentials/xlm/wallet"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	stablecoin "github.com/YaleOpenLab/opensolar/stablecoin"
+)
+
+// Ledger is the blockchain backend core uses to hold keys, issue assets, move funds through project escrows
+// and anchor memos. Stellar is the default backend, other chains (and the in-memory ledger used for
+// testing) need to implement this interface. Every function that returns a string along with an error
+// returns the hash of the transaction it submitted.
+type Ledger interface {
+	// DecryptSeed decrypts a seed stored in a user's wallet
+	DecryptSeed(encryptedSeed []byte, pwd string) (string, error)
+	// ReturnPubkey returns the publickey associated with a seed
+	ReturnPubkey(seed string) (string, error)
+
+	// AccountExists returns true if the account exists on the ledger
+	AccountExists(pubkey string) bool
+	// GetNativeBalance returns the balance of the ledger's native asset held by an account
+	GetNativeBalance(pubkey string) float64
+	// GetAssetBalance returns the balance of an asset held by an account
+	GetAssetBalance(pubkey string, asset string) float64
+	// GetTestStablecoin exchanges the native asset for stablecoin on testnet
+	GetTestStablecoin(username string, pubkey string, seed string, amount float64) error
+	// SendNative sends the ledger's native asset to an account
+	SendNative(dest string, amount float64, seed string, memo string) (string, error)
+
+	// AssetID returns the code of the asset with the given name
+	AssetID(name string) string
+	// TrustAsset creates a trustline towards an asset
+	TrustAsset(code string, issuerPubkey string, limit float64, seed string) (string, error)
+	// SendAsset sends an asset to an account
+	SendAsset(code string, issuerPubkey string, dest string, amount float64, seed string, memo string) (string, error)
+	// SendAssetFromIssuer issues an asset to an account
+	SendAssetFromIssuer(code string, dest string, amount float64, issuerSeed string, issuerPubkey string) (string, error)
+	// SendAssetToIssuer sends an asset back to its issuer
+	SendAssetToIssuer(code string, issuerPubkey string, amount float64, seed string) (string, error)
+	// SendBatch sends a batch of transfers from an account in a single transaction so that either all of
+	// them go through or none do
+	SendBatch(seed string, transfers []Transfer, memo string) (string, error)
+
+	// InitIssuer creates the issuer of a project's assets
+	InitIssuer(dir string, projIndex int, pwd string) error
+	// FundIssuer funds the issuer of a project's assets
+	FundIssuer(dir string, projIndex int, pwd string, funderSeed string) error
+	// RetrieveIssuer returns the publickey and seed of the issuer of a project's assets
+	RetrieveIssuer(dir string, projIndex int, pwd string) (string, string, error)
+	// FreezeIssuer freezes the issuer of a project's assets so no more assets can be issued
+	FreezeIssuer(dir string, projIndex int, pwd string) (string, error)
+
+	// InitEscrow creates a project's escrow controlled by the recipient and the platform and returns its publickey
+	InitEscrow(projIndex int, pwd string, recpPubkey string, recpSeed string, platformSeed string) (string, error)
+	// TransferFundsToEscrow transfers funds raised by the platform to a project's escrow
+	TransferFundsToEscrow(amount float64, projIndex int, escrowPubkey string, platformSeed string) error
+	// SendFundsFromEscrow sends stablecoin from an escrow, signed by both the escrow's signers
+	SendFundsFromEscrow(escrowPubkey string, dest string, signer1 string, signer2 string, amount float64, memo string) error
+	// SendAssetsFromEscrow sends an asset from an escrow, signed by both the escrow's signers
+	SendAssetsFromEscrow(escrowPubkey string, dest string, signer1 string, signer2 string, amount float64, memo string, code string) error
+
+	// AnchorMemo anchors a memo to the ledger with a transaction from the account to itself
+	AnchorMemo(pubkey string, seed string, memo string) (string, error)
+}
+
+// Transfer is a single payment of an asset that is part of a batch
+type Transfer struct {
+	Code         string
+	IssuerPubkey string
+	Dest         string
+	Amount       float64
+}
+
+var ledger Ledger = StellarLedger{}
+
+// SetLedger sets the ledger used by core
+func SetLedger(l Ledger) {
+	ledger = l
+}
+
+// CurrentLedger returns the ledger used by core
+func CurrentLedger() Ledger {
+	return ledger
+}
+
+// StellarLedger is the Stellar backend of Ledger
+type StellarLedger struct{}
+
+// DecryptSeed decrypts a seed stored in a user's wallet
+func (s StellarLedger) DecryptSeed(encryptedSeed []byte, pwd string) (string, error) {
+	return wallet.DecryptSeed(encryptedSeed, pwd)
+}
+
+// ReturnPubkey returns the publickey associated with a seed
+func (s StellarLedger) ReturnPubkey(seed string) (string, error) {
+	return wallet.ReturnPubkey(seed)
+}
+
+// AccountExists returns true if the account exists on the ledger
+func (s StellarLedger) AccountExists(pubkey string) bool {
+	return xlm.AccountExists(pubkey)
+}
+
+// GetNativeBalance returns the XLM balance of an account
+func (s StellarLedger) GetNativeBalance(pubkey string) float64 {
+	return xlm.GetNativeBalance(pubkey)
+}
+
+// GetAssetBalance returns the balance of an asset held by an account
+func (s StellarLedger) GetAssetBalance(pubkey string, asset string) float64 {
+	return xlm.GetAssetBalance(pubkey, asset)
+}
+
+// GetTestStablecoin exchanges XLM for STABLEUSD on testnet
+func (s StellarLedger) GetTestStablecoin(username string, pubkey string, seed string, amount float64) error {
+	return stablecoin.GetTestStablecoin(username, pubkey, seed, amount)
+}
+
+// SendNative sends XLM to an account
+func (s StellarLedger) SendNative(dest string, amount float64, seed string, memo string) (string, error) {
+	_, txhash, err := xlm.SendXLM(dest, amount, seed, memo)
+	return txhash, err
+}
+
+// AssetID returns the code of the asset with the given name
+func (s StellarLedger) AssetID(name string) string {
+	return assets.AssetID(name)
+}
+
+// TrustAsset creates a trustline towards an asset
+func (s StellarLedger) TrustAsset(code string, issuerPubkey string, limit float64, seed string) (string, error) {
+	return assets.TrustAsset(code, issuerPubkey, limit, seed)
+}
+
+// SendAsset sends an asset to an account
+func (s StellarLedger) SendAsset(code string, issuerPubkey string, dest string, amount float64, seed string, memo string) (string, error) {
+	_, txhash, err := assets.SendAsset(code, issuerPubkey, dest, amount, seed, memo)
+	return txhash, err
+}
+
+// SendAssetFromIssuer issues an asset to an account
+func (s StellarLedger) SendAssetFromIssuer(code string, dest string, amount float64, issuerSeed string, issuerPubkey string) (string, error) {
+	_, txhash, err := assets.SendAssetFromIssuer(code, dest, amount, issuerSeed, issuerPubkey)
+	return txhash, err
+}
+
+// SendAssetToIssuer sends an asset back to its issuer
+func (s StellarLedger) SendAssetToIssuer(code string, issuerPubkey string, amount float64, seed string) (string, error) {
+	_, txhash, err := assets.SendAssetToIssuer(code, issuerPubkey, amount, seed)
+	return txhash, err
+}
+
+// SendBatch sends a batch of transfers as operations of a single Stellar transaction
+func (s StellarLedger) SendBatch(seed string, transfers []Transfer, memo string) (string, error) {
+	kp, err := keypair.ParseFull(seed)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't parse seed")
+	}
+
+	client := horizonclient.DefaultTestNetClient
+	passphrase := network.TestNetworkPassphrase
+	if consts.Mainnet {
+		client = horizonclient.DefaultPublicNetClient
+		passphrase = network.PublicNetworkPassphrase
+	}
+
+	account, err := client.AccountDetail(horizonclient.AccountRequest{AccountID: kp.Address()})
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't retrieve source account")
+	}
+
+	var ops []txnbuild.Operation
+	for _, transfer := range transfers {
+		ops = append(ops, &txnbuild.Payment{
+			Destination: transfer.Dest,
+			Amount:      strconv.FormatFloat(transfer.Amount, 'f', 7, 64),
+			Asset:       txnbuild.CreditAsset{Code: transfer.Code, Issuer: transfer.IssuerPubkey},
+		})
+	}
+
+	tx, err := txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount:        &account,
+		IncrementSequenceNum: true,
+		Operations:           ops,
+		BaseFee:              txnbuild.MinBaseFee,
+		Memo:                 txnbuild.MemoText(memo),
+		Preconditions:        txnbuild.Preconditions{TimeBounds: txnbuild.NewInfiniteTimeout()},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't build transaction")
+	}
+
+	tx, err = tx.Sign(passphrase, kp)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't sign transaction")
+	}
+
+	resp, err := client.SubmitTransaction(tx)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't submit transaction")
+	}
+	return resp.Hash, nil
+}
+
+// InitIssuer creates the issuer of a project's assets
+func (s StellarLedger) InitIssuer(dir string, projIndex int, pwd string) error {
+	return issuer.InitIssuer(dir, projIndex, pwd)
+}
+
+// FundIssuer funds the issuer of a project's assets
+func (s StellarLedger) FundIssuer(dir string, projIndex int, pwd string, funderSeed string) error {
+	return issuer.FundIssuer(dir, projIndex, pwd, funderSeed)
+}
+
+// RetrieveIssuer returns the publickey and seed of the issuer of a project's assets
+func (s StellarLedger) RetrieveIssuer(dir string, projIndex int, pwd string) (string, string, error) {
+	return wallet.RetrieveSeed(issuer.GetPath(dir, projIndex), pwd)
+}
+
+// FreezeIssuer freezes the issuer of a project's assets so no more assets can be issued
+func (s StellarLedger) FreezeIssuer(dir string, projIndex int, pwd string) (string, error) {
+	return issuer.FreezeIssuer(dir, projIndex, pwd)
+}
+
+// InitEscrow creates a 2of2 multisig escrow controlled by the recipient and the platform
+func (s StellarLedger) InitEscrow(projIndex int, pwd string, recpPubkey string, recpSeed string, platformSeed string) (string, error) {
+	return escrow.InitEscrow(projIndex, pwd, recpPubkey, recpSeed, platformSeed)
+}
+
+// TransferFundsToEscrow transfers funds raised by the platform to a project's escrow
+func (s StellarLedger) TransferFundsToEscrow(amount float64, projIndex int, escrowPubkey string, platformSeed string) error {
+	return escrow.TransferFundsToEscrow(amount, projIndex, escrowPubkey, platformSeed)
+}
+
+// SendFundsFromEscrow sends stablecoin from an escrow, signed by both the escrow's signers
+func (s StellarLedger) SendFundsFromEscrow(escrowPubkey string, dest string, signer1 string, signer2 string, amount float64, memo string) error {
+	return escrow.SendFundsFromEscrow(escrowPubkey, dest, signer1, signer2, amount, memo)
+}
+
+// SendAssetsFromEscrow sends an asset from an escrow, signed by both the escrow's signers
+func (s StellarLedger) SendAssetsFromEscrow(escrowPubkey string, dest string, signer1 string, signer2 string, amount float64, memo string, code string) error {
+	return escrow.SendAssetsFromEscrow(escrowPubkey, dest, signer1, signer2, amount, memo, code)
+}
+
+// AnchorMemo anchors a memo to stellar with a payment from the account to itself. Stellar memos are limited
+// to 28 characters so longer messages need to be split over multiple memos
+func (s StellarLedger) AnchorMemo(pubkey string, seed string, memo string) (string, error) {
+	_, txhash, err := xlm.SendXLM(pubkey, float64(utils.Unix()), seed, memo)
+	return txhash, err
+}
@@ -0,0 +1,115 @@
+// +build all travis
+
+package core
+
+import (
+	"testing"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	notif "github.com/YaleOpenLab/opensolar/notif"
+)
+
+// testLedger runs core on an in-memory ledger and user store with a platform account and writes notifications
+// to the home directory. The returned function restores the previous ledger, user store and platform settings
+func testLedger(t *testing.T) (*MemoryLedger, *MemoryUsers, string, func()) {
+	l, users := NewMemoryLedger(), NewMemoryUsers()
+	oldLedger, oldUsers, oldTransport := CurrentLedger(), CurrentUserStore(), notif.CurrentTransport()
+	SetLedger(l)
+	SetUserStore(users)
+	notif.SetTransport(notif.FileTransport{Dir: consts.HomeDir})
+
+	platformSeed, platformPubkey := l.NewAccount()
+	oldSeed, oldMainnet, oldCode := consts.PlatformSeed, consts.Mainnet, consts.StablecoinCode
+	consts.PlatformSeed, consts.Mainnet, consts.StablecoinCode = platformSeed, false, "STABLEUSD"
+
+	return l, users, platformPubkey, func() {
+		SetLedger(oldLedger)
+		SetUserStore(oldUsers)
+		notif.SetTransport(oldTransport)
+		consts.PlatformSeed, consts.Mainnet, consts.StablecoinCode = oldSeed, oldMainnet, oldCode
+	}
+}
+
+func TestMemoryLedger(t *testing.T) {
+	l := NewMemoryLedger()
+	oldLedger := CurrentLedger()
+	SetLedger(l)
+	defer SetLedger(oldLedger)
+
+	platformSeed, platformPubkey := l.NewAccount()
+	recpSeed, recpPubkey := l.NewAccount()
+	_, invPubkey := l.NewAccount()
+
+	oldSeed := consts.PlatformSeed
+	consts.PlatformSeed = platformSeed
+	defer func() { consts.PlatformSeed = oldSeed }()
+
+	err := l.Mint(platformPubkey, stablecoinCode(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	escrowPubkey, err := l.InitEscrow(1, "", recpPubkey, recpSeed, platformSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.TransferFundsToEscrow(1000, 1, escrowPubkey, platformSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.SendFundsFromEscrow(escrowPubkey, invPubkey, recpSeed, recpSeed, 10, "")
+	if err == nil {
+		t.Fatalf("escrow transfer without the platform's signature succeeded")
+	}
+
+	var project Project
+	project.EscrowPubkey = escrowPubkey
+	plan := DistributionPlan{Amount: 300, Tiers: []TierPlan{{Name: "junior", Paid: 300, Payouts: []Payout{
+		{Pubkey: invPubkey, Amount: 100},
+		{Pubkey: "GUNKNOWN", Amount: 200},
+	}}}}
+	plan = project.executePlan(plan, recpSeed)
+	if l.GetAssetBalance(invPubkey, stablecoinCode()) != 100 {
+		t.Fatalf("investor wasn't paid from the escrow")
+	}
+	if !plan.Tiers[0].Payouts[1].Failed || plan.Tiers[0].Paid != 300 || plan.Tiers[0].Arrears != 0 || plan.Retained != 0 {
+		t.Fatalf("failed payout not held for its pubkey: %v", plan)
+	}
+	err = project.Apply(ProjectEvent{Type: EventPaymentsDistributed, Plan: &plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.WaterfallOwed["GUNKNOWN"] != 200 || len(project.WaterfallOwed) != 1 {
+		t.Fatalf("failed payout not owed to its pubkey: %v", project.WaterfallOwed)
+	}
+
+	err = l.InitIssuer("", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerPubkey, issuerSeed, err := l.RetrieveIssuer("", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.SendAssetFromIssuer("DEBT", recpPubkey, 50, issuerSeed, issuerPubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.FreezeIssuer("", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.SendAssetFromIssuer("DEBT", recpPubkey, 50, issuerSeed, issuerPubkey)
+	if err == nil {
+		t.Fatalf("frozen issuer issued assets")
+	}
+	_, err = l.SendAssetToIssuer("DEBT", issuerPubkey, 20, recpSeed)
+	if err != nil || l.GetAssetBalance(recpPubkey, "DEBT") != 30 {
+		t.Fatalf("debt asset not sent back to issuer")
+	}
+
+	_, err = l.AnchorMemo(recpPubkey, recpSeed, "CONTRACTHASH")
+	if err != nil || len(l.Memos[recpPubkey]) != 1 {
+		t.Fatalf("memo not anchored")
+	}
+}
@@ -0,0 +1,632 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+	utils "github.com/Varunram/essentials/utils"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// the secondary market lets investors trade the investor assets of funded projects among themselves. Orders are
+// backed by funds held by the platform: an ask moves the investor assets being sold to the platform and a bid moves
+// the stablecoin needed to pay for them. Matched orders are settled in a single transaction from the platform so
+// both legs of a trade go through or neither does.
+const (
+	// OrderBid is an order to buy investor assets
+	OrderBid = "bid"
+	// OrderAsk is an order to sell investor assets
+	OrderAsk = "ask"
+)
+
+// the states an order can be in
+const (
+	// OrderOpen is an order on the book that hasn't been filled completely
+	OrderOpen = "open"
+	// OrderFilled is an order that has been filled completely
+	OrderFilled = "filled"
+	// OrderCancelled is an order cancelled by the investor who placed it
+	OrderCancelled = "cancelled"
+)
+
+// Order is a bid or ask for the investor assets of a project
+type Order struct {
+	// Index is the index of the order in the orders bucket
+	Index int
+
+	// ProjIndex is the index of the project whose investor assets are traded
+	ProjIndex int
+
+	// InvIndex is the index of the investor who placed the order
+	InvIndex int
+
+	// Side is either OrderBid or OrderAsk
+	Side string
+
+	// Amount is the number of investor assets to buy or sell
+	Amount float64
+
+	// Price is the price in stablecoin per investor asset
+	Price float64
+
+	// Filled is the number of investor assets bought or sold until now
+	Filled float64
+
+	// Status is the status of the order (OrderOpen, OrderFilled, OrderCancelled)
+	Status string
+
+	// Timestamp is the unix time at which the order was placed
+	Timestamp int64
+
+	// EscrowTxHash is the hash of the transaction moving the order's funds to the platform
+	EscrowTxHash string
+
+	// LastError is the error returned by the last match of the order that couldn't be settled (if any)
+	LastError string
+}
+
+// Trade is a match between a bid and an ask settled by the platform
+type Trade struct {
+	// Index is the index of the trade in the trades bucket
+	Index int
+
+	// ProjIndex is the index of the project whose investor assets were traded
+	ProjIndex int
+
+	// BidIndex is the index of the bid that was matched
+	BidIndex int
+
+	// AskIndex is the index of the ask that was matched
+	AskIndex int
+
+	// Buyer is the index of the investor who bought investor assets
+	Buyer int
+
+	// Seller is the index of the investor who sold investor assets
+	Seller int
+
+	// Amount is the number of investor assets traded
+	Amount float64
+
+	// Price is the price in stablecoin per investor asset
+	Price float64
+
+	// Timestamp is the unix time at which the trade was settled
+	Timestamp int64
+
+	// TxHash is the hash of the settlement transaction
+	TxHash string
+}
+
+// Fill is a match between an incoming order and an order resting on the book
+type Fill struct {
+	// Maker is the index of the order resting on the book
+	Maker int
+
+	// Amount is the number of investor assets matched
+	Amount float64
+
+	// Price is the price of the resting order at which the match is made
+	Price float64
+}
+
+// OrderBook contains the open orders for the investor assets of a project in the order they're matched
+type OrderBook struct {
+	Bids []Order
+	Asks []Order
+}
+
+// marketLock makes sure orders are placed, matched and cancelled one at a time
+var marketLock sync.Mutex
+
+// Save saves an Order's details
+func (a *Order) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, OrdersBucket, a, a.Index)
+}
+
+// Save saves a Trade's details
+func (a *Trade) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, TradesBucket, a, a.Index)
+}
+
+// Remaining returns the number of investor assets left to be bought or sold
+func (a Order) Remaining() float64 {
+	return a.Amount - a.Filled
+}
+
+// RetrieveOrder retrieves a specific order from the database
+func RetrieveOrder(key int) (Order, error) {
+	var order Order
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, OrdersBucket, key)
+	if err != nil {
+		return order, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &order)
+	if err != nil {
+		return order, errors.Wrap(err, "could not unmarshal json")
+	}
+
+	if order.Index == 0 {
+		return order, errors.New("order not found")
+	}
+	return order, nil
+}
+
+// RetrieveAllOrders retrieves all orders from the database
+func RetrieveAllOrders() ([]Order, error) {
+	var arr []Order
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, OrdersBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Order
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// RetrieveInvestorOrders retrieves all orders placed by an investor
+func RetrieveInvestorOrders(invIndex int) ([]Order, error) {
+	var arr []Order
+	orders, err := RetrieveAllOrders()
+	if err != nil {
+		return arr, errors.Wrap(err, "couldn't retrieve orders")
+	}
+
+	for _, order := range orders {
+		if order.InvIndex == invIndex {
+			arr = append(arr, order)
+		}
+	}
+	return arr, nil
+}
+
+// RetrieveOrderBook retrieves the open orders for the investor assets of a project
+func RetrieveOrderBook(projIndex int) (OrderBook, error) {
+	var book OrderBook
+	orders, err := RetrieveAllOrders()
+	if err != nil {
+		return book, errors.Wrap(err, "couldn't retrieve orders")
+	}
+
+	for _, order := range orders {
+		if order.ProjIndex != projIndex || order.Status != OrderOpen {
+			continue
+		}
+		if order.Side == OrderBid {
+			book.Bids = append(book.Bids, order)
+		} else {
+			book.Asks = append(book.Asks, order)
+		}
+	}
+
+	sortOrders(book.Bids)
+	sortOrders(book.Asks)
+	return book, nil
+}
+
+// RetrieveProjectTrades retrieves the trades of a project's investor assets
+func RetrieveProjectTrades(projIndex int) ([]Trade, error) {
+	var arr []Trade
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, TradesBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Trade
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		if temp.ProjIndex == projIndex {
+			arr = append(arr, temp)
+		}
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Index < arr[j].Index
+	})
+	return arr, nil
+}
+
+// sortOrders sorts orders on the same side of the book by price (highest bid, lowest ask first) and then by time
+func sortOrders(orders []Order) {
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].Price != orders[j].Price {
+			if orders[i].Side == OrderBid {
+				return orders[i].Price > orders[j].Price
+			}
+			return orders[i].Price < orders[j].Price
+		}
+		if orders[i].Timestamp != orders[j].Timestamp {
+			return orders[i].Timestamp < orders[j].Timestamp
+		}
+		return orders[i].Index < orders[j].Index
+	})
+}
+
+// MatchOrders matches an incoming order against the open orders on the other side of the book using price time
+// priority. Matches are made at the price of the resting order and an investor's orders never match each other
+func MatchOrders(taker Order, book []Order) []Fill {
+	var fills []Fill
+	var resting []Order
+	for _, order := range book {
+		if order.Side == taker.Side || order.ProjIndex != taker.ProjIndex || order.Status != OrderOpen ||
+			order.InvIndex == taker.InvIndex {
+			continue
+		}
+		resting = append(resting, order)
+	}
+	sortOrders(resting)
+
+	remaining := taker.Remaining()
+	for _, order := range resting {
+		if remaining <= 0 {
+			break
+		}
+		if taker.Side == OrderBid && order.Price > taker.Price {
+			break
+		}
+		if taker.Side == OrderAsk && order.Price < taker.Price {
+			break
+		}
+		amount := math.Min(remaining, order.Remaining())
+		fills = append(fills, Fill{Maker: order.Index, Amount: amount, Price: order.Price})
+		remaining -= amount
+	}
+	return fills
+}
+
+// platformStablecoin returns the code and issuer of the stablecoin investor assets are traded against
+func platformStablecoin() (string, string) {
+	if consts.Mainnet {
+		return consts.AnchorUSDCode, consts.AnchorUSDAddress
+	}
+	return consts.StablecoinCode, consts.StablecoinPublicKey
+}
+
+// PlaceOrder places a bid or ask for the investor assets of a project and matches it against the book. The
+// investor assets being sold (for an ask) or the stablecoin needed to pay for them (for a bid) are moved to
+// the platform until the order is filled or cancelled
+func PlaceOrder(invIndex int, projIndex int, side string, amount float64, price float64, seedpwd string) (Order, error) {
+	var order Order
+	if side != OrderBid && side != OrderAsk {
+		return order, errors.New("order must be a bid or an ask, quitting")
+	}
+	if amount <= 0 || price <= 0 {
+		return order, errors.New("order amount and price must be positive, quitting")
+	}
+
+	marketLock.Lock()
+	defer marketLock.Unlock()
+
+	investor, err := RetrieveInvestor(invIndex)
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't retrieve investor")
+	}
+
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if project.InvestorAssetCode == "" || len(project.InvestorMap) == 0 {
+		return order, errors.New("project's investor assets can't be traded yet, quitting")
+	}
+	if project.Cancelled {
+		return order, errors.New("project has been cancelled, quitting")
+	}
+
+	seed, err := ledger.DecryptSeed(investor.U.StellarWallet.EncryptedSeed, seedpwd)
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't decrypt seed")
+	}
+
+	issuerPubkey, _, err := ledger.RetrieveIssuer(consts.OpenSolarIssuerDir, projIndex, consts.IssuerSeedPwd)
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't retrieve issuer")
+	}
+
+	projIndexString, err := utils.ToString(projIndex)
+	if err != nil {
+		return order, err
+	}
+
+	orders, err := RetrieveAllOrders()
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't retrieve orders")
+	}
+
+	if side == OrderAsk {
+		// investors can't sell more than they hold less what they're already selling
+		holding := project.InvestorMap[investor.U.StellarWallet.PublicKey] * project.TotalValue
+		for _, x := range orders {
+			if x.InvIndex == invIndex && x.ProjIndex == projIndex && x.Side == OrderAsk && x.Status == OrderOpen {
+				holding -= x.Remaining()
+			}
+		}
+		if amount > holding+1e-7 { // rounding errors in the share held
+			return order, errors.New("investor doesn't hold enough investor assets, quitting")
+		}
+
+		_, err = ledger.TrustAsset(project.InvestorAssetCode, issuerPubkey, project.TotalValue, consts.PlatformSeed)
+		if err != nil {
+			return order, errors.Wrap(err, "platform couldn't trust investor asset")
+		}
+
+		order.EscrowTxHash, err = ledger.SendAsset(project.InvestorAssetCode, issuerPubkey, consts.PlatformPublicKey,
+			amount, seed, "Opensolar ask: "+projIndexString)
+		if err != nil {
+			return order, errors.Wrap(err, "couldn't send investor assets to platform")
+		}
+	} else {
+		_, err = ledger.TrustAsset(project.InvestorAssetCode, issuerPubkey, project.TotalValue, seed)
+		if err != nil {
+			return order, errors.Wrap(err, "couldn't trust investor asset")
+		}
+
+		code, stablecoinIssuer := platformStablecoin()
+		order.EscrowTxHash, err = ledger.SendAsset(code, stablecoinIssuer, consts.PlatformPublicKey,
+			amount*price, seed, "Opensolar bid: "+projIndexString)
+		if err != nil {
+			return order, errors.Wrap(err, "couldn't send stablecoin to platform")
+		}
+	}
+
+	order.Index = len(orders) + 1
+	order.ProjIndex = projIndex
+	order.InvIndex = invIndex
+	order.Side = side
+	order.Amount = amount
+	order.Price = price
+	order.Status = OrderOpen
+	order.Timestamp = clock()
+	err = order.Save()
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't save order")
+	}
+
+	// the order's funds are with the platform and the order is on the book at this point, so a match that can't be
+	// settled leaves it open (and possibly partially filled) to be matched again instead of failing the order
+	for _, fill := range MatchOrders(order, orders) {
+		maker, err := RetrieveOrder(fill.Maker)
+		if err != nil {
+			return order.unsettled(errors.Wrap(err, "couldn't retrieve matched order")), nil
+		}
+
+		bid, ask := order, maker
+		if side == OrderAsk {
+			bid, ask = maker, order
+		}
+		bid, ask, err = settleTrade(project, bid, ask, fill.Amount, fill.Price, issuerPubkey)
+		if err != nil {
+			log.Println("couldn't settle trade between orders: ", bid.Index, ask.Index, err)
+			return order.unsettled(errors.Wrap(err, "couldn't settle trade")), nil
+		}
+
+		order = bid
+		if side == OrderAsk {
+			order = ask
+		}
+		project, err = RetrieveProject(projIndex)
+		if err != nil {
+			return order.unsettled(errors.Wrap(err, "couldn't retrieve project")), nil
+		}
+	}
+
+	return order, nil
+}
+
+// unsettled records the error that stopped an order from being matched and returns the order as saved
+func (a Order) unsettled(err error) Order {
+	order, rerr := RetrieveOrder(a.Index)
+	if rerr != nil {
+		order = a
+	}
+
+	order.LastError = err.Error()
+	serr := order.Save()
+	if serr != nil {
+		log.Println("couldn't save order: ", order.Index, serr)
+	}
+	return order
+}
+
+// settleTrade settles a match between a bid and an ask with a single transaction from the platform that sends the
+// investor assets to the buyer, the proceeds to the seller and refunds the buyer if the trade was made below their
+// bid. The share transfer is recorded on the project and both investors' project lists are updated so that future
+// distributions go to the new holder
+func settleTrade(project Project, bid Order, ask Order, amount float64, price float64, issuerPubkey string) (Order, Order, error) {
+	buyer, err := RetrieveInvestor(bid.InvIndex)
+	if err != nil {
+		return bid, ask, errors.Wrap(err, "couldn't retrieve buyer")
+	}
+
+	seller, err := RetrieveInvestor(ask.InvIndex)
+	if err != nil {
+		return bid, ask, errors.Wrap(err, "couldn't retrieve seller")
+	}
+
+	projIndexString, err := utils.ToString(project.Index)
+	if err != nil {
+		return bid, ask, err
+	}
+
+	code, stablecoinIssuer := platformStablecoin()
+	transfers := []Transfer{
+		{Code: project.InvestorAssetCode, IssuerPubkey: issuerPubkey, Dest: buyer.U.StellarWallet.PublicKey, Amount: amount},
+		{Code: code, IssuerPubkey: stablecoinIssuer, Dest: seller.U.StellarWallet.PublicKey, Amount: amount * price},
+	}
+	if bid.Price > price {
+		transfers = append(transfers, Transfer{Code: code, IssuerPubkey: stablecoinIssuer,
+			Dest: buyer.U.StellarWallet.PublicKey, Amount: amount * (bid.Price - price)})
+	}
+
+	txhash, err := ledger.SendBatch(consts.PlatformSeed, transfers, "Opensolar trade: "+projIndexString)
+	if err != nil {
+		return bid, ask, errors.Wrap(err, "couldn't settle trade on ledger")
+	}
+
+	for _, x := range []*Order{&bid, &ask} {
+		x.Filled += amount
+		x.LastError = ""
+		if x.Remaining() <= 0 {
+			x.Status = OrderFilled
+		}
+		err = x.Save()
+		if err != nil {
+			return bid, ask, errors.Wrap(err, "couldn't save order")
+		}
+	}
+
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, TradesBucket)
+	if err != nil {
+		return bid, ask, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	var trade Trade
+	trade.Index = len(x) + 1
+	trade.ProjIndex = project.Index
+	trade.BidIndex = bid.Index
+	trade.AskIndex = ask.Index
+	trade.Buyer = buyer.U.Index
+	trade.Seller = seller.U.Index
+	trade.Amount = amount
+	trade.Price = price
+	trade.Timestamp = clock()
+	trade.TxHash = txhash
+	err = trade.Save()
+	if err != nil {
+		return bid, ask, errors.Wrap(err, "couldn't save trade")
+	}
+
+	err = project.Record(ProjectEvent{Type: EventSharesTransferred, UserIndex: buyer.U.Index, FromIndex: seller.U.Index,
+		From: seller.U.StellarWallet.PublicKey, To: buyer.U.StellarWallet.PublicKey, Amount: amount, Price: price, TxHash: txhash})
+	if err != nil {
+		return bid, ask, errors.Wrap(err, "couldn't record share transfer")
+	}
+
+	if !containsIndex(buyer.InvestedSolarProjectsIndices, project.Index) {
+		buyer.InvestedSolarProjectsIndices = append(buyer.InvestedSolarProjectsIndices, project.Index)
+		buyer.InvestedSolarProjects = append(buyer.InvestedSolarProjects, project.InvestorAssetCode)
+	}
+	buyer.AmountInvested += amount * price
+	err = buyer.Save()
+	if err != nil {
+		return bid, ask, errors.Wrap(err, "couldn't save buyer")
+	}
+
+	if project.InvestorMap[seller.U.StellarWallet.PublicKey] <= 0 {
+		for i, index := range seller.InvestedSolarProjectsIndices {
+			if index == project.Index {
+				seller.InvestedSolarProjectsIndices = append(seller.InvestedSolarProjectsIndices[:i], seller.InvestedSolarProjectsIndices[i+1:]...)
+				break
+			}
+		}
+		for i, assetCode := range seller.InvestedSolarProjects {
+			if assetCode == project.InvestorAssetCode {
+				seller.InvestedSolarProjects = append(seller.InvestedSolarProjects[:i], seller.InvestedSolarProjects[i+1:]...)
+				break
+			}
+		}
+		err = seller.Save()
+		if err != nil {
+			return bid, ask, errors.Wrap(err, "couldn't save seller")
+		}
+	}
+
+	log.Println("settled trade of ", amount, " investor assets of project: ", project.Index, " at ", price, " txhash: ", txhash)
+	return bid, ask, nil
+}
+
+// CancelOrder cancels an open order and returns the funds held by the platform for what hasn't been filled
+func CancelOrder(invIndex int, orderIndex int) (Order, error) {
+	marketLock.Lock()
+	defer marketLock.Unlock()
+
+	order, err := RetrieveOrder(orderIndex)
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't retrieve order")
+	}
+
+	if order.InvIndex != invIndex {
+		return order, errors.New("order wasn't placed by investor, quitting")
+	}
+	if order.Status != OrderOpen {
+		return order, errors.New("order isn't open, quitting")
+	}
+
+	investor, err := RetrieveInvestor(invIndex)
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't retrieve investor")
+	}
+
+	project, err := RetrieveProject(order.ProjIndex)
+	if err != nil {
+		return order, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	projIndexString, err := utils.ToString(order.ProjIndex)
+	if err != nil {
+		return order, err
+	}
+
+	if order.Side == OrderAsk {
+		issuerPubkey, _, err := ledger.RetrieveIssuer(consts.OpenSolarIssuerDir, order.ProjIndex, consts.IssuerSeedPwd)
+		if err != nil {
+			return order, errors.Wrap(err, "couldn't retrieve issuer")
+		}
+		_, err = ledger.SendAsset(project.InvestorAssetCode, issuerPubkey, investor.U.StellarWallet.PublicKey,
+			order.Remaining(), consts.PlatformSeed, "Opensolar cancel: "+projIndexString)
+		if err != nil {
+			return order, errors.Wrap(err, "couldn't return investor assets")
+		}
+	} else {
+		code, stablecoinIssuer := platformStablecoin()
+		_, err = ledger.SendAsset(code, stablecoinIssuer, investor.U.StellarWallet.PublicKey,
+			order.Remaining()*order.Price, consts.PlatformSeed, "Opensolar cancel: "+projIndexString)
+		if err != nil {
+			return order, errors.Wrap(err, "couldn't return stablecoin")
+		}
+	}
+
+	order.Status = OrderCancelled
+	return order, order.Save()
+}
+
+// containsIndex returns true if index is in arr
+func containsIndex(arr []int, index int) bool {
+	for _, x := range arr {
+		if x == index {
+			return true
+		}
+	}
+	return false
+}
+
+// removeIndex returns arr without index
+func removeIndex(arr []int, index int) []int {
+	var result []int
+	for _, x := range arr {
+		if x != index {
+			result = append(result, x)
+		}
+	}
+	return result
+}
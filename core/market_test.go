@@ -0,0 +1,119 @@
+//go:build all || travis
+// +build all travis
+
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestMatchOrders(t *testing.T) {
+	book := []Order{
+		{Index: 1, ProjIndex: 1, InvIndex: 1, Side: OrderAsk, Amount: 100, Price: 1.2, Status: OrderOpen, Timestamp: 10},
+		{Index: 2, ProjIndex: 1, InvIndex: 2, Side: OrderAsk, Amount: 50, Price: 1.1, Status: OrderOpen, Timestamp: 20},
+		{Index: 3, ProjIndex: 1, InvIndex: 3, Side: OrderAsk, Amount: 50, Price: 1.1, Filled: 20, Status: OrderOpen, Timestamp: 5},
+		{Index: 4, ProjIndex: 1, InvIndex: 4, Side: OrderAsk, Amount: 10, Price: 0.5, Status: OrderCancelled},
+		{Index: 5, ProjIndex: 2, InvIndex: 5, Side: OrderAsk, Amount: 10, Price: 0.5, Status: OrderOpen},
+		{Index: 6, ProjIndex: 1, InvIndex: 6, Side: OrderAsk, Amount: 10, Price: 0.9, Status: OrderOpen},
+		{Index: 7, ProjIndex: 1, InvIndex: 1, Side: OrderBid, Amount: 10, Price: 2, Status: OrderOpen},
+	}
+
+	bid := Order{Index: 8, ProjIndex: 1, InvIndex: 6, Side: OrderBid, Amount: 100, Price: 1.15, Status: OrderOpen}
+	fills := MatchOrders(bid, book)
+	// the bidder's own ask is skipped, the oldest of the asks at 1.1 fills first and the ask at 1.2 is too expensive
+	if len(fills) != 2 || fills[0].Maker != 3 || fills[0].Amount != 30 || fills[1].Maker != 2 || fills[1].Amount != 50 {
+		t.Fatalf("bid not matched in price time order: %+v", fills)
+	}
+	if fills[0].Price != 1.1 {
+		t.Fatalf("match not made at the resting order's price: %+v", fills[0])
+	}
+
+	ask := Order{Index: 9, ProjIndex: 1, InvIndex: 2, Side: OrderAsk, Amount: 5, Price: 1.5, Status: OrderOpen}
+	fills = MatchOrders(ask, book)
+	if len(fills) != 1 || fills[0].Maker != 7 || fills[0].Amount != 5 || fills[0].Price != 2 {
+		t.Fatalf("ask not matched against the highest bid: %+v", fills)
+	}
+
+	ask.Price = 2.5
+	if len(MatchOrders(ask, book)) != 0 {
+		t.Fatalf("ask matched above the highest bid")
+	}
+}
+
+func TestShareTransfer(t *testing.T) {
+	var project Project
+	project.TotalValue = 1000
+	project.InvestorMap = map[string]float64{"seller": 0.3, "other": 0.7}
+	project.InvestorIndices = []int{1, 2}
+
+	err := project.Apply(ProjectEvent{Type: EventSharesTransferred, UserIndex: 3, FromIndex: 1, From: "seller",
+		To: "buyer", Amount: 100, Price: 1.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(project.InvestorMap["buyer"]-0.1) > 1e-9 || math.Abs(project.InvestorMap["seller"]-0.2) > 1e-9 ||
+		len(project.InvestorIndices) != 3 {
+		t.Fatalf("partial share transfer not applied: %+v %v", project.InvestorMap, project.InvestorIndices)
+	}
+
+	err = project.Apply(ProjectEvent{Type: EventSharesTransferred, UserIndex: 3, FromIndex: 1, From: "seller",
+		To: "buyer", Amount: 200, Price: 1.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := project.InvestorMap["seller"]; exists || containsIndex(project.InvestorIndices, 1) {
+		t.Fatalf("seller still holds shares after selling out: %+v %v", project.InvestorMap, project.InvestorIndices)
+	}
+
+	l := NewMemoryLedger()
+	seed, pubkey := l.NewAccount()
+	_, dest := l.NewAccount()
+	err = l.Mint(pubkey, "INVASSET", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Mint(pubkey, stablecoinCode(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = l.SendBatch(seed, []Transfer{{Code: "INVASSET", Dest: dest, Amount: 10},
+		{Code: stablecoinCode(), Dest: dest, Amount: 6}}, "")
+	if err == nil || l.GetAssetBalance(dest, "INVASSET") != 0 {
+		t.Fatalf("batch partially sent despite insufficient balance")
+	}
+	_, err = l.SendBatch(seed, []Transfer{{Code: "INVASSET", Dest: dest, Amount: 10},
+		{Code: stablecoinCode(), Dest: dest, Amount: 5}}, "")
+	if err != nil || l.GetAssetBalance(dest, stablecoinCode()) != 5 {
+		t.Fatalf("batch not sent: %v", err)
+	}
+}
+
+func TestUnsettledOrder(t *testing.T) {
+	defer testDb(t)()
+
+	order := Order{Index: 1, ProjIndex: 1, InvIndex: 1, Side: OrderBid, Amount: 100, Price: 1.1, Filled: 40,
+		Status: OrderOpen}
+	err := order.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy being matched may be ahead of what was saved, the saved order is returned
+	order.Filled = 70
+	order = order.unsettled(errors.New("ledger unavailable"))
+	if order.Filled != 40 || order.Status != OrderOpen || order.LastError != "ledger unavailable" {
+		t.Fatalf("unsettled order doesn't match the saved order: %+v", order)
+	}
+
+	order, err = RetrieveOrder(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.LastError != "ledger unavailable" {
+		t.Fatalf("settlement error not saved: %+v", order)
+	}
+}
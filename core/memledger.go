@@ -0,0 +1,388 @@
+package core
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// NativeAsset is the name of the native asset on the in-memory ledger
+const NativeAsset = "native"
+
+// MemoryLedger is an in-memory backend of Ledger used to run core offline. Seeds are stored unencrypted,
+// trustlines aren't enforced and assets are identified by their code alone.
+type MemoryLedger struct {
+	sync.Mutex
+
+	// Balances is a publickey: asset: balance map of all accounts on the ledger
+	Balances map[string]map[string]float64
+
+	// Memos is a publickey: memos map of the memos anchored by each account
+	Memos map[string][]string
+
+	// Transfers is the log of all transfers made on the ledger in the order they were made
+	Transfers []MemoryTransfer
+
+	keys    map[string]string     // seed: publickey
+	issuers map[string]*memIssuer // directory and project index: issuer
+	escrows map[string][]string   // escrow publickey: signer publickeys
+	indices map[int]string        // escrow index: escrow publickey
+	txs     int
+}
+
+// MemoryTransfer is a transfer made on the in-memory ledger. Minted assets have an empty source and burnt
+// assets an empty destination
+type MemoryTransfer struct {
+	From   string
+	To     string
+	Asset  string
+	Amount float64
+	Memo   string
+}
+
+type memIssuer struct {
+	pubkey string
+	seed   string
+	frozen bool
+}
+
+// NewMemoryLedger returns an empty in-memory ledger
+func NewMemoryLedger() *MemoryLedger {
+	var l MemoryLedger
+	l.Balances = make(map[string]map[string]float64)
+	l.Memos = make(map[string][]string)
+	l.keys = make(map[string]string)
+	l.issuers = make(map[string]*memIssuer)
+	l.escrows = make(map[string][]string)
+	l.indices = make(map[int]string)
+	return &l
+}
+
+// txhash returns the hash of a new transaction. Must be called with the lock held
+func (l *MemoryLedger) txhash() string {
+	l.txs++
+	return "TX" + strconv.Itoa(l.txs)
+}
+
+// newAccount creates an account and returns its seed and publickey. Must be called with the lock held
+func (l *MemoryLedger) newAccount() (string, string) {
+	n := strconv.Itoa(len(l.keys) + 1)
+	seed := "S" + n
+	pubkey := "G" + n
+	l.keys[seed] = pubkey
+	l.Balances[pubkey] = make(map[string]float64)
+	return seed, pubkey
+}
+
+// pubkey returns the publickey of a seed. Must be called with the lock held
+func (l *MemoryLedger) pubkey(seed string) (string, error) {
+	pubkey, exists := l.keys[seed]
+	if !exists {
+		return "", errors.New("seed not found on ledger")
+	}
+	return pubkey, nil
+}
+
+// transfer moves an asset between two accounts and logs the transfer. An empty source mints the asset and an
+// empty destination burns it. Must be called with the lock held
+func (l *MemoryLedger) transfer(from string, to string, asset string, amount float64, memo string) error {
+	if amount <= 0 {
+		return errors.New("amount must be positive, quitting")
+	}
+	if from != "" {
+		if l.Balances[from][asset] < amount {
+			return errors.New("insufficient balance, quitting")
+		}
+		l.Balances[from][asset] -= amount
+	}
+	if to != "" {
+		if _, exists := l.Balances[to]; !exists {
+			return errors.New("destination account doesn't exist, quitting")
+		}
+		l.Balances[to][asset] += amount
+	}
+	l.Transfers = append(l.Transfers, MemoryTransfer{From: from, To: to, Asset: asset, Amount: amount, Memo: memo})
+	return nil
+}
+
+// stablecoinCode returns the code of the stablecoin escrows hold
+func stablecoinCode() string {
+	if consts.StablecoinCode != "" {
+		return consts.StablecoinCode
+	}
+	return "STABLEUSD"
+}
+
+// NewAccount creates an account and returns its seed and publickey
+func (l *MemoryLedger) NewAccount() (string, string) {
+	l.Lock()
+	defer l.Unlock()
+	return l.newAccount()
+}
+
+// Mint creates an amount of an asset in an account
+func (l *MemoryLedger) Mint(pubkey string, asset string, amount float64) error {
+	l.Lock()
+	defer l.Unlock()
+	return l.transfer("", pubkey, asset, amount, "")
+}
+
+// DecryptSeed returns the seed as is since the in-memory ledger doesn't encrypt seeds
+func (l *MemoryLedger) DecryptSeed(encryptedSeed []byte, pwd string) (string, error) {
+	return string(encryptedSeed), nil
+}
+
+// ReturnPubkey returns the publickey associated with a seed
+func (l *MemoryLedger) ReturnPubkey(seed string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.pubkey(seed)
+}
+
+// AccountExists returns true if the account exists on the ledger
+func (l *MemoryLedger) AccountExists(pubkey string) bool {
+	l.Lock()
+	defer l.Unlock()
+	_, exists := l.Balances[pubkey]
+	return exists
+}
+
+// GetNativeBalance returns the native balance of an account
+func (l *MemoryLedger) GetNativeBalance(pubkey string) float64 {
+	return l.GetAssetBalance(pubkey, NativeAsset)
+}
+
+// GetAssetBalance returns the balance of an asset held by an account
+func (l *MemoryLedger) GetAssetBalance(pubkey string, asset string) float64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.Balances[pubkey][asset]
+}
+
+// GetTestStablecoin mints stablecoin in an account
+func (l *MemoryLedger) GetTestStablecoin(username string, pubkey string, seed string, amount float64) error {
+	return l.Mint(pubkey, stablecoinCode(), amount)
+}
+
+// SendNative sends the native asset to an account
+func (l *MemoryLedger) SendNative(dest string, amount float64, seed string, memo string) (string, error) {
+	return l.SendAsset(NativeAsset, "", dest, amount, seed, memo)
+}
+
+// AssetID returns the name of the asset as its code
+func (l *MemoryLedger) AssetID(name string) string {
+	return name
+}
+
+// TrustAsset is a no-op since the in-memory ledger doesn't enforce trustlines
+func (l *MemoryLedger) TrustAsset(code string, issuerPubkey string, limit float64, seed string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	_, err := l.pubkey(seed)
+	if err != nil {
+		return "", err
+	}
+	return l.txhash(), nil
+}
+
+// SendAsset sends an asset to an account
+func (l *MemoryLedger) SendAsset(code string, issuerPubkey string, dest string, amount float64, seed string, memo string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	pubkey, err := l.pubkey(seed)
+	if err != nil {
+		return "", err
+	}
+	err = l.transfer(pubkey, dest, code, amount, memo)
+	if err != nil {
+		return "", err
+	}
+	return l.txhash(), nil
+}
+
+// SendBatch sends a batch of transfers from an account. Balances are checked for the whole batch
+// before any transfer is made
+func (l *MemoryLedger) SendBatch(seed string, transfers []Transfer, memo string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	pubkey, err := l.pubkey(seed)
+	if err != nil {
+		return "", err
+	}
+
+	needed := make(map[string]float64)
+	for _, transfer := range transfers {
+		if transfer.Amount <= 0 {
+			return "", errors.New("amount must be positive, quitting")
+		}
+		if _, exists := l.Balances[transfer.Dest]; !exists {
+			return "", errors.New("destination account doesn't exist, quitting")
+		}
+		needed[transfer.Code] += transfer.Amount
+	}
+	for code, amount := range needed {
+		if l.Balances[pubkey][code] < amount {
+			return "", errors.New("insufficient balance, quitting")
+		}
+	}
+
+	for _, transfer := range transfers {
+		err = l.transfer(pubkey, transfer.Dest, transfer.Code, transfer.Amount, memo)
+		if err != nil {
+			return "", err
+		}
+	}
+	return l.txhash(), nil
+}
+
+// SendAssetFromIssuer issues an asset to an account
+func (l *MemoryLedger) SendAssetFromIssuer(code string, dest string, amount float64, issuerSeed string, issuerPubkey string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	for _, x := range l.issuers {
+		if x.seed == issuerSeed && x.pubkey == issuerPubkey {
+			if x.frozen {
+				return "", errors.New("issuer frozen, can't issue assets")
+			}
+			err := l.transfer("", dest, code, amount, "")
+			if err != nil {
+				return "", err
+			}
+			return l.txhash(), nil
+		}
+	}
+	return "", errors.New("issuer not found on ledger")
+}
+
+// SendAssetToIssuer sends an asset back to its issuer, which burns it
+func (l *MemoryLedger) SendAssetToIssuer(code string, issuerPubkey string, amount float64, seed string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	pubkey, err := l.pubkey(seed)
+	if err != nil {
+		return "", err
+	}
+	err = l.transfer(pubkey, "", code, amount, "")
+	if err != nil {
+		return "", err
+	}
+	return l.txhash(), nil
+}
+
+// issuerKey returns the key of an issuer stored in a directory for a project
+func issuerKey(dir string, projIndex int) string {
+	return dir + strconv.Itoa(projIndex)
+}
+
+// InitIssuer creates the issuer of a project's assets
+func (l *MemoryLedger) InitIssuer(dir string, projIndex int, pwd string) error {
+	l.Lock()
+	defer l.Unlock()
+	seed, pubkey := l.newAccount()
+	l.issuers[issuerKey(dir, projIndex)] = &memIssuer{pubkey: pubkey, seed: seed}
+	return nil
+}
+
+// FundIssuer is a no-op since accounts on the in-memory ledger don't need to be funded
+func (l *MemoryLedger) FundIssuer(dir string, projIndex int, pwd string, funderSeed string) error {
+	l.Lock()
+	defer l.Unlock()
+	if _, exists := l.issuers[issuerKey(dir, projIndex)]; !exists {
+		return errors.New("issuer not found on ledger")
+	}
+	return nil
+}
+
+// RetrieveIssuer returns the publickey and seed of the issuer of a project's assets
+func (l *MemoryLedger) RetrieveIssuer(dir string, projIndex int, pwd string) (string, string, error) {
+	l.Lock()
+	defer l.Unlock()
+	x, exists := l.issuers[issuerKey(dir, projIndex)]
+	if !exists {
+		return "", "", errors.New("issuer not found on ledger")
+	}
+	return x.pubkey, x.seed, nil
+}
+
+// FreezeIssuer freezes the issuer of a project's assets so no more assets can be issued
+func (l *MemoryLedger) FreezeIssuer(dir string, projIndex int, pwd string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	x, exists := l.issuers[issuerKey(dir, projIndex)]
+	if !exists {
+		return "", errors.New("issuer not found on ledger")
+	}
+	x.frozen = true
+	return l.txhash(), nil
+}
+
+// InitEscrow creates a project's escrow controlled by the recipient and the platform
+func (l *MemoryLedger) InitEscrow(projIndex int, pwd string, recpPubkey string, recpSeed string, platformSeed string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	platformPubkey, err := l.pubkey(platformSeed)
+	if err != nil {
+		return "", err
+	}
+	// escrow keys are stored by index, so a second escrow with the same index would overwrite the first one's keys
+	if _, exists := l.indices[projIndex]; exists {
+		return "", errors.New("escrow already exists for index, quitting")
+	}
+	_, pubkey := l.newAccount()
+	l.escrows[pubkey] = []string{recpPubkey, platformPubkey}
+	l.indices[projIndex] = pubkey
+	return pubkey, nil
+}
+
+// TransferFundsToEscrow transfers stablecoin raised by the platform to a project's escrow
+func (l *MemoryLedger) TransferFundsToEscrow(amount float64, projIndex int, escrowPubkey string, platformSeed string) error {
+	_, err := l.SendAsset(stablecoinCode(), "", escrowPubkey, amount, platformSeed, "escrow funding")
+	return err
+}
+
+// SendFundsFromEscrow sends stablecoin from an escrow, signed by both the escrow's signers
+func (l *MemoryLedger) SendFundsFromEscrow(escrowPubkey string, dest string, signer1 string, signer2 string, amount float64, memo string) error {
+	return l.SendAssetsFromEscrow(escrowPubkey, dest, signer1, signer2, amount, memo, stablecoinCode())
+}
+
+// SendAssetsFromEscrow sends an asset from an escrow, signed by both the escrow's signers
+func (l *MemoryLedger) SendAssetsFromEscrow(escrowPubkey string, dest string, signer1 string, signer2 string, amount float64, memo string, code string) error {
+	l.Lock()
+	defer l.Unlock()
+	signers, exists := l.escrows[escrowPubkey]
+	if !exists {
+		return errors.New("escrow not found on ledger")
+	}
+	pubkey1, err := l.pubkey(signer1)
+	if err != nil {
+		return err
+	}
+	pubkey2, err := l.pubkey(signer2)
+	if err != nil {
+		return err
+	}
+	if pubkey1 != signers[0] || pubkey2 != signers[1] {
+		return errors.New("escrow transfers need to be signed by the recipient and the platform")
+	}
+	err = l.transfer(escrowPubkey, dest, code, amount, memo)
+	if err != nil {
+		return err
+	}
+	l.txhash()
+	return nil
+}
+
+// AnchorMemo records a memo against an account
+func (l *MemoryLedger) AnchorMemo(pubkey string, seed string, memo string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	_, err := l.pubkey(seed)
+	if err != nil {
+		return "", err
+	}
+	l.Memos[pubkey] = append(l.Memos[pubkey], memo)
+	return l.txhash(), nil
+}
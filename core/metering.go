@@ -0,0 +1,218 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// EnergyReading is a single reading reported by a device (teller, smart meter, etc) attached to a project
+type EnergyReading struct {
+	// Index is the index of the reading in the readings bucket
+	Index int
+
+	// ProjIndex is the index of the project the device belongs to
+	ProjIndex int
+
+	// DeviceID is the id of the device that reported the reading
+	DeviceID string
+
+	// Timestamp is the unix time at which the reading was taken
+	Timestamp int64
+
+	// Generated is the energy generated by the project since the last reading in kWh
+	Generated float64
+
+	// Consumed is the energy consumed by the recipient since the last reading in kWh
+	Consumed float64
+
+	// Exported is the energy exported to the grid since the last reading in kWh
+	Exported float64
+
+	// Source is where the reading came from (mqtt, rpc, teller)
+	Source string
+
+	// Seq is the sequence number of the signed batch the reading was reported in, zero for unsigned readings
+	Seq uint64
+
+	// Position is the position of the reading in its batch
+	Position int
+}
+
+// EnergyAggregate is the sum of all readings within a period
+type EnergyAggregate struct {
+	Start     int64
+	End       int64
+	Generated float64
+	Consumed  float64
+	Exported  float64
+	Readings  int
+}
+
+// tellerReading is the json payload a teller publishes on its MQTT topic. Value is the field reported by
+// older tellers and is treated as energy consumed
+type tellerReading struct {
+	DeviceID  string  `json:"device_id"`
+	Timestamp int64   `json:"timestamp"`
+	Generated float64 `json:"generated"`
+	Consumed  float64 `json:"consumed"`
+	Exported  float64 `json:"exported"`
+	Value     float64 `json:"value"`
+}
+
+// readingLock makes sure two readings don't get the same index
+var readingLock sync.Mutex
+
+// Save saves an EnergyReading's details
+func (a *EnergyReading) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, ReadingsBucket, a, a.Index)
+}
+
+// RecordReading validates a reading and stores it in the database
+func RecordReading(reading EnergyReading) (EnergyReading, error) {
+	if reading.ProjIndex == 0 {
+		return reading, errors.New("reading doesn't belong to a project, quitting")
+	}
+	if reading.Generated < 0 || reading.Consumed < 0 || reading.Exported < 0 {
+		return reading, errors.New("energy readings can't be negative, quitting")
+	}
+	if reading.Timestamp == 0 {
+		reading.Timestamp = clock()
+	}
+
+	readingLock.Lock()
+	defer readingLock.Unlock()
+
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, ReadingsBucket)
+	if err != nil {
+		return reading, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	reading.Index = len(x) + 1
+	return reading, reading.Save()
+}
+
+// IngestReading parses a reading published by a project's teller and stores it. Readings published over mqtt
+// aren't signed, so they're rejected once the project's recipient has registered a device key
+func IngestReading(projIndex int, payload []byte) (EnergyReading, error) {
+	var reading EnergyReading
+	err := CheckUnsignedReading(projIndex)
+	if err != nil {
+		return reading, err
+	}
+
+	var x tellerReading
+	err = json.Unmarshal(payload, &x)
+	if err != nil {
+		return reading, errors.Wrap(err, "could not unmarshal teller reading")
+	}
+
+	reading.ProjIndex = projIndex
+	reading.DeviceID = x.DeviceID
+	reading.Timestamp = x.Timestamp
+	reading.Generated = x.Generated
+	reading.Consumed = x.Consumed
+	reading.Exported = x.Exported
+	if reading.Consumed == 0 {
+		reading.Consumed = x.Value
+	}
+	reading.Source = "mqtt"
+	return RecordReading(reading)
+}
+
+// RetrieveReadings retrieves the readings of a project taken between start and end (inclusive) ordered by time.
+// An empty deviceID returns readings from all of the project's devices
+func RetrieveReadings(projIndex int, deviceID string, start int64, end int64) ([]EnergyReading, error) {
+	var arr []EnergyReading
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, ReadingsBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp EnergyReading
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		if temp.ProjIndex != projIndex || (deviceID != "" && temp.DeviceID != deviceID) {
+			continue
+		}
+		if temp.Timestamp < start || temp.Timestamp > end {
+			continue
+		}
+		arr = append(arr, temp)
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Timestamp < arr[j].Timestamp
+	})
+	return arr, nil
+}
+
+// MaxAggregatePeriods is the maximum number of periods AggregateReadings splits a range into
+var MaxAggregatePeriods = int64(10000)
+
+// AggregateReadings sums readings into consecutive periods of interval seconds starting at start. An interval
+// of zero sums all readings between start and end into a single period
+func AggregateReadings(readings []EnergyReading, start int64, end int64, interval int64) ([]EnergyAggregate, error) {
+	var arr []EnergyAggregate
+	if end < start {
+		return arr, errors.New("end of range before its start, quitting")
+	}
+	if interval < 0 {
+		return arr, errors.New("interval can't be negative, quitting")
+	}
+	if interval == 0 {
+		interval = end - start + 1
+	}
+
+	periods := (end-start)/interval + 1
+	if periods > MaxAggregatePeriods {
+		return arr, errors.New("too many periods requested, choose a larger interval")
+	}
+	for i := int64(0); i < periods; i++ {
+		var x EnergyAggregate
+		x.Start = start + i*interval
+		x.End = x.Start + interval - 1
+		if x.End > end {
+			x.End = end
+		}
+		arr = append(arr, x)
+	}
+
+	for _, reading := range readings {
+		if reading.Timestamp < start || reading.Timestamp > end {
+			continue
+		}
+		x := &arr[(reading.Timestamp-start)/interval]
+		x.Generated += reading.Generated
+		x.Consumed += reading.Consumed
+		x.Exported += reading.Exported
+		x.Readings++
+	}
+
+	return arr, nil
+}
+
+// EnergyConsumed returns the energy consumed by the recipient of a project between start and end. Projects without
+// metered readings in that period fall back to the energy last reported by the recipient's teller
+func (a Project) EnergyConsumed(start int64, end int64, tellerEnergy uint32) uint32 {
+	readings, err := RetrieveReadings(a.Index, "", start, end)
+	if err != nil || len(readings) == 0 {
+		return tellerEnergy
+	}
+
+	aggregates, err := AggregateReadings(readings, start, end, 0)
+	if err != nil {
+		return tellerEnergy
+	}
+	return uint32(math.Round(aggregates[0].Consumed))
+}
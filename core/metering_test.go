@@ -0,0 +1,74 @@
+// +build all travis
+
+package core
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAggregateReadings(t *testing.T) {
+	readings := []EnergyReading{
+		{Timestamp: 100, Generated: 5, Consumed: 3, Exported: 2},
+		{Timestamp: 150, Generated: 4, Consumed: 4},
+		{Timestamp: 200, Generated: 6, Consumed: 1, Exported: 5},
+		{Timestamp: 300, Generated: 100, Consumed: 100},
+	}
+
+	aggregates, err := AggregateReadings(readings, 100, 299, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggregates) != 2 {
+		t.Fatalf("expected 2 periods, got %d", len(aggregates))
+	}
+	if aggregates[0].Consumed != 7 || aggregates[0].Readings != 2 || aggregates[0].End != 199 {
+		t.Fatalf("first period doesn't match: %+v", aggregates[0])
+	}
+	if aggregates[1].Exported != 5 || aggregates[1].Readings != 1 {
+		t.Fatalf("reading outside the range aggregated: %+v", aggregates[1])
+	}
+
+	aggregates, err = AggregateReadings(readings, 100, 300, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggregates) != 1 || aggregates[0].Generated != 115 {
+		t.Fatalf("range not aggregated into a single period: %+v", aggregates)
+	}
+
+	_, err = AggregateReadings(readings, 300, 100, 0)
+	if err == nil {
+		t.Fatalf("invalid range accepted")
+	}
+
+	_, err = AggregateReadings(readings, 0, 1<<40, 1)
+	if err == nil {
+		t.Fatalf("range split into too many periods")
+	}
+}
+
+func TestRecordReadingConcurrent(t *testing.T) {
+	defer testDb(t)()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := RecordReading(EnergyReading{ProjIndex: 1, DeviceID: "teller", Timestamp: 100, Consumed: 1})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	readings, err := RetrieveReadings(1, "", 0, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 10 {
+		t.Fatalf("concurrent readings overwrote each other, stored: %d", len(readings))
+	}
+}
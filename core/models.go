@@ -10,17 +10,17 @@ import (
 
 	utils "github.com/Varunram/essentials/utils"
 
-	xlm "github.com/Varunram/essentials/xlm"
-	assets "github.com/Varunram/essentials/xlm/assets"
-	issuer "github.com/Varunram/essentials/xlm/issuer"
-	wallet "github.com/Varunram/essentials/xlm/wallet"
-	stablecoin "github.com/YaleOpenLab/opensolar/stablecoin"
-
 	consts "github.com/YaleOpenLab/opensolar/consts"
 	notif "github.com/YaleOpenLab/opensolar/notif"
-	oracle "github.com/YaleOpenLab/opensolar/oracle"
+	openx "github.com/YaleOpenLab/openx/database"
 )
 
+// notifies returns true if a user wants notifications, either by opting in to them or by setting notification
+// preferences, which decide which notifications the user receives and when
+func notifies(user openx.User) bool {
+	return user.Notification || notif.HasPreferences(user.Index)
+}
+
 // MunibondInvest invests in a specific munibond
 func MunibondInvest(issuerPath string, invIndex int, invSeed string, invAmount float64,
 	projIndex int, invAssetCode string, totalValue float64, seedInvestmentFactor float64, seed bool) error {
@@ -33,11 +33,11 @@ func MunibondInvest(issuerPath string, invIndex int, invSeed string, invAmount f
 	}
 
 	if !consts.Mainnet {
-		usdBalance := xlm.GetAssetBalance(investor.U.StellarWallet.PublicKey, "STABLEUSD")
+		usdBalance := ledger.GetAssetBalance(investor.U.StellarWallet.PublicKey, "STABLEUSD")
 		if usdBalance < invAmount {
 			// need to exchange stablecoin equivalent to the difference in balance plus some change
 			amount := invAmount - usdBalance + 10
-			err = stablecoin.GetTestStablecoin(investor.U.Username, investor.U.StellarWallet.PublicKey, invSeed, amount)
+			err = ledger.GetTestStablecoin(investor.U.Username, investor.U.StellarWallet.PublicKey, invSeed, amount)
 			if err != nil {
 				return errors.Wrap(err, "Unable to offer xlm to STABLEUSD excahnge for investor")
 			}
@@ -54,33 +54,31 @@ func MunibondInvest(issuerPath string, invIndex int, invSeed string, invAmount f
 		return errors.Wrap(err, "Unable to send STABLEUSD to platform")
 	}
 
-	issuerPubkey, issuerSeed, err := wallet.RetrieveSeed(issuer.GetPath(issuerPath, projIndex), consts.IssuerSeedPwd)
+	issuerPubkey, issuerSeed, err := ledger.RetrieveIssuer(issuerPath, projIndex, consts.IssuerSeedPwd)
 	if err != nil {
 		return errors.Wrap(err, "Unable to retrieve seed")
 	}
 
-	InvestorAsset := assets.CreateAsset(invAssetCode, issuerPubkey)
-
-	invTrustTxHash, err := assets.TrustAsset(InvestorAsset.GetCode(), issuerPubkey, totalValue, invSeed)
+	invTrustTxHash, err := ledger.TrustAsset(invAssetCode, issuerPubkey, totalValue, invSeed)
 	if err != nil {
 		return errors.Wrap(err, "Error while trusting investor asset")
 	}
 
-	log.Printf("Investor trusts InvAsset %s with txhash %s", InvestorAsset.GetCode(), invTrustTxHash)
-	_, invAssetTxHash, err := assets.SendAssetFromIssuer(InvestorAsset.GetCode(), investor.U.StellarWallet.PublicKey, invAmount, issuerSeed, issuerPubkey)
+	log.Printf("Investor trusts InvAsset %s with txhash %s", invAssetCode, invTrustTxHash)
+	invAssetTxHash, err := ledger.SendAssetFromIssuer(invAssetCode, investor.U.StellarWallet.PublicKey, invAmount, issuerSeed, issuerPubkey)
 	if err != nil {
 		return errors.Wrap(err, "Error while sending out investor asset")
 	}
 
-	log.Printf("Sent InvAsset %s to investor %s with txhash %s", InvestorAsset.GetCode(), investor.U.StellarWallet.PublicKey, invAssetTxHash)
+	log.Printf("Sent InvAsset %s to investor %s with txhash %s", invAssetCode, investor.U.StellarWallet.PublicKey, invAssetTxHash)
 
 	investor.AmountInvested += invAmount
 
 	if seed {
-		investor.SeedInvestedSolarProjects = append(investor.InvestedSolarProjects, InvestorAsset.GetCode())
+		investor.SeedInvestedSolarProjects = append(investor.InvestedSolarProjects, invAssetCode)
 		investor.SeedInvestedSolarProjectsIndices = append(investor.InvestedSolarProjectsIndices, projIndex)
 	} else {
-		investor.InvestedSolarProjects = append(investor.InvestedSolarProjects, InvestorAsset.GetCode())
+		investor.InvestedSolarProjects = append(investor.InvestedSolarProjects, invAssetCode)
 		investor.InvestedSolarProjectsIndices = append(investor.InvestedSolarProjectsIndices, projIndex)
 	}
 
@@ -89,8 +87,8 @@ func MunibondInvest(issuerPath string, invIndex int, invSeed string, invAmount f
 		return err
 	}
 
-	if investor.U.Notification {
-		notif.SendInvestmentNotifToInvestor(projIndex, investor.U.Email, stableTxHash, invTrustTxHash, invAssetTxHash)
+	if notifies(*investor.U) {
+		notif.SendInvestmentNotifToInvestor(projIndex, investor.U.Index, investor.U.Email, stableTxHash, invTrustTxHash, invAssetTxHash)
 	}
 	return nil
 }
@@ -106,53 +104,50 @@ func MunibondReceive(issuerPath string, recpIndex int, projIndex int, debtAssetI
 	}
 
 	log.Println("Retrieving issuer")
-	issuerPubkey, issuerSeed, err := wallet.RetrieveSeed(issuer.GetPath(issuerPath, projIndex), consts.IssuerSeedPwd)
+	issuerPubkey, issuerSeed, err := ledger.RetrieveIssuer(issuerPath, projIndex, consts.IssuerSeedPwd)
 	if err != nil {
 		return errors.Wrap(err, "Unable to retrieve issuer seed")
 	}
 
-	DebtAsset := assets.CreateAsset(debtAssetId, issuerPubkey)
-	PaybackAsset := assets.CreateAsset(paybackAssetId, issuerPubkey)
-
 	if years == 0 {
 		years = 1
 	}
 
 	pbAmtTrust := float64(years * 12 * 2)
 
-	paybackTrustHash, err := assets.TrustAsset(PaybackAsset.GetCode(), issuerPubkey, pbAmtTrust, recpSeed)
+	paybackTrustHash, err := ledger.TrustAsset(paybackAssetId, issuerPubkey, pbAmtTrust, recpSeed)
 	if err != nil {
 		return errors.Wrap(err, "Error while trusting Payback Asset")
 	}
-	log.Printf("Recipient Trusts Payback asset %s with txhash %s", PaybackAsset.GetCode(), paybackTrustHash)
+	log.Printf("Recipient Trusts Payback asset %s with txhash %s", paybackAssetId, paybackTrustHash)
 
-	_, paybackAssetHash, err := assets.SendAssetFromIssuer(PaybackAsset.GetCode(), recipient.U.StellarWallet.PublicKey, pbAmtTrust, issuerSeed, issuerPubkey) // same amount as debt
+	paybackAssetHash, err := ledger.SendAssetFromIssuer(paybackAssetId, recipient.U.StellarWallet.PublicKey, pbAmtTrust, issuerSeed, issuerPubkey) // same amount as debt
 	if err != nil {
 		return errors.Wrap(err, "Error while sending payback asset from issue")
 	}
 
 	log.Printf("Sent PaybackAsset to recipient %s with txhash %s", recipient.U.StellarWallet.PublicKey, paybackAssetHash)
 
-	debtTrustHash, err := assets.TrustAsset(DebtAsset.GetCode(), issuerPubkey, totalValue*2, recpSeed)
+	debtTrustHash, err := ledger.TrustAsset(debtAssetId, issuerPubkey, totalValue*2, recpSeed)
 	if err != nil {
 		return errors.Wrap(err, "Error while trusting debt asset")
 	}
-	log.Printf("Recipient Trusts Debt asset %s with txhash %s", DebtAsset.GetCode(), debtTrustHash)
+	log.Printf("Recipient Trusts Debt asset %s with txhash %s", debtAssetId, debtTrustHash)
 
-	_, recpDebtAssetHash, err := assets.SendAssetFromIssuer(DebtAsset.GetCode(), recipient.U.StellarWallet.PublicKey, totalValue, issuerSeed, issuerPubkey) // same amount as debt
+	recpDebtAssetHash, err := ledger.SendAssetFromIssuer(debtAssetId, recipient.U.StellarWallet.PublicKey, totalValue, issuerSeed, issuerPubkey) // same amount as debt
 	if err != nil {
 		return errors.Wrap(err, "Error while sending debt asset")
 	}
 
 	log.Printf("Sent DebtAsset to recipient %s with txhash %s\n", recipient.U.StellarWallet.PublicKey, recpDebtAssetHash)
-	recipient.ReceivedSolarProjects = append(recipient.ReceivedSolarProjects, DebtAsset.GetCode())
+	recipient.ReceivedSolarProjects = append(recipient.ReceivedSolarProjects, debtAssetId)
 	recipient.ReceivedSolarProjectIndices = append(recipient.ReceivedSolarProjectIndices, projIndex)
 	err = recipient.Save()
 	if err != nil {
 		return errors.Wrap(err, "couldn't save recipient")
 	}
 
-	txhash, err := issuer.FreezeIssuer(issuerPath, projIndex, "blah")
+	txhash, err := ledger.FreezeIssuer(issuerPath, projIndex, "blah")
 	if err != nil {
 		return errors.Wrap(err, "Error while freezing issuer")
 	}
@@ -160,99 +155,116 @@ func MunibondReceive(issuerPath string, recpIndex int, projIndex int, debtAssetI
 	log.Printf("Tx hash for freezing issuer is: %s", txhash)
 	log.Printf("PROJECT %d's INVESTMENT CONFIRMED!", projIndex)
 
-	if recipient.U.Notification {
-		notif.SendInvestmentNotifToRecipient(projIndex, recipient.U.Email, paybackTrustHash, paybackAssetHash, debtTrustHash, recpDebtAssetHash)
+	if notifies(*recipient.U) {
+		notif.SendInvestmentNotifToRecipient(projIndex, recipient.U.Index, recipient.U.Email, paybackTrustHash, paybackAssetHash, debtTrustHash, recpDebtAssetHash)
 	}
 
-	go sendPaymentNotif(recipient.U.Index, projIndex, paybackPeriod, recipient.U.Email)
+	if paybackPeriod == 0 {
+		paybackPeriod = 4 // monthly reminders if the payback period isn't set
+	}
+	// sleep and bother during the next cycle, then remind the recipient every payback period
+	_, err = ScheduleJob(JobPaymentReminder, projIndex, 2*int64(consts.OneWeekInSecond/time.Second),
+		int64(paybackPeriod*consts.OneWeekInSecond/time.Second))
+	if err != nil {
+		return errors.Wrap(err, "couldn't schedule payment reminders")
+	}
 	return nil
 }
 
-// sendPaymentNotif sends a notification every payback period to the recipient to remind them to payback towards the project
-func sendPaymentNotif(recpIndex int, projIndex int, paybackPeriod time.Duration, email string) {
-	paybackTimes := 0
-	for {
+// sendPaymentNotif sends the recipient a statement every payback period to remind them to payback towards the project
+func sendPaymentNotif(job Job) (bool, error) {
+	project, err := RetrieveProject(job.ProjIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't retrieve project")
+	}
 
-		_, err := RetrieveRecipient(recpIndex)
-		if err != nil {
-			log.Println("Error while retrieving recipient from database", err)
-			message := "Error while retrieving your account details, please contact help as soon as you receive this message " + err.Error()
-			notif.SendAlertEmail(message, email) // don't catch the error here
-			time.Sleep(time.Second * 2 * 604800)
-		}
+	if project.Stage >= Stage9.Number {
+		return true, nil
+	}
 
-		if paybackTimes == 0 {
-			// sleep and bother during the next cycle
-			time.Sleep(time.Second * 2 * 604800)
-		}
+	recipient, err := RetrieveRecipient(project.RecipientIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "Error while retrieving recipient from database")
+	}
 
-		// PAYBACK TIME!!
-		// we don't know if the user has paid, but we send an email anyway
-		notif.SendPaybackAlertEmail(projIndex, email)
-		// sleep until the next payment is due
-		paybackTimes += 1
-		log.Println("Sent: ", email, "a notification on payments for payment cycle: ", paybackTimes)
-		time.Sleep(paybackPeriod * consts.OneWeekInSecond)
+	// PAYBACK TIME!!
+	// we don't know if the user has paid, but we send the statement for the period anyway
+	statement, err := GenerateStatement(job.ProjIndex, clock())
+	if err != nil {
+		log.Println("couldn't generate statement, sending a payback alert instead: ", err)
+		notif.SendPaybackAlertEmail(job.ProjIndex, recipient.U.Index, recipient.U.Email)
+		return false, errors.Wrap(err, "couldn't generate statement")
 	}
+
+	// notifications are sent as plain text, the html rendering is served by the statement endpoint
+	notif.SendStatementEmail(job.ProjIndex, recipient.U.Index, recipient.U.Email, statement.Text())
+	log.Println("Sent: ", recipient.U.Email, "a notification on payments for payment cycle: ", job.Runs+1)
+	return false, nil
 }
 
 // MunibondPayback is used by the recipient to pay the platform back. Here, we pay the
-// project escrow instead of the platform since it is responsible for redistribution of funds
+// project escrow instead of the platform since it is responsible for redistribution of funds. Returns the
+// ownership percentage shifted to the recipient and the hash of the stablecoin payment
 func MunibondPayback(issuerPath string, recpIndex int, amount float64, recipientSeed string, projIndex int,
-	assetName string, projectInvestors []int, totalValue float64, escrowPubkey string) (float64, error) {
+	assetName string, projectInvestors []int, totalValue float64, escrowPubkey string) (float64, string, error) {
 
 	recipient, err := RetrieveRecipient(recpIndex)
 	if err != nil {
-		return -1, errors.Wrap(err, "Error while retrieving recipient from database")
+		return -1, "", errors.Wrap(err, "Error while retrieving recipient from database")
 	}
 
-	issuerPubkey, _, err := wallet.RetrieveSeed(issuer.GetPath(issuerPath, projIndex), consts.IssuerSeedPwd)
+	issuerPubkey, _, err := ledger.RetrieveIssuer(issuerPath, projIndex, consts.IssuerSeedPwd)
 	if err != nil {
-		return -1, errors.Wrap(err, "Unable to retrieve issuer seed")
+		return -1, "", errors.Wrap(err, "Unable to retrieve issuer seed")
 	}
 
-	monthlyBill := oracle.MonthlyBill() * float64(recipient.TellerEnergy)
+	project, err := RetrieveProject(projIndex)
 	if err != nil {
-		return -1, errors.Wrap(err, "Unable to fetch oracle price, exiting")
+		return -1, "", errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	start, end := project.meteringPeriod()
+	monthlyBill, err := project.MonthlyBill(project.EnergyConsumed(start, end, recipient.TellerEnergy), start, end)
+	if err != nil {
+		return -1, "", errors.Wrap(err, "Unable to fetch oracle price, exiting")
 	}
 
 	log.Println("Retrieved average price from oracle: ", monthlyBill)
 
 	if amount < monthlyBill {
-		return -1, errors.New("amount paid is less than amount needed. Please refill your main account")
+		return -1, "", errors.New("amount paid is less than amount needed. Please refill your main account")
 	}
 
 	var StableBalance float64
 	var xlmBalance float64
 
 	if consts.Mainnet {
-		StableBalance = xlm.GetAssetBalance(recipient.U.StellarWallet.PublicKey, consts.AnchorUSDCode)
+		StableBalance = ledger.GetAssetBalance(recipient.U.StellarWallet.PublicKey, consts.AnchorUSDCode)
 	} else {
-		StableBalance = xlm.GetAssetBalance(recipient.U.StellarWallet.PublicKey, consts.StablecoinCode)
+		StableBalance = ledger.GetAssetBalance(recipient.U.StellarWallet.PublicKey, consts.StablecoinCode)
 	}
 
-	xlmBalance = xlm.GetNativeBalance(recipient.U.StellarWallet.PublicKey)
-	xlmUSD, err := tickers.BinanceTicker()
-	if err != nil {
-		return -1, errors.Wrap(err, "unable to fetch ticker price from binance")
-	}
-
-	balance := StableBalance + xlmUSD*xlmBalance
+	if StableBalance < amount {
+		// the ticker is only needed if part of the payback has to come from the recipient's xlm
+		xlmBalance = ledger.GetNativeBalance(recipient.U.StellarWallet.PublicKey)
+		xlmUSD, err := tickers.BinanceTicker()
+		if err != nil {
+			return -1, "", errors.Wrap(err, "unable to fetch ticker price from binance")
+		}
 
-	if balance < amount {
-		return -1, errors.Wrap(err, "You do not have the required stablecoin balance, please refill")
-	}
+		if StableBalance+xlmUSD*xlmBalance < amount {
+			return -1, "", errors.New("You do not have the required stablecoin balance, please refill")
+		}
 
-	if StableBalance < amount {
 		if consts.Mainnet {
-			return -1, errors.New("need more stablecoin, exiting")
+			return -1, "", errors.New("need more stablecoin, exiting")
 		} else {
 			// need to exchange some XLM for stablecoin
 			balNeeded := amount - StableBalance + 5 // 5 for change, fees, etc
-			err := stablecoin.GetTestStablecoin(recipient.U.Username, recipient.U.StellarWallet.PublicKey, recipientSeed, balNeeded)
+			err := ledger.GetTestStablecoin(recipient.U.Username, recipient.U.StellarWallet.PublicKey, recipientSeed, balNeeded)
 			if err != nil {
 				log.Println(err)
-				return -1, errors.Wrap(err, "could not exchange xlm for stablecoin")
+				return -1, "", errors.Wrap(err, "could not exchange xlm for stablecoin")
 			}
 			time.Sleep(20 * time.Second) // wait for the stablecoin daemon to  give stablecoin
 		}
@@ -260,34 +272,39 @@ func MunibondPayback(issuerPath string, recpIndex int, amount float64, recipient
 
 	projIndexString, err := utils.ToString(projIndex)
 	if err != nil {
-		return -1, err
+		return -1, "", err
 	}
 
 	var stablecoinHash string
 	if !consts.Mainnet {
-		_, stablecoinHash, err = assets.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey, escrowPubkey, amount, recipientSeed, "Opensolar payback: "+projIndexString)
+		stablecoinHash, err = ledger.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey, escrowPubkey, amount, recipientSeed, "Opensolar payback: "+projIndexString)
 		if err != nil {
-			return -1, errors.Wrap(err, "Error while sending STABLEUSD back")
+			return -1, "", errors.Wrap(err, "Error while sending STABLEUSD back")
 		}
 	} else {
-		_, stablecoinHash, err = assets.SendAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, escrowPubkey, amount, recipientSeed, "Opensolar payback: "+projIndexString)
+		stablecoinHash, err = ledger.SendAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, escrowPubkey, amount, recipientSeed, "Opensolar payback: "+projIndexString)
 		if err != nil {
-			return -1, errors.Wrap(err, "Error while sending STABLEUSD back")
+			return -1, "", errors.Wrap(err, "Error while sending STABLEUSD back")
 		}
 	}
 
 	log.Println("Paid", amount, " back to platform in stableUSD, txhash", stablecoinHash)
 
-	_, debtPaybackHash, err := assets.SendAssetToIssuer(assetName, issuerPubkey, amount, recipientSeed)
-	if err != nil {
-		return -1, errors.Wrap(err, "Error while sending debt asset back")
+	// debt assets are issued for the principal, so only the principal part of the payback is sent back
+	var debtPaybackHash string
+	principal, _ := project.SplitPayback(amount)
+	if principal > 0 {
+		debtPaybackHash, err = ledger.SendAssetToIssuer(assetName, issuerPubkey, principal, recipientSeed)
+		if err != nil {
+			return -1, "", errors.Wrap(err, "Error while sending debt asset back")
+		}
+		log.Println("Paid", principal, " back to platform in DebtAsset, txhash", debtPaybackHash)
 	}
-	log.Println("Paid", amount, " back to platform in DebtAsset, txhash", debtPaybackHash)
 
 	ownershipAmt := amount - monthlyBill
 	ownershipPct := ownershipAmt / totalValue
-	if recipient.U.Notification {
-		notif.SendPaybackNotifToRecipient(projIndex, recipient.U.Email, stablecoinHash, debtPaybackHash)
+	if notifies(*recipient.U) {
+		notif.SendPaybackNotifToRecipient(projIndex, recipient.U.Index, recipient.U.Email, stablecoinHash, debtPaybackHash)
 	}
 
 	for _, i := range projectInvestors {
@@ -296,12 +313,12 @@ func MunibondPayback(issuerPath string, recpIndex int, amount float64, recipient
 			log.Println("Error while retrieving investor from list of investors", err)
 			continue
 		}
-		if investor.U.Notification {
-			notif.SendPaybackNotifToInvestor(projIndex, investor.U.Email, stablecoinHash, debtPaybackHash)
+		if notifies(*investor.U) {
+			notif.SendPaybackNotifToInvestor(projIndex, investor.U.Index, investor.U.Email, stablecoinHash, debtPaybackHash)
 		}
 	}
 
-	return ownershipPct, nil
+	return ownershipPct, stablecoinHash, nil
 }
 
 // SendUSDToPlatform sends STABLEUSD back to the platform
@@ -314,27 +331,32 @@ func SendUSDToPlatform(invSeed string, invAmount float64, memo string) (string,
 	var txhash string
 
 	if !consts.Mainnet {
-		oldPlatformBalance = xlm.GetAssetBalance(consts.PlatformPublicKey, consts.StablecoinCode)
-		_, txhash, err = assets.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey, consts.PlatformPublicKey, invAmount, invSeed, memo)
+		oldPlatformBalance = ledger.GetAssetBalance(consts.PlatformPublicKey, consts.StablecoinCode)
+		txhash, err = ledger.SendAsset(consts.StablecoinCode, consts.StablecoinPublicKey, consts.PlatformPublicKey, invAmount, invSeed, memo)
 		if err != nil {
 			return txhash, errors.Wrap(err, "sending stableusd to platform failed")
 		}
 	} else {
-		oldPlatformBalance = xlm.GetAssetBalance(consts.PlatformPublicKey, consts.AnchorUSDCode)
-		_, txhash, err = assets.SendAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, consts.PlatformPublicKey, invAmount, invSeed, memo)
+		oldPlatformBalance = ledger.GetAssetBalance(consts.PlatformPublicKey, consts.AnchorUSDCode)
+		txhash, err = ledger.SendAsset(consts.AnchorUSDCode, consts.AnchorUSDAddress, consts.PlatformPublicKey, invAmount, invSeed, memo)
 		if err != nil {
 			return txhash, errors.Wrap(err, "sending stableusd to platform failed")
 		}
 	}
 
 	log.Println("Sent USD to platform, confirmation: ", txhash)
-	time.Sleep(5 * time.Second) // wait for a block
 
-	var newPlatformBalance float64
-	if !consts.Mainnet {
-		newPlatformBalance = xlm.GetAssetBalance(consts.PlatformPublicKey, consts.StablecoinCode)
-	} else {
-		newPlatformBalance = xlm.GetAssetBalance(consts.PlatformPublicKey, consts.AnchorUSDCode)
+	platformBalance := func() float64 {
+		if !consts.Mainnet {
+			return ledger.GetAssetBalance(consts.PlatformPublicKey, consts.StablecoinCode)
+		}
+		return ledger.GetAssetBalance(consts.PlatformPublicKey, consts.AnchorUSDCode)
+	}
+
+	newPlatformBalance := platformBalance()
+	if newPlatformBalance-oldPlatformBalance < invAmount-1 {
+		time.Sleep(5 * time.Second) // wait for a block
+		newPlatformBalance = platformBalance()
 	}
 
 	if newPlatformBalance-oldPlatformBalance < invAmount-1 {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"log"
+	"sync"
+	"time"
 
 	utils "github.com/Varunram/essentials/utils"
 	consts "github.com/YaleOpenLab/opensolar/consts"
@@ -12,13 +14,61 @@ import (
 	"github.com/sparrc/go-ping"
 )
 
+// tracked holds the projects whose brokers the platform is subscribed to so a project isn't tracked twice
+var (
+	trackLock sync.Mutex
+	tracked   = make(map[int]bool)
+)
+
+// StartTracking subscribes to the brokers of all projects that have teller details so the readings their
+// tellers publish are stored
+func StartTracking() {
+	projects, err := RetrieveAllProjects()
+	if err != nil {
+		log.Println("couldn't retrieve projects to track", err)
+		return
+	}
+
+	for _, project := range projects {
+		trackIfConfigured(project)
+	}
+}
+
+// trackIfConfigured tracks a project if it has a broker and a topic and logs any error tracking it runs into
+func trackIfConfigured(project Project) {
+	if project.BrokerUrl == "" || project.TellerPublishTopic == "" {
+		return
+	}
+
+	errs := make(chan error, 1)
+	TrackProject(project.Index, errs)
+	go func() {
+		for err := range errs {
+			log.Println(err)
+		}
+	}()
+}
+
 // TrackProject tracks a specific project's MQTT brokers and similar
 func TrackProject(projIndex int, errs chan error) {
+	trackLock.Lock()
+	if tracked[projIndex] {
+		trackLock.Unlock()
+		close(errs)
+		return
+	}
+	tracked[projIndex] = true
+	trackLock.Unlock()
+
 	go func() {
 		err := track(projIndex)
 		if err != nil {
 			errs <- fmt.Errorf("track project error: %s", err.Error())
 		}
+
+		trackLock.Lock()
+		delete(tracked, projIndex)
+		trackLock.Unlock()
 		close(errs)
 	}()
 }
@@ -29,26 +79,29 @@ func track(projIndex int) error {
 		return errors.Wrap(err, "could not retrieve project")
 	}
 
+	// the broker being unreachable by ping isn't fatal since the client retries connecting on its own
 	pinger, err := ping.NewPinger(project.BrokerUrl)
 	if err != nil {
-		return err
-	}
+		log.Println("couldn't ping broker: ", project.BrokerUrl, err)
+	} else {
+		pinger.Count = 3
+		pinger.Timeout = 10 * time.Second
+		pinger.OnRecv = func(pkt *ping.Packet) {
+			fmt.Printf("%d bytes from %s: icmp_seq=%d time=%v\n",
+				pkt.Nbytes, pkt.IPAddr, pkt.Seq, pkt.Rtt)
+		}
+		pinger.OnFinish = func(stats *ping.Statistics) {
+			fmt.Printf("\n--- %s ping statistics ---\n", stats.Addr)
+			fmt.Printf("%d packets transmitted, %d packets received, %v%% packet loss\n",
+				stats.PacketsSent, stats.PacketsRecv, stats.PacketLoss)
+			fmt.Printf("round-trip min/avg/max/stddev = %v/%v/%v/%v\n",
+				stats.MinRtt, stats.AvgRtt, stats.MaxRtt, stats.StdDevRtt)
+		}
 
-	pinger.OnRecv = func(pkt *ping.Packet) {
-		fmt.Printf("%d bytes from %s: icmp_seq=%d time=%v\n",
-			pkt.Nbytes, pkt.IPAddr, pkt.Seq, pkt.Rtt)
-	}
-	pinger.OnFinish = func(stats *ping.Statistics) {
-		fmt.Printf("\n--- %s ping statistics ---\n", stats.Addr)
-		fmt.Printf("%d packets transmitted, %d packets received, %v%% packet loss\n",
-			stats.PacketsSent, stats.PacketsRecv, stats.PacketLoss)
-		fmt.Printf("round-trip min/avg/max/stddev = %v/%v/%v/%v\n",
-			stats.MinRtt, stats.AvgRtt, stats.MaxRtt, stats.StdDevRtt)
+		fmt.Printf("PING %s (%s):\n", pinger.Addr(), pinger.IPAddr())
+		pinger.Run()
 	}
 
-	fmt.Printf("PING %s (%s):\n", pinger.Addr(), pinger.IPAddr())
-	pinger.Run()
-
 	log.Println("tracking project by starting an MQTT client: ", projIndex)
 	// start a subscriber connected to a specific topic
 	projectString, err := utils.ToString(projIndex)
@@ -61,7 +114,13 @@ func track(projIndex int) error {
 	mqttopts.SetClientID("platformID" + projectString)
 	mqttopts.SetUsername("platform" + projectString)
 
-	err = mqttlib.SubscribeMessage(mqttopts, project.TellerPublishTopic, consts.TellerQos, consts.TellerListenNum)
+	// store every reading the teller publishes in the project's time series
+	err = mqttlib.SubscribeMessage(mqttopts, project.TellerPublishTopic, consts.TellerQos, consts.TellerListenNum, func(payload []byte) {
+		_, err := IngestReading(projIndex, payload)
+		if err != nil {
+			log.Println("couldn't ingest teller reading: ", err)
+		}
+	})
 	if err != nil {
 		return errors.Wrap(err, "could not subscribe to topic / broker")
 	}
@@ -3,6 +3,7 @@ package core
 import (
 	"encoding/json"
 	"log"
+	"sync"
 
 	erpc "github.com/Varunram/essentials/rpc"
 	utils "github.com/Varunram/essentials/utils"
@@ -12,8 +13,43 @@ import (
 	openx "github.com/YaleOpenLab/openx/database"
 )
 
-// RetrieveUser retrieves a user from openx's database
+// UserStore is where core retrieves users from. openx is the default store, other stores (like the in-memory
+// store used to run core offline) need to implement this interface
+type UserStore interface {
+	// RetrieveUser retrieves a user
+	RetrieveUser(key int) (openx.User, error)
+
+	// ChangeReputation changes the reputation of a user by change
+	ChangeReputation(key int, change float64) error
+}
+
+var userStore UserStore = OpenxUsers{}
+
+// SetUserStore sets the store core retrieves users from
+func SetUserStore(s UserStore) {
+	userStore = s
+}
+
+// CurrentUserStore returns the store core retrieves users from
+func CurrentUserStore() UserStore {
+	return userStore
+}
+
+// RetrieveUser retrieves a user from the user store
 func RetrieveUser(key int) (openx.User, error) {
+	return userStore.RetrieveUser(key)
+}
+
+// ChangeReputation changes the reputation of a user in the user store by change
+func ChangeReputation(key int, change float64) error {
+	return userStore.ChangeReputation(key, change)
+}
+
+// OpenxUsers retrieves users from openx's database
+type OpenxUsers struct{}
+
+// RetrieveUser retrieves a user from openx's database
+func (s OpenxUsers) RetrieveUser(key int) (openx.User, error) {
 	var user openx.User
 	keyString, err := utils.ToString(key)
 	if err != nil {
@@ -37,6 +73,65 @@ func RetrieveUser(key int) (openx.User, error) {
 	return user, nil
 }
 
+// ChangeReputation changes the reputation of a user in openx's database by change
+func (s OpenxUsers) ChangeReputation(key int, change float64) error {
+	user, err := s.RetrieveUser(key)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve user")
+	}
+	return user.ChangeReputation(change)
+}
+
+// MemoryUsers is an in-memory UserStore used to run core offline
+type MemoryUsers struct {
+	sync.Mutex
+
+	// Users is an index: user map of the users in the store
+	Users map[int]openx.User
+}
+
+// NewMemoryUsers returns an empty in-memory user store
+func NewMemoryUsers() *MemoryUsers {
+	var s MemoryUsers
+	s.Users = make(map[int]openx.User)
+	return &s
+}
+
+// Add adds a user to the store or replaces the user with the same index
+func (s *MemoryUsers) Add(user openx.User) error {
+	if user.Index == 0 {
+		return errors.New("user doesn't have an index, quitting")
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.Users[user.Index] = user
+	return nil
+}
+
+// RetrieveUser retrieves a user from the store
+func (s *MemoryUsers) RetrieveUser(key int) (openx.User, error) {
+	s.Lock()
+	defer s.Unlock()
+	user, exists := s.Users[key]
+	if !exists {
+		return user, errors.New("problem with retrieving user")
+	}
+	return user, nil
+}
+
+// ChangeReputation changes the reputation of a user in the store by change
+func (s *MemoryUsers) ChangeReputation(key int, change float64) error {
+	s.Lock()
+	defer s.Unlock()
+	user, exists := s.Users[key]
+	if !exists {
+		return errors.New("problem with retrieving user")
+	}
+	user.Reputation += change
+	s.Users[key] = user
+	return nil
+}
+
 // ValidateUser validates a user with openx's database
 func ValidateUser(name string, token string) (openx.User, error) {
 	var user openx.User
@@ -37,15 +37,15 @@ func (contractor *Entity) Originate(panelSize string, totalValue float64, locati
 	return pc, err
 }
 
-// RepOriginatedProject adds reputation to an originator on successful origination of a contract
+// RepOriginatedProject updates the reputation of an originator on successful origination of a contract
 func RepOriginatedProject(origIndex int, projIndex int) error {
-	originator, err := RetrieveEntity(origIndex)
+	_, err := RetrieveEntity(origIndex)
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve entity from db")
 	}
-	project, err := RetrieveProject(projIndex)
+	_, err = RetrieveProject(projIndex)
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve project from db")
 	}
-	return originator.U.ChangeReputation(project.TotalValue * OriginatorWeight)
+	return UpdateReputation(origIndex)
 }
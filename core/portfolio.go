@@ -0,0 +1,366 @@
+package core
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// secondsInYear is the number of seconds in a year used to annualize returns
+const secondsInYear = 365 * 24 * 60 * 60
+
+// CashFlow is a payment made or received by an investor. Investments are negative, distributions positive
+type CashFlow struct {
+	Timestamp int64
+	Amount    float64
+}
+
+// ProjectReturns are the returns of an investor in a single project
+type ProjectReturns struct {
+	// ProjIndex is the index of the project
+	ProjIndex int
+
+	// Name is the name of the project
+	Name string
+
+	// Stage is the stage the project is in
+	Stage int
+
+	// Share is the investor's share of the project (regular and seed)
+	Share float64
+
+	// Invested is the amount the investor has invested in the project
+	Invested float64
+
+	// Distributed is the amount distributed to the investor from the project's paybacks
+	Distributed float64
+
+	// Sold is the amount the investor received from selling investor assets on the secondary market
+	Sold float64
+
+	// Refunded is the amount refunded to the investor when the project was cancelled
+	Refunded float64
+
+	// Expected is the amount the investor would have received by now had the recipient paid every
+	// installment of the project's amortization schedule on time
+	Expected float64
+
+	// Outstanding is the investor's share of the principal the recipient still owes
+	Outstanding float64
+
+	// CashOnCash is the amount distributed as a fraction of the amount invested
+	CashOnCash float64
+
+	// IRR is the annualized internal rate of return of the investment, valuing the outstanding principal at par
+	IRR float64
+
+	// Delinquency is the delinquency state of the project
+	Delinquency string
+
+	// AmountOwed is the investor's share of the amount the recipient owes
+	AmountOwed float64
+
+	// AtRisk is the outstanding principal of the investor if the project is delinquent
+	AtRisk float64
+
+	// Flows are the cash flows between the investor and the project
+	Flows []CashFlow
+}
+
+// Portfolio are the returns of an investor across all projects they've invested in
+type Portfolio struct {
+	InvIndex    int
+	Projects    []ProjectReturns
+	Invested    float64
+	Distributed float64
+	Sold        float64
+	Refunded    float64
+	Expected    float64
+	Outstanding float64
+	CashOnCash  float64
+	IRR         float64
+	AtRisk      float64
+}
+
+// RetrievePortfolio computes the returns of an investor across all projects they've invested in, including
+// projects they've since sold their shares in or been refunded from
+func RetrievePortfolio(invIndex int) (Portfolio, error) {
+	var portfolio Portfolio
+	investor, err := RetrieveInvestor(invIndex)
+	if err != nil {
+		return portfolio, errors.Wrap(err, "couldn't retrieve investor")
+	}
+
+	indices, err := investedProjects(invIndex, append(investor.InvestedSolarProjectsIndices,
+		investor.SeedInvestedSolarProjectsIndices...))
+	if err != nil {
+		return portfolio, err
+	}
+
+	portfolio.InvIndex = invIndex
+	var flows []CashFlow
+	now := clock()
+	for _, index := range indices {
+		project, err := RetrieveProject(index)
+		if err != nil {
+			return portfolio, errors.Wrap(err, "couldn't retrieve project")
+		}
+
+		returns, err := project.InvestorReturns(investor, now)
+		if err != nil {
+			return portfolio, errors.Wrap(err, "couldn't compute returns for project")
+		}
+
+		portfolio.Projects = append(portfolio.Projects, returns)
+		portfolio.Invested += returns.Invested
+		portfolio.Distributed += returns.Distributed
+		portfolio.Sold += returns.Sold
+		portfolio.Refunded += returns.Refunded
+		portfolio.Expected += returns.Expected
+		portfolio.Outstanding += returns.Outstanding
+		portfolio.AtRisk += returns.AtRisk
+		flows = append(flows, returns.Flows...)
+	}
+
+	if portfolio.Invested != 0 {
+		portfolio.CashOnCash = portfolio.Distributed / portfolio.Invested
+	}
+	if portfolio.Outstanding != 0 {
+		flows = append(flows, CashFlow{Timestamp: now, Amount: portfolio.Outstanding})
+	}
+	portfolio.IRR, _ = XIRR(flows) // portfolios without distributions or investments don't have an IRR
+	return portfolio, nil
+}
+
+// investedProjects returns the indices of the projects an investor has invested in or traded shares of. The
+// investor's project lists (held) only contain the projects they still hold, so projects are found from their
+// event logs as well
+func investedProjects(invIndex int, held []int) ([]int, error) {
+	indices := make(map[int]bool)
+	for _, index := range held {
+		indices[index] = true // investments made before the project's event log was started
+	}
+
+	projects, err := RetrieveAllProjects()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't retrieve projects")
+	}
+	for _, project := range projects {
+		if indices[project.Index] {
+			continue
+		}
+		events, err := RetrieveProjectEvents(project.Index)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't retrieve project events")
+		}
+		for _, event := range events {
+			switch event.Type {
+			case EventInvestmentReceived:
+				indices[project.Index] = indices[project.Index] || event.UserIndex == invIndex
+			case EventSharesTransferred:
+				indices[project.Index] = indices[project.Index] || event.UserIndex == invIndex || event.FromIndex == invIndex
+			}
+		}
+	}
+
+	var arr []int
+	for index := range indices {
+		arr = append(arr, index)
+	}
+	sort.Ints(arr)
+	return arr, nil
+}
+
+// InvestorReturns computes the returns of an investor in a project. Investments and secondary market trades are read
+// from the project's event log and distributions from the payouts made to the investor's publickey by DistributePayments
+func (a Project) InvestorReturns(investor Investor, now int64) (ProjectReturns, error) {
+	var returns ProjectReturns
+	if investor.U == nil {
+		return returns, errors.New("investor doesn't have a user, quitting")
+	}
+	pubkey := investor.U.StellarWallet.PublicKey
+
+	returns.ProjIndex = a.Index
+	returns.Name = a.Name
+	returns.Stage = a.Stage
+	returns.Share = a.InvestorMap[pubkey] + a.SeedInvestorMap[pubkey]
+	returns.Delinquency = a.Delinquency
+	returns.Outstanding = returns.Share * a.BalLeft
+	returns.AmountOwed = returns.Share * a.AmountOwed
+
+	events, err := RetrieveProjectEvents(a.Index)
+	if err != nil {
+		return returns, errors.Wrap(err, "couldn't retrieve project events")
+	}
+
+	for _, event := range events {
+		switch event.Type {
+		case EventInvestmentReceived:
+			if event.UserIndex != investor.U.Index {
+				continue
+			}
+			returns.Invested += event.Amount
+			returns.Flows = append(returns.Flows, CashFlow{Timestamp: event.Timestamp, Amount: -event.Amount})
+		case EventSharesTransferred:
+			if event.UserIndex == investor.U.Index {
+				returns.Invested += event.Amount * event.Price
+				returns.Flows = append(returns.Flows, CashFlow{Timestamp: event.Timestamp, Amount: -event.Amount * event.Price})
+			} else if event.FromIndex == investor.U.Index {
+				returns.Sold += event.Amount * event.Price
+				returns.Flows = append(returns.Flows, CashFlow{Timestamp: event.Timestamp, Amount: event.Amount * event.Price})
+			}
+		case EventInvestmentRefunded:
+			if event.UserIndex != investor.U.Index {
+				continue
+			}
+			returns.Refunded += event.Amount
+			returns.Flows = append(returns.Flows, CashFlow{Timestamp: event.Timestamp, Amount: event.Amount})
+		case EventPaymentsDistributed:
+			if event.Plan == nil {
+				continue
+			}
+			amount := planPayout(*event.Plan, pubkey)
+			if amount == 0 {
+				continue
+			}
+			returns.Distributed += amount
+			returns.Flows = append(returns.Flows, CashFlow{Timestamp: event.Timestamp, Amount: amount})
+		}
+	}
+
+	if returns.Invested == 0 {
+		// investments made before the project's event log was started
+		returns.Invested = returns.Share * a.TotalValue
+	}
+	if returns.Invested != 0 {
+		returns.CashOnCash = returns.Distributed / returns.Invested
+	}
+
+	tiers, err := a.WaterfallTiers()
+	if err != nil {
+		return returns, errors.Wrap(err, "couldn't retrieve project waterfall")
+	}
+	returns.Expected = ExpectedDistributions(tiers, a.Schedule, pubkey, now)
+
+	switch a.Delinquency {
+	case DelinquencyGrace, DelinquencyLate, DelinquencyStern, DelinquencyDefaulted:
+		returns.AtRisk = returns.Outstanding
+	}
+
+	flows := returns.Flows
+	if returns.Outstanding != 0 {
+		flows = append(flows, CashFlow{Timestamp: now, Amount: returns.Outstanding})
+	}
+	returns.IRR, _ = XIRR(flows) // projects without distributions or investments don't have an IRR
+	return returns, nil
+}
+
+// planPayout returns the amount paid out to pubkey by a distribution plan
+func planPayout(plan DistributionPlan, pubkey string) float64 {
+	var amount float64
+	for _, payout := range plan.Owed {
+		if payout.Pubkey == pubkey && !payout.Failed {
+			amount += payout.Amount
+		}
+	}
+	for _, tier := range plan.Tiers {
+		for _, payout := range tier.Payouts {
+			if payout.Pubkey == pubkey && !payout.Failed {
+				amount += payout.Amount
+			}
+		}
+	}
+	return amount
+}
+
+// ExpectedDistributions returns the amount pubkey would have received by now had every installment of schedule
+// been paid on its due date and distributed over the waterfall tiers
+func ExpectedDistributions(tiers []WaterfallTier, schedule []Installment, pubkey string, now int64) float64 {
+	var expected float64
+	paid := make(map[string]float64)
+	for _, x := range schedule {
+		if x.DueDate > now {
+			break
+		}
+		plan := PlanDistribution(tiers, x.Payment, paid, nil, nil)
+		for _, tier := range plan.Tiers {
+			paid[tier.Name] += tier.Paid
+		}
+		expected += planPayout(plan, pubkey)
+	}
+	return expected
+}
+
+// XIRR returns the annualized internal rate of return of a series of cash flows that need not be evenly spaced.
+// The rate is found using Newton's method, falling back to bisection if it doesn't converge
+func XIRR(flows []CashFlow) (float64, error) {
+	if len(flows) < 2 {
+		return 0, errors.New("need at least two cash flows to compute irr, quitting")
+	}
+
+	var positive, negative bool
+	start := flows[0].Timestamp
+	for _, flow := range flows {
+		if flow.Amount > 0 {
+			positive = true
+		} else if flow.Amount < 0 {
+			negative = true
+		}
+		if flow.Timestamp < start {
+			start = flow.Timestamp
+		}
+	}
+	if !positive || !negative {
+		return 0, errors.New("cash flows must contain both investments and returns, quitting")
+	}
+
+	npv := func(rate float64) (float64, float64) {
+		var value, derivative float64
+		for _, flow := range flows {
+			years := float64(flow.Timestamp-start) / secondsInYear
+			value += flow.Amount / math.Pow(1+rate, years)
+			derivative -= years * flow.Amount / math.Pow(1+rate, years+1)
+		}
+		return value, derivative
+	}
+
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		value, derivative := npv(rate)
+		if math.Abs(value) < 1e-7 {
+			return rate, nil
+		}
+		if derivative == 0 {
+			break
+		}
+		next := rate - value/derivative
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		rate = next
+	}
+
+	low, high := -0.9999, 1.0
+	for value, _ := npv(high); value > 0 && high < 1e6; value, _ = npv(high) {
+		high *= 2
+	}
+	lowValue, _ := npv(low)
+	highValue, _ := npv(high)
+	if lowValue*highValue > 0 {
+		return 0, errors.New("irr did not converge, quitting")
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		value, _ := npv(mid)
+		if math.Abs(value) < 1e-7 {
+			return mid, nil
+		}
+		if value*lowValue > 0 {
+			low, lowValue = mid, value
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2, nil
+}
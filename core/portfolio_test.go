@@ -0,0 +1,117 @@
+// +build all travis
+
+package core
+
+import (
+	"math"
+	"testing"
+
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+func TestXIRR(t *testing.T) {
+	flows := []CashFlow{
+		{Timestamp: 0, Amount: -100},
+		{Timestamp: secondsInYear, Amount: 110},
+	}
+	rate, err := XIRR(flows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(rate-0.1) > 1e-6 {
+		t.Fatalf("expected irr of 10%%, got %f", rate)
+	}
+
+	flows = []CashFlow{
+		{Timestamp: 0, Amount: -1000},
+		{Timestamp: secondsInYear / 2, Amount: 100},
+		{Timestamp: secondsInYear, Amount: 100},
+		{Timestamp: 2 * secondsInYear, Amount: 500},
+	}
+	rate, err = XIRR(flows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate >= 0 {
+		t.Fatalf("loss making investment has a positive irr: %f", rate)
+	}
+
+	_, err = XIRR([]CashFlow{{Timestamp: 0, Amount: -100}, {Timestamp: 10, Amount: -10}})
+	if err == nil {
+		t.Fatalf("irr computed without any returns")
+	}
+}
+
+func TestExpectedDistributions(t *testing.T) {
+	tiers := []WaterfallTier{
+		{Name: "om", Shares: map[string]float64{"om": 1}, TotalCap: 50},
+		{Name: "junior", Shares: map[string]float64{"inv1": 0.75, "inv2": 0.25}, Rate: 0.1},
+	}
+	schedule := []Installment{
+		{Period: 1, DueDate: 100, Payment: 100},
+		{Period: 2, DueDate: 200, Payment: 100},
+		{Period: 3, DueDate: 300, Payment: 100},
+	}
+
+	// the om tier takes 50 from the first installment after which the junior tier is paid 10 per installment
+	expected := ExpectedDistributions(tiers, schedule, "inv1", 250)
+	if math.Abs(expected-15) > 1e-9 {
+		t.Fatalf("expected distributions of 15, got %f", expected)
+	}
+
+	plan := PlanDistribution(tiers, 100, map[string]float64{"om": 50}, nil, nil)
+	plan.Tiers[1].Payouts[1].Failed = true
+	if planPayout(plan, "inv1") != 7.5 || planPayout(plan, "inv2") != 0 {
+		t.Fatalf("failed payouts counted as distributed: %+v", plan)
+	}
+}
+
+func TestRetrievePortfolio(t *testing.T) {
+	defer testDb(t)()
+	_, users, _, restore := testLedger(t)
+	defer restore()
+
+	user := openx.User{Index: 1, StellarWallet: openx.Wallet{PublicKey: "investor"}}
+	err := users.Add(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the investor has sold their shares, so the project isn't in their project lists anymore
+	investor := Investor{U: &user}
+	err = investor.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range []int{1, 2} {
+		project := Project{Index: index, TotalValue: 1000}
+		err = project.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	project, err := RetrieveProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []ProjectEvent{
+		{Type: EventInvestmentReceived, UserIndex: 1, From: "investor", Amount: 100},
+		{Type: EventSharesTransferred, UserIndex: 2, FromIndex: 1, Amount: 100, Price: 1.2},
+	} {
+		err = project.Record(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	portfolio, err := RetrievePortfolio(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(portfolio.Projects) != 1 || portfolio.Projects[0].ProjIndex != 1 {
+		t.Fatalf("sold project missing from portfolio: %+v", portfolio.Projects)
+	}
+	if portfolio.Invested != 100 || portfolio.Sold != 120 {
+		t.Fatalf("sale not included in portfolio totals: %+v", portfolio)
+	}
+}
@@ -39,15 +39,25 @@ type Project struct {
 	// Votes is the number of votes towards a proposed contract by investors
 	Votes float64
 
+	// VotesCast is an investor index: votes map of the votes cast towards the project
+	VotesCast map[int]float64
+
+	// Cancelled is set if the project was cancelled before being funded
+	Cancelled bool
+
 	// OwnershipShift is the percentage of the project that the recipient owns
 	OwnershipShift float64
 
 	// StageData is the data associated with stage migrations
 	StageData []string
 
-	// StageChecklist is the checklist that has to be completed before moving on to the next stage
+	// StageChecklist is the checklist that has to be completed before moving on to the next stage. It maps the
+	// index of each activity of a stage to whether it has been done
 	StageChecklist []map[string]bool
 
+	// StageEvidence is the log of checklist ticks along with the evidence attached to them
+	StageEvidence []ChecklistEntry
+
 	// InvestorMap publicKey: %investment map
 	InvestorMap map[string]float64
 
@@ -57,6 +67,39 @@ type Project struct {
 	// WaterfallMap publickey:amount map used to pay project stakeholders
 	WaterfallMap map[string]float64
 
+	// Waterfall contains the tiers paybacks are distributed over. The default waterfall is used if empty
+	Waterfall []WaterfallTier
+
+	// WaterfallPaid tier:amount map of the amount paid to each waterfall tier until now
+	WaterfallPaid map[string]float64
+
+	// WaterfallArrears tier:amount map of the amount each waterfall tier is owed from previous paybacks
+	WaterfallArrears map[string]float64
+
+	// WaterfallOwed publickey:amount map of failed payouts held in the escrow until they can be repaid
+	WaterfallOwed map[string]float64
+
+	// SeniorDebt publickey:amount map of debt owed to senior lenders, repaid before investors receive anything
+	SeniorDebt map[string]float64
+
+	// SeniorDebtRate is the interest rate senior debt is repaid with
+	SeniorDebtRate float64
+
+	// Delinquency is the delinquency state of the project (current, grace, late, stern, defaulted, cured)
+	Delinquency string
+
+	// DelinquencyHistory contains the transitions between delinquency states in the order they happened
+	DelinquencyHistory []DelinquencyTransition
+
+	// Breaches contains the breaches of stage breach rules by the project in the order they were detected
+	Breaches []Breach
+
+	// CollateralHeld is a contractor index: amount map of the collateral contractors hold in escrow for the project
+	CollateralHeld map[int]float64
+
+	// CollateralEscrow is the publickey of the escrow holding the collateral contractors posted for the project
+	CollateralEscrow string
+
 	// RecipientIndex is the index of the project's main recipient
 	RecipientIndex int
 
@@ -96,6 +139,21 @@ type Project struct {
 	// Stage is the stage at which the contract is at
 	Stage int
 
+	// AmortizationType is the type of amortization schedule used for paybacks (level, straight, balloon)
+	AmortizationType string
+
+	// GracePeriods is the number of initial payback periods where the recipient pays only interest
+	GracePeriods int
+
+	// BalloonPct is the percentage of the loan paid back with the final installment of a balloon schedule
+	BalloonPct float64
+
+	// Schedule is the amortization schedule of the project, generated once the project is funded
+	Schedule []Installment
+
+	// TariffOverride is the name of the tariff schedule used to bill the recipient instead of the one for the project's State / Country
+	TariffOverride string
+
 	// InvestorAssetCode the code of the asset given to investors on investment in the project
 	InvestorAssetCode string
 
@@ -214,6 +272,9 @@ type Project struct {
 
 // Feedback defines a structure that is used for providing feedback
 type Feedback struct {
+	// Index is the index of the feedback in the feedback bucket
+	Index int
+
 	// Content is the content of the feedback
 	Content string
 
@@ -228,40 +289,77 @@ type Feedback struct {
 
 	// Contract is the project for which the feedback was given for.
 	Contract []Project
+
+	// ProjIndex is the index of the project the feedback was given for
+	ProjIndex int
+
+	// Stage is the stage milestone of the project the feedback was given for
+	Stage int
+
+	// FromIndex is the index of the party who gave the feedback
+	FromIndex int
+
+	// ToIndex is the index of the party the feedback is about
+	ToIndex int
+
+	// Rating is the rating given from 1 (worst) to 5 (best)
+	Rating int
+
+	// Timestamp is the unix time at which the feedback was given
+	Timestamp int64
 }
 
 // Stage is the evolution of the erstwhile static stage integer construction
 type Stage struct {
 	Number          int
-	FriendlyName    string   // the informal name that one can use while referring to the stage
-	Name            string   // this is a more formal name to give to the given stage
-	Activities      []string // the activities that are covered in this particular stage and need to be fulfilled in order to move to the next stage.
-	StateTrigger    []string // trigger state change from n to n+1
-	BreachCondition []string // define breach conditions for a particular stage
+	FriendlyName    string       // the informal name that one can use while referring to the stage
+	Name            string       // this is a more formal name to give to the given stage
+	Activities      []string     // the activities that are covered in this particular stage and need to be fulfilled in order to move to the next stage.
+	StateTrigger    []string     // trigger state change from n to n+1
+	BreachCondition []string     // define breach conditions for a particular stage
+	BreachRules     []BreachRule // machine readable breach conditions evaluated by the platform
 }
 
-// ContractAuction is an auction struct
+// ContractAuction is the result of an auction among contractors for a project
 type ContractAuction struct {
 	AllContracts    []Project
 	AllContractors  []Entity
 	WinningContract Project
-}
 
-const (
-	// InvestorWeight is the percentage weight of the project's total reputation assigned to the investor
-	InvestorWeight = 0.1
+	// AuctionIndex is the index of the auction (zero for contracts selected without an auction)
+	AuctionIndex int
 
-	// OriginatorWeight is the percentage weight of the project's total reputation assigned to the originator
-	OriginatorWeight = 0.1
+	// ProjIndex is the index of the project that was auctioned
+	ProjIndex int
 
-	// ContractorWeight is the percentage weight of the project's total reputation assigned to the contractor
-	ContractorWeight = 0.3
+	// Type is the type of the auction
+	Type string
 
-	// DeveloperWeight is the percentage weight of the project's total reputation assigned to the developer
-	DeveloperWeight = 0.2
+	// Reserve is the highest price the recipient was willing to pay
+	Reserve float64
 
-	// RecipientWeight is the percentage weight of the project's total reputation assigned to the recipient
-	RecipientWeight = 0.3
+	// Bids are all the bids placed in the auction
+	Bids []Bid
+
+	// Weights are the weights the bids were scored with
+	Weights ScoringWeights
+
+	// Scores is the scoring breakdown of the valid bids ranked from best to worst
+	Scores []BidScore
+
+	// WinningBid is the bid that won the auction, empty if the auction failed
+	WinningBid Bid
+
+	// Price is the price the winning contractor is paid
+	Price float64
+
+	// Closed is the unix time at which the auction was closed
+	Closed int64
+}
+
+const (
+	// InvestorWeight is the percentage weight of the project's total reputation assigned to the investor
+	InvestorWeight = 0.1
 
 	// NormalThreshold is the normal payback interval of 1 payback period. Regular notifications are sent regardless of whether the user has paid back towards the project.
 	NormalThreshold = 1
@@ -2,6 +2,7 @@ package core
 
 import (
 	"log"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -30,6 +31,12 @@ type Recipient struct {
 	// DeviceId is the device ID of the associated solar hub / IoT device
 	DeviceId string
 
+	// DevicePubkey is the hex encoded ed25519 public key the teller signs the readings it reports with
+	DevicePubkey string
+
+	// DeviceSeq is the sequence number of the last reading batch accepted from the teller
+	DeviceSeq uint64
+
 	// DeviceStarts contains the start time of the above IoT devices.
 	DeviceStarts []string
 
@@ -39,6 +46,10 @@ type Recipient struct {
 	// StateHashes stores the list of state updates (ipfs hashes) of the teller
 	StateHashes []string
 
+	// PaybackKeys are the idempotency keys of the paybacks made by the teller, so a payback the teller retries
+	// after losing its connection isn't made twice
+	PaybackKeys []string
+
 	// TellerEnergy contains the net energy consumed during a given period
 	TellerEnergy uint32
 
@@ -62,6 +73,10 @@ func NewRecipient(uname string, pwd string, seedpwd string, Name string) (Recipi
 // SetOneTimeUnlock sets a one time seedpwd that can be used to automatically unlock the project once an investment comes in
 func (a *Recipient) SetOneTimeUnlock(projIndex int, seedpwd string) error {
 	log.Println("setting one time unlock for project with index: ", projIndex)
+	// the seedpwd isn't recorded in the event log, the project is saved directly but under its lock so an event
+	// recorded at the same time isn't lost
+	defer lockProject(projIndex)()
+
 	project, err := RetrieveProject(projIndex)
 	if err != nil {
 		return errors.Wrap(err, "couldn't retrieve project")
@@ -104,3 +119,58 @@ func (a *Recipient) SetCompanyDetails(companyType, name, legalName, adminEmail,
 
 	return a.Save()
 }
+
+// PaidBack returns true if a payback with the given idempotency key has already been made
+func (a Recipient) PaidBack(key string) bool {
+	for _, x := range a.PaybackKeys {
+		if x == key {
+			return true
+		}
+	}
+	return false
+}
+
+// paybackKeyLock makes sure the idempotency key of a payback can't be claimed twice
+var paybackKeyLock sync.Mutex
+
+// ClaimPaybackKey claims the idempotency key of a payback before the payback is made, so a retry that arrives
+// while the payback is being made isn't paid as well. Returns false if the key has been claimed before
+func ClaimPaybackKey(recpIndex int, key string) (bool, error) {
+	paybackKeyLock.Lock()
+	defer paybackKeyLock.Unlock()
+
+	recipient, err := RetrieveRecipient(recpIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't retrieve recipient")
+	}
+	if recipient.PaidBack(key) {
+		return false, nil
+	}
+
+	recipient.PaybackKeys = append(recipient.PaybackKeys, key)
+	err = recipient.Save()
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't save recipient")
+	}
+	return true, nil
+}
+
+// ReleasePaybackKey releases the idempotency key of a payback that failed so the payback can be retried
+func ReleasePaybackKey(recpIndex int, key string) error {
+	paybackKeyLock.Lock()
+	defer paybackKeyLock.Unlock()
+
+	recipient, err := RetrieveRecipient(recpIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve recipient")
+	}
+
+	var keys []string
+	for _, x := range recipient.PaybackKeys {
+		if x != key {
+			keys = append(keys, x)
+		}
+	}
+	recipient.PaybackKeys = keys
+	return recipient.Save()
+}
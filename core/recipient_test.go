@@ -0,0 +1,75 @@
+// +build all travis
+
+package core
+
+import (
+	"sync"
+	"testing"
+
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+func TestPaybackKeys(t *testing.T) {
+	defer testDb(t)()
+
+	_, users, _, restore := testLedger(t)
+	defer restore()
+
+	user := openx.User{Index: 1, Email: "recipient"}
+	err := users.Add(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := Recipient{U: &user}
+	err = recipient.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only one of the retries of a payback claims its key
+	var wg sync.WaitGroup
+	claims := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := ClaimPaybackKey(1, "key")
+			if err != nil {
+				t.Error(err)
+			}
+			claims <- claimed
+		}()
+	}
+	wg.Wait()
+	close(claims)
+	count := 0
+	for claimed := range claims {
+		if claimed {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("payback key claimed %d times", count)
+	}
+
+	recipient, err = RetrieveRecipient(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recipient.PaidBack("key") || len(recipient.PaybackKeys) != 1 {
+		t.Fatalf("payback key not stored: %v", recipient.PaybackKeys)
+	}
+
+	// a payback that failed can be retried with the same key
+	err = ReleasePaybackKey(1, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := ClaimPaybackKey(1, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claimed {
+		t.Fatal("released payback key not claimed again")
+	}
+}
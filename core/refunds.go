@@ -0,0 +1,435 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+	utils "github.com/Varunram/essentials/utils"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	notif "github.com/YaleOpenLab/opensolar/notif"
+)
+
+// the states a refund can be in
+const (
+	// RefundPending is a refund whose investor assets haven't been sent back to the issuer yet
+	RefundPending = "pending"
+	// RefundOwed is a refund whose investor assets have been sent back to the issuer but that hasn't been paid yet
+	RefundOwed = "owed"
+	// RefundPaid is a refund that has been paid to the investor
+	RefundPaid = "paid"
+)
+
+// Refund is the refund of an investor's investments in a project that was cancelled or demoted before the
+// recipient accepted the investment
+type Refund struct {
+	// Index is the index of the refund in the refunds bucket
+	Index int
+
+	// ProjIndex is the index of the project
+	ProjIndex int
+
+	// InvIndex is the index of the investor
+	InvIndex int
+
+	// Amount is the amount of regular investments refunded
+	Amount float64
+
+	// SeedAmount is the amount of seed investments refunded
+	SeedAmount float64
+
+	// BurnTxHashes asset code: tx hash map of the transactions sending the investor's assets back to the issuer
+	BurnTxHashes map[string]string
+
+	// TxHash is the hash of the transaction refunding the investor
+	TxHash string
+
+	// Status is the status of the refund (RefundPending, RefundOwed, RefundPaid)
+	Status string
+}
+
+// refundLock makes sure an investor isn't refunded twice at the same time
+var refundLock sync.Mutex
+
+// Save saves a Refund's details
+func (a *Refund) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, RefundsBucket, a, a.Index)
+}
+
+// RetrieveAllRefunds retrieves all refunds from the database
+func RetrieveAllRefunds() ([]Refund, error) {
+	var arr []Refund
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, RefundsBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Refund
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// refundable returns whether regular (or seed) investments in the project can be refunded. All investments
+// are refundable once a project has been cancelled. Regular investments are refundable if the project is demoted
+// to before the raise and seed investments if it is demoted to before the seed round
+func (a Project) refundable(seed bool) bool {
+	if a.Cancelled {
+		return true
+	}
+	if seed {
+		return a.Stage < Stage1.Number
+	}
+	return a.Stage < Stage4.Number
+}
+
+// checkReversible checks whether the project's investments are still held by the platform, which is the case
+// until the recipient accepts the investment and funds are transferred to the project's escrow
+func (a Project) checkReversible() error {
+	if a.Cancelled {
+		return errors.New("project has already been cancelled, quitting")
+	}
+	if a.EscrowPubkey != "" || a.Stage >= Stage5.Number {
+		return errors.New("project has been funded and its funds moved to escrow, quitting")
+	}
+	return nil
+}
+
+// CancelProject cancels a project that hasn't been funded yet. Open orders for the project's investor assets
+// are cancelled, votes cast towards the project and collateral posted for it are returned and all parties are
+// notified. Investors can then claim refunds of their investments using RefundInvestor
+func CancelProject(projIndex int, reason string) error {
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	err = project.checkReversible()
+	if err != nil {
+		return err
+	}
+
+	err = cancelProjectOrders(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't cancel open orders")
+	}
+
+	err = project.restoreVotes()
+	if err != nil {
+		return errors.Wrap(err, "couldn't restore votes")
+	}
+
+	err = project.releaseCollateral(0)
+	if err != nil {
+		return errors.Wrap(err, "couldn't release collateral")
+	}
+
+	err = project.Record(ProjectEvent{Type: EventProjectCancelled, Stage: project.Stage, Reason: reason})
+	if err != nil {
+		return errors.Wrap(err, "couldn't record cancellation")
+	}
+
+	log.Println("cancelled project: ", projIndex, " reason: ", reason)
+	for _, user := range project.stakeholders() {
+		notif.SendCancellationEmail(projIndex, user.Index, user.Email, reason)
+	}
+	return notif.SendCancellationEmail(projIndex, 0, consts.PlatformEmail, reason)
+}
+
+// DemoteStage moves a project that hasn't been funded yet back to an earlier stage. The checklists of the stages
+// the project moves back over have to be completed again. Demoting a project to before the raise (or seed round)
+// lets investors claim refunds of their regular (or seed) investments using RefundInvestor
+func DemoteStage(projIndex int, stage int, reason string) error {
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	err = project.checkReversible()
+	if err != nil {
+		return err
+	}
+
+	if stage < 0 || stage >= project.Stage {
+		return errors.New("project can only be demoted to an earlier stage, quitting")
+	}
+
+	if stage <= Stage2.Number {
+		// the project is back to being proposed, so investors can vote on it again
+		err = project.restoreVotes()
+		if err != nil {
+			return errors.Wrap(err, "couldn't restore votes")
+		}
+	}
+
+	err = project.Record(ProjectEvent{Type: EventStageDemoted, Stage: stage, Reason: reason})
+	if err != nil {
+		return errors.Wrap(err, "couldn't record demotion")
+	}
+
+	log.Println("demoted project: ", projIndex, " to stage: ", stage, " reason: ", reason)
+	for _, user := range project.stakeholders() {
+		notif.SendDemotionEmail(projIndex, user.Index, user.Email, stage, reason)
+	}
+	return notif.SendDemotionEmail(projIndex, 0, consts.PlatformEmail, stage, reason)
+}
+
+// cancelProjectOrders cancels the open orders for a project's investor assets
+func cancelProjectOrders(projIndex int) error {
+	book, err := RetrieveOrderBook(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve order book")
+	}
+
+	for _, order := range append(book.Bids, book.Asks...) {
+		_, err = CancelOrder(order.InvIndex, order.Index)
+		if err != nil {
+			return errors.Wrap(err, "couldn't cancel order")
+		}
+	}
+	return nil
+}
+
+// restoreVotes returns the votes investors cast towards the project
+func (a *Project) restoreVotes() error {
+	for invIndex, votes := range a.VotesCast {
+		investor, err := RetrieveInvestor(invIndex)
+		if err != nil {
+			return errors.Wrap(err, "couldn't retrieve investor")
+		}
+		err = investor.ChangeVotingBalance(votes)
+		if err != nil {
+			return errors.Wrap(err, "couldn't change voting balance")
+		}
+
+		// votes are removed one investor at a time so votes aren't restored twice if restoring the rest fails
+		votesCast := make(map[int]float64)
+		for index, x := range a.VotesCast {
+			if index != invIndex {
+				votesCast[index] = x
+			}
+		}
+		remaining := a.Votes - votes
+		if remaining < 0 {
+			remaining = 0
+		}
+		err = a.Update("votes restored", map[string]interface{}{"Votes": remaining, "VotesCast": votesCast})
+		if err != nil {
+			return errors.Wrap(err, "couldn't update project")
+		}
+	}
+	return nil
+}
+
+// refundAmounts returns the regular and seed investments of an investor in a project that haven't been
+// refunded yet according to the project's event log
+func refundAmounts(events []ProjectEvent, invIndex int) (float64, float64) {
+	var amount, seedAmount float64
+	for _, event := range events {
+		switch event.Type {
+		case EventInvestmentReceived:
+			if event.UserIndex != invIndex {
+				continue
+			}
+			if event.Seed {
+				seedAmount += event.Amount
+			} else {
+				amount += event.Amount
+			}
+		case EventSharesTransferred:
+			if event.UserIndex == invIndex {
+				amount += event.Amount
+			} else if event.FromIndex == invIndex {
+				amount -= event.Amount
+			}
+		case EventInvestmentRefunded:
+			if event.UserIndex != invIndex {
+				continue
+			}
+			if event.Seed {
+				seedAmount -= event.Amount
+			} else {
+				amount -= event.Amount
+			}
+		}
+	}
+	return amount, seedAmount
+}
+
+// RefundInvestor refunds an investor's refundable investments in a project. The refund is saved before the
+// investor's assets are sent back to the project's issuer and each burn is recorded, so a refund the platform
+// couldn't pay after the assets were burnt is still owed and is paid the next time the investor claims it
+func RefundInvestor(projIndex int, invIndex int, seedpwd string) (Refund, error) {
+	refundLock.Lock()
+	defer refundLock.Unlock()
+
+	var refund Refund
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return refund, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if project.EscrowPubkey != "" {
+		return refund, errors.New("project has been funded and its funds moved to escrow, quitting")
+	}
+
+	investor, err := RetrieveInvestor(invIndex)
+	if err != nil {
+		return refund, errors.Wrap(err, "couldn't retrieve investor")
+	}
+
+	refunds, err := RetrieveAllRefunds()
+	if err != nil {
+		return refund, errors.Wrap(err, "couldn't retrieve refunds")
+	}
+	for _, x := range refunds {
+		if x.ProjIndex == projIndex && x.InvIndex == invIndex && x.Status != RefundPaid {
+			refund = x
+			break
+		}
+	}
+
+	var seed string
+	if refund.Index == 0 || refund.Status == RefundPending {
+		seed, err = ledger.DecryptSeed(investor.U.StellarWallet.EncryptedSeed, seedpwd)
+		if err != nil {
+			return refund, errors.Wrap(err, "couldn't decrypt seed")
+		}
+	}
+
+	pubkey := investor.U.StellarWallet.PublicKey
+	if refund.Index == 0 {
+		events, err := RetrieveProjectEvents(projIndex)
+		if err != nil {
+			return refund, errors.Wrap(err, "couldn't retrieve project events")
+		}
+
+		amount, seedAmount := refundAmounts(events, invIndex)
+		if !project.refundable(false) {
+			amount = 0
+		}
+		if !project.refundable(true) {
+			seedAmount = 0
+		}
+
+		// investor assets are issued one per dollar invested, so the investor can't be refunded more than they hold
+		if balance := ledger.GetAssetBalance(pubkey, project.InvestorAssetCode); amount > balance {
+			amount = balance
+		}
+		if balance := ledger.GetAssetBalance(pubkey, project.SeedAssetCode); seedAmount > balance {
+			seedAmount = balance
+		}
+
+		if amount <= 0 && seedAmount <= 0 {
+			return refund, errors.New("investor doesn't have any refundable investments in project, quitting")
+		}
+
+		refund = Refund{Index: len(refunds) + 1, ProjIndex: projIndex, InvIndex: invIndex, Amount: amount,
+			SeedAmount: seedAmount, Status: RefundPending}
+		err = refund.Save()
+		if err != nil {
+			return refund, errors.Wrap(err, "couldn't save refund")
+		}
+	}
+
+	projIndexString, err := utils.ToString(projIndex)
+	if err != nil {
+		return refund, err
+	}
+
+	if refund.Status == RefundPending {
+		issuerPubkey, _, err := ledger.RetrieveIssuer(consts.OpenSolarIssuerDir, projIndex, consts.IssuerSeedPwd)
+		if err != nil {
+			return refund, errors.Wrap(err, "couldn't retrieve issuer")
+		}
+
+		for _, x := range []struct {
+			assetCode string
+			amount    float64
+		}{{project.InvestorAssetCode, refund.Amount}, {project.SeedAssetCode, refund.SeedAmount}} {
+			if x.amount <= 0 || refund.BurnTxHashes[x.assetCode] != "" {
+				continue // nothing to burn or burnt before the refund could be saved as owed
+			}
+			if ledger.GetAssetBalance(pubkey, x.assetCode) < x.amount {
+				log.Println("investor: ", invIndex, " no longer holds the assets of refund: ", refund.Index)
+				return refund, errors.New("investor no longer holds the assets to be refunded, quitting")
+			}
+
+			burnTxHash, err := ledger.SendAssetToIssuer(x.assetCode, issuerPubkey, x.amount, seed)
+			if err != nil {
+				return refund, errors.Wrap(err, "couldn't send investor assets back to issuer")
+			}
+			if refund.BurnTxHashes == nil {
+				refund.BurnTxHashes = make(map[string]string)
+			}
+			refund.BurnTxHashes[x.assetCode] = burnTxHash
+			err = refund.Save()
+			if err != nil {
+				return refund, errors.Wrap(err, "couldn't save refund")
+			}
+		}
+
+		refund.Status = RefundOwed
+		err = refund.Save()
+		if err != nil {
+			return refund, errors.Wrap(err, "couldn't save refund")
+		}
+	}
+
+	code, stablecoinIssuer := platformStablecoin()
+	txhash, err := ledger.SendAsset(code, stablecoinIssuer, pubkey, refund.Amount+refund.SeedAmount, consts.PlatformSeed,
+		"Opensolar refund: "+projIndexString)
+	if err != nil {
+		// the assets have been burnt, so the refund stays owed and the platform has to follow up
+		log.Println("couldn't pay refund: ", refund.Index, " to investor: ", invIndex, err)
+		notif.SendRefundFailedEmail(projIndex, invIndex, refund.Amount+refund.SeedAmount)
+		return refund, errors.Wrap(err, "couldn't send refund to investor")
+	}
+
+	refund.TxHash = txhash
+	refund.Status = RefundPaid
+	err = refund.Save()
+	if err != nil {
+		return refund, errors.Wrap(err, "couldn't save refund")
+	}
+
+	for _, x := range []struct {
+		seed   bool
+		amount float64
+	}{{false, refund.Amount}, {true, refund.SeedAmount}} {
+		if x.amount <= 0 {
+			continue
+		}
+
+		err = project.Record(ProjectEvent{Type: EventInvestmentRefunded, UserIndex: invIndex, From: pubkey,
+			Amount: x.amount, Seed: x.seed, TxHash: txhash})
+		if err != nil {
+			return refund, errors.Wrap(err, "couldn't record refund")
+		}
+
+		investor.AmountInvested -= x.amount
+		if x.seed {
+			investor.SeedInvestedSolarProjectsIndices = removeIndex(investor.SeedInvestedSolarProjectsIndices, projIndex)
+		} else {
+			investor.InvestedSolarProjectsIndices = removeIndex(investor.InvestedSolarProjectsIndices, projIndex)
+		}
+	}
+
+	err = investor.Save()
+	if err != nil {
+		return refund, errors.Wrap(err, "couldn't save investor")
+	}
+
+	notif.SendRefundEmail(projIndex, investor.U.Index, investor.U.Email, refund.Amount+refund.SeedAmount, refund.TxHash)
+	return refund, nil
+}
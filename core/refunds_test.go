@@ -0,0 +1,254 @@
+// +build all travis
+
+package core
+
+import (
+	"testing"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+func TestRefundAmounts(t *testing.T) {
+	events := []ProjectEvent{
+		{Type: EventInvestmentReceived, UserIndex: 1, Amount: 100},
+		{Type: EventInvestmentReceived, UserIndex: 1, Amount: 50, Seed: true},
+		{Type: EventInvestmentReceived, UserIndex: 2, Amount: 200},
+		{Type: EventSharesTransferred, UserIndex: 2, FromIndex: 1, Amount: 30},
+		{Type: EventInvestmentRefunded, UserIndex: 1, Amount: 20, Seed: true},
+	}
+
+	amount, seedAmount := refundAmounts(events, 1)
+	if amount != 70 || seedAmount != 30 {
+		t.Fatalf("expected refunds of 70 and 30, got %f and %f", amount, seedAmount)
+	}
+	amount, seedAmount = refundAmounts(events, 2)
+	if amount != 230 || seedAmount != 0 {
+		t.Fatalf("expected refunds of 230 and 0, got %f and %f", amount, seedAmount)
+	}
+}
+
+func TestCancellationEvents(t *testing.T) {
+	var project Project
+	project.Stage = 4
+	project.Lock = true
+	project.TotalValue = 1000
+	project.SeedInvestmentFactor = 1.1
+	project.MoneyRaised = 300
+	project.SeedMoneyRaised = 10
+	project.InvestorMap = map[string]float64{"inv1": 0.2, "inv2": 0.1}
+	project.SeedInvestorMap = map[string]float64{"inv1": 0.1}
+	project.InvestorIndices = []int{1, 2, 1}
+	project.SeedInvestorIndices = []int{1}
+	project.StageChecklist = []map[string]bool{{"0": true}, {"0": true}, {"0": true}, {"0": true}, {"0": true}}
+
+	if project.refundable(false) || project.refundable(true) {
+		t.Fatalf("investments refundable during the raise")
+	}
+
+	err := project.Apply(ProjectEvent{Type: EventStageDemoted, Stage: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Stage != 3 || project.Lock || project.StageChecklist[3] != nil || project.StageChecklist[2] == nil {
+		t.Fatalf("demotion not applied: %+v", project)
+	}
+	if !project.refundable(false) || project.refundable(true) {
+		t.Fatalf("only regular investments should be refundable after demotion to before the raise")
+	}
+
+	err = project.Apply(ProjectEvent{Type: EventInvestmentRefunded, UserIndex: 1, From: "inv1", Amount: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.MoneyRaised != 100 || project.InvestorMap["inv1"] != 0 || !containsIndex(project.InvestorIndices, 1) {
+		t.Fatalf("regular refund not applied or seed investor removed: %+v", project)
+	}
+
+	err = project.Apply(ProjectEvent{Type: EventProjectCancelled, Stage: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !project.Cancelled || !project.refundable(true) {
+		t.Fatalf("seed investments not refundable after cancellation")
+	}
+	if project.checkReversible() == nil {
+		t.Fatalf("cancelled project can be cancelled again")
+	}
+
+	err = project.Apply(ProjectEvent{Type: EventInvestmentRefunded, UserIndex: 1, From: "inv1", Amount: 100, Seed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsIndex(project.InvestorIndices, 1) || containsIndex(project.SeedInvestorIndices, 1) ||
+		project.SeedMoneyRaised > 1e-9 {
+		t.Fatalf("fully refunded investor still part of project: %+v", project)
+	}
+
+	project = Project{Stage: 4, EscrowPubkey: "escrow"}
+	if project.checkReversible() == nil {
+		t.Fatalf("funded project can be cancelled")
+	}
+}
+
+func TestRefundInvestor(t *testing.T) {
+	defer testDb(t)()
+
+	l, users, platformPubkey, restore := testLedger(t)
+	defer restore()
+
+	invSeed, invPubkey := l.NewAccount()
+	user := openx.User{Index: 1, Email: "investor", StellarWallet: openx.Wallet{PublicKey: invPubkey,
+		EncryptedSeed: []byte(invSeed)}}
+	err := users.Add(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	investor := Investor{U: &user, AmountInvested: 150, InvestedSolarProjectsIndices: []int{1},
+		SeedInvestedSolarProjectsIndices: []int{1}}
+	err = investor.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.InitIssuer(consts.OpenSolarIssuerDir, 1, consts.IssuerSeedPwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := Project{Index: 1, Stage: 3, TotalValue: 1000, SeedInvestmentFactor: 1.1, InvestorAssetCode: "INVASSET",
+		SeedAssetCode: "SEEDASSET"}
+	err = project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []ProjectEvent{
+		{Type: EventInvestmentReceived, UserIndex: 1, From: invPubkey, Amount: 50, Seed: true},
+		{Type: EventInvestmentReceived, UserIndex: 1, From: invPubkey, Amount: 100},
+		{Type: EventProjectCancelled, Stage: 3},
+	} {
+		err = project.Record(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Mint(invPubkey, "INVASSET", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Mint(invPubkey, "SEEDASSET", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the platform can't pay the refund, which is kept as owed after the assets have been burnt
+	refund, err := RefundInvestor(1, 1, "")
+	if err == nil {
+		t.Fatalf("refund paid without stablecoin")
+	}
+	if refund.Status != RefundOwed || len(refund.BurnTxHashes) != 2 || l.GetAssetBalance(invPubkey, "INVASSET") != 0 {
+		t.Fatalf("assets not burnt or refund not owed: %+v", refund)
+	}
+
+	err = l.Mint(platformPubkey, stablecoinCode(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// claiming the refund again pays it even though the investor no longer holds the assets
+	refund, err = RefundInvestor(1, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != RefundPaid || refund.Amount != 100 || refund.SeedAmount != 50 ||
+		l.GetAssetBalance(invPubkey, stablecoinCode()) != 150 {
+		t.Fatalf("owed refund not paid: %+v", refund)
+	}
+
+	refunds, err := RetrieveAllRefunds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 1 || refunds[0].Status != RefundPaid {
+		t.Fatalf("refund not saved as paid: %+v", refunds)
+	}
+
+	_, err = RefundInvestor(1, 1, "")
+	if err == nil {
+		t.Fatalf("investor refunded twice")
+	}
+	if l.GetAssetBalance(invPubkey, stablecoinCode()) != 150 {
+		t.Fatalf("investor paid twice")
+	}
+}
+
+func TestRefundInvestorPending(t *testing.T) {
+	defer testDb(t)()
+
+	l, users, platformPubkey, restore := testLedger(t)
+	defer restore()
+
+	invSeed, invPubkey := l.NewAccount()
+	user := openx.User{Index: 1, Email: "investor", StellarWallet: openx.Wallet{PublicKey: invPubkey,
+		EncryptedSeed: []byte(invSeed)}}
+	err := users.Add(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	investor := Investor{U: &user, AmountInvested: 150, InvestedSolarProjectsIndices: []int{1},
+		SeedInvestedSolarProjectsIndices: []int{1}}
+	err = investor.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.InitIssuer(consts.OpenSolarIssuerDir, 1, consts.IssuerSeedPwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := Project{Index: 1, Stage: 3, Cancelled: true, InvestorAssetCode: "INVASSET", SeedAssetCode: "SEEDASSET"}
+	err = project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Mint(platformPubkey, stablecoinCode(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the seed assets of a pending refund are gone without their burn being recorded
+	refund := Refund{Index: 1, ProjIndex: 1, InvIndex: 1, Amount: 100, SeedAmount: 50, Status: RefundPending}
+	err = refund.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Mint(invPubkey, "INVASSET", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RefundInvestor(1, 1, "")
+	if err == nil || l.GetAssetBalance(invPubkey, stablecoinCode()) != 0 {
+		t.Fatalf("refund paid without the investor's assets")
+	}
+
+	// once the burn is recorded, only the assets that haven't been burnt are sent back
+	refund, err = RefundInvestor(1, 1, "")
+	if err == nil {
+		t.Fatalf("refund paid without the investor's seed assets")
+	}
+	if refund.BurnTxHashes["INVASSET"] == "" || l.GetAssetBalance(invPubkey, "INVASSET") != 0 {
+		t.Fatalf("burn not recorded per asset: %+v", refund)
+	}
+	refund.BurnTxHashes["SEEDASSET"] = "burnt"
+	err = refund.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refund, err = RefundInvestor(1, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != RefundPaid || l.GetAssetBalance(invPubkey, stablecoinCode()) != 150 {
+		t.Fatalf("pending refund not paid: %+v", refund)
+	}
+}
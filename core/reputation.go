@@ -0,0 +1,370 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+	utils "github.com/Varunram/essentials/utils"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// the reputation of the recipient and entities on a project is computed from the feedback they receive from the
+// other parties, how punctually the recipient pays back and the breaches they're responsible for. Older feedback,
+// paybacks and breaches count for less, halving in weight every ReputationHalfLife seconds.
+var (
+	// ReputationHalfLife is the age in seconds at which feedback, paybacks and breaches count for half
+	ReputationHalfLife = int64(365 * 24 * 60 * 60)
+
+	// ReputationWeights are the weights of feedback, punctuality and breach history in the reputation score
+	ReputationWeights = ReputationComponents{Feedback: 0.5, Punctuality: 0.3, Breaches: 0.2}
+)
+
+// MaxReputation is the reputation of a user with a perfect score
+const MaxReputation = 100
+
+// ReputationComponents holds a value for each component of the reputation score
+type ReputationComponents struct {
+	Feedback    float64
+	Punctuality float64
+	Breaches    float64
+}
+
+// ReputationScore is the breakdown of a user's reputation. Each component is scored between zero and one and
+// components without any history are left out of the total
+type ReputationScore struct {
+	// UserIndex is the index of the user the score belongs to
+	UserIndex int
+
+	// Scores are the scores of each component
+	Scores ReputationComponents
+
+	// Feedback is the number of ratings received
+	Feedback int
+
+	// Paybacks is the number of paybacks made as a recipient (overdue paybacks included)
+	Paybacks int
+
+	// Breaches is the number of breaches the user was responsible for
+	Breaches int
+
+	// Projects is the number of projects the user is a party to (investments aside)
+	Projects int
+
+	// Total is the weighted average of the components with history
+	Total float64
+
+	// Reputation is the total scaled to MaxReputation
+	Reputation float64
+
+	// Timestamp is the unix time at which the score was computed
+	Timestamp int64
+}
+
+// Save saves a Feedback's details
+func (a *Feedback) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, FeedbackBucket, a, a.Index)
+}
+
+// RetrieveAllFeedback retrieves all feedback from the database
+func RetrieveAllFeedback() ([]Feedback, error) {
+	var arr []Feedback
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, FeedbackBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Feedback
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// RetrieveUserFeedback retrieves the feedback a user has received in the order it was given
+func RetrieveUserFeedback(userIndex int) ([]Feedback, error) {
+	var arr []Feedback
+	feedback, err := RetrieveAllFeedback()
+	if err != nil {
+		return arr, errors.Wrap(err, "couldn't retrieve feedback")
+	}
+
+	for _, x := range feedback {
+		if x.ToIndex == userIndex {
+			arr = append(arr, x)
+		}
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Index < arr[j].Index
+	})
+	return arr, nil
+}
+
+// isParty returns whether the user is a party to the project other than as an investor
+func (a Project) isParty(userIndex int) bool {
+	for party, isParty := range a.Parties(userIndex) {
+		if isParty && party != PartyInvestor {
+			return true
+		}
+	}
+	return false
+}
+
+// LeaveFeedback records a rating given by a party to a project to another party for a stage milestone the
+// project has passed. A party can rate each other party once per milestone
+func LeaveFeedback(projIndex int, stage int, fromIndex int, toIndex int, rating int, content string) (Feedback, error) {
+	var feedback Feedback
+	if rating < 1 || rating > 5 {
+		return feedback, errors.New("rating must be between 1 and 5, quitting")
+	}
+	if fromIndex == toIndex {
+		return feedback, errors.New("parties can't rate themselves, quitting")
+	}
+
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return feedback, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if stage < 0 || stage >= project.Stage {
+		return feedback, errors.New("feedback can only be left for stages the project has passed, quitting")
+	}
+
+	fromParty := false
+	for _, isParty := range project.Parties(fromIndex) {
+		fromParty = fromParty || isParty
+	}
+	if !fromParty {
+		return feedback, errors.New("only parties to the project can leave feedback, quitting")
+	}
+	if !project.isParty(toIndex) {
+		return feedback, errors.New("feedback can only be left for the recipient and entities on the project, quitting")
+	}
+
+	all, err := RetrieveAllFeedback()
+	if err != nil {
+		return feedback, errors.Wrap(err, "couldn't retrieve feedback")
+	}
+	for _, x := range all {
+		if x.ProjIndex == projIndex && x.Stage == stage && x.FromIndex == fromIndex && x.ToIndex == toIndex {
+			return feedback, errors.New("feedback has already been left for this stage, quitting")
+		}
+	}
+
+	feedback.Index = len(all) + 1
+	feedback.ProjIndex = projIndex
+	feedback.Stage = stage
+	feedback.FromIndex = fromIndex
+	feedback.ToIndex = toIndex
+	feedback.Rating = rating
+	feedback.Content = content
+	feedback.Date = utils.Timestamp()
+	feedback.Timestamp = clock()
+	err = feedback.Save()
+	if err != nil {
+		return feedback, errors.Wrap(err, "couldn't save feedback")
+	}
+
+	if project.RecipientIndex != toIndex {
+		entity, err := RetrieveEntity(toIndex)
+		if err != nil {
+			return feedback, errors.Wrap(err, "couldn't retrieve entity")
+		}
+		entity.PastFeedback = append(entity.PastFeedback, feedback)
+		err = entity.Save()
+		if err != nil {
+			return feedback, errors.Wrap(err, "couldn't save entity")
+		}
+	}
+
+	return feedback, UpdateReputation(toIndex)
+}
+
+// decay returns the weight of something that happened age seconds ago
+func decay(age int64) float64 {
+	if age <= 0 || ReputationHalfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(age)/float64(ReputationHalfLife))
+}
+
+// breachParties returns the parties responsible for breaching a rule
+func breachParties(rule BreachRule) []string {
+	switch rule.Type {
+	case BreachMissedPayments, BreachHeartbeat:
+		return []string{PartyRecipient}
+	case BreachCollateral:
+		return []string{PartyContractor}
+	case BreachDeadline:
+		return []string{PartyContractor, PartyDeveloper}
+	}
+	return nil
+}
+
+// paybackPeriod returns the payback period of a project in seconds
+func (a Project) paybackPeriod() int64 {
+	return int64(time.Duration(a.PaybackPeriod) * consts.OneWeekInSecond / time.Second)
+}
+
+// punctuality returns the weighted number of paybacks towards a project that were on time along with the total
+// weight and number of paybacks. A payback is on time if it was made within a payback period of the previous
+// payback (or the project being funded) and a payback that is overdue at now counts as late
+func (a Project) punctuality(events []ProjectEvent, now int64) (float64, float64, int) {
+	var onTime, weight float64
+	var count int
+	period := a.paybackPeriod()
+	if period <= 0 {
+		return 0, 0, 0
+	}
+
+	var last int64
+	for _, event := range events {
+		switch event.Type {
+		case EventProjectFunded:
+			last = event.Timestamp
+		case EventPaybackRecorded:
+			if last != 0 {
+				w := decay(now - event.Timestamp)
+				if event.Timestamp-last <= period {
+					onTime += w
+				}
+				weight += w
+				count++
+			}
+			last = event.Timestamp
+		}
+	}
+
+	if last != 0 && !a.Cancelled && a.Stage < Stage9.Number && now-last > period {
+		weight++
+		count++
+	}
+	return onTime, weight, count
+}
+
+// computeReputation computes the reputation of a user from the projects they're a party to, the event logs of
+// those projects (keyed by project index) and the feedback they've received
+func computeReputation(userIndex int, projects []Project, events map[int][]ProjectEvent, feedback []Feedback,
+	now int64) ReputationScore {
+	var score ReputationScore
+	score.UserIndex = userIndex
+	score.Timestamp = now
+
+	var weights ReputationComponents
+
+	var rated, ratedWeight float64
+	for _, x := range feedback {
+		if x.ToIndex != userIndex || x.Rating == 0 {
+			continue
+		}
+		w := decay(now - x.Timestamp)
+		rated += w * float64(x.Rating-1) / 4
+		ratedWeight += w
+		score.Feedback++
+	}
+	if ratedWeight > 0 {
+		score.Scores.Feedback = rated / ratedWeight
+		weights.Feedback = ReputationWeights.Feedback
+	}
+
+	var onTime, paybackWeight, breachWeight float64
+	for _, project := range projects {
+		if !project.isParty(userIndex) {
+			continue
+		}
+		score.Projects++
+		parties := project.Parties(userIndex)
+
+		if parties[PartyRecipient] {
+			x, w, count := project.punctuality(events[project.Index], now)
+			onTime += x
+			paybackWeight += w
+			score.Paybacks += count
+		}
+
+		for _, breach := range project.Breaches {
+			for _, party := range breachParties(breach.Rule) {
+				if parties[party] {
+					breachWeight += decay(now - breach.Timestamp)
+					score.Breaches++
+					break
+				}
+			}
+		}
+	}
+
+	if paybackWeight > 0 {
+		score.Scores.Punctuality = onTime / paybackWeight
+		weights.Punctuality = ReputationWeights.Punctuality
+	}
+	if score.Projects > 0 {
+		score.Scores.Breaches = 1 / (1 + breachWeight)
+		weights.Breaches = ReputationWeights.Breaches
+	}
+
+	total := weights.Feedback + weights.Punctuality + weights.Breaches
+	if total == 0 {
+		score.Total = 0.5 // no history, neither good nor bad
+	} else {
+		score.Total = (weights.Feedback*score.Scores.Feedback + weights.Punctuality*score.Scores.Punctuality +
+			weights.Breaches*score.Scores.Breaches) / total
+	}
+	score.Reputation = score.Total * MaxReputation
+	return score
+}
+
+// ComputeReputation computes the reputation of a user from the feedback they've received, their punctuality in
+// paying back as a recipient and the breaches they were responsible for
+func ComputeReputation(userIndex int) (ReputationScore, error) {
+	var score ReputationScore
+	projects, err := RetrieveAllProjects()
+	if err != nil {
+		return score, errors.Wrap(err, "couldn't retrieve projects")
+	}
+
+	events := make(map[int][]ProjectEvent)
+	for _, project := range projects {
+		if project.RecipientIndex != userIndex {
+			continue
+		}
+		events[project.Index], err = RetrieveProjectEvents(project.Index)
+		if err != nil {
+			return score, errors.Wrap(err, "couldn't retrieve project events")
+		}
+	}
+
+	feedback, err := RetrieveUserFeedback(userIndex)
+	if err != nil {
+		return score, errors.Wrap(err, "couldn't retrieve feedback")
+	}
+
+	return computeReputation(userIndex, projects, events, feedback, clock()), nil
+}
+
+// UpdateReputation recomputes the reputation of a user and stores it with openx
+func UpdateReputation(userIndex int) error {
+	score, err := ComputeReputation(userIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't compute reputation")
+	}
+
+	user, err := RetrieveUser(userIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve user")
+	}
+
+	log.Println("updating reputation of user: ", userIndex, " to: ", score.Reputation)
+	return ChangeReputation(userIndex, score.Reputation-user.Reputation)
+}
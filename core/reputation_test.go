@@ -0,0 +1,85 @@
+// +build all travis
+
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeReputation(t *testing.T) {
+	now := int64(10 * 365 * 24 * 60 * 60)
+	week := int64(7 * 24 * 60 * 60)
+
+	// a user without any history is neither good nor bad
+	score := computeReputation(1, nil, nil, nil, now)
+	if score.Total != 0.5 || score.Reputation != 50 {
+		t.Fatalf("user without history doesn't have a neutral score: %+v", score)
+	}
+
+	projects := []Project{
+		{Index: 1, RecipientIndex: 1, ContractorIndex: 2, PaybackPeriod: 1, Stage: 6,
+			Breaches: []Breach{{Rule: BreachRule{Type: BreachCollateral}, Timestamp: now}}},
+		{Index: 2, RecipientIndex: 3, ContractorIndex: 2, Stage: 6,
+			Breaches: []Breach{{Rule: BreachRule{Type: BreachMissedPayments}, Timestamp: now}}},
+	}
+	events := map[int][]ProjectEvent{1: {
+		{Type: EventProjectFunded, Timestamp: now - 4*week},
+		{Type: EventPaybackRecorded, Timestamp: now - 3*week},     // on time
+		{Type: EventPaybackRecorded, Timestamp: now - week - 100}, // late
+		{Type: EventPaybackRecorded, Timestamp: now - 100},        // on time
+	}}
+	feedback := []Feedback{
+		{ToIndex: 2, Rating: 5, Timestamp: now},
+		{ToIndex: 2, Rating: 1, Timestamp: now - ReputationHalfLife}, // counts for half
+		{ToIndex: 1, Rating: 3, Timestamp: now},
+	}
+
+	// the recipient of project 1 has feedback and punctuality but no breaches
+	score = computeReputation(1, projects, events, feedback, now)
+	if score.Feedback != 1 || score.Scores.Feedback != 0.5 || score.Paybacks != 3 || score.Breaches != 0 ||
+		score.Projects != 1 || score.Scores.Breaches != 1 {
+		t.Fatalf("recipient reputation breakdown doesn't match: %+v", score)
+	}
+	// recent paybacks barely decay
+	if math.Abs(score.Scores.Punctuality-2.0/3) > 1e-2 {
+		t.Fatalf("recipient punctuality doesn't match: %f", score.Scores.Punctuality)
+	}
+
+	// the contractor is responsible for the collateral breach but not the missed payments
+	score = computeReputation(2, projects, events, feedback, now)
+	if score.Breaches != 1 || score.Scores.Breaches != 0.5 || score.Paybacks != 0 || score.Projects != 2 {
+		t.Fatalf("contractor reputation breakdown doesn't match: %+v", score)
+	}
+	// (1 * 1 + 0.5 * 0) / 1.5
+	if math.Abs(score.Scores.Feedback-2.0/3) > 1e-9 {
+		t.Fatalf("older feedback doesn't decay: %f", score.Scores.Feedback)
+	}
+	expected := (0.5*2.0/3 + 0.2*0.5) / 0.7
+	if math.Abs(score.Total-expected) > 1e-9 || math.Abs(score.Reputation-expected*MaxReputation) > 1e-6 {
+		t.Fatalf("contractor total doesn't match: %f, expected %f", score.Total, expected)
+	}
+}
+
+func TestPunctuality(t *testing.T) {
+	week := int64(7 * 24 * 60 * 60)
+	project := Project{PaybackPeriod: 1, Stage: 6}
+	events := []ProjectEvent{{Type: EventProjectFunded, Timestamp: week}, {Type: EventPaybackRecorded, Timestamp: 2 * week}}
+
+	onTime, weight, count := project.punctuality(events, 2*week)
+	if onTime != 1 || weight != 1 || count != 1 {
+		t.Fatalf("payback on time not counted: %f %f %d", onTime, weight, count)
+	}
+
+	// the next payback is overdue
+	onTime, weight, count = project.punctuality(events, 4*week)
+	if onTime >= 1 || weight < 1.9 || count != 2 {
+		t.Fatalf("overdue payback not counted as late: %f %f %d", onTime, weight, count)
+	}
+
+	project.Stage = 9
+	_, _, count = project.punctuality(events, 4*week)
+	if count != 1 {
+		t.Fatalf("paid off project has an overdue payback")
+	}
+}
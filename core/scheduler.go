@@ -0,0 +1,309 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	notif "github.com/YaleOpenLab/opensolar/notif"
+)
+
+// the jobs run by the scheduler. Jobs are stored in the database along with the time they should run next so
+// they survive restarts of the platform
+const (
+	// JobPaybackCheck checks whether the recipient is paying back towards a project (was monitorPaybacks)
+	JobPaybackCheck = "paybackcheck"
+	// JobPaymentReminder reminds the recipient to pay back towards a project (was sendPaymentNotif)
+	JobPaymentReminder = "paymentreminder"
+	// JobTellerHealth checks whether a project's teller is live (was MonitorTeller)
+	JobTellerHealth = "tellerhealth"
+	// JobBreachCheck evaluates the breach rules of a project's stage
+	JobBreachCheck = "breachcheck"
+	// JobAuctionClose closes a project's auction once it has ended
+	JobAuctionClose = "auctionclose"
+	// JobNotifications retries the delivery of notifications in the outbox. It isn't tied to a project
+	JobNotifications = "notifications"
+	// JobWebhooks retries webhook deliveries that failed. It isn't tied to a project
+	JobWebhooks = "webhooks"
+)
+
+// SchedulerTick is the interval at which the scheduler looks for jobs that are due
+var SchedulerTick = 5 * time.Second
+
+// TellerCheckInterval is the interval in seconds between two health checks of a teller
+var TellerCheckInterval = int64(60)
+
+// NotificationInterval is the interval in seconds between two attempts to deliver queued notifications
+var NotificationInterval = int64(60)
+
+// Job is a recurring task persisted by the scheduler
+type Job struct {
+	// Index is the index of the job in the jobs bucket
+	Index int
+
+	// Type is the type of the job (JobPaybackCheck, JobPaymentReminder, JobTellerHealth, JobBreachCheck, JobAuctionClose,
+	// JobNotifications, JobWebhooks)
+	Type string
+
+	// ProjIndex is the index of the project the job is associated with
+	ProjIndex int
+
+	// Interval is the time in seconds between two runs of the job
+	Interval int64
+
+	// NextRun is the unix time at which the job should run next
+	NextRun int64
+
+	// LastRun is the unix time at which the job ran last
+	LastRun int64
+
+	// Runs is the number of times the job has run
+	Runs int
+
+	// LastError is the error returned by the last run of the job (if any)
+	LastError string
+
+	// Paused is set if an admin has paused the job
+	Paused bool
+
+	// Done is set once the job has nothing left to do (eg. the project has been paid off)
+	Done bool
+}
+
+// jobHandlers run a single iteration of a job and return true if the job is done
+var jobHandlers = map[string]func(Job) (bool, error){
+	JobPaybackCheck:    checkPayback,
+	JobPaymentReminder: sendPaymentNotif,
+	JobTellerHealth:    checkTeller,
+	JobBreachCheck:     checkBreaches,
+	JobAuctionClose:    checkAuction,
+	JobNotifications:   deliverNotifications,
+	JobWebhooks:        deliverWebhooks,
+}
+
+// schedulerLock guards loading and saving jobs. Jobs run without the lock held so a slow job doesn't hold up
+// the others, running keeps a job from being run by the scheduler and triggered by an admin at the same time
+var (
+	schedulerLock sync.Mutex
+	running       = make(map[int]bool)
+)
+
+// Save saves a Job's details
+func (a *Job) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, JobsBucket, a, a.Index)
+}
+
+// RetrieveJob retrieves a specific job from the database
+func RetrieveJob(key int) (Job, error) {
+	var job Job
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, JobsBucket, key)
+	if err != nil {
+		return job, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &job)
+	if err != nil {
+		return job, errors.Wrap(err, "could not unmarshal json")
+	}
+
+	if job.Index == 0 {
+		return job, errors.New("job not found")
+	}
+	return job, nil
+}
+
+// RetrieveAllJobs retrieves all jobs from the database
+func RetrieveAllJobs() ([]Job, error) {
+	var arr []Job
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, JobsBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Job
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// ScheduleJob schedules a job for a project to first run after delay seconds and then every interval seconds.
+// A project has at most one job of each type, scheduling an existing job updates its schedule
+func ScheduleJob(jobType string, projIndex int, delay int64, interval int64) (Job, error) {
+	var job Job
+	if _, exists := jobHandlers[jobType]; !exists {
+		return job, errors.New("unknown job type: " + jobType)
+	}
+	if interval <= 0 {
+		return job, errors.New("job interval must be positive, quitting")
+	}
+
+	schedulerLock.Lock()
+	defer schedulerLock.Unlock()
+
+	jobs, err := RetrieveAllJobs()
+	if err != nil {
+		return job, errors.Wrap(err, "couldn't retrieve jobs")
+	}
+
+	job.Index = len(jobs) + 1
+	for _, x := range jobs {
+		if x.Type == jobType && x.ProjIndex == projIndex {
+			job = x
+			break
+		}
+	}
+
+	job.Type = jobType
+	job.ProjIndex = projIndex
+	job.Interval = interval
+	job.NextRun = clock() + delay
+	job.Done = false
+	return job, job.Save()
+}
+
+// PauseJob pauses or resumes a job
+func PauseJob(index int, pause bool) error {
+	schedulerLock.Lock()
+	defer schedulerLock.Unlock()
+
+	job, err := RetrieveJob(index)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve job")
+	}
+
+	job.Paused = pause
+	return job.Save()
+}
+
+// TriggerJob runs a job immediately irrespective of its schedule
+func TriggerJob(index int) (Job, error) {
+	schedulerLock.Lock()
+	job, err := RetrieveJob(index)
+	if err != nil {
+		schedulerLock.Unlock()
+		return job, errors.Wrap(err, "couldn't retrieve job")
+	}
+	if running[job.Index] {
+		schedulerLock.Unlock()
+		return job, errors.New("job is already running, quitting")
+	}
+	running[job.Index] = true
+	schedulerLock.Unlock()
+
+	return runJob(job, clock())
+}
+
+// claimDueJobs marks the jobs that aren't paused, done or running and whose next run is at or before now as
+// running and returns them
+func claimDueJobs(now int64) ([]Job, error) {
+	schedulerLock.Lock()
+	defer schedulerLock.Unlock()
+
+	jobs, err := RetrieveAllJobs()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't retrieve jobs")
+	}
+
+	var due []Job
+	for _, job := range jobs {
+		if job.Paused || job.Done || job.NextRun > now || running[job.Index] {
+			continue
+		}
+		running[job.Index] = true
+		due = append(due, job)
+	}
+	return due, nil
+}
+
+// runJob runs a job that has been marked as running and schedules its next run. The lock is only taken to save
+// the job, which is retrieved again so changes made while it ran (eg. pausing it) aren't lost
+func runJob(job Job, now int64) (Job, error) {
+	defer func() {
+		schedulerLock.Lock()
+		delete(running, job.Index)
+		schedulerLock.Unlock()
+	}()
+
+	handler, exists := jobHandlers[job.Type]
+	if !exists {
+		return job, errors.New("unknown job type: " + job.Type)
+	}
+
+	done, err := handler(job)
+
+	schedulerLock.Lock()
+	defer schedulerLock.Unlock()
+
+	current, rerr := RetrieveJob(job.Index)
+	if rerr == nil {
+		job = current
+	}
+	job.LastError = ""
+	if err != nil {
+		log.Println("error while running job: ", job.Index, err)
+		job.LastError = err.Error()
+	}
+
+	job.Runs++
+	job.LastRun = now
+	job.NextRun = now + job.Interval
+	job.Done = done
+	return job, job.Save()
+}
+
+// RunDueJobs runs all jobs that aren't paused or done and whose next run is at or before now
+func RunDueJobs(now int64) error {
+	jobs, err := claimDueJobs(now)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		_, err = runJob(job, now)
+		if err != nil {
+			log.Println("couldn't save job: ", job.Index, err)
+		}
+	}
+	return nil
+}
+
+// deliverNotifications retries the delivery of queued notifications whose next attempt is due
+func deliverNotifications(job Job) (bool, error) {
+	return false, notif.DeliverDue(clock())
+}
+
+// StartScheduler starts running jobs stored in the database. Jobs that were due while the platform was down
+// run once as soon as the scheduler starts
+func StartScheduler() {
+	_, err := ScheduleJob(JobNotifications, 0, 0, NotificationInterval)
+	if err != nil {
+		log.Println("couldn't schedule notification delivery", err)
+	}
+
+	_, err = ScheduleJob(JobWebhooks, 0, 0, WebhookInterval)
+	if err != nil {
+		log.Println("couldn't schedule webhook delivery", err)
+	}
+
+	go func() {
+		for {
+			err := RunDueJobs(clock())
+			if err != nil {
+				log.Println(err)
+			}
+			time.Sleep(SchedulerTick)
+		}
+	}()
+}
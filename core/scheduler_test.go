@@ -0,0 +1,195 @@
+// +build all travis
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testJob registers a handler for the test job type and returns a function that removes it
+func testJob(handler func(Job) (bool, error)) func() {
+	jobHandlers["test"] = handler
+	return func() {
+		delete(jobHandlers, "test")
+	}
+}
+
+func TestScheduleJob(t *testing.T) {
+	defer testDb(t)()
+	defer testJob(func(Job) (bool, error) { return false, nil })()
+
+	now := int64(1000)
+	c := CurrentClock()
+	SetClock(func() int64 { return now })
+	defer SetClock(c)
+
+	_, err := ScheduleJob("unknown", 1, 0, 10)
+	if err == nil {
+		t.Fatal("unknown job type scheduled")
+	}
+	_, err = ScheduleJob("test", 1, 0, 0)
+	if err == nil {
+		t.Fatal("job with no interval scheduled")
+	}
+
+	job, err := ScheduleJob("test", 1, 50, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Index != 1 || job.NextRun != 1050 {
+		t.Fatalf("job scheduled wrongly: %+v", job)
+	}
+
+	// scheduling the same job again moves it instead of adding another one
+	job, err = ScheduleJob("test", 1, 20, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Index != 1 || job.NextRun != 1020 || job.Interval != 30 {
+		t.Fatalf("job rescheduled wrongly: %+v", job)
+	}
+
+	job, err = ScheduleJob("test", 2, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Index != 2 {
+		t.Fatalf("job for another project got index %d", job.Index)
+	}
+
+	jobs, err := RetrieveAllJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs, got %d", len(jobs))
+	}
+}
+
+func TestRunDueJobs(t *testing.T) {
+	defer testDb(t)()
+
+	runs := make(map[int]int)
+	defer testJob(func(job Job) (bool, error) {
+		runs[job.ProjIndex]++
+		switch job.ProjIndex {
+		case 2:
+			return false, errors.New("payback missed")
+		case 3:
+			return true, nil
+		}
+		return false, nil
+	})()
+
+	now := int64(1000)
+	c := CurrentClock()
+	SetClock(func() int64 { return now })
+	defer SetClock(c)
+
+	for i := 1; i <= 4; i++ {
+		_, err := ScheduleJob("test", i, 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := ScheduleJob("test", 5, 500, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PauseJob(4, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RunDueJobs(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[1] != 1 || runs[2] != 1 || runs[3] != 1 || runs[4] != 0 || runs[5] != 0 {
+		t.Fatalf("wrong jobs ran: %v", runs)
+	}
+
+	job, err := RetrieveJob(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Runs != 1 || job.LastRun != now || job.NextRun != now+100 || job.LastError != "" {
+		t.Fatalf("job not rescheduled: %+v", job)
+	}
+	job, err = RetrieveJob(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.LastError != "payback missed" {
+		t.Fatalf("job error not stored: %+v", job)
+	}
+
+	// the jobs that ran aren't due again until their interval has passed and the done job never runs again
+	err = RunDueJobs(now + 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[1] != 1 {
+		t.Fatalf("job ran before it was due: %v", runs)
+	}
+
+	err = RunDueJobs(now + 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[1] != 2 || runs[2] != 2 || runs[3] != 1 || runs[4] != 0 || runs[5] != 1 {
+		t.Fatalf("wrong jobs ran: %v", runs)
+	}
+}
+
+func TestJobLock(t *testing.T) {
+	defer testDb(t)()
+
+	// the handler needs the scheduler lock, which would deadlock if it were held while jobs run
+	var triggerErr error
+	defer testJob(func(job Job) (bool, error) {
+		_, triggerErr = TriggerJob(job.Index)
+		return false, PauseJob(job.Index, true)
+	})()
+
+	job, err := ScheduleJob("test", 1, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := TriggerJob(job.Index)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler lock held while the job ran")
+	}
+
+	if triggerErr == nil {
+		t.Fatal("job triggered while it was running")
+	}
+
+	job, err = RetrieveJob(job.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Paused || job.Runs != 1 {
+		t.Fatalf("changes made while the job ran were lost: %+v", job)
+	}
+
+	// the job can be triggered again once it has finished
+	_, err = TriggerJob(job.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ScoringWeights are the weights a recipient assigns to each criterion bids in an auction are scored on. Each
+// criterion is scored between zero and one relative to the other bids and the total score of a bid is the
+// weighted average of its criteria. An auction without weights is scored on price alone
+type ScoringWeights struct {
+	// Price is the weight of the price bid (lower is better)
+	Price float64
+
+	// Time is the weight of the completion time of the contract proposed with the bid (lower is better)
+	Time float64
+
+	// Reputation is the weight of the contractor's reputation (higher is better)
+	Reputation float64
+
+	// Collateral is the weight of the collateral posted by the contractor (higher is better)
+	Collateral float64
+
+	// Performance is the weight of the contractor's past performance (higher is better)
+	Performance float64
+}
+
+// BidScore is the scoring breakdown of a bid in an auction
+type BidScore struct {
+	// BidIndex is the index of the bid that was scored
+	BidIndex int
+
+	// ContractorIndex is the index of the contractor who placed the bid
+	ContractorIndex int
+
+	// Price is the price bid
+	Price float64
+
+	// Time is the completion time in years of the contract proposed with the bid, zero if no contract was proposed.
+	// Bids without a completion time score zero on time
+	Time float64
+
+	// Reputation is the reputation of the contractor when the auction was closed
+	Reputation float64
+
+	// Collateral is the collateral posted by the contractor when the auction was closed
+	Collateral float64
+
+	// Performance is the past performance of the contractor when the auction was closed
+	Performance float64
+
+	// PriceScore, TimeScore, ReputationScore, CollateralScore and PerformanceScore are the scores of each criterion
+	PriceScore       float64
+	TimeScore        float64
+	ReputationScore  float64
+	CollateralScore  float64
+	PerformanceScore float64
+
+	// Total is the weighted average of the scores of each criterion
+	Total float64
+}
+
+// total returns the sum of the weights
+func (a ScoringWeights) total() float64 {
+	return a.Price + a.Time + a.Reputation + a.Collateral + a.Performance
+}
+
+// validate checks that the weights aren't negative
+func (a ScoringWeights) validate() error {
+	if a.Price < 0 || a.Time < 0 || a.Reputation < 0 || a.Collateral < 0 || a.Performance < 0 {
+		return errors.New("scoring weights can't be negative, quitting")
+	}
+	return nil
+}
+
+// effective returns the weights bids are scored with, which is price alone if no weights were set
+func (a ScoringWeights) effective() ScoringWeights {
+	if a.total() == 0 {
+		return ScoringWeights{Price: 1}
+	}
+	return a
+}
+
+// pastPerformance returns the number of the entity's past contracts that were completed without a breach adjusted
+// by the feedback it has received, each rating counting from -1 (1 star) to 1 (5 stars)
+func (a Entity) pastPerformance() float64 {
+	var performance float64
+	for _, contract := range a.PastContracts {
+		if len(contract.Breaches) == 0 {
+			performance++
+		}
+	}
+	for _, feedback := range a.PastFeedback {
+		if feedback.Rating != 0 {
+			performance += float64(feedback.Rating-3) / 2
+		}
+	}
+	return performance
+}
+
+// normalise scores values between zero and one, the best value scoring one. All values score one if they're equal
+func normalise(values []float64, lowerIsBetter bool) []float64 {
+	scores := make([]float64, len(values))
+	if len(values) == 0 {
+		return scores
+	}
+
+	min, max := values[0], values[0]
+	for _, value := range values {
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+	}
+
+	for i, value := range values {
+		switch {
+		case max == min:
+			scores[i] = 1
+		case lowerIsBetter:
+			scores[i] = (max - value) / (max - min)
+		default:
+			scores[i] = (value - min) / (max - min)
+		}
+	}
+	return scores
+}
+
+// ScoreBids scores bids given the raw criteria in scores and returns them ranked from best to worst. Bids with
+// the same score are ranked by price and then by the order they were placed in
+func ScoreBids(weights ScoringWeights, scores []BidScore) []BidScore {
+	weights = weights.effective()
+	ranked := make([]BidScore, len(scores))
+	copy(ranked, scores)
+
+	criteria := func(value func(BidScore) float64) []float64 {
+		values := make([]float64, len(ranked))
+		for i, score := range ranked {
+			values[i] = value(score)
+		}
+		return values
+	}
+
+	price := normalise(criteria(func(a BidScore) float64 { return a.Price }), true)
+	reputation := normalise(criteria(func(a BidScore) float64 { return a.Reputation }), false)
+	collateral := normalise(criteria(func(a BidScore) float64 { return a.Collateral }), false)
+	performance := normalise(criteria(func(a BidScore) float64 { return a.Performance }), false)
+
+	// only bids with a completion time are scored against each other on time, the others score zero
+	time := make([]float64, len(ranked))
+	var timed []int
+	var times []float64
+	for i, score := range ranked {
+		if score.Time > 0 {
+			timed = append(timed, i)
+			times = append(times, score.Time)
+		}
+	}
+	for i, x := range normalise(times, true) {
+		time[timed[i]] = x
+	}
+
+	for i := range ranked {
+		ranked[i].PriceScore = price[i]
+		ranked[i].TimeScore = time[i]
+		ranked[i].ReputationScore = reputation[i]
+		ranked[i].CollateralScore = collateral[i]
+		ranked[i].PerformanceScore = performance[i]
+		ranked[i].Total = (weights.Price*price[i] + weights.Time*time[i] + weights.Reputation*reputation[i] +
+			weights.Collateral*collateral[i] + weights.Performance*performance[i]) / weights.total()
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Total != ranked[j].Total {
+			return ranked[i].Total > ranked[j].Total
+		}
+		if ranked[i].Price != ranked[j].Price {
+			return ranked[i].Price < ranked[j].Price
+		}
+		return ranked[i].BidIndex < ranked[j].BidIndex
+	})
+	return ranked
+}
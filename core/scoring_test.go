@@ -0,0 +1,67 @@
+// +build all travis
+
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoreBids(t *testing.T) {
+	scores := []BidScore{
+		{BidIndex: 1, Price: 1000, Time: 2, Reputation: 50, Collateral: 0, Performance: 4},
+		{BidIndex: 2, Price: 800, Time: 3, Reputation: 10, Collateral: 500, Performance: 0},
+		{BidIndex: 3, Price: 900, Time: 2, Reputation: 30, Collateral: 250, Performance: 2},
+	}
+
+	// price alone if no weights are set
+	ranked := ScoreBids(ScoringWeights{}, scores)
+	if ranked[0].BidIndex != 2 || ranked[1].BidIndex != 3 || ranked[2].BidIndex != 1 {
+		t.Fatalf("bids not ranked by price: %+v", ranked)
+	}
+	if ranked[0].Total != 1 || ranked[1].Total != 0.5 || ranked[2].Total != 0 {
+		t.Fatalf("price scores not normalised: %+v", ranked)
+	}
+
+	ranked = ScoreBids(ScoringWeights{Price: 1, Reputation: 1, Performance: 2}, scores)
+	if ranked[0].BidIndex != 1 {
+		t.Fatalf("weighted scoring not won by bid 1: %+v", ranked)
+	}
+	// bid 1 scores 0 on price, 1 on reputation and 1 on performance
+	if math.Abs(ranked[0].Total-0.75) > 1e-9 || ranked[0].PriceScore != 0 || ranked[0].TimeScore != 1 {
+		t.Fatalf("scoring breakdown doesn't match: %+v", ranked[0])
+	}
+	if scores[0].Total != 0 {
+		t.Fatalf("scores passed to ScoreBids modified")
+	}
+
+	// ties are broken by price
+	ranked = ScoreBids(ScoringWeights{Time: 1}, scores)
+	if ranked[0].BidIndex != 3 || ranked[1].BidIndex != 1 {
+		t.Fatalf("tie not broken by price: %+v", ranked)
+	}
+
+	// bids without a contract score as badly on time as the slowest bid
+	ranked = ScoreBids(ScoringWeights{Time: 1}, append(scores, BidScore{BidIndex: 4, Price: 700}))
+	if ranked[2].BidIndex != 4 || ranked[2].TimeScore != 0 || ranked[0].TimeScore != 1 {
+		t.Fatalf("bid without a completion time not scored worst on time: %+v", ranked)
+	}
+
+	// a higher scoring bid wins a vickrey auction and is paid the runner up's price if it is higher
+	auction := Auction{Type: AuctionVickrey, Weights: ScoringWeights{Price: 1, Reputation: 3}}
+	bids := []Bid{{Index: 1, ContractorIndex: 1, Price: 1000, Revealed: true},
+		{Index: 2, ContractorIndex: 2, Price: 800, Revealed: true}}
+	criteria := map[int]BidScore{1: {Reputation: 50}, 2: {Reputation: 10}}
+	result := auction.Settle(bids, criteria, 100)
+	if result.WinningBid.Index != 1 || result.Price != 1000 || len(result.Scores) != 2 {
+		t.Fatalf("weighted vickrey auction not settled: %+v", result)
+	}
+}
+
+func TestPastPerformance(t *testing.T) {
+	var entity Entity
+	entity.PastContracts = []Project{{Index: 1}, {Index: 2, Breaches: []Breach{{Stage: 6}}}, {Index: 3}}
+	if entity.pastPerformance() != 2 {
+		t.Fatalf("past performance doesn't count contracts completed without a breach")
+	}
+}
@@ -1,11 +1,25 @@
 package core
 
 import (
-	"github.com/pkg/errors"
 	"log"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
-// StageXtoY promtoes a contract's stage by one
+// Stages are the stages a project goes through on the opensolar platform
+var Stages = []Stage{Stage0, Stage1, Stage2, Stage3, Stage4, Stage5, Stage6, Stage7, Stage8, Stage9}
+
+// RetrieveStage returns the stage with the given number
+func RetrieveStage(number int) (Stage, error) {
+	if number < 0 || number >= len(Stages) {
+		return Stage{}, errors.New("stage number out of bounds, quitting")
+	}
+	return Stages[number], nil
+}
+
+// StageXtoY promtoes a contract's stage by one. Every activity of the current stage must be ticked and evidence
+// must be attached to the stage before the project can be promoted
 func StageXtoY(index int) error {
 	// check for out of bound errors
 	// retrieve the project
@@ -19,67 +33,18 @@ func StageXtoY(index int) error {
 		return errors.New("stage number out of bounds or not eligible for stage updation")
 	}
 
-	if project.StageChecklist == nil || project.StageData == nil {
-		log.Println("stage checklist or stage data is nil, quitting")
-		return errors.New("stage checklist or stage data is nil, quitting")
-	}
-
-	var baseStage Stage
-	var finalStage Stage
-
-	switch project.Stage {
-	case 0:
-		baseStage = Stage0
-		finalStage = Stage1
-	case 1:
-		baseStage = Stage1
-		finalStage = Stage2
-	case 2:
-		baseStage = Stage2
-		finalStage = Stage3
-	case 3:
-		baseStage = Stage3
-		finalStage = Stage4
-	case 4:
-		baseStage = Stage4
-		finalStage = Stage5
-	case 5:
-		baseStage = Stage5
-		finalStage = Stage6
-	case 6:
-		baseStage = Stage6
-		finalStage = Stage7
-	case 7:
-		baseStage = Stage7
-		finalStage = Stage8
-	case 8:
-		baseStage = Stage8
-		finalStage = Stage9
-	default:
-		// shouldn't come here? in case it does, error out.
-		return errors.New("base stage doesn't match with predefined stages, quitting")
-	}
-
-	if len(project.StageChecklist[baseStage.Number]) != len(baseStage.Activities) {
-		log.Println("length of checklists don't match, quitting")
-		return errors.New("length of checklists don't match, quitting")
-	}
-
-	if len(project.StageData[baseStage.Number]) == 0 {
-		log.Println("baseStage data is empty, can't upgrade stages!")
-		return errors.New("baseStage data is empty, can't upgrade stages")
+	blockers, err := project.StageBlockers()
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve stage blockers")
 	}
 
-	// go through the checklist and see if something's wrong
-	for _, check := range project.StageChecklist[baseStage.Number] {
-		if !check {
-			log.Println("checklist not satisfied, quitting")
-			return errors.New("checklist not satisfied, quitting")
-		}
+	if len(blockers) != 0 {
+		log.Println("project: ", project.Index, " blocked from promotion by: ", blockers)
+		return errors.New("stage promotion blocked by: " + strings.Join(blockers, "; "))
 	}
 
-	// everything in the checklist is set to true, so we can upgrade from stage 0 to 1 safely
-	log.Println("Upgrading: ", project.Index, " from stage: ", baseStage.Number, " to stage: ", finalStage.Number)
+	finalStage := Stages[project.Stage+1]
+	log.Println("Upgrading: ", project.Index, " from stage: ", project.Stage, " to stage: ", finalStage.Number)
 	return project.SetStage(finalStage.Number)
 }
 
@@ -206,6 +171,12 @@ var Stage5 = Stage{
 		"Installation reaches substantial completion",
 		"IoT devices detect energy generation",
 	},
+	BreachRules: []BreachRule{
+		{Type: BreachDeadline, Param: 180 * 24 * 60 * 60, Actions: []string{BreachActionNotify, BreachActionSlash},
+			Description: "[Engineering Procurement and Construction] fails to complete installation within six months"},
+		{Type: BreachCollateral, Param: 0.1, Actions: []string{BreachActionNotify},
+			Description: "[Engineering Procurement and Construction] collateral falls below 10% of the project's value"},
+	},
 }
 
 // Stage6 is the connection stage
@@ -223,6 +194,10 @@ var Stage6 = Stage{
 	StateTrigger: []string{
 		"[Utility] places project in service",
 	},
+	BreachRules: []BreachRule{
+		{Type: BreachDeadline, Param: 90 * 24 * 60 * 60, Actions: []string{BreachActionNotify},
+			Description: "[Utility] fails to place the project in service within three months"},
+	},
 }
 
 // Stage7 is the legacy stage
@@ -249,6 +224,12 @@ var Stage7 = Stage{
 	BreachCondition: []string{
 		"[Offtaker] fails to make $/kWh payments after X period of time due. ",
 	},
+	BreachRules: []BreachRule{
+		{Type: BreachHeartbeat, Param: 3 * 24 * 60 * 60, Actions: []string{BreachActionNotify},
+			Description: "[IoT] teller stops reporting readings for three days"},
+		{Type: BreachMissedPayments, Param: DisconnectionThreshold, Actions: []string{BreachActionNotify, BreachActionCoverFirstLoss},
+			Description: "[Offtaker] fails to make $/kWh payments after X period of time due. "},
+	},
 }
 
 // Stage8 is the legacy stage
@@ -264,6 +245,12 @@ var Stage8 = Stage{
 		"[Beneficiary] (eg. Host, Holding)  becomes full legal owner of physical assets",
 		"[Investors] exit the project",
 	},
+	BreachRules: []BreachRule{
+		{Type: BreachHeartbeat, Param: 3 * 24 * 60 * 60, Actions: []string{BreachActionNotify},
+			Description: "[IoT] teller stops reporting readings for three days"},
+		{Type: BreachMissedPayments, Param: DisconnectionThreshold, Actions: []string{BreachActionNotify, BreachActionCoverFirstLoss},
+			Description: "[Beneficiary/Offtakers] fail to make payments after X period of time due"},
+	},
 }
 
 // Stage9 is the end of life stage
@@ -282,58 +269,68 @@ var Stage9 = Stage{
 	},
 }
 
+// ReputationChanges returns a role: change map of the reputation that the parties involved in a project gain
+// when the project moves to a given stage. Only investors gain a fixed amount, the reputation of the recipient
+// and entities is recomputed from their track record (see ComputeReputation)
+func (a Project) ReputationChanges(number int) map[string]float64 {
+	switch number {
+	case 5:
+		return map[string]float64{"investor": a.TotalValue * InvestorWeight}
+	}
+	return nil
+}
+
 // SetStage sets the stage of a project
 func (a *Project) SetStage(number int) error {
+	changes := a.ReputationChanges(number)
 	switch number {
 	case 3:
-		a.Reputation = a.TotalValue // upgrade reputation since totalValue might have changed from the originated contract
-		err := a.Save()
+		// upgrade reputation since totalValue might have changed from the originated contract
+		err := a.Update("reputation upgraded", map[string]interface{}{"Reputation": a.TotalValue})
 		if err != nil {
-			log.Println("Error while saving project", err)
+			log.Println("Error while updating project", err)
 			return err
 		}
-		err = RepOriginatedProject(a.OriginatorIndex, a.Index) // modify originator reputation now that the final price is fixed
+		err = RepOriginatedProject(a.OriginatorIndex, a.Index) // update originator reputation now that the final price is fixed
 		if err != nil {
 			log.Println("Error while increasing reputation", err)
 			return err
 		}
 	case 5:
-		contractor, err := RetrieveEntity(a.ContractorIndex)
+		err := UpdateReputation(a.ContractorIndex) // update contractor reputation now that a project has been installed
 		if err != nil {
-			log.Println("error while retrieving entity from db, quitting")
-			return err
-		}
-		err = contractor.U.ChangeReputation(a.TotalValue * ContractorWeight) // modify contractor Reputation now that a project has been installed
-		if err != nil {
-			log.Println("Couldn't increase contractor reputation", err)
+			log.Println("Couldn't update contractor reputation", err)
 			return err
 		}
 
 		for _, i := range a.InvestorIndices {
-			elem, err := RetrieveInvestor(i)
-			if err != nil {
-				log.Println("Error while retrieving investor", err)
-				return err
-			}
-			err = elem.U.ChangeReputation(a.TotalValue * InvestorWeight)
+			err = ChangeReputation(i, changes["investor"])
 			if err != nil {
 				log.Println("Couldn't change investor reputation", err)
 				return err
 			}
 		}
 	case 6:
-		recp, err := RetrieveRecipient(a.RecipientIndex)
+		err := UpdateReputation(a.RecipientIndex) // update recipient reputation now that the system had begun power generation
 		if err != nil {
+			log.Println("Error while updating recipient reputation", err)
 			return err
 		}
-		err = recp.U.ChangeReputation(a.TotalValue * RecipientWeight) // modify recipient reputation now that the system had begun power generation
+	case 7:
+		err := a.releaseCollateral(0) // the project has been installed and interconnected
 		if err != nil {
-			log.Println("Error while changing recipient reputation", err)
+			log.Println("Couldn't release contractor collateral", err)
 			return err
 		}
 	default:
 		log.Println("default")
 	}
-	a.Stage = number
-	return a.Save()
+	err := a.Record(ProjectEvent{Type: EventStagePromoted, Stage: number})
+	if err != nil {
+		return err
+	}
+
+	// evaluate the breach rules of the new stage periodically
+	_, err = ScheduleJob(JobBreachCheck, a.Index, BreachCheckInterval, BreachCheckInterval)
+	return err
 }
@@ -0,0 +1,374 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	oracle "github.com/YaleOpenLab/opensolar/oracle"
+)
+
+// Statement is the invoice sent to the recipient of a project at the end of every payback period. It records
+// what was billed for the period and what was paid towards it
+type Statement struct {
+	// Index is the index of the statement in the statements bucket
+	Index int
+
+	// ProjIndex is the index of the project the statement is for
+	ProjIndex int
+
+	// RecpIndex is the index of the recipient the statement is addressed to
+	RecpIndex int
+
+	// Period is the number of the statement for the project, starting at 1
+	Period int
+
+	// Start is the unix time at which the billing period starts (exclusive)
+	Start int64
+
+	// End is the unix time at which the billing period ends (inclusive)
+	End int64
+
+	// Issued is the unix time at which the statement was generated
+	Issued int64
+
+	// EnergyConsumed is the energy consumed by the recipient during the period in kWh
+	EnergyConsumed uint32
+
+	// EnergyGenerated is the energy generated by the project during the period in kWh
+	EnergyGenerated float64
+
+	// EnergyExported is the energy exported to the grid during the period in kWh
+	EnergyExported float64
+
+	// Tariff is the name of the tariff schedule used to bill the recipient
+	Tariff string
+
+	// EnergyCharge is the amount billed for energy consumed during the period
+	EnergyCharge float64
+
+	// InstallmentDue is the amount due on installments of the amortization schedule falling in the period
+	InstallmentDue float64
+
+	// AmountDue is the amount due for the period, the larger of EnergyCharge and InstallmentDue
+	AmountDue float64
+
+	// PriorBalance is the balance carried over from the previous statement
+	PriorBalance float64
+
+	// Payments are the paybacks received during the period
+	Payments []StatementPayment
+
+	// PaymentsReceived is the sum of all payments received during the period
+	PaymentsReceived float64
+
+	// Balance is the amount the recipient still owes at the end of the period. A negative balance is a credit
+	Balance float64
+
+	// OwnershipShift is the percentage of the project owned by the recipient at the end of the period
+	OwnershipShift float64
+
+	// BalLeft is the balance left against the project's original investment at the end of the period
+	BalLeft float64
+}
+
+// StatementPayment is a payback received during the period of a statement
+type StatementPayment struct {
+	// Timestamp is the unix time at which the payback was recorded
+	Timestamp int64
+
+	// Amount is the amount paid back
+	Amount float64
+
+	// TxHash is the hash of the stablecoin transaction on Stellar
+	TxHash string
+}
+
+// Save saves a Statement's details
+func (a *Statement) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, StatementsBucket, a, a.Index)
+}
+
+// RetrieveStatement retrieves a specific statement from the database
+func RetrieveStatement(key int) (Statement, error) {
+	var statement Statement
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, StatementsBucket, key)
+	if err != nil {
+		return statement, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &statement)
+	if err != nil {
+		return statement, errors.Wrap(err, "could not unmarshal json")
+	}
+
+	if statement.Index == 0 {
+		return statement, errors.New("statement not found")
+	}
+	return statement, nil
+}
+
+// RetrieveProjectStatements retrieves the statements of a project ordered by period
+func RetrieveProjectStatements(projIndex int) ([]Statement, error) {
+	var arr []Statement
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, StatementsBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Statement
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		if temp.ProjIndex == projIndex {
+			arr = append(arr, temp)
+		}
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Period < arr[j].Period
+	})
+	return arr, nil
+}
+
+// GenerateStatement generates and stores the statement of a project for the period ending at end. The period
+// starts where the previous statement ended, or one payback period before end for the first statement
+func GenerateStatement(projIndex int, end int64) (Statement, error) {
+	var statement Statement
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return statement, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	recipient, err := RetrieveRecipient(project.RecipientIndex)
+	if err != nil {
+		return statement, errors.Wrap(err, "couldn't retrieve recipient")
+	}
+
+	statements, err := RetrieveProjectStatements(projIndex)
+	if err != nil {
+		return statement, errors.Wrap(err, "couldn't retrieve statements")
+	}
+
+	events, err := RetrieveProjectEvents(projIndex)
+	if err != nil {
+		return statement, errors.Wrap(err, "couldn't retrieve project events")
+	}
+
+	weeks := project.PaybackPeriod
+	if weeks <= 0 {
+		weeks = 4 // monthly statements if the payback period isn't set
+	}
+	statement.Start = end - int64(weeks*consts.OneWeekInSecond/time.Second)
+	for _, event := range events {
+		// the first statement doesn't bill for time before the project was funded
+		if event.Type == EventProjectFunded && event.Timestamp > statement.Start {
+			statement.Start = event.Timestamp
+		}
+	}
+	if len(statements) != 0 {
+		prev := statements[len(statements)-1]
+		statement.Start = prev.End
+		statement.PriorBalance = prev.Balance
+	}
+	if statement.Start >= end {
+		return statement, errors.New("statement period has already been billed, quitting")
+	}
+
+	statement.ProjIndex = projIndex
+	statement.RecpIndex = project.RecipientIndex
+	statement.Period = len(statements) + 1
+	statement.End = end
+	statement.Issued = clock()
+	statement.Tariff = project.tariffName()
+	statement.OwnershipShift = project.OwnershipShift
+	statement.BalLeft = project.BalLeft
+
+	readings, err := RetrieveReadings(projIndex, "", statement.Start+1, end)
+	if err != nil {
+		return statement, errors.Wrap(err, "couldn't retrieve energy readings")
+	}
+	for _, reading := range readings {
+		statement.EnergyGenerated += reading.Generated
+		statement.EnergyExported += reading.Exported
+	}
+
+	statement.EnergyConsumed = project.EnergyConsumed(statement.Start+1, end, recipient.TellerEnergy)
+	statement.EnergyCharge, err = project.MonthlyBill(statement.EnergyConsumed, statement.Start, end)
+	if err != nil {
+		return statement, errors.Wrap(err, "couldn't bill energy consumed")
+	}
+
+	for _, x := range project.Schedule {
+		if x.DueDate > statement.Start && x.DueDate <= end {
+			statement.InstallmentDue += x.Payment
+		}
+	}
+
+	statement.settle(events)
+
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, StatementsBucket)
+	if err != nil {
+		return statement, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	statement.Index = len(x) + 1
+	return statement, statement.Save()
+}
+
+// settle adds the paybacks recorded during the statement's period and computes the amount due and the
+// balance carried over to the next statement
+func (a *Statement) settle(events []ProjectEvent) {
+	for _, event := range events {
+		if event.Type != EventPaybackRecorded || event.Timestamp <= a.Start || event.Timestamp > a.End {
+			continue
+		}
+		a.Payments = append(a.Payments, StatementPayment{Timestamp: event.Timestamp, Amount: event.Amount, TxHash: event.TxHash})
+		a.PaymentsReceived += event.Amount
+	}
+
+	a.AmountDue = math.Max(a.EnergyCharge, a.InstallmentDue)
+	a.Balance = a.PriorBalance + a.AmountDue - a.PaymentsReceived
+}
+
+// tariffName returns the name of the tariff schedule the recipient of a project is billed with
+func (project Project) tariffName() string {
+	if project.TariffOverride != "" {
+		return project.TariffOverride
+	}
+	schedule, err := oracle.CurrentProvider().Lookup(project.State, project.Country)
+	if err != nil {
+		return oracle.DefaultSchedule().Name
+	}
+	return schedule.Name
+}
+
+// statementDate formats a unix time for display on a statement
+func statementDate(timestamp int64) string {
+	return time.Unix(timestamp, 0).UTC().Format("Jan 2, 2006")
+}
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"date": statementDate,
+	"pct": func(x float64) string {
+		return fmt.Sprintf("%.2f%%", x*100)
+	},
+}).Parse(`<html>
+<body>
+<h2>Opensolar statement #{{.Period}} for project {{.ProjIndex}}</h2>
+<p>Billing period: {{date .Start}} - {{date .End}}<br>Issued: {{date .Issued}}</p>
+<table>
+<tr><td>Energy consumed</td><td>{{.EnergyConsumed}} kWh</td></tr>
+<tr><td>Energy generated</td><td>{{printf "%.2f" .EnergyGenerated}} kWh</td></tr>
+<tr><td>Energy exported</td><td>{{printf "%.2f" .EnergyExported}} kWh</td></tr>
+<tr><td>Tariff</td><td>{{.Tariff}}</td></tr>
+<tr><td>Energy charge</td><td>{{printf "%.2f" .EnergyCharge}}</td></tr>
+<tr><td>Installments due</td><td>{{printf "%.2f" .InstallmentDue}}</td></tr>
+<tr><td><b>Amount due</b></td><td><b>{{printf "%.2f" .AmountDue}}</b></td></tr>
+<tr><td>Prior balance</td><td>{{printf "%.2f" .PriorBalance}}</td></tr>
+<tr><td>Payments received</td><td>{{printf "%.2f" .PaymentsReceived}}</td></tr>
+<tr><td><b>Balance</b></td><td><b>{{printf "%.2f" .Balance}}</b></td></tr>
+</table>
+{{if .Payments}}<h3>Payments</h3>
+<table>
+<tr><th>Date</th><th>Amount</th><th>Stellar transaction</th></tr>
+{{range .Payments}}<tr><td>{{date .Timestamp}}</td><td>{{printf "%.2f" .Amount}}</td><td>{{.TxHash}}</td></tr>
+{{end}}</table>
+{{end}}<h3>Ownership</h3>
+<p>You own {{pct .OwnershipShift}} of the project. Balance left against the original investment: {{printf "%.2f" .BalLeft}}</p>
+</body>
+</html>
+`))
+
+// HTML renders the statement as html for email
+func (a Statement) HTML() (string, error) {
+	var buf bytes.Buffer
+	err := statementTemplate.Execute(&buf, a)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't render statement")
+	}
+	return buf.String(), nil
+}
+
+// Text renders the statement as plain text for email
+func (a Statement) Text() string {
+	return strings.Join(a.lines(), "\n")
+}
+
+// lines returns the statement as lines of plain text
+func (a Statement) lines() []string {
+	lines := []string{
+		fmt.Sprintf("Opensolar statement #%d for project %d", a.Period, a.ProjIndex),
+		fmt.Sprintf("Billing period: %s - %s", statementDate(a.Start), statementDate(a.End)),
+		fmt.Sprintf("Issued: %s", statementDate(a.Issued)),
+		"",
+		fmt.Sprintf("Energy consumed: %d kWh", a.EnergyConsumed),
+		fmt.Sprintf("Energy generated: %.2f kWh", a.EnergyGenerated),
+		fmt.Sprintf("Energy exported: %.2f kWh", a.EnergyExported),
+		fmt.Sprintf("Tariff: %s", a.Tariff),
+		fmt.Sprintf("Energy charge: %.2f", a.EnergyCharge),
+		fmt.Sprintf("Installments due: %.2f", a.InstallmentDue),
+		fmt.Sprintf("Amount due: %.2f", a.AmountDue),
+		fmt.Sprintf("Prior balance: %.2f", a.PriorBalance),
+		fmt.Sprintf("Payments received: %.2f", a.PaymentsReceived),
+		fmt.Sprintf("Balance: %.2f", a.Balance),
+		"",
+	}
+	if len(a.Payments) != 0 {
+		lines = append(lines, "Payments:")
+		for _, x := range a.Payments {
+			lines = append(lines, fmt.Sprintf("  %s  %.2f  tx %s", statementDate(x.Timestamp), x.Amount, x.TxHash))
+		}
+		lines = append(lines, "")
+	}
+	lines = append(lines, fmt.Sprintf("Ownership: %.2f%% of the project", a.OwnershipShift*100),
+		fmt.Sprintf("Balance left against the original investment: %.2f", a.BalLeft))
+	return lines
+}
+
+// PDF renders the statement as a single page pdf document
+func (a Statement) PDF() []byte {
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 9 Tf\n12 TL\n50 790 Td\n")
+	escaper := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+	for _, line := range a.lines() {
+		content.WriteString("(" + escaper.Replace(line) + ") Tj T*\n")
+	}
+	content.WriteString("ET")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,104 @@
+// +build all travis
+
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+func TestStatementSettle(t *testing.T) {
+	var statement Statement
+	statement.Start = 100
+	statement.End = 200
+	statement.PriorBalance = 10
+	statement.EnergyCharge = 40
+	statement.InstallmentDue = 50
+
+	events := []ProjectEvent{
+		{Type: EventPaybackRecorded, Timestamp: 100, Amount: 1000, TxHash: "previous"},
+		{Type: EventPaybackRecorded, Timestamp: 150, Amount: 30, TxHash: "first"},
+		{Type: EventPaymentsDistributed, Timestamp: 160, Amount: 30},
+		{Type: EventPaybackRecorded, Timestamp: 200, Amount: 20, TxHash: "second"},
+		{Type: EventPaybackRecorded, Timestamp: 201, Amount: 1000, TxHash: "next"},
+	}
+
+	statement.settle(events)
+	if len(statement.Payments) != 2 || statement.Payments[1].TxHash != "second" {
+		t.Fatalf("payments outside the period settled: %+v", statement.Payments)
+	}
+	if statement.AmountDue != 50 || statement.PaymentsReceived != 50 || statement.Balance != 10 {
+		t.Fatalf("statement doesn't add up: due %f, paid %f, balance %f", statement.AmountDue,
+			statement.PaymentsReceived, statement.Balance)
+	}
+
+	html, err := statement.HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, "first") || !strings.Contains(html, "50.00") {
+		t.Fatalf("html statement doesn't list payments")
+	}
+
+	text := statement.Text()
+	if strings.Contains(text, "<") || !strings.Contains(text, "tx first") || !strings.Contains(text, "Balance: 10.00") {
+		t.Fatalf("text statement not rendered: %s", text)
+	}
+
+	pdf := statement.PDF()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.Contains(pdf, []byte("tx second")) {
+		t.Fatalf("pdf statement not rendered")
+	}
+}
+
+func TestSetTariffOverride(t *testing.T) {
+	defer testDb(t)()
+
+	err := os.MkdirAll(consts.TariffDir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(consts.TariffDir+"solar.json", []byte(`{"Name":"solar","Country":"US"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	project := Project{Index: 1, State: "CT", Country: "US"}
+	err = project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if SetTariffOverride(1, "unknown") == nil {
+		t.Fatalf("override set to a schedule that doesn't exist")
+	}
+
+	err = SetTariffOverride(1, "solar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err = RetrieveProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.TariffOverride != "solar" || project.tariffName() != "solar" {
+		t.Fatalf("override not set: %s", project.TariffOverride)
+	}
+
+	err = SetTariffOverride(1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err = RetrieveProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.TariffOverride != "" {
+		t.Fatalf("override not removed: %s", project.TariffOverride)
+	}
+}
@@ -3,7 +3,8 @@ package core
 import (
 	"encoding/json"
 	"log"
-	"time"
+
+	"github.com/pkg/errors"
 
 	erpc "github.com/Varunram/essentials/rpc"
 	//	consts "github.com/YaleOpenLab/opensolar/consts"
@@ -17,38 +18,37 @@ type statusResponse struct {
 	Status string
 }
 
-// MonitorTeller monitors a teller and checks whether its live. If not, send an email to platform admins
-func MonitorTeller(projIndex int, tellerUrl string) {
-	// call this function only after a specific order has been accepted by the recipient
+// MonitorTeller schedules a health check of a project's teller. If the teller isn't live, an email is sent to
+// platform admins. Call this function only after a specific order has been accepted by the recipient
+func MonitorTeller(projIndex int) error {
 	log.Println("monitoring the teller")
-	for {
-		project, err := RetrieveProject(projIndex)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-
-		data, err := erpc.GetRequest(tellerUrl + "/ping")
-		if err != nil {
-			log.Println("did not create new GET request", err)
-			notif.SendTellerDownEmail(project.Index, project.RecipientIndex)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		var x statusResponse
-		err = json.Unmarshal(data, &x)
-		if err != nil {
-			log.Println("error while unmarshalling data", err, string(data))
-			notif.SendTellerDownEmail(project.Index, project.RecipientIndex)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		if x.Code != 200 || x.Status != "HEALTH OK" {
-			notif.SendTellerDownEmail(project.Index, project.RecipientIndex)
-		}
-
-		time.Sleep(5 * time.Second)
+	_, err := ScheduleJob(JobTellerHealth, projIndex, 0, TellerCheckInterval)
+	return err
+}
+
+// checkTeller checks whether the teller of the project the job belongs to is live
+func checkTeller(job Job) (bool, error) {
+	project, err := RetrieveProject(job.ProjIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	data, err := erpc.GetRequest(project.TellerUrl + "/ping")
+	if err != nil {
+		notif.SendTellerDownEmail(project.Index, project.RecipientIndex)
+		return false, errors.Wrap(err, "did not create new GET request")
 	}
+
+	var x statusResponse
+	err = json.Unmarshal(data, &x)
+	if err != nil {
+		notif.SendTellerDownEmail(project.Index, project.RecipientIndex)
+		return false, errors.Wrap(err, "error while unmarshalling data")
+	}
+
+	if x.Code != 200 || x.Status != "HEALTH OK" {
+		notif.SendTellerDownEmail(project.Index, project.RecipientIndex)
+	}
+
+	return false, nil
 }
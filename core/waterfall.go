@@ -0,0 +1,442 @@
+package core
+
+import (
+	"log"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// The stakeholders a waterfall tier can be paid to. The shares of a tier with members are taken from the project
+// when a payback is planned
+const (
+	MembersOM        = "om"        // O&M accounts (WaterfallMap)
+	MembersSenior    = "senior"    // senior lenders (SeniorDebt)
+	MembersSeed      = "seed"      // seed investors (SeedInvestorMap)
+	MembersJunior    = "junior"    // regular investors (InvestorMap)
+	MembersFees      = "fees"      // developer, contractor and originator fees
+	MembersOwnership = "ownership" // the recipient, the tier's payout stays in the escrow towards their ownership
+)
+
+// WaterfallTier is a tier in a project's payout waterfall. Tiers are paid in order, each tier is paid in full
+// before the next tier receives anything and whatever remains after the last tier stays in the escrow
+// towards the recipient's ownership of the project.
+type WaterfallTier struct {
+	// Name is the name of the tier (om, senior, seed, junior, fees, etc)
+	Name string
+
+	// Members names the project stakeholders the tier is paid to. Tiers without members are paid according to Shares
+	Members string
+
+	// Shares is a publickey: share map that decides how a tier's payout is split among its members
+	Shares map[string]float64
+
+	// Rate is the fraction of each payback this tier is entitled to. A rate of zero entitles the tier to
+	// whatever is left when it is reached (subject to its caps)
+	Rate float64
+
+	// PeriodCap is the maximum amount the tier can receive from a single payback. Zero means uncapped
+	PeriodCap float64
+
+	// TotalCap is the maximum amount the tier can receive over the lifetime of the project. Zero means uncapped
+	TotalCap float64
+
+	// CatchUp carries over the amount a tier was entitled to but didn't receive to future paybacks
+	CatchUp bool
+
+	// Retain keeps the tier's payout in the escrow towards the recipient's ownership instead of paying it out
+	Retain bool
+}
+
+// Payout is a single transfer from the project escrow
+type Payout struct {
+	Pubkey string
+	Amount float64
+	Failed bool
+}
+
+// TierPlan is the part of a distribution plan that concerns a single tier
+type TierPlan struct {
+	Name    string
+	Due     float64
+	Paid    float64
+	Arrears float64
+	Payouts []Payout
+}
+
+// DistributionPlan is the plan according to which a payback is distributed to project stakeholders
+type DistributionPlan struct {
+	// Amount is the amount of the payback being distributed
+	Amount float64
+
+	// Owed contains the payouts that failed in earlier distributions. The amounts are held in the escrow and
+	// repaid before the payback is distributed
+	Owed []Payout
+
+	// Tiers contains the plan for each tier in the order they are paid
+	Tiers []TierPlan
+
+	// Retained is the amount that stays in the escrow towards the recipient's ownership
+	Retained float64
+}
+
+// PlanDistribution plans the distribution of amount over the tiers of a waterfall given the amount paid to each tier
+// until now, the arrears each tier is owed and the amounts held in the escrow for publickeys whose payouts failed.
+// PlanDistribution is deterministic, tier members are paid in the order of their publickeys.
+func PlanDistribution(tiers []WaterfallTier, amount float64, paid map[string]float64, arrears map[string]float64,
+	owed map[string]float64) DistributionPlan {
+	var plan DistributionPlan
+	plan.Amount = amount
+	available := amount
+
+	// amounts owed are already in the escrow, so they don't come out of the payback
+	for _, pubkey := range sortedKeys(owed) {
+		if owed[pubkey] > 0 {
+			plan.Owed = append(plan.Owed, Payout{Pubkey: pubkey, Amount: owed[pubkey]})
+		}
+	}
+
+	for _, tier := range tiers {
+		var x TierPlan
+		x.Name = tier.Name
+
+		var sum float64
+		for _, share := range tier.Shares {
+			sum += share
+		}
+		if sum <= 0 && !tier.Retain {
+			continue
+		}
+
+		if tier.Rate > 0 {
+			x.Due = tier.Rate * amount
+		} else {
+			x.Due = available
+		}
+		if tier.PeriodCap > 0 {
+			x.Due = math.Min(x.Due, tier.PeriodCap)
+		}
+		if tier.CatchUp {
+			x.Due += arrears[tier.Name]
+		}
+		if tier.TotalCap > 0 {
+			x.Due = math.Max(math.Min(x.Due, tier.TotalCap-paid[tier.Name]), 0)
+		}
+
+		x.Paid = math.Min(x.Due, available)
+		if tier.CatchUp && tier.Rate > 0 {
+			x.Arrears = x.Due - x.Paid
+		}
+		available -= x.Paid
+
+		if tier.Retain {
+			plan.Retained += x.Paid
+			plan.Tiers = append(plan.Tiers, x)
+			continue
+		}
+
+		for _, pubkey := range sortedKeys(tier.Shares) {
+			if tier.Shares[pubkey] <= 0 {
+				continue
+			}
+			x.Payouts = append(x.Payouts, Payout{Pubkey: pubkey, Amount: x.Paid * tier.Shares[pubkey] / sum})
+		}
+
+		plan.Tiers = append(plan.Tiers, x)
+	}
+
+	plan.Retained += available
+	return plan
+}
+
+// sortedKeys returns the keys of a publickey: amount map in order
+func sortedKeys(x map[string]float64) []string {
+	var keys []string
+	for key := range x {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WaterfallTiers returns the waterfall of a project. If the project doesn't define its own waterfall, the default
+// waterfall is O&M accounts (WaterfallMap) until they're paid off, senior lenders until their debt is repaid with
+// interest, seed investors (returns multiplied by SeedInvestmentFactor), regular investors, the developer, contractor
+// and originator fees and then the recipient's ownership.
+func (a Project) WaterfallTiers() ([]WaterfallTier, error) {
+	lookup := func(index int) (string, error) {
+		entity, err := RetrieveEntity(index)
+		if err != nil {
+			return "", errors.Wrap(err, "couldn't retrieve entity")
+		}
+		return entity.U.StellarWallet.PublicKey, nil
+	}
+
+	if len(a.Waterfall) != 0 {
+		return a.resolveWaterfall(a.Waterfall, lookup)
+	}
+	return a.DefaultWaterfall(lookup)
+}
+
+// DefaultWaterfall returns the default waterfall of a project. lookup is used to find the publickey of the
+// entities that are paid fees
+func (a Project) DefaultWaterfall(lookup func(index int) (string, error)) ([]WaterfallTier, error) {
+	var fixedRate float64
+	if a.InterestRate != 0 {
+		fixedRate = a.InterestRate
+	} else {
+		fixedRate = 0.05 // 5 % interest rate if rate not defined
+	}
+
+	factor := a.SeedInvestmentFactor
+	if factor == 0 {
+		factor = 1
+	}
+	var seedRate, juniorRate float64
+	for _, pct := range a.SeedInvestorMap {
+		seedRate += fixedRate * pct * factor
+	}
+	for _, pct := range a.InvestorMap {
+		juniorRate += fixedRate * pct
+	}
+
+	return a.resolveWaterfall([]WaterfallTier{
+		{Name: "om", Members: MembersOM},
+		{Name: "senior", Members: MembersSenior},
+		{Name: "seed", Members: MembersSeed, Rate: seedRate, CatchUp: true},
+		{Name: "junior", Members: MembersJunior, Rate: juniorRate, CatchUp: true},
+		{Name: "fees", Members: MembersFees, Rate: fixedRate},
+		{Name: "ownership", Members: MembersOwnership},
+	}, lookup)
+}
+
+// resolveWaterfall takes the shares of tiers with members from the project. Tiers paid a fixed amount (O&M, senior
+// debt and fees) are capped at that amount unless they define their own cap. lookup is used to find the publickey of
+// the entities that are paid fees
+func (a Project) resolveWaterfall(tiers []WaterfallTier, lookup func(index int) (string, error)) ([]WaterfallTier, error) {
+	var resolved []WaterfallTier
+	for _, tier := range tiers {
+		if tier.Members == "" {
+			resolved = append(resolved, tier)
+			continue
+		}
+
+		tier.Shares = make(map[string]float64)
+		var total float64
+		switch tier.Members {
+		case MembersOM:
+			for pubkey, amount := range a.WaterfallMap {
+				tier.Shares[pubkey] = amount
+				total += amount
+			}
+		case MembersSenior:
+			for pubkey, amount := range a.SeniorDebt {
+				tier.Shares[pubkey] = amount
+				total += amount * (1 + a.SeniorDebtRate)
+			}
+		case MembersSeed:
+			for pubkey, pct := range a.SeedInvestorMap {
+				tier.Shares[pubkey] = pct
+			}
+		case MembersJunior:
+			for pubkey, pct := range a.InvestorMap {
+				tier.Shares[pubkey] = pct
+			}
+		case MembersFees:
+			addFee := func(index int, fee float64) error {
+				if fee <= 0 {
+					return nil
+				}
+				key, err := lookup(index)
+				if err != nil {
+					return err
+				}
+				tier.Shares[key] += fee
+				total += fee
+				return nil
+			}
+
+			err := addFee(a.ContractorIndex, a.ContractorFee)
+			if err != nil {
+				return resolved, err
+			}
+			err = addFee(a.OriginatorIndex, a.OriginatorFee)
+			if err != nil {
+				return resolved, err
+			}
+			for i, index := range a.DeveloperIndices {
+				if i >= len(a.DeveloperFee) {
+					break
+				}
+				err = addFee(index, a.DeveloperFee[i])
+				if err != nil {
+					return resolved, err
+				}
+			}
+		case MembersOwnership:
+			tier.Shares = nil
+			tier.Retain = true
+		default:
+			return resolved, errors.New("tier " + tier.Name + " has unknown members " + tier.Members)
+		}
+
+		if tier.TotalCap == 0 {
+			tier.TotalCap = total
+		}
+		resolved = append(resolved, tier)
+	}
+	return resolved, nil
+}
+
+// validateWaterfall checks that a waterfall can be used to distribute paybacks
+func validateWaterfall(tiers []WaterfallTier) error {
+	names := make(map[string]bool)
+	var rates float64
+	var ownership bool
+	for _, tier := range tiers {
+		if tier.Name == "" {
+			return errors.New("waterfall tier doesn't have a name")
+		}
+		if names[tier.Name] {
+			return errors.New("waterfall has more than one tier named " + tier.Name)
+		}
+		names[tier.Name] = true
+
+		if tier.Rate < 0 || tier.Rate > 1 {
+			return errors.New("rate of tier " + tier.Name + " is not between 0 and 1")
+		}
+		rates += tier.Rate
+		if tier.PeriodCap < 0 || tier.TotalCap < 0 {
+			return errors.New("caps of tier " + tier.Name + " can't be negative")
+		}
+
+		switch tier.Members {
+		case MembersOM, MembersSenior, MembersSeed, MembersJunior, MembersFees:
+		case MembersOwnership:
+			if ownership {
+				return errors.New("waterfall has more than one ownership tier")
+			}
+			ownership = true
+		case "":
+			if tier.Retain {
+				if len(tier.Shares) != 0 {
+					return errors.New("tier " + tier.Name + " is retained but has shares")
+				}
+				continue
+			}
+			var sum float64
+			for pubkey, share := range tier.Shares {
+				if pubkey == "" || share < 0 {
+					return errors.New("tier " + tier.Name + " has an invalid share")
+				}
+				sum += share
+			}
+			if sum <= 0 {
+				return errors.New("tier " + tier.Name + " doesn't have members or shares")
+			}
+			continue
+		default:
+			return errors.New("tier " + tier.Name + " has unknown members " + tier.Members)
+		}
+
+		if len(tier.Shares) != 0 {
+			return errors.New("tier " + tier.Name + " has both members and shares")
+		}
+	}
+
+	if rates > 1 {
+		return errors.New("rates of the waterfall's tiers add up to more than 1")
+	}
+	return nil
+}
+
+// SetWaterfall sets the tiers paybacks towards a project are distributed over. The waterfall can't be changed once
+// investors have invested against it, an empty waterfall restores the default waterfall
+func SetWaterfall(projIndex int, tiers []WaterfallTier) error {
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if project.MoneyRaised != 0 {
+		return errors.New("project has already raised money, can't change its waterfall")
+	}
+
+	err = validateWaterfall(tiers)
+	if err != nil {
+		return errors.Wrap(err, "invalid waterfall")
+	}
+
+	return project.Update("waterfall set", map[string]interface{}{"Waterfall": tiers})
+}
+
+// SetSeniorDebt sets the publickey: amount map of senior debt taken on by a project and the interest rate it is
+// repaid with. Senior lenders are repaid before investors receive anything
+func SetSeniorDebt(projIndex int, lenders map[string]float64, rate float64) error {
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	if project.MoneyRaised != 0 {
+		return errors.New("project has already raised money, can't change its senior debt")
+	}
+
+	if rate < 0 {
+		return errors.New("senior debt rate can't be negative")
+	}
+	for pubkey, amount := range lenders {
+		if pubkey == "" || amount <= 0 {
+			return errors.New("invalid senior debt amount")
+		}
+	}
+
+	return project.Update("senior debt set", map[string]interface{}{"SeniorDebt": lenders, "SeniorDebtRate": rate})
+}
+
+// PlanPayments plans the distribution of a payback towards a project without transferring any funds
+func PlanPayments(projIndex int, amount float64) (DistributionPlan, error) {
+	var plan DistributionPlan
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return plan, errors.Wrap(err, "couldn't retrieve project")
+	}
+
+	tiers, err := project.WaterfallTiers()
+	if err != nil {
+		return plan, errors.Wrap(err, "couldn't build project waterfall")
+	}
+
+	return PlanDistribution(tiers, amount, project.WaterfallPaid, project.WaterfallArrears, project.WaterfallOwed), nil
+}
+
+// executePlan transfers funds from the project escrow according to a distribution plan. Amounts owed from earlier
+// distributions are repaid first. A failed transfer doesn't stop the other transfers, the payout is marked as failed
+// and the amount is held in the escrow, owed to the payout's publickey
+func (a Project) executePlan(plan DistributionPlan, recipientSeed string) DistributionPlan {
+	pay := func(payout *Payout, memo string) {
+		if payout.Amount <= 0 {
+			return
+		}
+		// here we send funds from the 2of2 multisig. Platform signs by default
+		err := ledger.SendFundsFromEscrow(a.EscrowPubkey, payout.Pubkey, recipientSeed, consts.PlatformSeed, payout.Amount, memo)
+		if err != nil {
+			log.Println("Error with payback to pubkey: ", payout.Pubkey, err) // if there is an error with one payback, doesn't mean we should stop and wait for the others
+			payout.Failed = true
+		}
+	}
+
+	for i := range plan.Owed {
+		pay(&plan.Owed[i], "owed")
+	}
+	for i := range plan.Tiers {
+		tier := &plan.Tiers[i]
+		for j := range tier.Payouts {
+			pay(&tier.Payouts[j], tier.Name)
+		}
+	}
+	return plan
+}
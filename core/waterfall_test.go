@@ -0,0 +1,164 @@
+// +build all travis
+
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlanDistribution(t *testing.T) {
+	tiers := []WaterfallTier{
+		{Name: "om", Shares: map[string]float64{"om": 1}, TotalCap: 30},
+		{Name: "seed", Shares: map[string]float64{"s1": 1}, Rate: 0.2, CatchUp: true},
+		{Name: "junior", Shares: map[string]float64{"j2": 3, "j1": 1}, Rate: 0.4, PeriodCap: 20, CatchUp: true},
+		{Name: "empty"},
+	}
+
+	paid := map[string]float64{"om": 10}
+	arrears := map[string]float64{"seed": 5}
+
+	plan := PlanDistribution(tiers, 100, paid, arrears, nil)
+	if len(plan.Tiers) != 3 {
+		t.Fatalf("expected 3 tiers in plan, got %d", len(plan.Tiers))
+	}
+	if plan.Tiers[0].Paid != 20 {
+		t.Fatalf("o&m tier not capped at its total cap, paid: %f", plan.Tiers[0].Paid)
+	}
+	if plan.Tiers[1].Paid != 25 || plan.Tiers[1].Arrears != 0 {
+		t.Fatalf("seed tier didn't catch up, paid: %f", plan.Tiers[1].Paid)
+	}
+	if plan.Tiers[2].Paid != 20 {
+		t.Fatalf("junior tier not capped at its period cap, paid: %f", plan.Tiers[2].Paid)
+	}
+	if plan.Tiers[2].Payouts[0].Pubkey != "j1" || plan.Tiers[2].Payouts[0].Amount != 5 {
+		t.Fatalf("junior payouts not ordered or split by share")
+	}
+	if plan.Retained != 35 {
+		t.Fatalf("expected 35 to be retained, got: %f", plan.Retained)
+	}
+
+	// a small payback leaves the junior tier in arrears
+	plan = PlanDistribution(tiers, 30, map[string]float64{"om": 30}, nil, nil)
+	if plan.Tiers[1].Paid != 6 || math.Abs(plan.Tiers[2].Paid-12) > 1e-9 || plan.Tiers[2].Arrears != 0 {
+		t.Fatalf("unexpected plan for small payback: %v", plan)
+	}
+
+	// o&m takes the entire payback, leaving investors in arrears
+	plan = PlanDistribution(tiers, 10, nil, nil, nil)
+	if plan.Tiers[1].Arrears != 2 || plan.Tiers[2].Arrears != 4 || plan.Retained != 0 {
+		t.Fatalf("shortfall not carried over: %v", plan)
+	}
+}
+
+func TestPlanDistributionOwed(t *testing.T) {
+	tiers := []WaterfallTier{
+		{Name: "junior", Shares: map[string]float64{"j1": 1, "j2": 1}, Rate: 0.5},
+		{Name: "ownership", Retain: true},
+	}
+
+	project := Project{WaterfallOwed: map[string]float64{"j2": 10}}
+	plan := PlanDistribution(tiers, 100, nil, nil, project.WaterfallOwed)
+	if len(plan.Owed) != 1 || plan.Owed[0].Pubkey != "j2" || plan.Owed[0].Amount != 10 {
+		t.Fatalf("owed amount not repaid first: %v", plan.Owed)
+	}
+	if plan.Tiers[0].Paid != 50 || plan.Tiers[1].Paid != 50 || plan.Retained != 50 || len(plan.Tiers[1].Payouts) != 0 {
+		t.Fatalf("owed amount taken from the payback or ownership tier paid out: %v", plan)
+	}
+
+	// the owed amount is repaid but j1's payout fails
+	plan.Tiers[0].Payouts[0].Failed = true
+	err := project.Apply(ProjectEvent{Type: EventPaymentsDistributed, Plan: &plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.WaterfallOwed) != 1 || project.WaterfallOwed["j1"] != 25 || project.WaterfallPaid["junior"] != 50 {
+		t.Fatalf("owed amounts not updated: %v", project.WaterfallOwed)
+	}
+}
+
+func TestDefaultWaterfall(t *testing.T) {
+	project := Project{
+		InterestRate:    0.1,
+		WaterfallMap:    map[string]float64{"om": 10},
+		SeniorDebt:      map[string]float64{"lender": 100},
+		SeniorDebtRate:  0.2,
+		InvestorMap:     map[string]float64{"inv": 1},
+		ContractorIndex: 1,
+		ContractorFee:   5,
+	}
+	tiers, err := project.DefaultWaterfall(func(index int) (string, error) { return "contractor", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 6 || tiers[1].Name != "senior" || tiers[1].TotalCap != 120 || !tiers[5].Retain {
+		t.Fatalf("senior debt or ownership tier missing: %v", tiers)
+	}
+
+	plan := PlanDistribution(tiers, 100, nil, nil, nil)
+	if plan.Tiers[0].Paid != 10 || plan.Tiers[1].Paid != 90 || plan.Retained != 0 {
+		t.Fatalf("senior lenders not paid ahead of investors: %v", plan)
+	}
+	plan = PlanDistribution(tiers, 100, map[string]float64{"om": 10, "senior": 120}, nil, nil)
+	if plan.Tiers[2].Paid != 10 || plan.Tiers[3].Paid != 5 || plan.Retained != 85 {
+		t.Fatalf("unexpected plan once senior debt is repaid: %v", plan)
+	}
+}
+
+func TestSetWaterfall(t *testing.T) {
+	defer testDb(t)()
+
+	var project Project
+	project.Index = 1
+	err := project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := [][]WaterfallTier{
+		{{Name: "junior", Members: MembersJunior}, {Name: "junior", Members: MembersSeed}},
+		{{Name: "junior", Members: MembersJunior, Rate: 0.6}, {Name: "fees", Members: MembersFees, Rate: 0.6}},
+		{{Name: "lenders", Shares: map[string]float64{"lender": 0}}},
+		{{Name: "lenders", Members: MembersSenior, Shares: map[string]float64{"lender": 1}}},
+		{{Name: "lenders", Members: "banks"}},
+		{{Name: "owner", Members: MembersOwnership}, {Name: "recipient", Members: MembersOwnership}},
+	}
+	for _, tiers := range invalid {
+		if SetWaterfall(1, tiers) == nil {
+			t.Fatalf("invalid waterfall accepted: %v", tiers)
+		}
+	}
+
+	err = SetWaterfall(1, []WaterfallTier{
+		{Name: "bank", Shares: map[string]float64{"bank": 1}, TotalCap: 50},
+		{Name: "investors", Members: MembersJunior, Rate: 0.5},
+		{Name: "ownership", Members: MembersOwnership},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetSeniorDebt(1, map[string]float64{"lender": 100}, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	project, err = RetrieveProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiers, err := project.WaterfallTiers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 3 || tiers[0].Shares["bank"] != 1 || !tiers[2].Retain || project.SeniorDebt["lender"] != 100 {
+		t.Fatalf("waterfall not set: %v", tiers)
+	}
+
+	err = project.Record(ProjectEvent{Type: EventInvestmentReceived, UserIndex: 1, Amount: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SetWaterfall(1, nil) == nil || SetSeniorDebt(1, nil, 0) == nil {
+		t.Fatalf("waterfall changed after the project raised money")
+	}
+}
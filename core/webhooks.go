@@ -0,0 +1,696 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// partners subscribe to the lifecycle events of projects with webhooks instead of polling /project/get. A
+// webhook is registered by a user either for a single project or for every project the user is a party to.
+// Each event is POSTed to the webhook's url as json signed with the webhook's secret (the hex encoded
+// HMAC-SHA256 of the body in the X-Opensolar-Signature header). Deliveries that fail are retried with
+// exponential backoff and every attempt is kept in the delivery's log
+
+// the events webhooks can subscribe to
+const (
+	// WebhookStageChanged is fired when a project is promoted or demoted to a stage
+	WebhookStageChanged = "stage.changed"
+	// WebhookInvestment is fired when an investor (seed or regular) invests in a project
+	WebhookInvestment = "investment.received"
+	// WebhookPayback is fired when the recipient pays back towards a project
+	WebhookPayback = "payback.recorded"
+	// WebhookFirstLossCovered is fired when the guarantor covers first loss for a project's investors
+	WebhookFirstLossCovered = "firstloss.covered"
+	// WebhookProjectFlagged is fired when an admin flags a project
+	WebhookProjectFlagged = "project.flagged"
+	// WebhookTellerShutdown is fired when a project's teller shuts down
+	WebhookTellerShutdown = "teller.shutdown"
+)
+
+// WebhookEvents are all the events webhooks can subscribe to
+var WebhookEvents = []string{WebhookStageChanged, WebhookInvestment, WebhookPayback, WebhookFirstLossCovered,
+	WebhookProjectFlagged, WebhookTellerShutdown}
+
+// webhookEventTypes maps the project events that fire webhooks when they're recorded to the webhook event fired
+var webhookEventTypes = map[string]string{
+	EventStagePromoted:      WebhookStageChanged,
+	EventStageDemoted:       WebhookStageChanged,
+	EventProjectFunded:      WebhookStageChanged,
+	EventInvestmentReceived: WebhookInvestment,
+	EventPaybackRecorded:    WebhookPayback,
+	EventFirstLossCovered:   WebhookFirstLossCovered,
+}
+
+// the delivery statuses of a webhook event
+const (
+	// DeliveryPending is the status of a delivery waiting to be attempted
+	DeliveryPending = "pending"
+	// DeliverySucceeded is the status of a delivery the webhook's url responded to with a 2xx status code
+	DeliverySucceeded = "delivered"
+	// DeliveryFailed is the status of a delivery that failed WebhookMaxAttempts times
+	DeliveryFailed = "failed"
+)
+
+var (
+	// WebhookMaxAttempts is the number of times a delivery is attempted before it is marked as failed
+	WebhookMaxAttempts = 8
+
+	// WebhookRetryBackoff is the time in seconds before a failed delivery is retried, doubled after each failed attempt
+	WebhookRetryBackoff = int64(30)
+
+	// WebhookMaxBackoff is the maximum time in seconds between two delivery attempts
+	WebhookMaxBackoff = int64(6 * 60 * 60)
+
+	// WebhookTimeout is the time a webhook's url has to respond to a delivery
+	WebhookTimeout = 10 * time.Second
+
+	// WebhookInterval is the interval in seconds between two runs of the job that retries failed deliveries
+	WebhookInterval = int64(30)
+)
+
+// Webhook is a subscription to the lifecycle events of projects
+type Webhook struct {
+	// Index is the index of the webhook in the webhooks bucket
+	Index int
+
+	// UserIndex is the index of the user who registered the webhook
+	UserIndex int
+
+	// ProjIndex is the index of the project the webhook subscribes to. Webhooks with a ProjIndex of 0 subscribe
+	// to every project the user is a party to
+	ProjIndex int
+
+	// URL is the url events are POSTed to
+	URL string
+
+	// Secret is the key deliveries are signed with
+	Secret string
+
+	// Events are the events the webhook subscribes to. Webhooks without events subscribe to all events
+	Events []string
+
+	// Active is unset once the user deletes the webhook
+	Active bool
+
+	// Created is the unix time at which the webhook was registered
+	Created int64
+}
+
+// WebhookPayload is the json body POSTed to a webhook's url
+type WebhookPayload struct {
+	// Delivery is the index of the delivery, which stays the same across retries
+	Delivery int
+
+	// Event is the event that was fired
+	Event string
+
+	// ProjIndex is the index of the project the event belongs to
+	ProjIndex int
+
+	// Timestamp is the unix time at which the event was fired
+	Timestamp int64
+
+	// Data describes the event (the recorded ProjectEvent for events recorded in the project's event log)
+	Data interface{}
+}
+
+// WebhookAttempt is an entry in the log of a delivery
+type WebhookAttempt struct {
+	// Time is the unix time of the attempt
+	Time int64
+
+	// ResponseCode is the status code the webhook's url responded with (0 if it didn't respond)
+	ResponseCode int
+
+	// Error is the reason the attempt failed
+	Error string
+}
+
+// WebhookDelivery is the delivery of an event to a webhook
+type WebhookDelivery struct {
+	// Index is the index of the delivery in the deliveries bucket
+	Index int
+
+	// WebhookIndex is the index of the webhook the event is delivered to
+	WebhookIndex int
+
+	// Event is the event that was fired
+	Event string
+
+	// ProjIndex is the index of the project the event belongs to
+	ProjIndex int
+
+	// Payload is the signed json body POSTed to the webhook's url
+	Payload string
+
+	// Status is the status of the delivery (DeliveryPending, DeliverySucceeded or DeliveryFailed)
+	Status string
+
+	// Attempts is the number of delivery attempts made
+	Attempts int
+
+	// Created is the unix time at which the event was fired
+	Created int64
+
+	// NextAttempt is the unix time at which delivery should next be attempted
+	NextAttempt int64
+
+	// Delivered is the unix time at which the webhook's url acknowledged the delivery
+	Delivered int64
+
+	// Log is the log of all attempts made to deliver the event
+	Log []WebhookAttempt
+}
+
+// webhookLock guards loading and saving deliveries. Deliveries are attempted without the lock held so a slow
+// webhook doesn't hold up recording events, delivering keeps a delivery from being attempted twice at the same time
+var (
+	webhookLock sync.Mutex
+	delivering  = make(map[int]bool)
+)
+
+// blockedWebhookNets are the networks webhooks can't deliver to so users can't make the platform send requests
+// to itself or its internal network: unspecified, loopback, private and link-local addresses (which include
+// the cloud metadata address 169.254.169.254)
+var blockedWebhookNets = parseCIDRs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10")
+
+// parseCIDRs parses a list of networks in CIDR notation
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets
+}
+
+// checkWebhookIP returns an error if webhooks can't deliver to an ip
+var checkWebhookIP = func(ip net.IP) error {
+	for _, ipnet := range blockedWebhookNets {
+		if ipnet.Contains(ip) {
+			return errors.New("webhooks can't deliver to internal address: " + ip.String())
+		}
+	}
+	return nil
+}
+
+// checkWebhookHost returns an error if a webhook url's host is or resolves to an address webhooks can't deliver to
+func checkWebhookHost(host string) error {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = net.LookupIP(host)
+		if err != nil {
+			return errors.Wrap(err, "couldn't resolve webhook host")
+		}
+	}
+
+	for _, ip := range ips {
+		err := checkWebhookIP(ip)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save saves a Webhook's details
+func (a *Webhook) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, WebhooksBucket, a, a.Index)
+}
+
+// Save saves a WebhookDelivery's details
+func (a *WebhookDelivery) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, DeliveriesBucket, a, a.Index)
+}
+
+// RetrieveAllWebhooks retrieves all webhooks from the database
+func RetrieveAllWebhooks() ([]Webhook, error) {
+	var arr []Webhook
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, WebhooksBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Webhook
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Index < arr[j].Index
+	})
+	return arr, nil
+}
+
+// RetrieveWebhook retrieves a specific webhook from the database
+func RetrieveWebhook(key int) (Webhook, error) {
+	var webhook Webhook
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, WebhooksBucket, key)
+	if err != nil {
+		return webhook, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &webhook)
+	if err != nil {
+		return webhook, errors.Wrap(err, "could not unmarshal json")
+	}
+
+	if webhook.Index == 0 {
+		return webhook, errors.New("webhook not found")
+	}
+	return webhook, nil
+}
+
+// RetrieveUserWebhooks retrieves the webhooks registered by a user
+func RetrieveUserWebhooks(userIndex int) ([]Webhook, error) {
+	var arr []Webhook
+	webhooks, err := RetrieveAllWebhooks()
+	if err != nil {
+		return arr, err
+	}
+
+	for _, webhook := range webhooks {
+		if webhook.UserIndex == userIndex {
+			arr = append(arr, webhook)
+		}
+	}
+	return arr, nil
+}
+
+// RetrieveAllDeliveries retrieves all webhook deliveries from the database
+func RetrieveAllDeliveries() ([]WebhookDelivery, error) {
+	var arr []WebhookDelivery
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, DeliveriesBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp WebhookDelivery
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Index < arr[j].Index
+	})
+	return arr, nil
+}
+
+// RetrieveDelivery retrieves a specific webhook delivery from the database
+func RetrieveDelivery(key int) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, DeliveriesBucket, key)
+	if err != nil {
+		return delivery, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &delivery)
+	if err != nil {
+		return delivery, errors.Wrap(err, "could not unmarshal json")
+	}
+
+	if delivery.Index == 0 {
+		return delivery, errors.New("delivery not found")
+	}
+	return delivery, nil
+}
+
+// RetrieveWebhookDeliveries retrieves the delivery log of a webhook
+func RetrieveWebhookDeliveries(webhookIndex int) ([]WebhookDelivery, error) {
+	var arr []WebhookDelivery
+	deliveries, err := RetrieveAllDeliveries()
+	if err != nil {
+		return arr, err
+	}
+
+	for _, delivery := range deliveries {
+		if delivery.WebhookIndex == webhookIndex {
+			arr = append(arr, delivery)
+		}
+	}
+	return arr, nil
+}
+
+// RegisterWebhook registers a webhook for a user. A projIndex of 0 subscribes to every project the user is a
+// party to and empty events subscribe to all events. The returned webhook contains the secret deliveries are
+// signed with
+func RegisterWebhook(userIndex int, projIndex int, hookURL string, events []string) (Webhook, error) {
+	var webhook Webhook
+	u, err := url.Parse(hookURL)
+	if err != nil {
+		return webhook, errors.Wrap(err, "couldn't parse webhook url")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhook, errors.New("webhook url must be an absolute http or https url, quitting")
+	}
+	err = checkWebhookHost(u.Hostname())
+	if err != nil {
+		return webhook, err
+	}
+
+	for _, event := range events {
+		if !containsString(WebhookEvents, event) {
+			return webhook, errors.New("unknown webhook event: " + event)
+		}
+	}
+
+	if projIndex != 0 {
+		_, err = RetrieveProject(projIndex)
+		if err != nil {
+			return webhook, errors.Wrap(err, "couldn't retrieve project")
+		}
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return webhook, errors.Wrap(err, "couldn't generate webhook secret")
+	}
+
+	webhooks, err := RetrieveAllWebhooks()
+	if err != nil {
+		return webhook, errors.Wrap(err, "couldn't retrieve webhooks")
+	}
+
+	webhook.Index = len(webhooks) + 1
+	webhook.UserIndex = userIndex
+	webhook.ProjIndex = projIndex
+	webhook.URL = hookURL
+	webhook.Secret = hex.EncodeToString(secret)
+	webhook.Events = events
+	webhook.Active = true
+	webhook.Created = clock()
+	return webhook, webhook.Save()
+}
+
+// DeleteWebhook deactivates a user's webhook. The webhook's delivery log is kept
+func DeleteWebhook(userIndex int, index int) error {
+	webhook, err := RetrieveWebhook(index)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve webhook")
+	}
+	if webhook.UserIndex != userIndex {
+		return errors.New("webhook wasn't registered by user, quitting")
+	}
+
+	webhook.Active = false
+	return webhook.Save()
+}
+
+// containsString returns true if the array contains the string
+func containsString(arr []string, x string) bool {
+	for _, elem := range arr {
+		if elem == x {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribes returns true if the webhook subscribes to an event of the project
+func (a Webhook) subscribes(event string, project Project) bool {
+	if !a.Active {
+		return false
+	}
+	if len(a.Events) != 0 && !containsString(a.Events, event) {
+		return false
+	}
+	if a.ProjIndex != 0 {
+		return a.ProjIndex == project.Index
+	}
+
+	for _, isParty := range project.Parties(a.UserIndex) {
+		if isParty {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhook returns the hex encoded HMAC-SHA256 of a payload with a webhook's secret. Receivers verify
+// deliveries by comparing it with the X-Opensolar-Signature header
+func SignWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// FireWebhook fires an event of a project to the webhooks subscribed to it
+func FireWebhook(event string, projIndex int, data interface{}) error {
+	project, err := RetrieveProject(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve project")
+	}
+	return fireWebhooks(event, project, data)
+}
+
+// fireWebhooks queues a delivery of an event to each webhook subscribed to it and attempts the deliveries in
+// the background
+func fireWebhooks(event string, project Project, data interface{}) error {
+	webhooks, err := RetrieveAllWebhooks()
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve webhooks")
+	}
+
+	webhookLock.Lock()
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, DeliveriesBucket)
+	if err != nil {
+		webhookLock.Unlock()
+		return errors.Wrap(err, "error while retrieving all keys")
+	}
+	index := len(x)
+
+	now := clock()
+	queued := false
+	for _, webhook := range webhooks {
+		if !webhook.subscribes(event, project) {
+			continue
+		}
+
+		index++
+		payload, err := json.Marshal(WebhookPayload{Delivery: index, Event: event, ProjIndex: project.Index,
+			Timestamp: now, Data: data})
+		if err != nil {
+			webhookLock.Unlock()
+			return errors.Wrap(err, "couldn't marshal webhook payload")
+		}
+
+		delivery := WebhookDelivery{Index: index, WebhookIndex: webhook.Index, Event: event, ProjIndex: project.Index,
+			Payload: string(payload), Status: DeliveryPending, Created: now, NextAttempt: now}
+		err = delivery.Save()
+		if err != nil {
+			webhookLock.Unlock()
+			return errors.Wrap(err, "couldn't queue webhook delivery")
+		}
+		queued = true
+	}
+	webhookLock.Unlock()
+
+	if queued {
+		go func() {
+			err := DeliverWebhooks(now)
+			if err != nil {
+				log.Println("couldn't deliver webhooks", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// webhookBackoff returns the time in seconds to wait before the next delivery attempt after attempts failed attempts
+func webhookBackoff(attempts int) int64 {
+	wait := WebhookRetryBackoff
+	for i := 1; i < attempts && wait < WebhookMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > WebhookMaxBackoff {
+		wait = WebhookMaxBackoff
+	}
+	return wait
+}
+
+// post POSTs a signed payload to the webhook's url and returns the status code of the response
+func (a Webhook) post(delivery WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", a.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, errors.Wrap(err, "couldn't create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Opensolar-Event", delivery.Event)
+	req.Header.Set("X-Opensolar-Delivery", strconv.Itoa(delivery.Index))
+	req.Header.Set("X-Opensolar-Signature", SignWebhook(a.Secret, []byte(delivery.Payload)))
+
+	// the address is checked again when connecting since the host could resolve to a different address than it
+	// did when the webhook was registered, which also covers redirects
+	dialer := &net.Dialer{Timeout: WebhookTimeout, Control: func(network string, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		return checkWebhookIP(net.ParseIP(host))
+	}}
+	client := &http.Client{Timeout: WebhookTimeout, Transport: &http.Transport{DialContext: dialer.DialContext}}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, errors.New("webhook responded with status: " + res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// recordAttempt records the result of a delivery attempt and schedules a retry if it failed
+func (a *WebhookDelivery) recordAttempt(now int64, code int, err error) {
+	a.Attempts++
+	entry := WebhookAttempt{Time: now, ResponseCode: code}
+	if err != nil {
+		entry.Error = err.Error()
+		a.NextAttempt = now + webhookBackoff(a.Attempts)
+		if a.Attempts >= WebhookMaxAttempts {
+			a.Status = DeliveryFailed
+		}
+	} else {
+		a.Status = DeliverySucceeded
+		a.Delivered = now
+	}
+	a.Log = append(a.Log, entry)
+}
+
+// attempt attempts to deliver an event to its webhook. The delivery must be marked as delivering
+func (a *WebhookDelivery) attempt(now int64) error {
+	webhook, err := RetrieveWebhook(a.WebhookIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve webhook")
+	}
+
+	if !webhook.Active {
+		a.Status = DeliveryFailed
+		a.Log = append(a.Log, WebhookAttempt{Time: now, Error: "webhook has been deleted"})
+		return errors.New("webhook has been deleted")
+	}
+
+	code, err := webhook.post(*a)
+	if err != nil {
+		log.Println("couldn't deliver webhook event: ", a.Index, " to: ", webhook.URL, err)
+	}
+	a.recordAttempt(now, code, err)
+	return err
+}
+
+// DeliverWebhooks attempts the pending webhook deliveries whose next attempt is at or before now
+func DeliverWebhooks(now int64) error {
+	deliveries, err := claimDueDeliveries(now)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		delivery.attempt(now)
+		err = delivery.finish()
+		if err != nil {
+			log.Println("couldn't save webhook delivery: ", delivery.Index, err)
+		}
+	}
+	return nil
+}
+
+// claimDueDeliveries marks the pending deliveries that aren't being delivered and whose next attempt is at or
+// before now as delivering and returns them
+func claimDueDeliveries(now int64) ([]WebhookDelivery, error) {
+	webhookLock.Lock()
+	defer webhookLock.Unlock()
+
+	deliveries, err := RetrieveAllDeliveries()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't retrieve webhook deliveries")
+	}
+
+	var due []WebhookDelivery
+	for _, delivery := range deliveries {
+		if delivery.Status != DeliveryPending || delivery.NextAttempt > now || delivering[delivery.Index] {
+			continue
+		}
+		delivering[delivery.Index] = true
+		due = append(due, delivery)
+	}
+	return due, nil
+}
+
+// finish saves a delivery that was attempted and unmarks it as delivering
+func (a *WebhookDelivery) finish() error {
+	webhookLock.Lock()
+	defer webhookLock.Unlock()
+	delete(delivering, a.Index)
+	return a.Save()
+}
+
+// RedeliverWebhook attempts a delivery to one of the user's webhooks again immediately
+func RedeliverWebhook(userIndex int, index int) (WebhookDelivery, error) {
+	webhookLock.Lock()
+	delivery, err := RetrieveDelivery(index)
+	if err != nil {
+		webhookLock.Unlock()
+		return delivery, errors.Wrap(err, "couldn't retrieve webhook delivery")
+	}
+
+	webhook, err := RetrieveWebhook(delivery.WebhookIndex)
+	if err != nil {
+		webhookLock.Unlock()
+		return delivery, errors.Wrap(err, "couldn't retrieve webhook")
+	}
+	if webhook.UserIndex != userIndex {
+		webhookLock.Unlock()
+		return delivery, errors.New("webhook wasn't registered by user, quitting")
+	}
+	if delivering[delivery.Index] {
+		webhookLock.Unlock()
+		return delivery, errors.New("webhook delivery is being attempted, quitting")
+	}
+	delivering[delivery.Index] = true
+	webhookLock.Unlock()
+
+	delivery.Status = DeliveryPending
+	delivery.Attempts = 0
+	delivery.attempt(clock())
+	return delivery, delivery.finish()
+}
+
+// deliverWebhooks retries the webhook deliveries whose next attempt is due
+func deliverWebhooks(job Job) (bool, error) {
+	return false, DeliverWebhooks(clock())
+}
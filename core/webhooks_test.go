@@ -0,0 +1,184 @@
+//go:build all || travis
+// +build all travis
+
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// allowLocalWebhooks lets webhooks deliver to the test servers, which listen on the loopback address
+func allowLocalWebhooks() func() {
+	check := checkWebhookIP
+	checkWebhookIP = func(ip net.IP) error { return nil }
+	return func() { checkWebhookIP = check }
+}
+
+func TestWebhookSubscribes(t *testing.T) {
+	project := Project{Index: 2, RecipientIndex: 5, InvestorIndices: []int{7}}
+
+	user := Webhook{Active: true, UserIndex: 7}
+	if !user.subscribes(WebhookPayback, project) {
+		t.Fatalf("user webhook doesn't receive events of projects the user is a party to")
+	}
+	user.UserIndex = 8
+	if user.subscribes(WebhookPayback, project) {
+		t.Fatalf("user webhook receives events of projects the user isn't a party to")
+	}
+
+	proj := Webhook{Active: true, UserIndex: 8, ProjIndex: 2, Events: []string{WebhookStageChanged}}
+	if !proj.subscribes(WebhookStageChanged, project) || proj.subscribes(WebhookPayback, project) {
+		t.Fatalf("project webhook doesn't filter events")
+	}
+	if proj.subscribes(WebhookStageChanged, Project{Index: 3}) {
+		t.Fatalf("project webhook receives events of other projects")
+	}
+	proj.Active = false
+	if proj.subscribes(WebhookStageChanged, project) {
+		t.Fatalf("deleted webhook receives events")
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	payload := `{"Event":"payback.recorded"}`
+	var signature string
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != payload || r.Header.Get("X-Opensolar-Event") != WebhookPayback {
+			t.Errorf("payload not delivered: %s", body)
+		}
+		signature = r.Header.Get("X-Opensolar-Signature")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	webhook := Webhook{URL: server.URL, Secret: "secret", Active: true}
+	delivery := WebhookDelivery{Index: 1, Event: WebhookPayback, Payload: payload, Status: DeliveryPending}
+
+	_, err := webhook.post(delivery)
+	if err == nil {
+		t.Fatalf("webhook delivered to the loopback address")
+	}
+	defer allowLocalWebhooks()()
+
+	code, err := webhook.post(delivery)
+	if err == nil || code != http.StatusInternalServerError {
+		t.Fatalf("failed delivery not detected: %d", code)
+	}
+	if signature != SignWebhook("secret", []byte(payload)) || signature == SignWebhook("other", []byte(payload)) {
+		t.Fatalf("delivery not signed with the webhook's secret: %s", signature)
+	}
+
+	for i := 1; i <= WebhookMaxAttempts; i++ {
+		delivery.recordAttempt(100, code, err)
+		if i < WebhookMaxAttempts && (delivery.Status != DeliveryPending || delivery.NextAttempt != 100+webhookBackoff(i)) {
+			t.Fatalf("failed delivery not scheduled for retry: %+v", delivery)
+		}
+	}
+	if delivery.Status != DeliveryFailed || len(delivery.Log) != WebhookMaxAttempts {
+		t.Fatalf("delivery not failed after max attempts: %+v", delivery)
+	}
+
+	status = http.StatusOK
+	code, err = webhook.post(delivery)
+	delivery.recordAttempt(200, code, err)
+	if err != nil || delivery.Status != DeliverySucceeded || delivery.Delivered != 200 ||
+		delivery.Log[len(delivery.Log)-1].ResponseCode != http.StatusOK {
+		t.Fatalf("delivery not recorded: %+v", delivery)
+	}
+
+	if webhookBackoff(100) != WebhookMaxBackoff {
+		t.Fatalf("backoff not capped: %d", webhookBackoff(100))
+	}
+	delivery.recordAttempt(300, 0, errors.New("connection refused"))
+	if delivery.Log[len(delivery.Log)-1].Error != "connection refused" {
+		t.Fatalf("error not logged: %+v", delivery.Log)
+	}
+}
+
+func TestWebhookHosts(t *testing.T) {
+	for _, hookURL := range []string{"http://127.0.0.1/hook", "http://localhost:8080/hook", "http://10.0.0.5/hook",
+		"https://192.168.1.1/hook", "http://172.16.3.4/hook", "http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook", "http://[fe80::1]/hook", "http://0.0.0.0/hook"} {
+		_, err := RegisterWebhook(1, 0, hookURL, nil)
+		if err == nil {
+			t.Fatalf("webhook registered for internal url: %s", hookURL)
+		}
+	}
+
+	for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		if checkWebhookIP(net.ParseIP(ip)) != nil {
+			t.Fatalf("public address rejected: %s", ip)
+		}
+	}
+}
+
+func TestWebhookLock(t *testing.T) {
+	defer testDb(t)()
+	defer allowLocalWebhooks()()
+
+	// the webhook's url takes the lock while the delivery is being attempted, which would deadlock if the lock
+	// were held across the request
+	locked := make(chan bool, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done := make(chan bool)
+		go func() {
+			webhookLock.Lock()
+			webhookLock.Unlock()
+			close(done)
+		}()
+		select {
+		case <-done:
+			locked <- false
+		case <-time.After(5 * time.Second):
+			locked <- true
+		}
+	}))
+	defer server.Close()
+
+	webhook := Webhook{Index: 1, UserIndex: 1, URL: server.URL, Secret: "secret", Active: true}
+	err := webhook.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := WebhookDelivery{Index: 1, WebhookIndex: 1, Event: WebhookPayback, Payload: "{}", Status: DeliveryPending}
+	err = delivery.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DeliverWebhooks(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if <-locked {
+		t.Fatalf("lock held while delivering webhook")
+	}
+
+	delivery, err = RetrieveDelivery(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != DeliverySucceeded || len(delivering) != 0 {
+		t.Fatalf("delivery not saved: %+v", delivery)
+	}
+
+	delivering[1] = true
+	_, err = RedeliverWebhook(1, 1)
+	delete(delivering, 1)
+	if err == nil {
+		t.Fatalf("delivery attempted twice at the same time")
+	}
+
+	delivery, err = RedeliverWebhook(1, 1)
+	if err != nil || delivery.Attempts != 1 || <-locked {
+		t.Fatalf("delivery not redelivered: %+v %v", delivery, err)
+	}
+}
@@ -370,11 +370,11 @@ func TestDb(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = SelectContractVickrey(arr)
+	_, _, err = SelectContractVickrey(arr)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = SelectContractVickrey(arrDup)
+	_, _, err = SelectContractVickrey(arrDup)
 	if err == nil {
 		t.Fatalf("SelectContractVickrey succeeds with empty array!")
 	}
@@ -428,14 +428,6 @@ func TestDb(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = contractor.AddCollateral(10000, "This is test collateral")
-	if err != nil {
-		t.Fatal(err)
-	}
-	err = contractor.Slash(10)
-	if err != nil {
-		t.Fatal(err)
-	}
 	err = RepInstalledProject(contractor.U.Index, project.Index)
 	if err != nil {
 		t.Fatal(err)
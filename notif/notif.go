@@ -1,364 +1,186 @@
 package notif
 
 import (
-	"encoding/json"
-	"log"
-	"net/http"
-	"net/url"
-	"time"
-
-	erpc "github.com/Varunram/essentials/rpc"
-	utils "github.com/Varunram/essentials/utils"
+	"strconv"
+
 	consts "github.com/YaleOpenLab/opensolar/consts"
 )
 
 // package notif is used to send out notifications regarding important events that take
-// place with respect to a specific project / investment
-
-// footerString is a common signoff / footer string that is used by all emails sent from the platform's email address
-var footerString = "Have a nice day!\n\nWarm Regards, \nThe OpenSolar Team\n\n\n\n" +
-	"You're receiving this email because your contact was given" +
-	" on the opensolar platform for receiving notifications on orders in which you're a party.\n\n\n"
-
-// SendMail sends an email request to openx for fulfilment
-func SendMail(body string, to string) error {
-	log.Println("calling openx url")
-	urlbody := consts.OpenxURL + "/platform/email"
-
-	postdata := url.Values{}
-	postdata.Set("body", body)
-	postdata.Set("to", to)
-	// postdata.Set("code", consts.TopSecretCode)
-	postdata.Set("code", "OPENSOLARTEST")
-
-	transport := &http.Transport{
-		MaxIdleConns:       10,
-		IdleConnTimeout:    30 * time.Second,
-		DisableCompression: true,
-	}
-
-	client := &http.Client{Transport: transport}
-
-	data, err := erpc.HttpsPost(client, urlbody, postdata)
-	if err != nil {
-		log.Println("did not receive success response", err)
-		return err
-	}
-
-	var x erpc.StatusResponse
-	log.Println(string(data))
-	err = json.Unmarshal(data, &x)
-	if err != nil || x.Code != 200 {
-		log.Println("did not unmarshal json", err)
-		return err
-	}
-
-	return nil
+// place with respect to a specific project / investment. Notifications are rendered from
+// templates and delivered through the outbox
+
+// itoa formats an index for a template
+func itoa(x int) string {
+	return strconv.Itoa(x)
+}
+
+// ftoa formats an amount for a template
+func ftoa(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
 }
 
 // SendInvestmentNotifToRecipient sends a notification to the recipient when an investor
 // invests in a project they're recipient of
-func SendInvestmentNotifToRecipient(projIndex int, to string, recpPbTrustHash string, recpAssetHash string, recpDebtTrustHash string, recpDebtAssetHash string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	body := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that project number: " + projIndexString + " has been invested in.\n\n" +
-		"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
-		"Your payback trusted asset hash is: https://testnet.steexp.com/tx/" + recpPbTrustHash + "\n" +
-		"Your payback asset hash is: https://testnet.steexp.com/tx/" + recpAssetHash + "\n" +
-		"Your debt trusted asset hash is: https://testnet.steexp.com/tx/" + recpDebtTrustHash + "\n" +
-		"Your debt asset hash is: https://testnet.steexp.com/tx/" + recpDebtAssetHash + "\n\n\n" +
-		footerString
-	return SendMail(body, to)
+func SendInvestmentNotifToRecipient(projIndex int, userIndex int, to string, recpPbTrustHash string, recpAssetHash string, recpDebtTrustHash string, recpDebtAssetHash string) error {
+	return Notify(TemplateInvestmentRecipient, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "PbTrustHash": recpPbTrustHash,
+		"AssetHash": recpAssetHash, "DebtTrustHash": recpDebtTrustHash, "DebtAssetHash": recpDebtAssetHash})
 }
 
 // SendInvestmentNotifToInvestor sends a notification to the investor when he invests
 // in a particular project
-func SendInvestmentNotifToInvestor(projIndex int, to string, stableHash string, trustHash string, assetHash string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	body := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know have invested in project number: " + projIndexString + "\n\n" +
-		"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
-		"Your stablecoin payment hash is: https://testnet.steexp.com/tx/" + stableHash + "\n" +
-		"Your trusted asset hash is: https://testnet.steexp.com/tx/" + trustHash + "\n" +
-		"Your investment asset hash is: https://testnet.steexp.com/tx/" + assetHash + "\n\n\n" +
-		footerString
-	return SendMail(body, to)
+func SendInvestmentNotifToInvestor(projIndex int, userIndex int, to string, stableHash string, trustHash string, assetHash string) error {
+	return Notify(TemplateInvestmentInvestor, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "StableHash": stableHash,
+		"TrustHash": trustHash, "AssetHash": assetHash})
 }
 
 // SendSeedInvestmentNotifToInvestor sends a notification to the user after seed investment
-func SendSeedInvestmentNotifToInvestor(projIndex int, to string, stableHash string, trustHash string, assetHash string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	body := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know have invested in the seed round of project: " + projIndexString + "\n\n" +
-		"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
-		"Your stablecoin payment hash is: https://testnet.steexp.com/tx/" + stableHash + "\n" +
-		"Your trusted asset hash is: https://testnet.steexp.com/tx/" + trustHash + "\n" +
-		"Your investment asset hash is: https://testnet.steexp.com/tx/" + assetHash + "\n\n\n" +
-		footerString
-	return SendMail(body, to)
+func SendSeedInvestmentNotifToInvestor(projIndex int, userIndex int, to string, stableHash string, trustHash string, assetHash string) error {
+	return Notify(TemplateSeedInvestment, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "StableHash": stableHash,
+		"TrustHash": trustHash, "AssetHash": assetHash})
 }
 
 // SendPaybackNotifToRecipient sends a notification email to the recipient when they
 // pay back towards a particular project
-func SendPaybackNotifToRecipient(projIndex int, to string, stableUSDHash string, debtPaybackHash string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	body := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know have paid back towards project number: " + projIndexString + "\n\n" +
-		"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
-		"Stablecoin payment hash is: https://testnet.steexp.com/tx/" + stableUSDHash + "\n" +
-		"Debt asset hash is: https://testnet.steexp.com/tx/" + debtPaybackHash + "\n\n\n" +
-		footerString
-	return SendMail(body, to)
+func SendPaybackNotifToRecipient(projIndex int, userIndex int, to string, stableUSDHash string, debtPaybackHash string) error {
+	return Notify(TemplatePaybackRecipient, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "StableHash": stableUSDHash,
+		"DebtHash": debtPaybackHash})
 }
 
 // SendPaybackNotifToInvestor sends a notification email to the investor when the recipient
 // pays back towards a particular order
-func SendPaybackNotifToInvestor(projIndex int, to string, stableUSDHash string, debtPaybackHash string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	body := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that the recipient has paid back towards project number: " + projIndexString + "\n\n" +
-		"The recipient's proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
-		"Stablecoin payment hash is: https://testnet.steexp.com/tx/" + stableUSDHash + "\n" +
-		"Debt asset hash is: https://testnet.steexp.com/tx/" + debtPaybackHash + "\n\n\n" +
-		footerString
-	return SendMail(body, to)
+func SendPaybackNotifToInvestor(projIndex int, userIndex int, to string, stableUSDHash string, debtPaybackHash string) error {
+	return Notify(TemplatePaybackInvestor, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "StableHash": stableUSDHash,
+		"DebtHash": debtPaybackHash})
 }
 
 // SendUnlockNotifToRecipient sends a notification email to the recipient to unlock
 // the given project for accepting investment
-func SendUnlockNotifToRecipient(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	body := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that project number: " + projIndexString + " has been invested in\n\n" +
-		"You are required to logon to the platform within a period of 3(THREE) days in order to accept the investment\n\n" +
-		"If you choose to not accept the given investment in your project, please be warned that your reputation score " +
-		"will be adjusted accordingly and this may affect any future proposal that you seek funding for on the platform\n\n" +
-		footerString
-	return SendMail(body, to)
+func SendUnlockNotifToRecipient(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateUnlock, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
 }
 
 // SendEmail is a helper for the rpc to send an email to an entity
 func SendEmail(message string, to string, name string) error {
 	// we can't send emails as the entities themselves since we would need their email password
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that " + name + " has sent you a message. The message contents follow: \n\n"
-	body := startString + message + "\n\n\n" + footerString
-	return SendMail(body, to)
+	return Notify(TemplateMessage, 0, to, Vars{"Name": name, "Message": message})
 }
 
 // SendAlertEmail sends an alert email to an entity
 func SendAlertEmail(message string, to string) error {
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that you have received a message from the platform: \n\n\n" + message
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+	return Notify(TemplateAlert, 0, to, Vars{"Message": message})
 }
 
 // SendPaybackAlertEmail sends a payback alert email. We don't know if the user has paid and send
 // this even if the user has paid / received a donation towards this month
-func SendPaybackAlertEmail(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"This is a kind reminder to let you know that your payment is due this period for project numbered: " + projIndexString +
-		"\n\n If you have already paid or have received a donation towards this month, please ignore this alert."
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendPaybackAlertEmail(projIndex int, userIndex int, to string) error {
+	return Notify(TemplatePaybackAlert, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
+}
+
+// SendStatementEmail sends the recipient the statement for a payback period. statement is the plain text
+// rendering of the statement
+func SendStatementEmail(projIndex int, userIndex int, to string, statement string) error {
+	return Notify(TemplateStatement, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "Statement": statement})
 }
 
 // SendNicePaybackAlertEmail sends an email when the amount for 2 payment cycles is due
-func SendNicePaybackAlertEmail(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"This is a kind reminder to let you know that your payment is due this period for project numbered: " + projIndexString +
-		"\n\n Please payback at the earliest."
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendNicePaybackAlertEmail(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateNicePaybackAlert, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
+}
+
+// SendLatePaybackAlertEmail sends an email when the amount for 2 to 4 payment cycles is due
+func SendLatePaybackAlertEmail(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateLatePaybackAlert, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
 }
 
 // SendSternPaybackAlertEmail sends an email when the amount for 4 payment cycles is due.
-func SendSternPaybackAlertEmail(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that your payment is due this period for project numbered: " + projIndexString +
-		"\n\n Please payback within two payback cycles to avoid re-routing of power services."
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendSternPaybackAlertEmail(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateSternPaybackAlert, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
 }
 
 // SendDisconnectionEmail sends an email when the amount for 6 payment cycles is due
-func SendDisconnectionEmail(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that electricity produced from your project numbered: " + projIndexString +
-		"\n\nHas been redirected towards the main power grid. Please contact your guarantor to resume services"
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendDisconnectionEmail(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateDisconnection, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
 }
 
 // SendDisconnectionEmailI sends an email to the investor when the amount for 6 payment cycles is due on the recipient's end
-func SendDisconnectionEmailI(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that electricity produced from your project numbered: " + projIndexString +
-		"\n\nHas been redirected towards the main power grid due to irregular payments by the recipient involved.\n\n" +
-		"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
-		"Please feel free to write to support with your queries in the meantime."
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendDisconnectionEmailI(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateDisconnectionInvestor, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
 }
 
 // SendSternPaybackAlertEmailI sends a stern payback email notification to the investor
-func SendSternPaybackAlertEmailI(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that we are aware that payments towards the project: " + projIndexString +
-		"\n\n haven't been made and we have reached out to the project recipient on the same. If this situation continues for " +
-		"two more payment periods, we will be redirecting power towards the general grid and you would receive payments " +
-		"for all periods where they were due. \n\n" +
-		"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
-		"Please feel free to write to support with your queries in the meantime."
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendSternPaybackAlertEmailI(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateSternPaybackAlertInvestor, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
 }
 
 // SendSternPaybackAlertEmailG sends a stern payback email notification to the guarantor
-func SendSternPaybackAlertEmailG(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that we are aware that payments towards the project: " + projIndexString +
-		"\n\n haven't been made and have reached out to the project recipient on the same. If this situation continues for " +
-		"two more payment periods, we will be redirecting power towards the general grid and contact you for further" +
-		"information on how the guarantee towards the project would be realized to investors.\n\n" +
-		"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
-		"Please feel free to write to support with your queries in the meantime."
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendSternPaybackAlertEmailG(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateSternPaybackAlertGuarantor, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
 }
 
 // SendDisconnectionEmailG sends a disconnection email notification to the guarantor
-func SendDisconnectionEmailG(projIndex int, to string) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-	startString := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that electricity produced from your project numbered: " + projIndexString +
-		"\n\nHas been redirected towards the main power grid due to irregular payments by the recipient involved.\n\n" +
-		"We will be reaching out to you in the coming days on how to proceed with realizing the guarantee towards this " +
-		"project in order to safeguard investors. We will also be contacting the recipient involved to update them on the" +
-		"situation and will make efforts to alleviate this problem as soon as possible." +
-		"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
-		"Please feel free to write to support with your queries in the meantime."
-	body := startString + "\n\n\n" + footerString
-	return SendMail(body, to)
+func SendDisconnectionEmailG(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateDisconnectionGuarantor, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
+}
+
+// SendDelinquencyCuredEmail sends an email when the recipient has caught up on overdue payments
+func SendDelinquencyCuredEmail(projIndex int, userIndex int, to string) error {
+	return Notify(TemplateDelinquencyCured, userIndex, to, Vars{"ProjIndex": itoa(projIndex)})
+}
+
+// SendBreachEmail sends an email to a project's stakeholders when the project breaches a stage's breach rule
+func SendBreachEmail(projIndex int, userIndex int, to string, rule string, detail string) error {
+	return Notify(TemplateBreach, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "Rule": rule, "Detail": detail})
+}
+
+// SendCancellationEmail sends an email to a project's stakeholders when the project is cancelled
+func SendCancellationEmail(projIndex int, userIndex int, to string, reason string) error {
+	return Notify(TemplateCancellation, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "Reason": reason})
+}
+
+// SendDemotionEmail sends an email to a project's stakeholders when the project is moved back to an earlier stage
+func SendDemotionEmail(projIndex int, userIndex int, to string, stage int, reason string) error {
+	return Notify(TemplateDemotion, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "Stage": itoa(stage), "Reason": reason})
+}
+
+// SendRefundEmail sends an email to an investor once their investment in a project has been refunded
+func SendRefundEmail(projIndex int, userIndex int, to string, amount float64, txhash string) error {
+	return Notify(TemplateRefund, userIndex, to, Vars{"ProjIndex": itoa(projIndex), "Amount": ftoa(amount), "TxHash": txhash})
+}
+
+// SendRefundFailedEmail is an email to the platform notifying that an investor's assets were returned but the
+// platform couldn't refund them
+func SendRefundFailedEmail(projIndex int, invIndex int, amount float64) error {
+	return Notify(TemplateRefundFailed, 0, consts.PlatformEmail, Vars{"ProjIndex": itoa(projIndex),
+		"InvIndex": itoa(invIndex), "Amount": ftoa(amount)})
 }
 
 // SendContractNotification sends a notification after an entity signs a contract
-func SendContractNotification(Hash1 string, Hash2 string, Hash3 string, Hash4 string, Hash5 string, to string) error {
-	body := "Greetings from the opensolar platform! \n\n" +
-		"We're writing to let you know that you have signed a contract\n\n" +
-		"Your proofs of signing are attached below and may be used as future reference in case of discrepancies:  \n\n" +
-		"Your first hash is: https://testnet.steexp.com/tx/" + Hash1 + "\n" +
-		"Your second hash is: https://testnet.steexp.com/tx/" + Hash2 + "\n" +
-		"Your third hash is: https://testnet.steexp.com/tx/" + Hash3 + "\n" +
-		"Your fourth hash is: https://testnet.steexp.com/tx/" + Hash4 + "\n" +
-		"Your fifth hash is: https://testnet.steexp.com/tx/" + Hash5 + "\n\n\n" +
-		footerString
-	return SendMail(body, to)
+func SendContractNotification(Hash1 string, Hash2 string, Hash3 string, Hash4 string, Hash5 string, userIndex int, to string) error {
+	return Notify(TemplateContract, userIndex, to, Vars{"Hash1": Hash1, "Hash2": Hash2, "Hash3": Hash3, "Hash4": Hash4,
+		"Hash5": Hash5})
 }
 
 // SendTellerShutdownEmail sends the platform an email notifying that the teller has shut down
 func SendTellerShutdownEmail(from string, projIndex string, deviceId string, tx1 string, tx2 string) error {
-	body := "Greetings from the remote teller " + deviceId + " installed for: " + from + " on behalf of project: " + projIndex + "\n\n" +
-		"We're writing to let you know that the teller has shut down and requires your immediate action. The proof of shutdown transactions " +
-		"are atached below:" + "\n\n" +
-		"Tx1: https://testnet.steexp.com/tx/" + tx1 + "\n\n" +
-		"Tx2: https://testnet.steexp.com/tx/" + tx2 + "\n\n" +
-		"Please tend to this situation at the earliest." + "\n\n\n" +
-		footerString
-	return SendMail(body, consts.PlatformEmail)
+	return Notify(TemplateTellerShutdown, 0, consts.PlatformEmail, Vars{"From": from, "ProjIndex": projIndex,
+		"DeviceId": deviceId, "Tx1": tx1, "Tx2": tx2})
 }
 
 // SendTellerPaymentFailedEmail is a notification ot the platform that the teller's payback routine has been disturbed
 func SendTellerPaymentFailedEmail(from string, projIndex string, deviceId string) error {
-	body := "Greetings from the remote teller " + deviceId + " installed for: " + from + " on behalf of project: " + projIndex + "\n\n" +
-		"We're writing to let you know that the teller encountered an error, didn't result in automatic payback and requires your immediate action. " +
-		"Please tend to this situation at the earliest." + "\n\n\n" +
-		footerString
-	return SendMail(body, consts.PlatformEmail)
+	return Notify(TemplateTellerPaymentFailed, 0, consts.PlatformEmail, Vars{"From": from, "ProjIndex": projIndex,
+		"DeviceId": deviceId})
 }
 
 // SendTellerDownEmail is an email to the platform notifying that the teller for a particular project is down.
 func SendTellerDownEmail(projIndex int, recpIndex int) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-
-	recpIndexString, err := utils.ToString(recpIndex)
-	if err != nil {
-		return err
-	}
-	body := "Greetings from the opensolar platform! \n\nWe're writing to let you know that remote teller " + projIndexString +
-		" installed on behalf of recipient with index: " + recpIndexString + " has not been responding to pings for a while. Please take action at " +
-		"the earliest," + "\n\n\n" +
-		footerString
-	return SendMail(body, consts.PlatformEmail)
+	return Notify(TemplateTellerDown, 0, consts.PlatformEmail, Vars{"ProjIndex": itoa(projIndex),
+		"RecpIndex": itoa(recpIndex)})
 }
 
+// SendRecpNotFoundEmail is an email to the admin notifying that a funded project's recipient doesn't have an account
 func SendRecpNotFoundEmail(projIndex int, recpIndex int) error {
-	projIndexString, err := utils.ToString(projIndex)
-	if err != nil {
-		return err
-	}
-
-	recpIndexString, err := utils.ToString(recpIndex)
-	if err != nil {
-		return err
-	}
-
-	body := "Greetings from the opensolar platform! \n\nWe're writing to let you know that project with index: " + projIndexString +
-		" and recipient index: " + recpIndexString + " has just beenf funded. Please create a new recipient account with log details in order to be able to proceed with investment" +
-		"\n\n\n" + footerString
-
-	return SendMail(body, consts.AdminEmail)
+	return Notify(TemplateRecipientNotFound, 0, consts.AdminEmail, Vars{"ProjIndex": itoa(projIndex),
+		"RecpIndex": itoa(recpIndex)})
 }
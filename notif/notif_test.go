@@ -0,0 +1,242 @@
+// +build all travis
+
+package notif
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	edb "github.com/Varunram/essentials/database"
+	utils "github.com/Varunram/essentials/utils"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+func TestRender(t *testing.T) {
+	subject, body, err := Render(TemplateDemotion, Vars{"ProjIndex": "1", "Stage": "2", "Reason": "no contractor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Project 1 has been moved back to stage 2" || !strings.Contains(body, "Reason: no contractor") ||
+		!strings.HasSuffix(body, footerString) {
+		t.Fatalf("template not rendered: %s %s", subject, body)
+	}
+
+	_, _, err = Render(TemplateDemotion, Vars{"ProjIndex": "1"})
+	if err == nil {
+		t.Fatalf("template rendered with missing variables")
+	}
+	_, _, err = Render("blah", nil)
+	if err == nil {
+		t.Fatalf("unknown template rendered")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if backoff(1) != RetryBackoff || backoff(3) != 4*RetryBackoff {
+		t.Fatalf("backoff doesn't double after each attempt: %d %d", backoff(1), backoff(3))
+	}
+	if backoff(100) != MaxBackoff {
+		t.Fatalf("backoff not capped: %d", backoff(100))
+	}
+}
+
+type failingTransport struct{}
+
+func (t failingTransport) Name() string { return "failing" }
+
+func (t failingTransport) Send(msg Message) error { return os.ErrClosed }
+
+func TestAttempt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldTransport := CurrentTransport()
+	defer SetTransport(oldTransport)
+
+	msg := Message{Index: 1, To: "user@example.com", Subject: "subject", Body: "body", Status: StatusQueued}
+	SetTransport(failingTransport{})
+	for i := 1; i <= MaxAttempts; i++ {
+		if msg.attempt(100) == nil || msg.Attempts != i {
+			t.Fatalf("failed delivery not counted")
+		}
+		if i < MaxAttempts && (msg.Status != StatusQueued || msg.NextAttempt != 100+backoff(i)) {
+			t.Fatalf("failed delivery not scheduled for retry: %+v", msg)
+		}
+	}
+	if msg.Status != StatusFailed || msg.LastError == "" {
+		t.Fatalf("notification not failed after max attempts: %+v", msg)
+	}
+
+	transport := FileTransport{Dir: dir}
+	SetTransport(transport)
+	err = msg.attempt(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusSent || msg.Delivered != 200 || msg.Transport != "file" {
+		t.Fatalf("delivery not recorded: %+v", msg)
+	}
+	data, err := ioutil.ReadFile(transport.Path(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "To: user@example.com") || !strings.HasSuffix(string(data), "body") {
+		t.Fatalf("notification not written to file: %s", data)
+	}
+}
+
+// retryingTransport retries the notification it sends, which needs the outbox lock
+type retryingTransport struct {
+	errs chan error
+}
+
+func (t retryingTransport) Name() string { return "retrying" }
+
+func (t retryingTransport) Send(msg Message) error {
+	_, err := RetryMessage(msg.Index)
+	t.errs <- err
+	return nil
+}
+
+func TestOutboxLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbDir := consts.DbDir
+	consts.DbDir = dir + "/"
+	defer func() { consts.DbDir = dbDir }()
+	db, err := edb.CreateDB(consts.DbDir+consts.DbName, OutboxBucket, PreferencesBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	oldTransport := CurrentTransport()
+	defer SetTransport(oldTransport)
+
+	SetTransport(failingTransport{})
+	err = Notify(TemplateDemotion, 1, "user@example.com", Vars{"ProjIndex": "1", "Stage": "2", "Reason": "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := retryingTransport{errs: make(chan error, 1)}
+	SetTransport(transport)
+	done := make(chan error)
+	go func() {
+		done <- DeliverDue(utils.Unix() + MaxBackoff)
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("outbox lock held while the notification was sent")
+	}
+
+	if <-transport.errs == nil {
+		t.Fatalf("notification retried while it was being delivered")
+	}
+	msg, err := RetrieveMessage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusSent || msg.Attempts != 2 {
+		t.Fatalf("delivery not saved: %+v", msg)
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	var prefs Preferences
+	if prefs.Digest(EventPayback, ChannelEmail) != DigestImmediate {
+		t.Fatalf("notifications without a preference not delivered immediately")
+	}
+
+	err := prefs.Set(EventProject, ChannelEmail, DigestWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.Digest(EventProject, ChannelEmail) != DigestWeekly || prefs.Digest(EventPayback, ChannelEmail) != DigestImmediate {
+		t.Fatalf("preference not set: %v", prefs.Events)
+	}
+
+	for _, x := range [][]string{{"blah", ChannelEmail, DigestOff}, {EventPayback, "sms", DigestOff},
+		{EventPayback, ChannelEmail, "hourly"}} {
+		if prefs.Set(x[0], x[1], x[2]) == nil {
+			t.Fatalf("invalid preference set: %v", x)
+		}
+	}
+	if ValidatePreferences(map[string]map[string]string{EventDelinquency: {ChannelEmail: DigestDaily}}) != nil ||
+		ValidatePreferences(map[string]map[string]string{EventDelinquency: {ChannelEmail: "blah"}}) == nil {
+		t.Fatalf("preferences not validated")
+	}
+	if digestPeriod(DigestWeekly) != 7*digestPeriod(DigestDaily) || digestPeriod(DigestOff) != 0 {
+		t.Fatalf("digest periods wrong")
+	}
+
+	// every template users receive must belong to an event type they can set preferences for
+	for _, x := range RetrieveTemplates() {
+		if x.Name != TemplateDigest && !contains(EventTypes, x.EventType) {
+			t.Fatalf("template %s has unknown event type %s", x.Name, x.EventType)
+		}
+	}
+}
+
+func TestNotifyPreferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbDir := consts.DbDir
+	consts.DbDir = dir + "/"
+	defer func() { consts.DbDir = dbDir }()
+	db, err := edb.CreateDB(consts.DbDir+consts.DbName, OutboxBucket, PreferencesBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	oldTransport := CurrentTransport()
+	defer SetTransport(oldTransport)
+	SetTransport(failingTransport{})
+
+	_, err = UpdatePreferences(1, "user@example.com", map[string]map[string]string{EventProject: {ChannelEmail: DigestWeekly}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasPreferences(1) || HasPreferences(2) {
+		t.Fatalf("preferences not looked up by user index")
+	}
+
+	// preferences belong to the user and not the address, so notifications to the same address that aren't
+	// sent to the user are delivered immediately
+	vars := Vars{"ProjIndex": "1", "Stage": "2", "Reason": "none"}
+	for _, userIndex := range []int{1, 2, 0} {
+		err = Notify(TemplateDemotion, userIndex, "user@example.com", vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	held, err := RetrieveMessages(StatusHeld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(held) != 1 || held[0].Index != 1 {
+		t.Fatalf("preferences not applied by user index: %+v", held)
+	}
+}
@@ -0,0 +1,443 @@
+package notif
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+	utils "github.com/Varunram/essentials/utils"
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// notifications are rendered from a template and stored in the outbox before they're delivered. Notifications
+// that can't be delivered are retried with exponential backoff until they've been attempted MaxAttempts times,
+// after which they're marked as failed and can be retried by an admin. Notifications users turned off are stored
+// as suppressed and those they asked to receive daily or weekly are held until they're collected into a digest
+
+// OutboxBucket is the bucket where notifications are stored along with their delivery status
+var OutboxBucket = []byte("Outbox")
+
+// the delivery statuses of a notification
+const (
+	// StatusQueued is the status of a notification waiting to be delivered
+	StatusQueued = "queued"
+	// StatusSent is the status of a notification that has been delivered
+	StatusSent = "sent"
+	// StatusFailed is the status of a notification that couldn't be delivered after MaxAttempts attempts
+	StatusFailed = "failed"
+	// StatusSuppressed is the status of a notification the recipient turned off
+	StatusSuppressed = "suppressed"
+	// StatusHeld is the status of a notification waiting to be collected into a digest
+	StatusHeld = "held"
+	// StatusDigested is the status of a notification that has been collected into a digest
+	StatusDigested = "digested"
+)
+
+var (
+	// MaxAttempts is the number of times delivery of a notification is attempted before it is marked as failed
+	MaxAttempts = 6
+
+	// RetryBackoff is the time in seconds before a failed delivery is retried, doubled after each failed attempt
+	RetryBackoff = int64(60)
+
+	// MaxBackoff is the maximum time in seconds between two delivery attempts
+	MaxBackoff = int64(6 * 60 * 60)
+)
+
+// Message is a notification in the outbox
+type Message struct {
+	// Index is the index of the notification in the outbox
+	Index int
+
+	// Template is the name of the template the notification was rendered from
+	Template string
+
+	// EventType is the type of event the notification was sent for
+	EventType string
+
+	// Channel is the channel the notification is delivered over
+	Channel string
+
+	// Digest is how the recipient asked for the notification to be delivered (DigestOff, DigestImmediate,
+	// DigestDaily or DigestWeekly)
+	Digest string
+
+	// DigestIndex is the index of the digest a held notification was collected into
+	DigestIndex int
+
+	// Vars are the variables the template was rendered with
+	Vars Vars
+
+	// To is the email address the notification is sent to
+	To string
+
+	// Subject and Body are the rendered notification
+	Subject string
+	Body    string
+
+	// Status is the delivery status of the notification (StatusQueued, StatusSent, StatusFailed,
+	// StatusSuppressed, StatusHeld or StatusDigested)
+	Status string
+
+	// Transport is the name of the transport the notification was last attempted with
+	Transport string
+
+	// Attempts is the number of delivery attempts made
+	Attempts int
+
+	// LastError is the error returned by the last failed delivery attempt
+	LastError string
+
+	// Created is the unix time at which the notification was queued
+	Created int64
+
+	// NextAttempt is the unix time at which delivery should next be attempted
+	NextAttempt int64
+
+	// Delivered is the unix time at which the notification was delivered
+	Delivered int64
+}
+
+// outboxLock guards queueing notifications and saving their delivery status. Notifications are sent without the
+// lock held so a slow transport doesn't hold up the outbox, sending keeps a notification from being delivered
+// twice at the same time
+var (
+	outboxLock sync.Mutex
+	sending    = make(map[int]bool)
+)
+
+// Bytes returns the notification as an email sent from the given address
+func (a Message) Bytes(from string) []byte {
+	return []byte("From: " + from + "\r\n" +
+		"To: " + a.To + "\r\n" +
+		"Subject: " + a.Subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		a.Body)
+}
+
+// Save saves a Message's details
+func (a *Message) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, OutboxBucket, a, a.Index)
+}
+
+// RetrieveMessage retrieves a specific notification from the outbox
+func RetrieveMessage(key int) (Message, error) {
+	var msg Message
+	x, err := edb.Retrieve(consts.DbDir+consts.DbName, OutboxBucket, key)
+	if err != nil {
+		return msg, errors.Wrap(err, "error while retrieving key from bucket")
+	}
+
+	err = json.Unmarshal(x, &msg)
+	if err != nil {
+		return msg, errors.Wrap(err, "could not unmarshal json")
+	}
+
+	if msg.Index == 0 {
+		return msg, errors.New("notification not found")
+	}
+	return msg, nil
+}
+
+// RetrieveAllMessages retrieves all notifications in the outbox
+func RetrieveAllMessages() ([]Message, error) {
+	var arr []Message
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, OutboxBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Message
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	sort.Slice(arr, func(i, j int) bool {
+		return arr[i].Index < arr[j].Index
+	})
+	return arr, nil
+}
+
+// RetrieveMessages retrieves the notifications in the outbox with a given status, all notifications if status
+// is empty
+func RetrieveMessages(status string) ([]Message, error) {
+	var arr []Message
+	msgs, err := RetrieveAllMessages()
+	if err != nil {
+		return arr, err
+	}
+
+	for _, msg := range msgs {
+		if status == "" || msg.Status == status {
+			arr = append(arr, msg)
+		}
+	}
+	return arr, nil
+}
+
+// backoff returns the time in seconds to wait before the next delivery attempt after attempts failed attempts
+func backoff(attempts int) int64 {
+	wait := RetryBackoff
+	for i := 1; i < attempts && wait < MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > MaxBackoff {
+		wait = MaxBackoff
+	}
+	return wait
+}
+
+// attempt attempts to deliver a notification and updates its delivery status. The notification must have been
+// marked as sending
+func (a *Message) attempt(now int64) error {
+	a.Transport = transport.Name()
+	a.Attempts++
+	err := transport.Send(*a)
+	if err != nil {
+		log.Println("couldn't deliver notification: ", a.Index, " to: ", a.To, err)
+		a.LastError = err.Error()
+		a.NextAttempt = now + backoff(a.Attempts)
+		if a.Attempts >= MaxAttempts {
+			a.Status = StatusFailed
+		}
+		return err
+	}
+
+	a.Status = StatusSent
+	a.LastError = ""
+	a.Delivered = now
+	return nil
+}
+
+// deliver attempts to deliver a notification marked as sending and saves its delivery status
+func deliver(msg Message, now int64) (Message, error) {
+	msg.attempt(now)
+
+	outboxLock.Lock()
+	defer outboxLock.Unlock()
+	delete(sending, msg.Index)
+	return msg, msg.Save()
+}
+
+// Notify renders a notification from a template, stores it in the outbox and attempts to deliver it if the
+// preferences of the user with index userIndex ask for it immediately. Notifications to addresses that don't
+// belong to a user (eg. the platform's) are sent with userIndex 0 and always delivered immediately. A notification
+// that couldn't be delivered stays in the outbox and is retried by DeliverDue, so only errors rendering or storing
+// the notification are returned
+func Notify(name string, userIndex int, to string, vars Vars) error {
+	var msg Message
+	var err error
+	msg.Subject, msg.Body, err = Render(name, vars)
+	if err != nil {
+		return errors.Wrap(err, "couldn't render notification")
+	}
+
+	msg.EventType = eventType(name)
+	msg.Channel = ChannelEmail
+	msg.Digest = DigestImmediate
+	if msg.EventType != "" && userIndex != 0 {
+		prefs, err := RetrievePreferences(userIndex)
+		if err != nil {
+			return errors.Wrap(err, "couldn't retrieve notification preferences")
+		}
+		msg.Digest = prefs.Digest(msg.EventType, msg.Channel)
+	}
+
+	err = queue(&msg, name, to, vars)
+	if err != nil {
+		return err
+	}
+	if msg.Status != StatusQueued {
+		return nil
+	}
+
+	_, err = deliver(msg, msg.Created)
+	return err
+}
+
+// queue stores a notification in the outbox and marks it as sending if it is to be delivered immediately
+func queue(msg *Message, name string, to string, vars Vars) error {
+	outboxLock.Lock()
+	defer outboxLock.Unlock()
+
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, OutboxBucket)
+	if err != nil {
+		return errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	msg.Index = len(x) + 1
+	msg.Template = name
+	msg.Vars = vars
+	msg.To = to
+	msg.Status = StatusQueued
+	msg.Created = utils.Unix()
+	msg.NextAttempt = msg.Created
+
+	switch msg.Digest {
+	case DigestOff:
+		msg.Status = StatusSuppressed
+		return msg.Save()
+	case DigestDaily, DigestWeekly:
+		msg.Status = StatusHeld
+		msg.NextAttempt = msg.Created + digestPeriod(msg.Digest)
+		return msg.Save()
+	}
+
+	err = msg.Save()
+	if err != nil {
+		return errors.Wrap(err, "couldn't queue notification")
+	}
+	sending[msg.Index] = true
+	return nil
+}
+
+// collectDigests collects the notifications held for each recipient and digest into a queued digest once the
+// oldest of them has been held for the digest period. The lock must be held
+func collectDigests(now int64) error {
+	msgs, err := RetrieveMessages(StatusHeld)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve held notifications")
+	}
+
+	// msgs are sorted by index, so the first notification of each group is the oldest
+	var keys []string
+	groups := make(map[string][]Message)
+	for _, msg := range msgs {
+		key := msg.To + "/" + msg.Digest
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], msg)
+	}
+
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, OutboxBucket)
+	if err != nil {
+		return errors.Wrap(err, "error while retrieving all keys")
+	}
+	index := len(x)
+
+	for _, key := range keys {
+		held := groups[key]
+		if held[0].NextAttempt > now {
+			continue
+		}
+
+		var parts []string
+		for _, msg := range held {
+			parts = append(parts, msg.Subject+"\n\n"+strings.TrimSuffix(msg.Body, "\n\n\n"+footerString))
+		}
+
+		var digest Message
+		digest.Vars = Vars{"Period": held[0].Digest, "Count": itoa(len(held))}
+		digest.Subject, digest.Body, err = Render(TemplateDigest, Vars{"Period": held[0].Digest,
+			"Count": itoa(len(held)), "Digest": strings.Join(parts, "\n\n----------\n\n")})
+		if err != nil {
+			return errors.Wrap(err, "couldn't render digest")
+		}
+
+		index++
+		digest.Index = index
+		digest.Template = TemplateDigest
+		digest.To = held[0].To
+		digest.Channel = held[0].Channel
+		digest.Digest = DigestImmediate
+		digest.Status = StatusQueued
+		digest.Created = now
+		digest.NextAttempt = now
+		err = digest.Save()
+		if err != nil {
+			return errors.Wrap(err, "couldn't queue digest")
+		}
+
+		for _, msg := range held {
+			msg.Status = StatusDigested
+			msg.DigestIndex = digest.Index
+			err = msg.Save()
+			if err != nil {
+				log.Println("couldn't save notification: ", msg.Index, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DeliverDue collects held notifications into the digests that are due and attempts to deliver the queued
+// notifications whose next attempt is at or before now
+func DeliverDue(now int64) error {
+	msgs, err := claimDue(now)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range msgs {
+		_, err = deliver(msg, now)
+		if err != nil {
+			log.Println("couldn't save notification: ", msg.Index, err)
+		}
+	}
+	return nil
+}
+
+// claimDue collects the digests that are due and marks the queued notifications whose next attempt is at or
+// before now and that aren't being sent already as sending
+func claimDue(now int64) ([]Message, error) {
+	outboxLock.Lock()
+	defer outboxLock.Unlock()
+
+	err := collectDigests(now)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := RetrieveMessages(StatusQueued)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't retrieve queued notifications")
+	}
+
+	var due []Message
+	for _, msg := range msgs {
+		if msg.NextAttempt > now || sending[msg.Index] {
+			continue
+		}
+		sending[msg.Index] = true
+		due = append(due, msg)
+	}
+	return due, nil
+}
+
+// RetryMessage queues a failed notification again and attempts to deliver it immediately
+func RetryMessage(index int) (Message, error) {
+	outboxLock.Lock()
+	msg, err := RetrieveMessage(index)
+	if err != nil {
+		outboxLock.Unlock()
+		return msg, errors.Wrap(err, "couldn't retrieve notification")
+	}
+	if msg.Status == StatusSent {
+		outboxLock.Unlock()
+		return msg, errors.New("notification has already been delivered, quitting")
+	}
+	if msg.Status != StatusQueued && msg.Status != StatusFailed {
+		outboxLock.Unlock()
+		return msg, errors.New("notification is " + msg.Status + " and can't be retried, quitting")
+	}
+	if sending[msg.Index] {
+		outboxLock.Unlock()
+		return msg, errors.New("notification is being delivered, quitting")
+	}
+	sending[msg.Index] = true
+	outboxLock.Unlock()
+
+	msg.Status = StatusQueued
+	msg.Attempts = 0
+	return deliver(msg, utils.Unix())
+}
@@ -0,0 +1,223 @@
+package notif
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	edb "github.com/Varunram/essentials/database"
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// users choose, for each type of event and each channel, whether they want notifications immediately, in a daily
+// or weekly digest or not at all. Users who haven't set a preference for an event receive it immediately
+
+// PreferencesBucket is the bucket where users' notification preferences are stored
+var PreferencesBucket = []byte("Preferences")
+
+// the types of events notifications are sent for. Each template belongs to one of them
+const (
+	// EventInvestment covers investments in a project and requests to accept them
+	EventInvestment = "investment"
+	// EventPayback covers payback receipts
+	EventPayback = "payback"
+	// EventStatement covers statements and payment reminders
+	EventStatement = "statement"
+	// EventDelinquency covers alerts about overdue paybacks, disconnections and cures
+	EventDelinquency = "delinquency"
+	// EventProject covers breaches, cancellations and stage changes of a project
+	EventProject = "project"
+	// EventRefund covers refunds of investments
+	EventRefund = "refund"
+	// EventContract covers contracts signed on the platform
+	EventContract = "contract"
+	// EventMessage covers messages sent by other users and the platform
+	EventMessage = "message"
+	// EventPlatform covers alerts sent to the platform and its admins
+	EventPlatform = "platform"
+)
+
+// EventTypes are all the types of events notifications are sent for
+var EventTypes = []string{EventInvestment, EventPayback, EventStatement, EventDelinquency, EventProject, EventRefund,
+	EventContract, EventMessage, EventPlatform}
+
+// ChannelEmail is the email channel, which notifications are delivered over by the transport
+const ChannelEmail = "email"
+
+// Channels are all the channels notifications can be delivered over
+var Channels = []string{ChannelEmail}
+
+// the ways notifications can be delivered
+const (
+	// DigestOff turns notifications off
+	DigestOff = "off"
+	// DigestImmediate delivers notifications as soon as they're sent
+	DigestImmediate = "immediate"
+	// DigestDaily collects notifications into a digest delivered once a day
+	DigestDaily = "daily"
+	// DigestWeekly collects notifications into a digest delivered once a week
+	DigestWeekly = "weekly"
+)
+
+// Preferences are a user's notification preferences
+type Preferences struct {
+	// Index is the index of the user the preferences belong to
+	Index int
+
+	// Email is the address the user receives notifications at
+	Email string
+
+	// Events is an event type: channel: digest map of the user's preferences
+	Events map[string]map[string]string
+}
+
+// Save saves a user's Preferences
+func (a *Preferences) Save() error {
+	return edb.Save(consts.DbDir+consts.DbName, PreferencesBucket, a, a.Index)
+}
+
+// RetrievePreferences retrieves the notification preferences of a user. Users without preferences get empty
+// preferences, which deliver every notification immediately
+func RetrievePreferences(userIndex int) (Preferences, error) {
+	prefs, _, err := retrievePreferences(userIndex)
+	return prefs, err
+}
+
+// retrievePreferences retrieves the notification preferences of a user and returns false if the user hasn't
+// set any
+func retrievePreferences(userIndex int) (Preferences, bool, error) {
+	prefs := Preferences{Index: userIndex}
+	all, err := RetrieveAllPreferences()
+	if err != nil {
+		return prefs, false, err
+	}
+
+	for _, x := range all {
+		if x.Index == userIndex {
+			return x, true, nil
+		}
+	}
+	return prefs, false, nil
+}
+
+// RetrieveAllPreferences retrieves the notification preferences of all users who have set them
+func RetrieveAllPreferences() ([]Preferences, error) {
+	var arr []Preferences
+	x, err := edb.RetrieveAllKeys(consts.DbDir+consts.DbName, PreferencesBucket)
+	if err != nil {
+		return arr, errors.Wrap(err, "error while retrieving all keys")
+	}
+
+	for _, value := range x {
+		var temp Preferences
+		err = json.Unmarshal(value, &temp)
+		if err != nil {
+			return arr, errors.New("could not unmarshal json")
+		}
+		arr = append(arr, temp)
+	}
+
+	return arr, nil
+}
+
+// HasPreferences returns true if the user with the given index has set notification preferences
+func HasPreferences(userIndex int) bool {
+	_, exists, err := retrievePreferences(userIndex)
+	return err == nil && exists
+}
+
+// contains returns true if the array contains the string
+func contains(arr []string, x string) bool {
+	for _, elem := range arr {
+		if elem == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Digest returns how the user wants notifications for an event type delivered over a channel
+func (a Preferences) Digest(eventType string, channel string) string {
+	digest, exists := a.Events[eventType][channel]
+	if !exists {
+		return DigestImmediate
+	}
+	return digest
+}
+
+// Set sets how the user wants notifications for an event type delivered over a channel
+func (a *Preferences) Set(eventType string, channel string, digest string) error {
+	if !contains(EventTypes, eventType) {
+		return errors.New("unknown event type: " + eventType)
+	}
+	if !contains(Channels, channel) {
+		return errors.New("unknown channel: " + channel)
+	}
+	if digestPeriod(digest) < 0 {
+		return errors.New("unknown digest: " + digest)
+	}
+
+	if a.Events == nil {
+		a.Events = make(map[string]map[string]string)
+	}
+	if a.Events[eventType] == nil {
+		a.Events[eventType] = make(map[string]string)
+	}
+	a.Events[eventType][channel] = digest
+	return nil
+}
+
+// digestPeriod returns the time in seconds notifications are collected for before a digest is delivered, zero
+// for notifications that aren't collected and -1 for unknown digests
+func digestPeriod(digest string) int64 {
+	switch digest {
+	case DigestOff, DigestImmediate:
+		return 0
+	case DigestDaily:
+		return 24 * 60 * 60
+	case DigestWeekly:
+		return 7 * 24 * 60 * 60
+	}
+	return -1
+}
+
+// ValidatePreferences returns an error if an event type: channel: digest map of preferences refers to an
+// unknown event type, channel or digest
+func ValidatePreferences(events map[string]map[string]string) error {
+	var prefs Preferences
+	for eventType, channels := range events {
+		for channel, digest := range channels {
+			err := prefs.Set(eventType, channel, digest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UpdatePreferences updates the notification preferences of a user. events is an event type: channel: digest
+// map of the preferences to change, other preferences are left as they are
+func UpdatePreferences(userIndex int, email string, events map[string]map[string]string) (Preferences, error) {
+	prefs, err := RetrievePreferences(userIndex)
+	if err != nil {
+		return prefs, errors.Wrap(err, "couldn't retrieve preferences")
+	}
+
+	err = ValidatePreferences(events)
+	if err != nil {
+		return prefs, err
+	}
+
+	prefs.Email = email
+	for eventType, channels := range events {
+		for channel, digest := range channels {
+			err = prefs.Set(eventType, channel, digest)
+			if err != nil {
+				return prefs, err
+			}
+		}
+	}
+
+	return prefs, prefs.Save()
+}
@@ -0,0 +1,323 @@
+package notif
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// the templates notifications are rendered from. Each template refers to the variables of the event it is sent
+// for (eg. {{.ProjIndex}}) and rendering fails if a variable is missing. The footer is appended to every body
+const (
+	TemplateInvestmentRecipient        = "investment_recipient"
+	TemplateInvestmentInvestor         = "investment_investor"
+	TemplateSeedInvestment             = "seed_investment"
+	TemplatePaybackRecipient           = "payback_recipient"
+	TemplatePaybackInvestor            = "payback_investor"
+	TemplateUnlock                     = "unlock"
+	TemplateMessage                    = "message"
+	TemplateAlert                      = "alert"
+	TemplatePaybackAlert               = "payback_alert"
+	TemplateStatement                  = "statement"
+	TemplateNicePaybackAlert           = "payback_alert_nice"
+	TemplateLatePaybackAlert           = "payback_alert_late"
+	TemplateSternPaybackAlert          = "payback_alert_stern"
+	TemplateSternPaybackAlertInvestor  = "payback_alert_stern_investor"
+	TemplateSternPaybackAlertGuarantor = "payback_alert_stern_guarantor"
+	TemplateDisconnection              = "disconnection"
+	TemplateDisconnectionInvestor      = "disconnection_investor"
+	TemplateDisconnectionGuarantor     = "disconnection_guarantor"
+	TemplateDelinquencyCured           = "delinquency_cured"
+	TemplateBreach                     = "breach"
+	TemplateCancellation               = "cancellation"
+	TemplateDemotion                   = "demotion"
+	TemplateRefund                     = "refund"
+	TemplateRefundFailed               = "refund_failed"
+	TemplateContract                   = "contract"
+	TemplateTellerShutdown             = "teller_shutdown"
+	TemplateTellerPaymentFailed        = "teller_payment_failed"
+	TemplateTellerDown                 = "teller_down"
+	TemplateRecipientNotFound          = "recipient_not_found"
+	TemplateDigest                     = "digest"
+)
+
+// Vars are the variables a template is rendered with
+type Vars map[string]string
+
+// Template is a named notification template
+type Template struct {
+	// Name is the name notifications refer to the template by
+	Name string
+
+	// EventType is the type of event the template is sent for, which users set their preferences by
+	EventType string
+
+	// Subject is the subject line of the notification
+	Subject string
+
+	// Body is the body of the notification
+	Body string
+
+	subject *template.Template
+	body    *template.Template
+}
+
+// footerString is a common signoff / footer string that is used by all emails sent from the platform's email address
+var footerString = "Have a nice day!\n\nWarm Regards, \nThe OpenSolar Team\n\n\n\n" +
+	"You're receiving this email because your contact was given" +
+	" on the opensolar platform for receiving notifications on orders in which you're a party.\n\n\n"
+
+// greeting is the opening line of emails sent from the platform
+const greeting = "Greetings from the opensolar platform! \n\n"
+
+var templates = make(map[string]*Template)
+
+var templatesLock sync.RWMutex
+
+// RegisterTemplate registers a template, replacing any template with the same name
+func RegisterTemplate(name string, eventType string, subject string, body string) error {
+	var x Template
+	var err error
+	x.Name = name
+	x.EventType = eventType
+	x.Subject = subject
+	x.Body = body
+
+	x.subject, err = template.New(name + "_subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return errors.Wrap(err, "couldn't parse template subject")
+	}
+	x.body, err = template.New(name).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return errors.Wrap(err, "couldn't parse template body")
+	}
+
+	templatesLock.Lock()
+	defer templatesLock.Unlock()
+	templates[name] = &x
+	return nil
+}
+
+// RetrieveTemplates returns all registered templates
+func RetrieveTemplates() []Template {
+	templatesLock.RLock()
+	defer templatesLock.RUnlock()
+	var arr []Template
+	for _, x := range templates {
+		arr = append(arr, *x)
+	}
+	return arr
+}
+
+// eventType returns the type of event a template is sent for
+func eventType(name string) string {
+	templatesLock.RLock()
+	defer templatesLock.RUnlock()
+	x, exists := templates[name]
+	if !exists {
+		return ""
+	}
+	return x.EventType
+}
+
+// Render renders the subject and body of a notification from a template
+func Render(name string, vars Vars) (string, string, error) {
+	templatesLock.RLock()
+	x, exists := templates[name]
+	templatesLock.RUnlock()
+	if !exists {
+		return "", "", errors.New("template not found: " + name)
+	}
+
+	var subject, body bytes.Buffer
+	err := x.subject.Execute(&subject, vars)
+	if err != nil {
+		return "", "", errors.Wrap(err, "couldn't render template subject")
+	}
+	err = x.body.Execute(&body, vars)
+	if err != nil {
+		return "", "", errors.Wrap(err, "couldn't render template body")
+	}
+	return subject.String(), body.String() + "\n\n\n" + footerString, nil
+}
+
+func init() {
+	for _, x := range []Template{
+		{Name: TemplateInvestmentRecipient, EventType: EventInvestment, Subject: "Project {{.ProjIndex}} has been invested in",
+			Body: greeting +
+				"We're writing to let you know that project number: {{.ProjIndex}} has been invested in.\n\n" +
+				"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
+				"Your payback trusted asset hash is: https://testnet.steexp.com/tx/{{.PbTrustHash}}\n" +
+				"Your payback asset hash is: https://testnet.steexp.com/tx/{{.AssetHash}}\n" +
+				"Your debt trusted asset hash is: https://testnet.steexp.com/tx/{{.DebtTrustHash}}\n" +
+				"Your debt asset hash is: https://testnet.steexp.com/tx/{{.DebtAssetHash}}"},
+		{Name: TemplateInvestmentInvestor, EventType: EventInvestment, Subject: "Your investment in project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know have invested in project number: {{.ProjIndex}}\n\n" +
+				"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
+				"Your stablecoin payment hash is: https://testnet.steexp.com/tx/{{.StableHash}}\n" +
+				"Your trusted asset hash is: https://testnet.steexp.com/tx/{{.TrustHash}}\n" +
+				"Your investment asset hash is: https://testnet.steexp.com/tx/{{.AssetHash}}"},
+		{Name: TemplateSeedInvestment, EventType: EventInvestment, Subject: "Your seed investment in project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know have invested in the seed round of project: {{.ProjIndex}}\n\n" +
+				"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
+				"Your stablecoin payment hash is: https://testnet.steexp.com/tx/{{.StableHash}}\n" +
+				"Your trusted asset hash is: https://testnet.steexp.com/tx/{{.TrustHash}}\n" +
+				"Your investment asset hash is: https://testnet.steexp.com/tx/{{.AssetHash}}"},
+		{Name: TemplatePaybackRecipient, EventType: EventPayback, Subject: "Your payback towards project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know have paid back towards project number: {{.ProjIndex}}\n\n" +
+				"Your proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
+				"Stablecoin payment hash is: https://testnet.steexp.com/tx/{{.StableHash}}\n" +
+				"Debt asset hash is: https://testnet.steexp.com/tx/{{.DebtHash}}"},
+		{Name: TemplatePaybackInvestor, EventType: EventPayback, Subject: "Payback received for project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know that the recipient has paid back towards project number: {{.ProjIndex}}\n\n" +
+				"The recipient's proofs of payment are attached below and may be used as future reference in case of discrepancies:  \n\n" +
+				"Stablecoin payment hash is: https://testnet.steexp.com/tx/{{.StableHash}}\n" +
+				"Debt asset hash is: https://testnet.steexp.com/tx/{{.DebtHash}}"},
+		{Name: TemplateUnlock, EventType: EventInvestment, Subject: "Accept the investment in project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know that project number: {{.ProjIndex}} has been invested in\n\n" +
+				"You are required to logon to the platform within a period of 3(THREE) days in order to accept the investment\n\n" +
+				"If you choose to not accept the given investment in your project, please be warned that your reputation score " +
+				"will be adjusted accordingly and this may affect any future proposal that you seek funding for on the platform"},
+		{Name: TemplateMessage, EventType: EventMessage, Subject: "Message from {{.Name}}",
+			Body: greeting +
+				"We're writing to let you know that {{.Name}} has sent you a message. The message contents follow: \n\n" +
+				"{{.Message}}"},
+		{Name: TemplateAlert, EventType: EventMessage, Subject: "Message from the opensolar platform",
+			Body: greeting +
+				"We're writing to let you know that you have received a message from the platform: \n\n\n{{.Message}}"},
+		{Name: TemplatePaybackAlert, EventType: EventStatement, Subject: "Payment due for project {{.ProjIndex}}",
+			Body: greeting +
+				"This is a kind reminder to let you know that your payment is due this period for project numbered: {{.ProjIndex}}" +
+				"\n\n If you have already paid or have received a donation towards this month, please ignore this alert."},
+		{Name: TemplateStatement, EventType: EventStatement, Subject: "Your statement for project {{.ProjIndex}}",
+			Body: greeting +
+				"Your statement for this period is ready for project numbered: {{.ProjIndex}}" +
+				". Your payment is due this period, the amount due and payments we've received are listed below." +
+				"\n\n If you have already paid or have received a donation towards this month, please ignore the amount due." +
+				"\n\n{{.Statement}}"},
+		{Name: TemplateNicePaybackAlert, EventType: EventStatement, Subject: "Payment due for project {{.ProjIndex}}",
+			Body: greeting +
+				"This is a kind reminder to let you know that your payment is due this period for project numbered: {{.ProjIndex}}" +
+				"\n\n Please payback at the earliest."},
+		{Name: TemplateLatePaybackAlert, EventType: EventDelinquency, Subject: "Payments overdue for project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know that payments for multiple periods are overdue for project numbered: {{.ProjIndex}}" +
+				"\n\n Please payback at the earliest to avoid further action."},
+		{Name: TemplateSternPaybackAlert, EventType: EventDelinquency, Subject: "Payments overdue for project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know that your payment is due this period for project numbered: {{.ProjIndex}}" +
+				"\n\n Please payback within two payback cycles to avoid re-routing of power services."},
+		{Name: TemplateSternPaybackAlertInvestor, EventType: EventDelinquency, Subject: "Payments overdue for project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know that we are aware that payments towards the project: {{.ProjIndex}}" +
+				"\n\n haven't been made and we have reached out to the project recipient on the same. If this situation continues for " +
+				"two more payment periods, we will be redirecting power towards the general grid and you would receive payments " +
+				"for all periods where they were due. \n\n" +
+				"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
+				"Please feel free to write to support with your queries in the meantime."},
+		{Name: TemplateSternPaybackAlertGuarantor, EventType: EventDelinquency, Subject: "Payments overdue for project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know that we are aware that payments towards the project: {{.ProjIndex}}" +
+				"\n\n haven't been made and have reached out to the project recipient on the same. If this situation continues for " +
+				"two more payment periods, we will be redirecting power towards the general grid and contact you for further" +
+				"information on how the guarantee towards the project would be realized to investors.\n\n" +
+				"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
+				"Please feel free to write to support with your queries in the meantime."},
+		{Name: TemplateDisconnection, EventType: EventDelinquency, Subject: "Power from project {{.ProjIndex}} has been redirected",
+			Body: greeting +
+				"We're writing to let you know that electricity produced from your project numbered: {{.ProjIndex}}" +
+				"\n\nHas been redirected towards the main power grid. Please contact your guarantor to resume services"},
+		{Name: TemplateDisconnectionInvestor, EventType: EventDelinquency, Subject: "Power from project {{.ProjIndex}} has been redirected",
+			Body: greeting +
+				"We're writing to let you know that electricity produced from your project numbered: {{.ProjIndex}}" +
+				"\n\nHas been redirected towards the main power grid due to irregular payments by the recipient involved.\n\n" +
+				"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
+				"Please feel free to write to support with your queries in the meantime."},
+		{Name: TemplateDisconnectionGuarantor, EventType: EventDelinquency, Subject: "Power from project {{.ProjIndex}} has been redirected",
+			Body: greeting +
+				"We're writing to let you know that electricity produced from your project numbered: {{.ProjIndex}}" +
+				"\n\nHas been redirected towards the main power grid due to irregular payments by the recipient involved.\n\n" +
+				"We will be reaching out to you in the coming days on how to proceed with realizing the guarantee towards this " +
+				"project in order to safeguard investors. We will also be contacting the recipient involved to update them on the" +
+				"situation and will make efforts to alleviate this problem as soon as possible." +
+				"We are constantly monitoring this situation and will be continuing to send you emails on the same.\n\n" +
+				"Please feel free to write to support with your queries in the meantime."},
+		{Name: TemplateDelinquencyCured, EventType: EventDelinquency, Subject: "Project {{.ProjIndex}} is back on track",
+			Body: greeting +
+				"We're writing to let you know that overdue payments towards the project numbered: {{.ProjIndex}}" +
+				"\n\nHave been made and the project is back on track. Thank you for your patience."},
+		{Name: TemplateBreach, EventType: EventProject, Subject: "Project {{.ProjIndex}} has breached a stage condition",
+			Body: greeting +
+				"We're writing to let you know that the project numbered: {{.ProjIndex}}" +
+				" has breached the following condition of its current stage: {{.Rule}}" +
+				"\n\n Details: {{.Detail}}" +
+				"\n\n The platform will follow up with the parties responsible."},
+		{Name: TemplateCancellation, EventType: EventProject, Subject: "Project {{.ProjIndex}} has been cancelled",
+			Body: greeting +
+				"We're writing to let you know that the project numbered: {{.ProjIndex}} has been cancelled." +
+				"\n\n Reason: {{.Reason}}" +
+				"\n\n If you have invested in this project, please logon to the platform to claim a refund of your investment."},
+		{Name: TemplateDemotion, EventType: EventProject, Subject: "Project {{.ProjIndex}} has been moved back to stage {{.Stage}}",
+			Body: greeting +
+				"We're writing to let you know that the project numbered: {{.ProjIndex}}" +
+				" has been moved back to stage: {{.Stage}}" +
+				"\n\n Reason: {{.Reason}}" +
+				"\n\n If your investment in this project is now refundable, you can claim a refund by logging on to the platform."},
+		{Name: TemplateRefund, EventType: EventRefund, Subject: "Your investment in project {{.ProjIndex}} has been refunded",
+			Body: greeting +
+				"We're writing to let you know that your investment of {{.Amount}} in the project numbered: " +
+				"{{.ProjIndex}} has been refunded." +
+				"\n\n Proof of refund: https://testnet.steexp.com/tx/{{.TxHash}}"},
+		{Name: TemplateRefundFailed, EventType: EventPlatform, Subject: "Refund failed for investor {{.InvIndex}}",
+			Body: greeting +
+				"We're writing to let you know that investor with index: {{.InvIndex}} returned their assets in project: " +
+				"{{.ProjIndex}} but couldn't be refunded {{.Amount}}. Please refund the investor at the earliest."},
+		{Name: TemplateContract, EventType: EventContract, Subject: "You have signed a contract",
+			Body: greeting +
+				"We're writing to let you know that you have signed a contract\n\n" +
+				"Your proofs of signing are attached below and may be used as future reference in case of discrepancies:  \n\n" +
+				"Your first hash is: https://testnet.steexp.com/tx/{{.Hash1}}\n" +
+				"Your second hash is: https://testnet.steexp.com/tx/{{.Hash2}}\n" +
+				"Your third hash is: https://testnet.steexp.com/tx/{{.Hash3}}\n" +
+				"Your fourth hash is: https://testnet.steexp.com/tx/{{.Hash4}}\n" +
+				"Your fifth hash is: https://testnet.steexp.com/tx/{{.Hash5}}"},
+		{Name: TemplateTellerShutdown, EventType: EventPlatform, Subject: "Teller {{.DeviceId}} for project {{.ProjIndex}} has shut down",
+			Body: "Greetings from the remote teller {{.DeviceId}} installed for: {{.From}} on behalf of project: {{.ProjIndex}}\n\n" +
+				"We're writing to let you know that the teller has shut down and requires your immediate action. The proof of shutdown transactions " +
+				"are atached below:" + "\n\n" +
+				"Tx1: https://testnet.steexp.com/tx/{{.Tx1}}\n\n" +
+				"Tx2: https://testnet.steexp.com/tx/{{.Tx2}}\n\n" +
+				"Please tend to this situation at the earliest."},
+		{Name: TemplateTellerPaymentFailed, EventType: EventPlatform, Subject: "Teller {{.DeviceId}} for project {{.ProjIndex}} couldn't pay back",
+			Body: "Greetings from the remote teller {{.DeviceId}} installed for: {{.From}} on behalf of project: {{.ProjIndex}}\n\n" +
+				"We're writing to let you know that the teller encountered an error, didn't result in automatic payback and requires your immediate action. " +
+				"Please tend to this situation at the earliest."},
+		{Name: TemplateTellerDown, EventType: EventPlatform, Subject: "Teller for project {{.ProjIndex}} is down",
+			Body: greeting +
+				"We're writing to let you know that remote teller {{.ProjIndex}}" +
+				" installed on behalf of recipient with index: {{.RecpIndex}} has not been responding to pings for a while. Please take action at " +
+				"the earliest,"},
+		{Name: TemplateRecipientNotFound, EventType: EventPlatform, Subject: "Recipient needed for project {{.ProjIndex}}",
+			Body: greeting +
+				"We're writing to let you know that project with index: {{.ProjIndex}}" +
+				" and recipient index: {{.RecpIndex}} has just beenf funded. Please create a new recipient account with log details in order to be able to proceed with investment"},
+		// digests collect the notifications users asked to receive daily or weekly and are always delivered
+		{Name: TemplateDigest, Subject: "Your {{.Period}} opensolar digest",
+			Body: greeting +
+				"Here are the {{.Count}} notifications sent to you since your last {{.Period}} digest:\n\n" +
+				"{{.Digest}}"},
+	} {
+		err := RegisterTemplate(x.Name, x.EventType, x.Subject, x.Body)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
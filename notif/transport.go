@@ -0,0 +1,147 @@
+package notif
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	erpc "github.com/Varunram/essentials/rpc"
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// Transport delivers rendered notifications. The openx relay is the default transport, direct SMTP and a file
+// sink (for tests and local development) are also available
+type Transport interface {
+	// Name returns the name of the transport recorded with each delivery
+	Name() string
+	// Send delivers a notification and returns an error if it couldn't be delivered
+	Send(msg Message) error
+}
+
+var transport Transport = OpenxTransport{}
+
+// SetTransport sets the transport notifications are delivered with
+func SetTransport(t Transport) {
+	transport = t
+}
+
+// CurrentTransport returns the transport notifications are delivered with
+func CurrentTransport() Transport {
+	return transport
+}
+
+// OpenxTransport relays notifications through openx's email endpoint
+type OpenxTransport struct{}
+
+// Name returns the name of the transport
+func (t OpenxTransport) Name() string {
+	return "openx"
+}
+
+// Send relays a notification through openx
+func (t OpenxTransport) Send(msg Message) error {
+	return SendMail(msg.Body, msg.To)
+}
+
+// SendMail sends an email request to openx for fulfilment
+func SendMail(body string, to string) error {
+	log.Println("calling openx url")
+	urlbody := consts.OpenxURL + "/platform/email"
+
+	postdata := url.Values{}
+	postdata.Set("body", body)
+	postdata.Set("to", to)
+	postdata.Set("code", consts.TopSecretCode)
+
+	transport := &http.Transport{
+		MaxIdleConns:       10,
+		IdleConnTimeout:    30 * time.Second,
+		DisableCompression: true,
+	}
+
+	client := &http.Client{Transport: transport}
+
+	data, err := erpc.HttpsPost(client, urlbody, postdata)
+	if err != nil {
+		log.Println("did not receive success response", err)
+		return err
+	}
+
+	var x erpc.StatusResponse
+	err = json.Unmarshal(data, &x)
+	if err != nil {
+		log.Println("did not unmarshal json", err)
+		return err
+	}
+	if x.Code != 200 {
+		return errors.New("openx responded with code: " + strconv.Itoa(x.Code))
+	}
+
+	return nil
+}
+
+// SMTPTransport sends notifications directly through an SMTP server
+type SMTPTransport struct {
+	// Host is the hostname of the SMTP server
+	Host string
+
+	// Port is the port of the SMTP server
+	Port int
+
+	// Username and Password authenticate with the SMTP server. No authentication is used if Username is empty
+	Username string
+	Password string
+
+	// From is the address notifications are sent from
+	From string
+}
+
+// Name returns the name of the transport
+func (t SMTPTransport) Name() string {
+	return "smtp"
+}
+
+// Send sends a notification through the SMTP server
+func (t SMTPTransport) Send(msg Message) error {
+	var auth smtp.Auth
+	if t.Username != "" {
+		auth = smtp.PlainAuth("", t.Username, t.Password, t.Host)
+	}
+
+	addr := t.Host + ":" + strconv.Itoa(t.Port)
+	return smtp.SendMail(addr, auth, t.From, []string{msg.To}, msg.Bytes(t.From))
+}
+
+// FileTransport writes each notification to a file in a directory instead of sending it
+type FileTransport struct {
+	// Dir is the directory notifications are written to
+	Dir string
+}
+
+// Name returns the name of the transport
+func (t FileTransport) Name() string {
+	return "file"
+}
+
+// Path returns the path of the file a notification is written to
+func (t FileTransport) Path(msg Message) string {
+	return filepath.Join(t.Dir, strconv.Itoa(msg.Index)+".eml")
+}
+
+// Send writes a notification to its file
+func (t FileTransport) Send(msg Message) error {
+	err := os.MkdirAll(t.Dir, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create notification directory")
+	}
+	return ioutil.WriteFile(t.Path(msg), msg.Bytes(consts.PlatformEmail), 0644)
+}
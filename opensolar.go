@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 
@@ -15,7 +16,9 @@ import (
 	consts "github.com/YaleOpenLab/opensolar/consts"
 	core "github.com/YaleOpenLab/opensolar/core"
 	loader "github.com/YaleOpenLab/opensolar/loader"
+	notif "github.com/YaleOpenLab/opensolar/notif"
 	rpc "github.com/YaleOpenLab/opensolar/rpc"
+	simulate "github.com/YaleOpenLab/opensolar/simulate"
 	// utils "github.com/Varunram/essentials/utils"
 	//sandbox "github.com/YaleOpenLab/opensolar/sandboxv2"
 	stablecoin "github.com/Varunram/essentials/xlm/stablecoin"
@@ -27,6 +30,7 @@ var opts struct {
 	Insecure bool   `short:"i" description:"Start the API using http. Not recommended"`
 	Port     int    `short:"p" description:"The port on which the server runs on. Default: HTTPS/8081"`
 	OpenxURL string `short:"o" description:"The URL of the openx instance to connect to. Default: http://localhost:8080"`
+	Simulate string `short:"s" description:"Simulate the lifecycle of the project described in the given scenario file and print the result"`
 }
 
 // parseConfig parses CLI parameters
@@ -42,6 +46,10 @@ func parseConfig(args []string) (bool, int, error) {
 	if opts.OpenxURL != "" {
 		consts.OpenxURL = opts.OpenxURL
 	}
+	if opts.Simulate != "" {
+		// simulations don't talk to openx, so there's no need for the access code
+		return opts.Insecure, port, nil
+	}
 
 	viper.SetConfigType("yaml")
 	viper.SetConfigName("config")
@@ -59,9 +67,44 @@ func parseConfig(args []string) (bool, int, error) {
 
 	consts.TopSecretCode = viper.GetString("code")
 
+	err = setupNotifTransport()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return opts.Insecure, port, nil
 }
 
+// setupNotifTransport sets the transport notifications are delivered with. Notifications are relayed through
+// openx unless the config asks for direct smtp or a file sink
+func setupNotifTransport() error {
+	switch viper.GetString("notiftransport") {
+	case "", "openx":
+		notif.SetTransport(notif.OpenxTransport{})
+	case "smtp":
+		err := checkViperParams("smtphost", "smtpport", "smtpfrom")
+		if err != nil {
+			return err
+		}
+		notif.SetTransport(notif.SMTPTransport{
+			Host:     viper.GetString("smtphost"),
+			Port:     viper.GetInt("smtpport"),
+			Username: viper.GetString("smtpusername"),
+			Password: viper.GetString("smtppassword"),
+			From:     viper.GetString("smtpfrom"),
+		})
+	case "file":
+		err := checkViperParams("notifdir")
+		if err != nil {
+			return err
+		}
+		notif.SetTransport(notif.FileTransport{Dir: viper.GetString("notifdir")})
+	default:
+		return errors.New("unknown notification transport: " + viper.GetString("notiftransport"))
+	}
+	return nil
+}
+
 func checkViperParams(params ...string) error {
 	for _, param := range params {
 		if !viper.IsSet(param) {
@@ -117,6 +160,33 @@ func loadOpenxConsts() error {
 	return nil
 }
 
+// runSimulation runs the scenario in the given file and prints the result
+func runSimulation(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read scenario file")
+	}
+
+	var scenario simulate.Scenario
+	err = json.Unmarshal(data, &scenario)
+	if err != nil {
+		return errors.Wrap(err, "couldn't unmarshal scenario")
+	}
+
+	result, err := simulate.Run(scenario)
+	if err != nil {
+		return errors.Wrap(err, "simulation failed")
+	}
+
+	data, err = json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal simulation result")
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
+
 func main() {
 	var err error
 	//log.Fatal(sandbox.Test())
@@ -125,6 +195,14 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if opts.Simulate != "" {
+		err = runSimulation(opts.Simulate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	consts.Mainnet = mainnet() // make an API call to openx for the status on this
 	openxconsts.SetConsts(consts.Mainnet)
 
@@ -182,5 +260,7 @@ func main() {
 	  ╚═════╝ ╚═╝     ╚══════╝╚═╝  ╚═══╝╚══════╝ ╚═════╝ ╚══════╝╚═╝  ╚═╝╚═╝  ╚═╝
 		`)
 	fmt.Println(`Starting Opensolar`)
+	core.StartScheduler() // resume payback checks, reminders and teller health checks stored in the database
+	core.StartTracking()  // subscribe to the brokers of projects whose tellers publish readings
 	rpc.StartServer(port, insecure)
 }
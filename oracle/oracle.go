@@ -1,7 +1,9 @@
 package oracle
 
+// DefaultRate is the flat per kWh rate used when no tariff schedule is available for a region
+var DefaultRate = 0.2
+
 // MonthlyBill returns the power tariffs for a month charged by the utility companies
 func MonthlyBill() float64 {
-	priceOfElectricity := 0.2
-	return priceOfElectricity
+	return DefaultRate
 }
@@ -0,0 +1,309 @@
+package oracle
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// Tier is a block of a tiered tariff. Energy up to UpTo kWh (cumulative, within a period)
+// is charged at Rate. An UpTo of zero denotes the final, unbounded tier.
+type Tier struct {
+	UpTo float64
+	Rate float64
+}
+
+// TimeOfUse is a window in the day (local hours, [StartHour, EndHour)) during which the
+// tiered rate is scaled by Multiplier. Hours not covered by any window have a multiplier of 1.
+type TimeOfUse struct {
+	Name       string
+	StartHour  int
+	EndHour    int
+	Multiplier float64
+}
+
+// Season groups the tiers and time of use windows that apply between StartMonth and EndMonth
+// (inclusive, 1-12). Seasons may wrap around the year, eg November to February.
+type Season struct {
+	Name       string
+	StartMonth int
+	EndMonth   int
+	Tiers      []Tier
+	TimeOfUse  []TimeOfUse
+}
+
+// Schedule is a tariff schedule published by a utility for a given State / Country
+type Schedule struct {
+	// Name is the unique name of the schedule, used for project level overrides
+	Name string
+
+	// State is the state the schedule applies to. Empty means the schedule applies to the whole country
+	State string
+
+	// Country is the country the schedule applies to. Empty means the schedule is the platform default
+	Country string
+
+	// Currency is the currency the rates are denominated in
+	Currency string
+
+	// FixedCharge is charged once per billing period regardless of consumption
+	FixedCharge float64
+
+	// Seasons contains the seasonal rates of the schedule. A month not covered by any season
+	// is billed at DefaultRate
+	Seasons []Season
+}
+
+// Provider is the interface that tariff sources must satisfy in order to be used for billing
+type Provider interface {
+	// Lookup returns the schedule in effect for a given state and country
+	Lookup(state string, country string) (Schedule, error)
+	// Named returns the schedule with the given name
+	Named(name string) (Schedule, error)
+}
+
+// FileProvider reads tariff schedules stored as json files in Dir
+type FileProvider struct {
+	Dir string
+}
+
+var provider Provider
+
+// SetProvider sets the provider that is used to look up tariffs
+func SetProvider(p Provider) {
+	provider = p
+}
+
+// CurrentProvider returns the provider in use, defaulting to schedules stored in consts.TariffDir
+func CurrentProvider() Provider {
+	if provider == nil {
+		return FileProvider{Dir: consts.TariffDir}
+	}
+	return provider
+}
+
+// DefaultSchedule returns a flat schedule billed at DefaultRate
+func DefaultSchedule() Schedule {
+	var x Schedule
+	x.Name = "default"
+	x.Currency = "USD"
+	x.Seasons = []Season{{Name: "all", StartMonth: 1, EndMonth: 12, Tiers: []Tier{{Rate: DefaultRate}}}}
+	return x
+}
+
+// Bill returns the amount owed for energy (in kWh) consumed between start and end using the
+// schedule in effect for the given region. If override is not empty, the schedule with that
+// name is used instead of the regional one.
+func Bill(state string, country string, override string, energy float64, start time.Time, end time.Time) (float64, error) {
+	var schedule Schedule
+	var err error
+
+	p := CurrentProvider()
+	if override != "" {
+		schedule, err = p.Named(override)
+	} else {
+		schedule, err = p.Lookup(state, country)
+	}
+	if err != nil {
+		return -1, errors.Wrap(err, "couldn't find tariff schedule")
+	}
+
+	return schedule.Bill(energy, start, end)
+}
+
+// Bill returns the amount owed for energy consumed between start and end. Energy is assumed to be
+// spread evenly over the period, so a period crossing seasons is billed pro rata by days in each
+// season and time of use multipliers are weighted by the hours they cover.
+func (s Schedule) Bill(energy float64, start time.Time, end time.Time) (float64, error) {
+	if energy < 0 {
+		return -1, errors.New("energy consumed can't be negative, quitting")
+	}
+	if !end.After(start) {
+		return -1, errors.New("metering period end must be after start, quitting")
+	}
+
+	days := 0
+	charge := 0.0
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days++
+		season, ok := s.season(day.Month())
+		if !ok {
+			charge += energy * DefaultRate
+			continue
+		}
+		charge += tieredCharge(season.Tiers, energy) * touFactor(season.TimeOfUse)
+	}
+
+	return s.FixedCharge + charge/float64(days), nil
+}
+
+// season returns the season that covers a given month
+func (s Schedule) season(month time.Month) (Season, bool) {
+	m := int(month)
+	for _, season := range s.Seasons {
+		if season.StartMonth <= season.EndMonth {
+			if m >= season.StartMonth && m <= season.EndMonth {
+				return season, true
+			}
+		} else if m >= season.StartMonth || m <= season.EndMonth {
+			// season wraps around the end of the year
+			return season, true
+		}
+	}
+	return Season{}, false
+}
+
+// tieredCharge charges energy against each tier in order
+func tieredCharge(tiers []Tier, energy float64) float64 {
+	if len(tiers) == 0 {
+		return energy * DefaultRate
+	}
+
+	charge := 0.0
+	prev := 0.0
+	for _, tier := range tiers {
+		if tier.UpTo == 0 || energy <= tier.UpTo {
+			return charge + (energy-prev)*tier.Rate
+		}
+		charge += (tier.UpTo - prev) * tier.Rate
+		prev = tier.UpTo
+	}
+	// energy exceeds the last bounded tier, bill the rest at the last tier's rate
+	return charge + (energy-prev)*tiers[len(tiers)-1].Rate
+}
+
+// validate checks that the hours of the schedule's time of use windows are in [0, 24]
+func (s Schedule) validate() error {
+	for _, season := range s.Seasons {
+		for _, window := range season.TimeOfUse {
+			if window.StartHour < 0 || window.StartHour > 24 || window.EndHour < 0 || window.EndHour > 24 {
+				return errors.New("time of use window " + window.Name + " must start and end between hours 0 and 24, quitting")
+			}
+		}
+	}
+	return nil
+}
+
+// touFactor returns the average time of use multiplier over a day
+func touFactor(windows []TimeOfUse) float64 {
+	if len(windows) == 0 {
+		return 1
+	}
+
+	var hours [24]float64
+	for i := range hours {
+		hours[i] = 1
+	}
+	for _, window := range windows {
+		if window.StartHour < 0 || window.StartHour > 24 || window.EndHour < 0 || window.EndHour > 24 {
+			continue // invalid windows are rejected when schedules are read
+		}
+		// hour 24 is midnight, so a window from 0 to 24 covers the whole day
+		start := window.StartHour % 24
+		length := (window.EndHour%24 - start + 24) % 24
+		if length == 0 && window.StartHour != window.EndHour {
+			length = 24
+		}
+		for i := 0; i < length; i++ {
+			hours[(start+i)%24] = window.Multiplier
+		}
+	}
+
+	sum := 0.0
+	for _, x := range hours {
+		sum += x
+	}
+	return sum / 24
+}
+
+// readAll reads all schedules stored in the provider's directory
+func (f FileProvider) readAll() ([]Schedule, error) {
+	if f.Dir == "" {
+		return nil, nil // tariff directory not set, only the default schedule is available
+	}
+
+	files, err := filepath.Glob(filepath.Join(f.Dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list tariff directory")
+	}
+
+	var arr []Schedule
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return arr, errors.Wrap(err, "couldn't read tariff file")
+		}
+		var x Schedule
+		err = json.Unmarshal(data, &x)
+		if err != nil {
+			return arr, errors.Wrap(err, "couldn't unmarshal tariff file "+file)
+		}
+		if x.Name == "" {
+			x.Name = strings.TrimSuffix(filepath.Base(file), ".json")
+		}
+		err = x.validate()
+		if err != nil {
+			return arr, errors.Wrap(err, "invalid tariff file "+file)
+		}
+		arr = append(arr, x)
+	}
+	return arr, nil
+}
+
+// Lookup returns the schedule for a state in a country. If there is no schedule for the state, the
+// country wide schedule is returned and if that doesn't exist, the default schedule is returned.
+func (f FileProvider) Lookup(state string, country string) (Schedule, error) {
+	schedules, err := f.readAll()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	var countryWide, platform *Schedule
+	for i, x := range schedules {
+		if !strings.EqualFold(x.Country, country) {
+			if x.Country == "" && x.State == "" {
+				platform = &schedules[i]
+			}
+			continue
+		}
+		if x.State != "" && strings.EqualFold(x.State, state) {
+			return x, nil
+		}
+		if x.State == "" {
+			countryWide = &schedules[i]
+		}
+	}
+
+	if countryWide != nil {
+		return *countryWide, nil
+	}
+	if platform != nil {
+		return *platform, nil
+	}
+	return DefaultSchedule(), nil
+}
+
+// Named returns the schedule with the given name
+func (f FileProvider) Named(name string) (Schedule, error) {
+	schedules, err := f.readAll()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	for _, x := range schedules {
+		if x.Name == name {
+			return x, nil
+		}
+	}
+
+	if name == "default" {
+		return DefaultSchedule(), nil
+	}
+	return Schedule{}, errors.New("tariff schedule " + name + " not found")
+}
@@ -0,0 +1,86 @@
+// +build all travis
+
+package oracle
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func floatEq(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestScheduleBill(t *testing.T) {
+	var x Schedule
+	x.FixedCharge = 5
+	x.Seasons = []Season{
+		{Name: "summer", StartMonth: 5, EndMonth: 10, Tiers: []Tier{{UpTo: 100, Rate: 0.1}, {Rate: 0.2}}},
+		{Name: "winter", StartMonth: 11, EndMonth: 4, Tiers: []Tier{{Rate: 0.1}},
+			TimeOfUse: []TimeOfUse{{Name: "peak", StartHour: 18, EndHour: 6, Multiplier: 2}}},
+	}
+
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	bill, err := x.Bill(150, start, start.AddDate(0, 0, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !floatEq(bill, 5+100*0.1+50*0.2) {
+		t.Fatalf("tiered bill doesn't match, got: %f", bill)
+	}
+
+	start = time.Date(2019, time.December, 1, 0, 0, 0, 0, time.UTC)
+	bill, err = x.Bill(100, start, start.AddDate(0, 0, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !floatEq(bill, 5+100*0.1*1.5) {
+		t.Fatalf("time of use bill doesn't match, got: %f", bill)
+	}
+
+	_, err = x.Bill(100, start, start)
+	if err == nil {
+		t.Fatalf("empty metering period accepted")
+	}
+
+	_, err = x.Bill(-1, start, start.AddDate(0, 0, 1))
+	if err == nil {
+		t.Fatalf("negative energy accepted")
+	}
+}
+
+func TestTouFactor(t *testing.T) {
+	// a window until midnight ends at hour 24
+	factor := touFactor([]TimeOfUse{{Name: "evening", StartHour: 18, EndHour: 24, Multiplier: 2}})
+	if !floatEq(factor, (18+6*2)/24.0) {
+		t.Fatalf("window until midnight doesn't match, got: %f", factor)
+	}
+
+	factor = touFactor([]TimeOfUse{{Name: "all", StartHour: 0, EndHour: 24, Multiplier: 3}})
+	if !floatEq(factor, 3) {
+		t.Fatalf("window over the whole day doesn't match, got: %f", factor)
+	}
+
+	factor = touFactor([]TimeOfUse{{Name: "bad", StartHour: 20, EndHour: 25, Multiplier: 3}})
+	if !floatEq(factor, 1) {
+		t.Fatalf("invalid window applied, got: %f", factor)
+	}
+
+	var x Schedule
+	x.Seasons = []Season{{TimeOfUse: []TimeOfUse{{Name: "bad", StartHour: -1, EndHour: 6}}}}
+	if x.validate() == nil {
+		t.Fatalf("window with a negative hour accepted")
+	}
+}
+
+func TestDefaultSchedule(t *testing.T) {
+	start := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+	bill, err := DefaultSchedule().Bill(100, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !floatEq(bill, 100*DefaultRate) {
+		t.Fatalf("default bill doesn't match, got: %f", bill)
+	}
+}
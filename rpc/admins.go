@@ -8,15 +8,36 @@ import (
 	erpc "github.com/Varunram/essentials/rpc"
 	utils "github.com/Varunram/essentials/utils"
 	core "github.com/YaleOpenLab/opensolar/core"
+	notif "github.com/YaleOpenLab/opensolar/notif"
 	openx "github.com/YaleOpenLab/openx/database"
 )
 
 func setupAdminHandlers() {
 	flagProject()
+	getJobs()
+	pauseJob()
+	resumeJob()
+	triggerJob()
+	cancelProject()
+	demoteProject()
+	getNotifications()
+	retryNotification()
+	resetDeviceKey()
+	setTariffOverride()
 }
 
 var AdminRPC = map[int][]string{
-	1: []string{"/admin/flag", "GET", "projIndex"}, // GET
+	1:  []string{"/admin/flag", "GET", "projIndex"},                              // GET
+	2:  []string{"/admin/jobs", "GET"},                                           // GET
+	3:  []string{"/admin/jobs/pause", "GET", "index"},                            // GET
+	4:  []string{"/admin/jobs/resume", "GET", "index"},                           // GET
+	5:  []string{"/admin/jobs/trigger", "GET", "index"},                          // GET
+	6:  []string{"/admin/project/cancel", "GET", "projIndex", "reason"},          // GET
+	7:  []string{"/admin/project/demote", "GET", "projIndex", "stage", "reason"}, // GET
+	8:  []string{"/admin/notifications", "GET"},                                  // GET, optionally filtered by status
+	9:  []string{"/admin/notifications/retry", "GET", "index"},                   // GET
+	10: []string{"/admin/devicekey/reset", "GET", "recpIndex"},                   // GET
+	11: []string{"/admin/project/tariff", "GET", "projIndex"},                    // GET, tariff is optional and removes the override if not passed
 }
 
 func adminValidateHelper(w http.ResponseWriter, r *http.Request) (openx.User, error) {
@@ -70,3 +91,305 @@ func flagProject() {
 		erpc.ResponseHandler(w, erpc.StatusOK)
 	})
 }
+
+// getJobs lists all jobs known to the scheduler
+func getJobs() {
+	http.HandleFunc(AdminRPC[2][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[2][2:], AdminRPC[2][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		jobs, err := core.RetrieveAllJobs()
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, jobs)
+	})
+}
+
+// setJobPaused pauses or resumes the job whose index is passed
+func setJobPaused(w http.ResponseWriter, r *http.Request, options []string, method string, pause bool) {
+	err := checkReqdParams(w, r, options, method)
+	if err != nil {
+		return
+	}
+
+	_, err = adminValidateHelper(w, r)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	index, err := utils.ToInt(r.URL.Query()["index"][0])
+	if err != nil {
+		log.Println(err)
+		erpc.ResponseHandler(w, erpc.StatusBadRequest)
+		return
+	}
+
+	err = core.PauseJob(index, pause)
+	if err != nil {
+		log.Println(err)
+		erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+		return
+	}
+
+	erpc.ResponseHandler(w, erpc.StatusOK)
+}
+
+// pauseJob pauses a job
+func pauseJob() {
+	http.HandleFunc(AdminRPC[3][0], func(w http.ResponseWriter, r *http.Request) {
+		setJobPaused(w, r, AdminRPC[3][2:], AdminRPC[3][1], true)
+	})
+}
+
+// resumeJob resumes a paused job
+func resumeJob() {
+	http.HandleFunc(AdminRPC[4][0], func(w http.ResponseWriter, r *http.Request) {
+		setJobPaused(w, r, AdminRPC[4][2:], AdminRPC[4][1], false)
+	})
+}
+
+// triggerJob runs a job immediately and returns the job after the run
+func triggerJob() {
+	http.HandleFunc(AdminRPC[5][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[5][2:], AdminRPC[5][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		job, err := core.TriggerJob(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, job)
+	})
+}
+
+// cancelProject cancels a project that hasn't been funded yet so that investors can claim refunds
+func cancelProject() {
+	http.HandleFunc(AdminRPC[6][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[6][2:], AdminRPC[6][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		err = core.CancelProject(projIndex, r.URL.Query()["reason"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
+
+// demoteProject moves a project that hasn't been funded yet back to an earlier stage
+func demoteProject() {
+	http.HandleFunc(AdminRPC[7][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[7][2:], AdminRPC[7][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		stage, err := utils.ToInt(r.URL.Query()["stage"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		err = core.DemoteStage(projIndex, stage, r.URL.Query()["reason"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
+
+// getNotifications lists the notifications in the outbox along with their delivery status
+func getNotifications() {
+	http.HandleFunc(AdminRPC[8][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[8][2:], AdminRPC[8][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		msgs, err := notif.RetrieveMessages(r.URL.Query().Get("status"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, msgs)
+	})
+}
+
+// retryNotification retries the delivery of a notification that couldn't be delivered
+func retryNotification() {
+	http.HandleFunc(AdminRPC[9][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[9][2:], AdminRPC[9][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		msg, err := notif.RetryMessage(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, msg)
+	})
+}
+
+// resetDeviceKey removes the device key registered by a recipient's teller so a reinstalled teller can register
+// a new one
+func resetDeviceKey() {
+	http.HandleFunc(AdminRPC[10][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[10][2:], AdminRPC[10][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		recpIndex, err := utils.ToInt(r.URL.Query()["recpIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		recipient, err := core.RetrieveRecipient(recpIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		err = recipient.ResetDeviceKey()
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
+
+// setTariffOverride sets the tariff schedule a project's recipient is billed with instead of the one for the
+// project's region
+func setTariffOverride() {
+	http.HandleFunc(AdminRPC[11][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, AdminRPC[11][2:], AdminRPC[11][1])
+		if err != nil {
+			return
+		}
+
+		_, err = adminValidateHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		err = core.SetTariffOverride(projIndex, r.URL.Query().Get("tariff"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
@@ -24,18 +24,24 @@ func setupEntityRPCs() {
 	registerEntity()
 	contractorDashboard()
 	developerDashboard()
+	commitAuctionBid()
+	revealAuctionBid()
+	placeAuctionBid()
 }
 
 var EntityRPC = map[int][]string{
-	1: []string{"/entity/validate", "GET"},                                                                  // GET
-	2: []string{"/entity/stage0", "GET"},                                                                    // GET
-	3: []string{"/entity/stage1", "GET"},                                                                    // GET
-	4: []string{"/entity/stage2", "GET"},                                                                    // GET
-	5: []string{"/entity/addcollateral", "POST", "amount", "collateral"},                                    // POST
-	6: []string{"/entity/proposeproject/opensolar", "POST", "projIndex", "fee"},                             // POST
-	7: []string{"/entity/register", "POST", "name", "username", "pwhash", "token", "seedpwd", "entityType"}, // POST
-	8: []string{"/entity/contractor/dashboard", "GET"},                                                      // GET
-	9: []string{"/entity/developer/dashboard", "GET"},                                                       // GET
+	1:  []string{"/entity/validate", "GET"},                                                                  // GET
+	2:  []string{"/entity/stage0", "GET"},                                                                    // GET
+	3:  []string{"/entity/stage1", "GET"},                                                                    // GET
+	4:  []string{"/entity/stage2", "GET"},                                                                    // GET
+	5:  []string{"/entity/addcollateral", "POST", "projIndex", "amount", "seedpwd"},                          // POST
+	6:  []string{"/entity/proposeproject/opensolar", "POST", "projIndex", "fee"},                             // POST, contractors also pass collateral and seedpwd
+	7:  []string{"/entity/register", "POST", "name", "username", "pwhash", "token", "seedpwd", "entityType"}, // POST
+	8:  []string{"/entity/contractor/dashboard", "GET"},                                                      // GET
+	9:  []string{"/entity/developer/dashboard", "GET"},                                                       // GET
+	10: []string{"/entity/auction/commit", "POST", "index", "commitment"},                                    // POST
+	11: []string{"/entity/auction/reveal", "POST", "index", "price", "salt"},                                 // POST
+	12: []string{"/entity/auction/bid", "POST", "index"},                                                     // POST, english auctions also pass price
 }
 
 // entityValidateHelper is a helper that helps validate an entity
@@ -141,7 +147,7 @@ func getStage2Contracts() {
 	})
 }
 
-// addCollateral is a route that a contractor can use to add collateral
+// addCollateral is a route that a contractor can use to add collateral to a project it has proposed
 func addCollateral() {
 	http.HandleFunc(EntityRPC[5][0], func(w http.ResponseWriter, r *http.Request) {
 		err := erpc.CheckPost(w, r)
@@ -161,24 +167,29 @@ func addCollateral() {
 			return
 		}
 
-		amountx := r.FormValue("amount")
-		collateral := r.FormValue("collateral")
+		projIndex, err := utils.ToInt(r.FormValue("projIndex"))
+		if err != nil {
+			log.Println("Error while converting string to int", err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
 
-		amount, err := utils.ToFloat(amountx)
+		amount, err := utils.ToFloat(r.FormValue("amount"))
 		if err != nil {
 			log.Println("Error while converting string to float", err)
 			erpc.ResponseHandler(w, erpc.StatusBadRequest)
 			return
 		}
 
-		err = prepEntity.AddCollateral(amount, collateral)
+		// collateral is held in the project's collateral escrow like the collateral posted with the proposal
+		project, err := core.PostCollateral(projIndex, prepEntity.U.Index, amount, r.FormValue("seedpwd"))
 		if err != nil {
 			log.Println("Error while adding collateral", err)
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
 			return
 		}
 
-		erpc.ResponseHandler(w, erpc.StatusOK)
+		erpc.MarshalSend(w, project)
 	})
 }
 
@@ -225,12 +236,29 @@ func proposeOpensolarProject() {
 			erpc.ResponseHandler(w, erpc.StatusBadRequest)
 		}
 
-		x.TotalValue += fee
-		x.OriginatorFee = fee
-		x.OriginatorIndex = prepEntity.U.Index
-		x.Stage = 2
+		if prepEntity.Contractor {
+			// contractors back their proposals with collateral held in escrow for the project
+			collateral, err := utils.ToFloat(r.FormValue("collateral"))
+			if err != nil || r.FormValue("seedpwd") == "" {
+				log.Println("contractors need to post collateral with their proposals")
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
 
-		err = x.Save()
+			x, err = core.PostCollateral(projIndex, prepEntity.U.Index, collateral, r.FormValue("seedpwd"))
+			if err != nil {
+				log.Println("couldn't post collateral", err)
+				erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+				return
+			}
+		}
+
+		err = x.Update("project originated", map[string]interface{}{
+			"TotalValue":      x.TotalValue + fee,
+			"OriginatorFee":   fee,
+			"OriginatorIndex": prepEntity.U.Index,
+			"Stage":           2,
+		})
 		if err != nil {
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
 			return
@@ -500,3 +528,139 @@ func developerDashboard() {
 		erpc.MarshalSend(w, ret)
 	})
 }
+
+// auctionContractIndex parses the optional index of the contract a contractor proposed for the auctioned project
+func auctionContractIndex(r *http.Request) (int, error) {
+	if r.FormValue("contractIndex") == "" {
+		return 0, nil
+	}
+	return utils.ToInt(r.FormValue("contractIndex"))
+}
+
+// commitAuctionBid commits a contractor to the hash of a price in a sealed auction
+func commitAuctionBid() {
+	http.HandleFunc(EntityRPC[10][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		prepEntity, err := entityValidateHelper(w, r, EntityRPC[10][2:], EntityRPC[10][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		contractIndex, err := auctionContractIndex(r)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		bid, err := core.CommitBid(index, prepEntity.U.Index, contractIndex, r.FormValue("commitment"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, bid)
+	})
+}
+
+// revealAuctionBid reveals the price a contractor committed to in a sealed auction
+func revealAuctionBid() {
+	http.HandleFunc(EntityRPC[11][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		prepEntity, err := entityValidateHelper(w, r, EntityRPC[11][2:], EntityRPC[11][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		price, err := utils.ToFloat(r.FormValue("price"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		bid, err := core.RevealBid(index, prepEntity.U.Index, price, r.FormValue("salt"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, bid)
+	})
+}
+
+// placeAuctionBid places an open bid in an english auction or accepts the price offered by a dutch auction
+func placeAuctionBid() {
+	http.HandleFunc(EntityRPC[12][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		prepEntity, err := entityValidateHelper(w, r, EntityRPC[12][2:], EntityRPC[12][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		// dutch auctions accept the price currently offered if no price is passed
+		var price float64
+		if r.FormValue("price") != "" {
+			price, err = utils.ToFloat(r.FormValue("price"))
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
+		}
+
+		contractIndex, err := auctionContractIndex(r)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		bid, err := core.PlaceBid(index, prepEntity.U.Index, contractIndex, price)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, bid)
+	})
+}
@@ -6,17 +6,19 @@ import (
 
 	erpc "github.com/Varunram/essentials/rpc"
 	utils "github.com/Varunram/essentials/utils"
-	// core "github.com/YaleOpenLab/opensolar/core"
+	core "github.com/YaleOpenLab/opensolar/core"
 )
 
 func setupGuarantorRPCs() {
 	depositXLMGuarantor()
 	depositAssetGuarantor()
+	guaDelinquency()
 }
 
 var GuaRPC = map[int][]string{
 	1: []string{"/guarantor/deposit/xlm", "POST", "amount", "projIndex", "seedpwd"},                // POST
 	2: []string{"/guarantor/deposit/asset", "POST", "amount", "projIndex", "seedpwd", "assetCode"}, // POST
+	3: []string{"/guarantor/delinquency", "GET", "projIndex"},                                      // GET
 }
 
 // depositXLMGuarantor is called by a guarantor when they wish to refill the escrow account with xlm
@@ -97,3 +99,40 @@ func depositAssetGuarantor() {
 		erpc.ResponseHandler(w, erpc.StatusOK)
 	})
 }
+
+// guaDelinquency returns the delinquency history of a project the guarantor guarantees
+func guaDelinquency() {
+	http.HandleFunc(GuaRPC[3][0], func(w http.ResponseWriter, r *http.Request) {
+		prepEntity, err := entityValidateHelper(w, r, GuaRPC[3][2:], GuaRPC[3][1])
+		if err != nil {
+			log.Println("Error while validating entity", err)
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.RetrieveProject(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		if !prepEntity.Guarantor || project.GuarantorIndex != prepEntity.U.Index {
+			log.Println("entity isn't the guarantor of project: ", projIndex)
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		var ret DelinquencyReturn
+		ret.State = project.Delinquency
+		ret.History = project.DelinquencyHistory
+		erpc.MarshalSend(w, ret)
+	})
+}
@@ -30,6 +30,14 @@ func setupInvestorRPCs() {
 	invDashboard()
 	setCompanyBool()
 	setCompany()
+	invDelinquency()
+	invPortfolio()
+	placeOrder()
+	cancelOrder()
+	getOrderBook()
+	getInvOrders()
+	getTrades()
+	claimRefund()
 }
 
 // InvRPC contains a list of all investor related endpoints
@@ -46,6 +54,14 @@ var InvRPC = map[int][]string{
 	10: []string{"/investor/company/set", "POST"},                                                     // POST
 	11: []string{"/investor/company/details", "POST", "companytype",
 		"name", "legalname", "address", "country", "city", "zipcode", "role"}, // POST
+	12: []string{"/investor/delinquency", "GET", "projIndex"},                                         // GET
+	13: []string{"/investor/portfolio", "GET"},                                                        // GET
+	14: []string{"/investor/market/order", "POST", "projIndex", "side", "amount", "price", "seedpwd"}, // POST
+	15: []string{"/investor/market/cancel", "POST", "orderIndex"},                                     // POST
+	16: []string{"/investor/market/book", "GET", "projIndex"},                                         // GET
+	17: []string{"/investor/market/orders", "GET"},                                                    // GET
+	18: []string{"/investor/market/trades", "GET", "projIndex"},                                       // GET
+	19: []string{"/investor/refund", "POST", "projIndex", "seedpwd"},                                  // POST
 }
 
 // InvValidateHelper is a helper used to validate an investor on the platform
@@ -574,3 +590,252 @@ func setCompany() {
 		erpc.ResponseHandler(w, erpc.StatusOK)
 	})
 }
+
+// DelinquencyReturn is the delinquency state of a project along with its transition history
+type DelinquencyReturn struct {
+	State   string
+	History []core.DelinquencyTransition
+}
+
+// invDelinquency returns the delinquency history of a project the investor has invested in
+func invDelinquency() {
+	http.HandleFunc(InvRPC[12][0], func(w http.ResponseWriter, r *http.Request) {
+		prepInvestor, err := InvValidateHelper(w, r, InvRPC[12][2:], InvRPC[12][1])
+		if err != nil {
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.RetrieveProject(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		invested := false
+		for _, index := range project.InvestorIndices {
+			if index == prepInvestor.U.Index {
+				invested = true
+				break
+			}
+		}
+		if !invested {
+			log.Println("investor hasn't invested in project: ", projIndex)
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		var ret DelinquencyReturn
+		ret.State = project.Delinquency
+		ret.History = project.DelinquencyHistory
+		erpc.MarshalSend(w, ret)
+	})
+}
+
+// invPortfolio returns the investor's realized returns, outstanding principal, irr and delinquency exposure
+// across all the projects they've invested in
+func invPortfolio() {
+	http.HandleFunc(InvRPC[13][0], func(w http.ResponseWriter, r *http.Request) {
+		prepInvestor, err := InvValidateHelper(w, r, InvRPC[13][2:], InvRPC[13][1])
+		if err != nil {
+			return
+		}
+
+		portfolio, err := core.RetrievePortfolio(prepInvestor.U.Index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, portfolio)
+	})
+}
+
+// placeOrder places a bid or ask for the investor assets of a project on the secondary market
+func placeOrder() {
+	http.HandleFunc(InvRPC[14][0], func(w http.ResponseWriter, r *http.Request) {
+		prepInvestor, err := InvValidateHelper(w, r, InvRPC[14][2:], InvRPC[14][1])
+		if err != nil {
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.FormValue("projIndex"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		amount, err := utils.ToFloat(r.FormValue("amount"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		price, err := utils.ToFloat(r.FormValue("price"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		order, err := core.PlaceOrder(prepInvestor.U.Index, projIndex, r.FormValue("side"), amount, price, r.FormValue("seedpwd"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, order)
+	})
+}
+
+// cancelOrder cancels an open order placed by the investor on the secondary market
+func cancelOrder() {
+	http.HandleFunc(InvRPC[15][0], func(w http.ResponseWriter, r *http.Request) {
+		prepInvestor, err := InvValidateHelper(w, r, InvRPC[15][2:], InvRPC[15][1])
+		if err != nil {
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		orderIndex, err := utils.ToInt(r.FormValue("orderIndex"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		order, err := core.CancelOrder(prepInvestor.U.Index, orderIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, order)
+	})
+}
+
+// getOrderBook gets the open bids and asks for the investor assets of a project
+func getOrderBook() {
+	http.HandleFunc(InvRPC[16][0], func(w http.ResponseWriter, r *http.Request) {
+		_, err := InvValidateHelper(w, r, InvRPC[16][2:], InvRPC[16][1])
+		if err != nil {
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		book, err := core.RetrieveOrderBook(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, book)
+	})
+}
+
+// getInvOrders gets all the orders the investor has placed on the secondary market
+func getInvOrders() {
+	http.HandleFunc(InvRPC[17][0], func(w http.ResponseWriter, r *http.Request) {
+		prepInvestor, err := InvValidateHelper(w, r, InvRPC[17][2:], InvRPC[17][1])
+		if err != nil {
+			return
+		}
+
+		orders, err := core.RetrieveInvestorOrders(prepInvestor.U.Index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, orders)
+	})
+}
+
+// getTrades gets the secondary market trades of a project's investor assets
+func getTrades() {
+	http.HandleFunc(InvRPC[18][0], func(w http.ResponseWriter, r *http.Request) {
+		_, err := InvValidateHelper(w, r, InvRPC[18][2:], InvRPC[18][1])
+		if err != nil {
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		trades, err := core.RetrieveProjectTrades(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, trades)
+	})
+}
+
+// claimRefund refunds the investor's investments in a project that was cancelled or demoted before being funded
+func claimRefund() {
+	http.HandleFunc(InvRPC[19][0], func(w http.ResponseWriter, r *http.Request) {
+		prepInvestor, err := InvValidateHelper(w, r, InvRPC[19][2:], InvRPC[19][1])
+		if err != nil {
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.FormValue("projIndex"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		refund, err := core.RefundInvestor(projIndex, prepInvestor.U.Index, r.FormValue("seedpwd"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, refund)
+	})
+}
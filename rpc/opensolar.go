@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 
@@ -20,17 +21,39 @@ func setupProjectRPCs() {
 	addContractHash()
 	sendTellerShutdownEmail()
 	sendTellerFailedPaybackEmail()
+	getProjectEvents()
+	replayProject()
+	getProjectSchedule()
+	getDistributionPlan()
+	getProjectEnergy()
+	getProjectEnergyAggregates()
+	getProjectBreaches()
+	getProjectAuctions()
+	leaveFeedback()
+	setWaterfall()
+	setSeniorDebt()
 }
 
 var ProjectRPC = map[int][]string{
-	1: []string{"/project/insert", "POST", "PanelSize", "TotalValue", "Location", "Metadata", "Stage"}, // POST
-	2: []string{"/project/all", "GET"},                                                                 // GET
-	3: []string{"/project/get", "GET", "index"},                                                        // GET
-	4: []string{"/projects", "GET", "stage"},                                                           // GET
-	5: []string{"/utils/addhash", "GET", "projIndex", "choice", "choicestr"},                           // GET
-	6: []string{"/tellershutdown", "GET", "projIndex", "deviceId", "tx1", "tx2"},                       // GET
-	7: []string{"/tellerpayback", "GET", "deviceId", "projIndex"},                                      // GET
-	8: []string{"/project/get/dashboard", "GET", "index"},                                              // GET
+	1:  []string{"/project/insert", "POST", "PanelSize", "TotalValue", "Location", "Metadata", "Stage"}, // POST
+	2:  []string{"/project/all", "GET"},                                                                 // GET
+	3:  []string{"/project/get", "GET", "index"},                                                        // GET
+	4:  []string{"/projects", "GET", "stage"},                                                           // GET
+	5:  []string{"/utils/addhash", "GET", "projIndex", "choice", "choicestr"},                           // GET
+	6:  []string{"/tellershutdown", "GET", "projIndex", "deviceId", "tx1", "tx2"},                       // GET
+	7:  []string{"/tellerpayback", "GET", "deviceId", "projIndex"},                                      // GET
+	8:  []string{"/project/get/dashboard", "GET", "index"},                                              // GET
+	9:  []string{"/project/events", "GET", "index"},                                                     // GET
+	10: []string{"/project/replay", "GET", "index", "until"},                                            // GET
+	11: []string{"/project/schedule", "GET", "index"},                                                   // GET
+	12: []string{"/project/waterfall", "GET", "index", "amount"},                                        // GET
+	13: []string{"/project/energy", "GET", "index", "start", "end"},                                     // GET
+	14: []string{"/project/energy/aggregate", "GET", "index", "start", "end", "interval"},               // GET
+	15: []string{"/project/breaches", "GET", "index"},                                                   // GET
+	16: []string{"/project/auctions", "GET", "index"},                                                   // GET
+	17: []string{"/project/feedback", "POST", "index", "stage", "to", "rating"},                         // POST
+	18: []string{"/project/waterfall/set", "POST", "index", "waterfall"},                                // POST
+	19: []string{"/project/seniordebt/set", "POST", "index", "lenders", "rate"},                         // POST
 }
 
 // insertProject inserts a project into the database.
@@ -216,26 +239,27 @@ func addContractHash() {
 		// lets have a fixed set of strings that we can map on here so we have a single endpoint for storing all these hashes
 
 		// TODO: read from the pending docs map here and store this only if we need to.
+		var store bool
 		switch choice {
 		case "omh":
 			if project.Stage == 0 {
-				project.StageData = append(project.StageData, hashString)
+				store = true
 			}
 		case "cch":
 			if project.Stage == 2 {
-				project.StageData = append(project.StageData, hashString)
+				store = true
 			}
 		case "ipch":
 			if project.Stage == 4 {
-				project.StageData = append(project.StageData, hashString)
+				store = true
 			}
 		case "rpch":
 			if project.Stage == 4 {
-				project.StageData = append(project.StageData, hashString)
+				store = true
 			}
 		case "ssh":
 			if project.Stage == 5 {
-				project.StageData = append(project.StageData, hashString)
+				store = true
 			}
 		default:
 			log.Println("invalid choice passed, quitting!")
@@ -243,7 +267,11 @@ func addContractHash() {
 			return
 		}
 
-		err = project.Save()
+		if store {
+			err = project.Update("stage data added", map[string]interface{}{
+				"StageData": append(append([]string(nil), project.StageData...), hashString),
+			})
+		}
 		if err != nil {
 			log.Println("error while saving project to db, quitting!")
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
@@ -273,6 +301,15 @@ func sendTellerShutdownEmail() {
 		tx1 := r.URL.Query()["tx1"][0]
 		tx2 := r.URL.Query()["tx2"][0]
 		notif.SendTellerShutdownEmail(prepUser.Email, projIndex, deviceId, tx1, tx2)
+
+		index, err := utils.ToInt(projIndex)
+		if err == nil {
+			err = core.FireWebhook(core.WebhookTellerShutdown, index, map[string]string{"DeviceId": deviceId,
+				"Tx1": tx1, "Tx2": tx2})
+		}
+		if err != nil {
+			log.Println("couldn't fire teller shutdown webhooks", err)
+		}
 		erpc.ResponseHandler(w, erpc.StatusOK)
 	})
 }
@@ -325,3 +362,431 @@ func getProjectDashboard() {
 		erpc.MarshalSend(w, project)
 	})
 }
+
+// getProjectEvents gets the event log of a specific project
+func getProjectEvents() {
+	http.HandleFunc(ProjectRPC[9][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[9][2:], ProjectRPC[9][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		events, err := core.RetrieveProjectEvents(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, events)
+	})
+}
+
+// replayProject rebuilds a project from its event log as it was at a given unix time. Passing
+// an until of 0 replays the entire event log
+func replayProject() {
+	http.HandleFunc(ProjectRPC[10][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[10][2:], ProjectRPC[10][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		until, err := utils.ToInt(r.URL.Query()["until"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.ReplayProject(index, int64(until))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, project)
+	})
+}
+
+// getProjectSchedule gets the amortization schedule of a project. If the project hasn't been funded yet,
+// the schedule it would have if it were funded now is returned
+func getProjectSchedule() {
+	http.HandleFunc(ProjectRPC[11][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[11][2:], ProjectRPC[11][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.RetrieveProject(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		if len(project.Schedule) != 0 {
+			erpc.MarshalSend(w, project.Schedule)
+			return
+		}
+
+		schedule, err := project.GenerateSchedule(utils.Unix())
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, schedule)
+	})
+}
+
+// getDistributionPlan gets the plan according to which a payback of the given amount would be distributed
+// to the stakeholders of a project
+func getDistributionPlan() {
+	http.HandleFunc(ProjectRPC[12][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[12][2:], ProjectRPC[12][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		amount, err := utils.ToFloat(r.URL.Query()["amount"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		plan, err := core.PlanPayments(index, amount)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, plan)
+	})
+}
+
+// energyRangeHelper parses the project index and the time range of an energy query
+func energyRangeHelper(w http.ResponseWriter, r *http.Request) (int, int64, int64, error) {
+	index, err := utils.ToInt(r.URL.Query()["index"][0])
+	if err != nil {
+		erpc.ResponseHandler(w, erpc.StatusBadRequest)
+		return index, 0, 0, err
+	}
+
+	start, err := utils.ToInt(r.URL.Query()["start"][0])
+	if err != nil {
+		erpc.ResponseHandler(w, erpc.StatusBadRequest)
+		return index, 0, 0, err
+	}
+
+	end, err := utils.ToInt(r.URL.Query()["end"][0])
+	if err != nil {
+		erpc.ResponseHandler(w, erpc.StatusBadRequest)
+		return index, 0, 0, err
+	}
+
+	return index, int64(start), int64(end), nil
+}
+
+// getProjectEnergy gets the energy readings of a project between start and end (unix times). Readings can
+// be limited to a single device by passing its deviceId
+func getProjectEnergy() {
+	http.HandleFunc(ProjectRPC[13][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[13][2:], ProjectRPC[13][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, start, end, err := energyRangeHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		readings, err := core.RetrieveReadings(index, r.URL.Query().Get("deviceId"), start, end)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, readings)
+	})
+}
+
+// getProjectEnergyAggregates gets the energy generated, consumed and exported by a project in each period of
+// interval seconds between start and end. An interval of zero aggregates the entire range
+func getProjectEnergyAggregates() {
+	http.HandleFunc(ProjectRPC[14][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[14][2:], ProjectRPC[14][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, start, end, err := energyRangeHelper(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		interval, err := utils.ToInt(r.URL.Query()["interval"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		readings, err := core.RetrieveReadings(index, r.URL.Query().Get("deviceId"), start, end)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		aggregates, err := core.AggregateReadings(readings, start, end, int64(interval))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, aggregates)
+	})
+}
+
+// getProjectBreaches returns the breaches of stage breach rules by a project along with the rules of its current stage
+func getProjectBreaches() {
+	http.HandleFunc(ProjectRPC[15][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[15][2:], ProjectRPC[15][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.RetrieveProject(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		stage, err := core.RetrieveStage(project.Stage)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		var x struct {
+			Rules    []core.BreachRule
+			Breaches []core.Breach
+		}
+		x.Rules = stage.BreachRules
+		x.Breaches = project.Breaches
+		erpc.MarshalSend(w, x)
+	})
+}
+
+// getProjectAuctions returns the auctions among contractors for a project along with their bids. The prices of
+// sealed bids are hidden until an auction is closed
+func getProjectAuctions() {
+	http.HandleFunc(ProjectRPC[16][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, ProjectRPC[16][2:], ProjectRPC[16][1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		auctions, err := core.RetrieveProjectAuctions(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		type auctionBids struct {
+			Auction core.Auction
+			Bids    []core.Bid
+		}
+
+		var x []auctionBids
+		for _, auction := range auctions {
+			bids, err := core.RetrieveAuctionBids(auction.Index)
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+				return
+			}
+			x = append(x, auctionBids{Auction: auction, Bids: auction.VisibleBids(bids)})
+		}
+		erpc.MarshalSend(w, x)
+	})
+}
+
+// leaveFeedback lets a party to a project rate another party for a stage milestone the project has passed. The
+// rated party's reputation is recomputed and can be seen through the public reputation route
+func leaveFeedback() {
+	http.HandleFunc(ProjectRPC[17][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		user, err := userValidateHelper(w, r, ProjectRPC[17][2:], ProjectRPC[17][1])
+		if err != nil {
+			return
+		}
+
+		var params []int
+		for _, param := range []string{"index", "stage", "to", "rating"} {
+			x, err := utils.ToInt(r.FormValue(param))
+			if err != nil {
+				log.Println("passed ", param, " not an integer, quitting!")
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
+			params = append(params, x)
+		}
+
+		feedback, err := core.LeaveFeedback(params[0], params[1], user.Index, params[2], params[3], r.FormValue("content"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, feedback)
+	})
+}
+
+// setWaterfall sets the waterfall paybacks towards a project are distributed over. waterfall is a json encoded list
+// of tiers, an empty list restores the default waterfall
+func setWaterfall() {
+	http.HandleFunc(ProjectRPC[18][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		_, err = userValidateHelper(w, r, ProjectRPC[18][2:], ProjectRPC[18][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println("passed index not an integer, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		var tiers []core.WaterfallTier
+		err = json.Unmarshal([]byte(r.FormValue("waterfall")), &tiers)
+		if err != nil {
+			log.Println("couldn't unmarshal waterfall: ", err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		err = core.SetWaterfall(index, tiers)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
+
+// setSeniorDebt sets the senior debt a project has taken on. lenders is a json encoded publickey: amount map and rate
+// the interest rate the debt is repaid with
+func setSeniorDebt() {
+	http.HandleFunc(ProjectRPC[19][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		_, err = userValidateHelper(w, r, ProjectRPC[19][2:], ProjectRPC[19][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println("passed index not an integer, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		rate, err := utils.ToFloat(r.FormValue("rate"))
+		if err != nil {
+			log.Println("passed rate not a float, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		var lenders map[string]float64
+		err = json.Unmarshal([]byte(r.FormValue("lenders")), &lenders)
+		if err != nil {
+			log.Println("couldn't unmarshal senior lenders: ", err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		err = core.SetSeniorDebt(index, lenders, rate)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
@@ -0,0 +1,329 @@
+package rpc
+
+import (
+	"log"
+	"net/http"
+
+	erpc "github.com/Varunram/essentials/rpc"
+	utils "github.com/Varunram/essentials/utils"
+	openx "github.com/YaleOpenLab/openx/database"
+
+	core "github.com/YaleOpenLab/opensolar/core"
+)
+
+// the roles a caller can hold. Admins are allowed on every route that isn't public
+const (
+	// RoleUser is held by every user that has logged in
+	RoleUser = "user"
+	// RoleRecipient is held by registered recipients
+	RoleRecipient = "recipient"
+	// RoleInvestor is held by registered investors
+	RoleInvestor = "investor"
+	// RoleEntity is held by all registered entities (contractors, developers, originators and guarantors)
+	RoleEntity = "entity"
+	// RoleContractor is held by entities registered as contractors
+	RoleContractor = "contractor"
+	// RoleDeveloper is held by entities registered as developers
+	RoleDeveloper = "developer"
+	// RoleOriginator is held by entities registered as originators
+	RoleOriginator = "originator"
+	// RoleGuarantor is held by entities registered as guarantors
+	RoleGuarantor = "guarantor"
+	// RoleAdmin is held by platform admins
+	RoleAdmin = "admin"
+)
+
+// the relationships a caller can have with the project a route acts on
+const (
+	// RelRecipient is the recipient of the project
+	RelRecipient = "recipientof"
+	// RelInvestor is an investor in the project
+	RelInvestor = "investorin"
+	// RelContractor is the contractor on the project
+	RelContractor = "contractoron"
+	// RelDeveloper is the main developer on the project
+	RelDeveloper = "developeron"
+	// RelOriginator is the originator of the project
+	RelOriginator = "originatorof"
+	// RelGuarantor is the guarantor on the project
+	RelGuarantor = "guarantoron"
+)
+
+// Policy decides who can call a route
+type Policy struct {
+	// Public routes can be called by anyone
+	Public bool
+
+	// Roles are the roles allowed to call the route. The caller needs at least one of them
+	Roles []string
+
+	// Relations are the relationships to the project in ProjectParam allowed to call the route. The caller
+	// needs at least one of them
+	Relations []string
+
+	// ProjectParam is the param that contains the index of the project relations are checked against
+	ProjectParam string
+}
+
+// projectParties are the relationships of everyone who is a party to a project
+var projectParties = []string{RelRecipient, RelInvestor, RelContractor, RelDeveloper, RelOriginator, RelGuarantor}
+
+// Policies maps routes to the policy enforced before the route's handler runs
+var Policies = map[string]Policy{
+	ProjectRPC[1][0]:  {Roles: []string{RoleRecipient, RoleOriginator}},
+	ProjectRPC[2][0]:  {Public: true},
+	ProjectRPC[3][0]:  {Roles: []string{RoleUser}},
+	ProjectRPC[4][0]:  {Roles: []string{RoleUser}},
+	ProjectRPC[5][0]:  {Relations: []string{RelRecipient, RelContractor, RelDeveloper, RelOriginator, RelGuarantor}, ProjectParam: "projIndex"},
+	ProjectRPC[6][0]:  {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	ProjectRPC[7][0]:  {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	ProjectRPC[8][0]:  {Roles: []string{RoleUser}},
+	ProjectRPC[9][0]:  {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[10][0]: {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[11][0]: {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[12][0]: {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[13][0]: {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[14][0]: {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[15][0]: {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[16][0]: {Roles: []string{RoleUser}},
+	ProjectRPC[17][0]: {Relations: projectParties, ProjectParam: "index"},
+	ProjectRPC[18][0]: {Relations: []string{RelOriginator}, ProjectParam: "index"},
+	ProjectRPC[19][0]: {Relations: []string{RelOriginator}, ProjectParam: "index"},
+
+	RecpRPC[1][0]:  {Roles: []string{RoleRecipient}},
+	RecpRPC[2][0]:  {Public: true},
+	RecpRPC[3][0]:  {Roles: []string{RoleRecipient}},
+	RecpRPC[4][0]:  {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[5][0]:  {Roles: []string{RoleRecipient}},
+	RecpRPC[6][0]:  {Roles: []string{RoleRecipient}},
+	RecpRPC[7][0]:  {Roles: []string{RoleRecipient}},
+	RecpRPC[8][0]:  {Roles: []string{RoleRecipient}},
+	RecpRPC[9][0]:  {Roles: []string{RoleRecipient}},
+	RecpRPC[10][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[11][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[12][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[13][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[14][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[15][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[16][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[17][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[18][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[19][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[20][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[21][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[22][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[23][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[24][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[25][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[26][0]: {Roles: []string{RoleRecipient}},
+	RecpRPC[27][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+	RecpRPC[28][0]: {Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"},
+
+	InvRPC[1][0]:  {Public: true},
+	InvRPC[2][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[3][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[4][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[5][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[6][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[7][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[8][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[9][0]:  {Roles: []string{RoleInvestor}},
+	InvRPC[10][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[11][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[12][0]: {Roles: []string{RoleInvestor}, Relations: []string{RelInvestor}, ProjectParam: "projIndex"},
+	InvRPC[13][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[14][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[15][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[16][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[17][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[18][0]: {Roles: []string{RoleInvestor}},
+	InvRPC[19][0]: {Roles: []string{RoleInvestor}, Relations: []string{RelInvestor}, ProjectParam: "projIndex"},
+
+	EntityRPC[1][0]:  {Roles: []string{RoleEntity}},
+	EntityRPC[2][0]:  {Roles: []string{RoleEntity}},
+	EntityRPC[3][0]:  {Roles: []string{RoleEntity}},
+	EntityRPC[4][0]:  {Roles: []string{RoleEntity}},
+	EntityRPC[5][0]:  {Roles: []string{RoleContractor}},
+	EntityRPC[6][0]:  {Roles: []string{RoleContractor, RoleDeveloper}},
+	EntityRPC[7][0]:  {Public: true},
+	EntityRPC[8][0]:  {Roles: []string{RoleContractor}},
+	EntityRPC[9][0]:  {Roles: []string{RoleDeveloper}},
+	EntityRPC[10][0]: {Roles: []string{RoleContractor}},
+	EntityRPC[11][0]: {Roles: []string{RoleContractor}},
+	EntityRPC[12][0]: {Roles: []string{RoleContractor}},
+
+	StagesRPC[1][0]: {Public: true},
+	StagesRPC[2][0]: {Public: true},
+	StagesRPC[3][0]: {Relations: []string{RelRecipient, RelContractor, RelDeveloper, RelGuarantor}, ProjectParam: "index"},
+	StagesRPC[4][0]: {Relations: projectParties, ProjectParam: "index"},
+	StagesRPC[5][0]: {Relations: projectParties, ProjectParam: "index"},
+
+	AdminRPC[1][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[2][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[3][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[4][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[5][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[6][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[7][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[8][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[9][0]:  {Roles: []string{RoleAdmin}},
+	AdminRPC[10][0]: {Roles: []string{RoleAdmin}},
+	AdminRPC[11][0]: {Roles: []string{RoleAdmin}},
+
+	GuaRPC[1][0]: {Roles: []string{RoleGuarantor}, Relations: []string{RelGuarantor}, ProjectParam: "projIndex"},
+	GuaRPC[2][0]: {Roles: []string{RoleGuarantor}, Relations: []string{RelGuarantor}, ProjectParam: "projIndex"},
+	GuaRPC[3][0]: {Roles: []string{RoleGuarantor}, Relations: []string{RelGuarantor}, ProjectParam: "projIndex"},
+
+	DevRPC[1][0]: {Roles: []string{RoleDeveloper}, Relations: []string{RelDeveloper}, ProjectParam: "projIndex"},
+}
+
+// policyTables are the RPC tables whose routes must all have a policy
+func policyTables() []map[int][]string {
+	return []map[int][]string{ProjectRPC, RecpRPC, InvRPC, EntityRPC, StagesRPC, AdminRPC, GuaRPC, DevRPC}
+}
+
+// checkPolicies logs the routes that don't have a policy. Those routes are only protected by their handlers
+func checkPolicies() {
+	for _, table := range policyTables() {
+		for _, route := range table {
+			if _, exists := Policies[route[0]]; !exists {
+				log.Println("route: ", route[0], " doesn't have an access policy")
+			}
+		}
+	}
+}
+
+// callerRoles returns the roles held by a user
+func callerRoles(user openx.User) map[string]bool {
+	roles := make(map[string]bool)
+	roles[RoleUser] = true
+	if user.Admin {
+		roles[RoleAdmin] = true
+	}
+
+	recipient, err := core.RetrieveRecipient(user.Index)
+	if err == nil && recipient.U != nil && recipient.U.Index == user.Index {
+		roles[RoleRecipient] = true
+	}
+
+	investor, err := core.RetrieveInvestor(user.Index)
+	if err == nil && investor.U != nil && investor.U.Index == user.Index {
+		roles[RoleInvestor] = true
+	}
+
+	entity, err := core.RetrieveEntity(user.Index)
+	if err == nil && entity.U != nil && entity.U.Index == user.Index {
+		roles[RoleEntity] = true
+		roles[RoleContractor] = entity.Contractor
+		roles[RoleDeveloper] = entity.Developer
+		roles[RoleOriginator] = entity.Originator
+		roles[RoleGuarantor] = entity.Guarantor
+	}
+
+	return roles
+}
+
+// projectRelations returns the relationships a user has with a project
+func projectRelations(user openx.User, project core.Project) map[string]bool {
+	relations := make(map[string]bool)
+	relations[RelRecipient] = project.RecipientIndex == user.Index
+	relations[RelContractor] = project.ContractorIndex == user.Index
+	relations[RelDeveloper] = project.MainDeveloperIndex == user.Index
+	relations[RelOriginator] = project.OriginatorIndex == user.Index
+	relations[RelGuarantor] = project.GuarantorIndex == user.Index
+	for _, index := range project.InvestorIndices {
+		if index == user.Index {
+			relations[RelInvestor] = true
+		}
+	}
+	return relations
+}
+
+// validateUser validates the caller of a route
+var validateUser = core.ValidateUser
+
+// deny returns the reason a request is denied by a policy or an empty string if the request is allowed
+func (p Policy) deny(r *http.Request) string {
+	username, token := r.FormValue("username"), r.FormValue("token")
+	if username == "" || token == "" {
+		return "username or token missing"
+	}
+
+	user, err := validateUser(username, token)
+	if err != nil {
+		return "couldn't validate user: " + err.Error()
+	}
+	if user.Admin {
+		return ""
+	}
+
+	if len(p.Roles) != 0 {
+		roles := callerRoles(user)
+		allowed := false
+		for _, role := range p.Roles {
+			if roles[role] {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return username + " doesn't hold any of the roles: " + join(p.Roles)
+		}
+	}
+
+	if len(p.Relations) != 0 {
+		projIndex, err := utils.ToInt(r.FormValue(p.ProjectParam))
+		if err != nil {
+			return "project index in param " + p.ProjectParam + " not an integer"
+		}
+
+		project, err := core.RetrieveProject(projIndex)
+		if err != nil {
+			return "couldn't retrieve project: " + err.Error()
+		}
+
+		relations := projectRelations(user, project)
+		allowed := false
+		for _, relation := range p.Relations {
+			if relations[relation] {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return username + " doesn't have any of the relations: " + join(p.Relations) + " with project " +
+				r.FormValue(p.ProjectParam)
+		}
+	}
+
+	return ""
+}
+
+// join joins roles or relations for logging
+func join(arr []string) string {
+	var x string
+	for i, elem := range arr {
+		if i != 0 {
+			x += ", "
+		}
+		x += elem
+	}
+	return x
+}
+
+// authorize enforces the policy of a route before passing the request on to its handler. Routes without
+// a policy (eg. those relayed to openx) are passed on as is
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy, exists := Policies[r.URL.Path]
+		if exists && !policy.Public {
+			reason := policy.deny(r)
+			if reason != "" {
+				log.Println("denied request to ", r.URL.Path, ": ", reason)
+				erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
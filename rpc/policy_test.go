@@ -0,0 +1,149 @@
+// +build all travis
+
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	core "github.com/YaleOpenLab/opensolar/core"
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+// testPolicies sets up a database with a project and its parties and validates the users below by their username
+func testPolicies(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "opensolar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homeDir, dbDir := consts.HomeDir, consts.DbDir
+	consts.HomeDir = dir
+	consts.DbDir = dir + "/database/"
+	core.CreateHomeDir()
+
+	users := map[string]openx.User{
+		"recipient":  {Index: 1, Username: "recipient"},
+		"investor":   {Index: 2, Username: "investor"},
+		"contractor": {Index: 3, Username: "contractor"},
+		"outsider":   {Index: 4, Username: "outsider"},
+		"admin":      {Index: 5, Username: "admin", Admin: true},
+	}
+
+	store, userStore := core.CurrentUserStore(), core.NewMemoryUsers()
+	core.SetUserStore(userStore)
+	for _, user := range users {
+		err = userStore.Add(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recipient := core.Recipient{U: &openx.User{Index: 1}}
+	investor := core.Investor{U: &openx.User{Index: 2}}
+	contractor := core.Entity{U: &openx.User{Index: 3}, Contractor: true}
+	outsider := core.Investor{U: &openx.User{Index: 4}}
+	for _, save := range []func() error{recipient.Save, investor.Save, contractor.Save, outsider.Save} {
+		err = save()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	project := core.Project{Index: 1, RecipientIndex: 1, ContractorIndex: 3, InvestorIndices: []int{2}}
+	err = project.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validateUser
+	validateUser = func(name string, token string) (openx.User, error) {
+		user, exists := users[name]
+		if !exists || token != "token" {
+			return user, errors.New("problem with user validation")
+		}
+		return user, nil
+	}
+
+	return func() {
+		validateUser = validate
+		core.SetUserStore(store)
+		consts.HomeDir, consts.DbDir = homeDir, dbDir
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPolicyDeny(t *testing.T) {
+	defer testPolicies(t)()
+
+	recipientOf := Policy{Roles: []string{RoleRecipient}, Relations: []string{RelRecipient}, ProjectParam: "projIndex"}
+	parties := Policy{Relations: projectParties, ProjectParam: "index"}
+
+	cases := []struct {
+		name   string
+		policy Policy
+		query  string
+		denied bool
+	}{
+		{"no token", Policy{Roles: []string{RoleUser}}, "username=investor", true},
+		{"bad token", Policy{Roles: []string{RoleUser}}, "username=investor&token=bad", true},
+		{"any user", Policy{Roles: []string{RoleUser}}, "username=outsider&token=token", false},
+		{"role held", Policy{Roles: []string{RoleInvestor}}, "username=investor&token=token", false},
+		{"one of the roles held", Policy{Roles: []string{RoleDeveloper, RoleContractor}}, "username=contractor&token=token", false},
+		{"role not held", Policy{Roles: []string{RoleRecipient}}, "username=investor&token=token", true},
+		{"entity role not held", Policy{Roles: []string{RoleDeveloper}}, "username=contractor&token=token", true},
+		{"admin role", Policy{Roles: []string{RoleAdmin}}, "username=recipient&token=token", true},
+		{"admin bypasses roles", Policy{Roles: []string{RoleAdmin}}, "username=admin&token=token", false},
+		{"admin bypasses relations", recipientOf, "username=admin&token=token&projIndex=1", false},
+		{"relation held", recipientOf, "username=recipient&token=token&projIndex=1", false},
+		{"relation to another project", recipientOf, "username=recipient&token=token&projIndex=2", true},
+		{"role without relation", parties, "username=outsider&token=token&index=1", true},
+		{"investor in project", parties, "username=investor&token=token&index=1", false},
+		{"contractor on project", parties, "username=contractor&token=token&index=1", false},
+		{"project param missing", parties, "username=investor&token=token", true},
+		{"project param not an integer", parties, "username=investor&token=token&index=one", true},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/route?"+c.query, nil)
+		reason := c.policy.deny(r)
+		if (reason != "") != c.denied {
+			t.Errorf("%s: expected denied %v, got %q", c.name, c.denied, reason)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	defer testPolicies(t)()
+
+	var served bool
+	handler := authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	cases := []struct {
+		name   string
+		route  string
+		query  string
+		served bool
+	}{
+		{"public route", ProjectRPC[2][0], "", true},
+		{"route without a policy", "/nopolicy", "", true},
+		{"denied", AdminRPC[1][0], "username=investor&token=token", false},
+		{"allowed", AdminRPC[1][0], "username=admin&token=token", true},
+	}
+
+	for _, c := range cases {
+		served = false
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", c.route+"?"+c.query, nil))
+		if served != c.served {
+			t.Errorf("%s: expected served %v, got %v", c.name, c.served, served)
+		}
+	}
+}
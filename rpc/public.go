@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	erpc "github.com/Varunram/essentials/rpc"
+	utils "github.com/Varunram/essentials/utils"
 	core "github.com/YaleOpenLab/opensolar/core"
 )
 
@@ -13,6 +14,8 @@ func setupPublicRoutes() {
 	getAllRecipientsPublic()     // GET
 	getInvTopReputationPublic()  // GET
 	getRecpTopReputationPublic() // GET
+	getReputationPublic()        // GET
+	getFeedbackPublic()          // GET
 }
 
 var PublicRpc = map[int][]string{
@@ -20,6 +23,8 @@ var PublicRpc = map[int][]string{
 	2: []string{"/public/recipient/all"},            // GET
 	3: []string{"/public/recipient/reputation/top"}, // GET
 	4: []string{"/public/investor/reputation/top"},  // GET
+	5: []string{"/public/reputation"},               // GET
+	6: []string{"/public/feedback"},                 // GET
 }
 
 // SnInvestor defines a sanitized investor
@@ -162,3 +167,51 @@ func getInvTopReputationPublic() {
 		erpc.MarshalSend(w, sInvestors)
 	})
 }
+
+// getReputationPublic gets the breakdown of a user's reputation score
+func getReputationPublic() {
+	http.HandleFunc(PublicRpc[5][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckGet(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		index, err := utils.ToInt(r.URL.Query().Get("index"))
+		if err != nil {
+			log.Println("passed index not an integer", err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+		score, err := core.ComputeReputation(index)
+		if err != nil {
+			log.Println("did not compute reputation", err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+		erpc.MarshalSend(w, score)
+	})
+}
+
+// getFeedbackPublic gets the feedback a user has received
+func getFeedbackPublic() {
+	http.HandleFunc(PublicRpc[6][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckGet(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		index, err := utils.ToInt(r.URL.Query().Get("index"))
+		if err != nil {
+			log.Println("passed index not an integer", err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+		feedback, err := core.RetrieveUserFeedback(index)
+		if err != nil {
+			log.Println("did not retrieve feedback", err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+		erpc.MarshalSend(w, feedback)
+	})
+}
@@ -37,6 +37,11 @@ func setupRecipientRPCs() {
 	storeTellerDetails()
 	recpDashboard()
 	storeTellerEnergy()
+	storeTellerReading()
+	getStatements()
+	getStatement()
+	openAuction()
+	closeAuction()
 }
 
 // RecpRPC is a collection of all recipient RPC endpoints and their required params
@@ -44,8 +49,8 @@ var RecpRPC = map[int][]string{
 	1:  []string{"/recipient/all", "GET"},                                                                                                   // GET
 	2:  []string{"/recipient/register", "POST", "name", "username", "pwhash", "seedpwd"},                                                    // POST
 	3:  []string{"/recipient/validate", "GET"},                                                                                              // GET
-	4:  []string{"/recipient/payback", "POST", "assetName", "amount", "seedpwd", "projIndex"},                                               // POST
-	5:  []string{"/recipient/deviceId", "POST", "deviceId"},                                                                                 // POST
+	4:  []string{"/recipient/payback", "POST", "assetName", "amount", "seedpwd", "projIndex"},                                               // POST, optionally idempotencykey
+	5:  []string{"/recipient/deviceId", "POST", "deviceId", "pubkey"},                                                                       // POST
 	6:  []string{"/recipient/startdevice", "POST", "start"},                                                                                 // POST
 	7:  []string{"/recipient/storelocation", "POST", "location"},                                                                            // POST
 	8:  []string{"/recipient/auction/choose/blind", "GET"},                                                                                  // GET
@@ -63,7 +68,12 @@ var RecpRPC = map[int][]string{
 	20: []string{"/recipient/dashboard", "GET"},                                                                                             // GET
 	21: []string{"/recipient/company/set", "POST"},                                                                                          // POST
 	22: []string{"/recipient/company/details", "POST", "companytype", "name", "legalname", "address", "country", "city", "zipcode", "role"}, // POST
-	23: []string{"/recipient/teller/energy", "POST", "energy"},                                                                              // POST
+	23: []string{"/recipient/teller/energy", "POST", "batch", "signature"},                                                                  // POST
+	24: []string{"/recipient/teller/reading", "POST", "projIndex", "deviceId", "generated", "consumed", "exported"},                         // POST
+	25: []string{"/recipient/statements", "GET", "projIndex"},                                                                               // GET
+	26: []string{"/recipient/statement", "GET", "index"},                                                                                    // GET
+	27: []string{"/recipient/auction/open", "POST", "projIndex", "type", "reserve"},                                                         // POST
+	28: []string{"/recipient/auction/close", "POST", "projIndex"},                                                                           // POST
 }
 
 // recpValidateHelper is a helper that helps validates recipients in routes
@@ -214,17 +224,42 @@ func payback() {
 			return
 		}
 
+		// tellers retry paybacks they didn't get a response for with the same idempotency key. The key is
+		// claimed before paying back and released if the payback fails so the teller can retry it
+		key := r.FormValue("idempotencykey")
+		if key != "" {
+			claimed, err := core.ClaimPaybackKey(recpIndex, key)
+			if err != nil {
+				log.Println("couldn't claim payback idempotency key", err)
+				erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				log.Println("payback with idempotency key: ", key, " already made")
+				erpc.ResponseHandler(w, erpc.StatusOK)
+				return
+			}
+		}
+
 		err = core.Payback(recpIndex, projIndex, assetName, amount, recipientSeed)
 		if err != nil {
 			log.Println("did not payback", err)
+			if key != "" {
+				rerr := core.ReleasePaybackKey(recpIndex, key)
+				if rerr != nil {
+					log.Println("couldn't release payback idempotency key: ", key, rerr)
+				}
+			}
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
 			return
 		}
+
 		erpc.ResponseHandler(w, erpc.StatusOK)
 	})
 }
 
-// storeDeviceId stores the recipient's device id from the teller. Called by the teller
+// storeDeviceId stores the recipient's device id and the public key the teller signs readings with. Called by
+// the teller
 func storeDeviceId() {
 	http.HandleFunc(RecpRPC[5][0], func(w http.ResponseWriter, r *http.Request) {
 		// first validate the recipient or anyone would be able to set device ids
@@ -234,12 +269,12 @@ func storeDeviceId() {
 		}
 
 		deviceId := r.FormValue("deviceId")
-		// we have the recipient ready. Now set the device id
-		prepRecipient.DeviceId = deviceId
-		err = prepRecipient.Save()
+		pubkey := r.FormValue("pubkey")
+		// we have the recipient ready. Now set the device id and key
+		err = prepRecipient.RegisterDeviceKey(deviceId, pubkey)
 		if err != nil {
-			log.Println("did not save recipient", err)
-			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			log.Println("did not register device key", err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
 			return
 		}
 		erpc.ResponseHandler(w, erpc.StatusOK)
@@ -341,7 +376,7 @@ func chooseVickreyAuction() {
 			return
 		}
 
-		bestContract, err := core.SelectContractVickrey(allContracts)
+		bestContract, price, err := core.SelectContractVickrey(allContracts)
 		if err != nil {
 			log.Println("did not select contract", err)
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
@@ -355,7 +390,13 @@ func chooseVickreyAuction() {
 			return
 		}
 
-		erpc.ResponseHandler(w, erpc.StatusOK)
+		// the winning contract is left as proposed, the price it is paid is part of the result
+		var result core.ContractAuction
+		result.AllContracts = allContracts
+		result.WinningContract = bestContract
+		result.Type = core.AuctionVickrey
+		result.Price = price
+		erpc.MarshalSend(w, result)
 	})
 }
 
@@ -531,6 +572,14 @@ func storeStateHash() {
 
 		hash := r.FormValue("hash")
 
+		// tellers resend state hashes they didn't get a response for
+		for _, x := range prepRecipient.StateHashes {
+			if x == hash {
+				erpc.ResponseHandler(w, erpc.StatusOK)
+				return
+			}
+		}
+
 		prepRecipient.StateHashes = append(prepRecipient.StateHashes, hash)
 		err = prepRecipient.Save()
 		if err != nil {
@@ -604,14 +653,19 @@ func storeTellerURL() {
 			return
 		}
 
-		project.TellerUrl = url
-		err = project.Save()
+		err = project.Update("teller url set", map[string]interface{}{"TellerUrl": url})
 		if err != nil {
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
 			return
 		}
 
-		go core.MonitorTeller(projIndex, url)
+		err = core.MonitorTeller(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
 		erpc.ResponseHandler(w, erpc.StatusOK)
 	})
 }
@@ -830,6 +884,9 @@ func setCompanyRecp() {
 	})
 }
 
+// storeTellerEnergy stores a batch of readings reported by the recipient's teller. The batch is json encoded
+// and signed with the teller's device key, batches that aren't signed by the registered key or that have been
+// accepted before are rejected
 func storeTellerEnergy() {
 	http.HandleFunc(RecpRPC[23][0], func(w http.ResponseWriter, r *http.Request) {
 		recipient, err := recpValidateHelper(w, r, RecpRPC[23][2:], RecpRPC[23][1])
@@ -843,24 +900,342 @@ func storeTellerEnergy() {
 			return
 		}
 
-		energy := r.FormValue("energy")
+		_, err = recipient.AcceptBatch([]byte(r.FormValue("batch")), r.FormValue("signature"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
+
+// storeTellerReading stores a reading reported by a device attached to the recipient's project. The
+// timestamp of the reading (unix time) is optional and defaults to the time the reading is received. Unsigned
+// readings are rejected once the recipient has registered a device key, storeTellerEnergy has to be used instead
+func storeTellerReading() {
+	http.HandleFunc(RecpRPC[24][0], func(w http.ResponseWriter, r *http.Request) {
+		recipient, err := recpValidateHelper(w, r, RecpRPC[24][2:], RecpRPC[24][1])
+		if err != nil {
+			return
+		}
+
+		err = r.ParseForm()
+		if err != nil {
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		var reading core.EnergyReading
+		reading.ProjIndex, err = utils.ToInt(r.FormValue("projIndex"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
 
-		energyInt, err := utils.ToInt(energy)
+		project, err := core.RetrieveProject(reading.ProjIndex)
 		if err != nil {
 			log.Println(err)
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
 			return
 		}
 
-		recipient.TellerEnergy = uint32(energyInt)
+		if project.RecipientIndex != recipient.U.Index {
+			log.Println("recipient indices don't match, quitting")
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
 
-		err = recipient.Save()
+		reading.Generated, err = utils.ToFloat(r.FormValue("generated"))
 		if err != nil {
 			log.Println(err)
-			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		reading.Consumed, err = utils.ToFloat(r.FormValue("consumed"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		reading.Exported, err = utils.ToFloat(r.FormValue("exported"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		if r.FormValue("timestamp") != "" {
+			timestamp, err := utils.ToInt(r.FormValue("timestamp"))
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
+			reading.Timestamp = int64(timestamp)
+		}
+
+		reading.DeviceID = r.FormValue("deviceId")
+		reading.Source = "rpc"
+
+		// once the recipient's teller has registered a device key, readings from every device must be reported
+		// in batches signed with it
+		if recipient.DevicePubkey != "" {
+			log.Println("unsigned reading reported for device: ", reading.DeviceID)
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		_, err = core.RecordReading(reading)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
 			return
 		}
 
 		erpc.ResponseHandler(w, erpc.StatusOK)
 	})
 }
+
+// getStatements gets the billing statements the recipient has received for a project
+func getStatements() {
+	http.HandleFunc(RecpRPC[25][0], func(w http.ResponseWriter, r *http.Request) {
+		recipient, err := recpValidateHelper(w, r, RecpRPC[25][2:], RecpRPC[25][1])
+		if err != nil {
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.URL.Query()["projIndex"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.RetrieveProject(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		if project.RecipientIndex != recipient.U.Index {
+			log.Println("recipient indices don't match, quitting")
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		statements, err := core.RetrieveProjectStatements(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, statements)
+	})
+}
+
+// getStatement gets a single billing statement of the recipient. The statement is rendered as html or pdf if
+// format is passed, json otherwise
+func getStatement() {
+	http.HandleFunc(RecpRPC[26][0], func(w http.ResponseWriter, r *http.Request) {
+		recipient, err := recpValidateHelper(w, r, RecpRPC[26][2:], RecpRPC[26][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		statement, err := core.RetrieveStatement(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusNotFound)
+			return
+		}
+
+		if statement.RecpIndex != recipient.U.Index {
+			log.Println("recipient indices don't match, quitting")
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Query().Get("format") {
+		case "html":
+			html, err := statement.HTML()
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(html))
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(statement.PDF())
+		default:
+			erpc.MarshalSend(w, statement)
+		}
+	})
+}
+
+// auctionParams parses the type, reserve and timing of an auction. Params that don't apply to the type of the
+// auction can be left out
+func auctionParams(r *http.Request) (core.Auction, error) {
+	var params core.Auction
+	params.Type = r.FormValue("type")
+
+	var err error
+	params.Reserve, err = utils.ToFloat(r.FormValue("reserve"))
+	if err != nil {
+		return params, err
+	}
+
+	for _, x := range []struct {
+		param string
+		value *int64
+	}{{"window", &params.Window}, {"revealwindow", &params.RevealWindow}, {"roundlength", &params.RoundLength}} {
+		if r.FormValue(x.param) == "" {
+			continue
+		}
+		seconds, err := utils.ToInt(r.FormValue(x.param))
+		if err != nil {
+			return params, err
+		}
+		*x.value = int64(seconds)
+	}
+
+	for _, x := range []struct {
+		param string
+		value *float64
+	}{{"startprice", &params.StartPrice}, {"step", &params.Step}, {"wprice", &params.Weights.Price},
+		{"wtime", &params.Weights.Time}, {"wreputation", &params.Weights.Reputation},
+		{"wcollateral", &params.Weights.Collateral}, {"wperformance", &params.Weights.Performance}} {
+		if r.FormValue(x.param) == "" {
+			continue
+		}
+		*x.value, err = utils.ToFloat(r.FormValue(x.param))
+		if err != nil {
+			return params, err
+		}
+	}
+	return params, nil
+}
+
+// openAuction opens an auction among contractors for one of the recipient's projects. Sealed (blind, vickrey)
+// auctions take a window and revealwindow, english auctions a window, roundlength and step and dutch auctions
+// a startprice, step and roundlength. Bids in all but dutch auctions can be scored by passing the weights of
+// price, completion time, reputation, collateral and past performance (wprice, wtime, wreputation,
+// wcollateral, wperformance)
+func openAuction() {
+	http.HandleFunc(RecpRPC[27][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		recipient, err := recpValidateHelper(w, r, RecpRPC[27][2:], RecpRPC[27][1])
+		if err != nil {
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.FormValue("projIndex"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.RetrieveProject(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		if project.RecipientIndex != recipient.U.Index {
+			log.Println("recipient indices don't match, quitting")
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		params, err := auctionParams(r)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		auction, err := core.OpenAuction(projIndex, params)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, auction)
+	})
+}
+
+// closeAuction closes the open auction of one of the recipient's projects once it has ended. The scheduler
+// closes auctions that have ended as well
+func closeAuction() {
+	http.HandleFunc(RecpRPC[28][0], func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckPost(w, r)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		recipient, err := recpValidateHelper(w, r, RecpRPC[28][2:], RecpRPC[28][1])
+		if err != nil {
+			return
+		}
+
+		projIndex, err := utils.ToInt(r.FormValue("projIndex"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		project, err := core.RetrieveProject(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		if project.RecipientIndex != recipient.U.Index {
+			log.Println("recipient indices don't match, quitting")
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		auction, err := core.RetrieveOpenAuction(projIndex)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusNotFound)
+			return
+		}
+
+		auction, err = core.CloseAuction(auction.Index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, auction)
+	})
+}
@@ -151,6 +151,7 @@ func StartServer(portx int, insecure bool) {
 	setupAdminHandlers()
 	setupDeveloperRPCs()
 	setupGuarantorRPCs()
+	checkPolicies()
 
 	erpc.SetConsts(60)
 	port, err := utils.ToString(portx)
@@ -159,10 +160,11 @@ func StartServer(portx int, insecure bool) {
 		port = "80"
 	}
 
+	handler := authorize(http.DefaultServeMux)
 	log.Println("Starting RPC Server on Port: ", port)
 	if insecure {
-		log.Fatal(http.ListenAndServe(":"+port, nil))
+		log.Fatal(http.ListenAndServe(":"+port, handler))
 	} else {
-		log.Fatal(http.ListenAndServeTLS(":"+port, "server.crt", "server.key", nil))
+		log.Fatal(http.ListenAndServeTLS(":"+port, "server.crt", "server.key", handler))
 	}
 }
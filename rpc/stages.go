@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 
@@ -15,12 +16,16 @@ func setupStagesHandlers() {
 	returnAllStages()
 	returnSpecificStage()
 	promoteStage()
+	getChecklist()
+	tickActivity()
 }
 
 var StagesRPC = map[int][]string{
-	1: []string{"/stages/all", "GET"},              // GET
-	2: []string{"/stages", "GET", "index"},         // GET
-	3: []string{"/stages/promote", "GET", "index"}, // GET
+	1: []string{"/stages/all", "GET"},                                                   // GET
+	2: []string{"/stages", "GET", "index"},                                              // GET
+	3: []string{"/stages/promote", "GET", "index"},                                      // GET
+	4: []string{"/stages/checklist", "GET", "index", "stage"},                           // GET
+	5: []string{"/stages/checklist/tick", "POST", "index", "stage", "activity", "done"}, // POST
 }
 
 // returnAllStages returns all the defined stages for this specific platform.  Opensolar
@@ -94,7 +99,30 @@ func returnSpecificStage() {
 	})
 }
 
-// promoteStage returns details on a specific stage defined in the opensolar platform
+// PromotionBlocked is the response sent when a project can't be promoted, along with the items blocking promotion
+type PromotionBlocked struct {
+	Code     int
+	Status   string
+	Blockers []string
+}
+
+// sendBlockers responds with a bad request carrying the items blocking the promotion of a project
+func sendBlockers(w http.ResponseWriter, blockers []string) {
+	data, err := json.Marshal(PromotionBlocked{Code: erpc.StatusBadRequest, Status: "project can't be promoted",
+		Blockers: blockers})
+	if err != nil {
+		log.Println(err)
+		erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(erpc.StatusBadRequest)
+	w.Write(data)
+}
+
+// promoteStage promotes a project to the next stage. Only the recipient, contractor, guarantor and main
+// developer of the project (and admins) can promote stages, which is enforced by the route's policy
 func promoteStage() {
 	http.HandleFunc(StagesRPC[3][0], func(w http.ResponseWriter, r *http.Request) {
 		err := erpc.CheckGet(w, r)
@@ -103,7 +131,7 @@ func promoteStage() {
 			return
 		}
 
-		err = checkReqdParams(w, r, StagesRPC[2][2:], StagesRPC[2][1])
+		err = checkReqdParams(w, r, StagesRPC[3][2:], StagesRPC[3][1])
 		if err != nil {
 			log.Println(err)
 			return
@@ -119,59 +147,130 @@ func promoteStage() {
 
 		project, err := core.RetrieveProject(index)
 		if err != nil {
-			log.Println("Couldn't retrieve project from the database")
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		blockers, err := project.StageBlockers()
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
 			return
 		}
 
-		var recpBool, invBool, entityBool bool
-		recpBool, invBool, entityBool = true, true, true
+		if len(blockers) != 0 {
+			// send the items blocking promotion so the caller knows what's left to be done
+			log.Println("project: ", index, " blocked from promotion by: ", blockers)
+			sendBlockers(w, blockers)
+			return
+		}
 
-		recp, err := recpValidateHelper(w, r, StagesRPC[2][2:], StagesRPC[2][1])
+		err = core.StageXtoY(index)
 		if err != nil {
-			log.Println("stage promoter not a recipient: ", err)
-			recpBool = false
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
 		}
 
-		inv, err := InvValidateHelper(w, r, StagesRPC[2][2:], StagesRPC[2][1])
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
+
+// getChecklist returns the checklist of a project's stage along with the items blocking promotion
+func getChecklist() {
+	http.HandleFunc(StagesRPC[4][0], func(w http.ResponseWriter, r *http.Request) {
+		err := checkReqdParams(w, r, StagesRPC[4][2:], StagesRPC[4][1])
 		if err != nil {
-			log.Println("stage promoter not an investor: ", err)
-			invBool = false
+			log.Println(err)
+			return
 		}
 
-		entity, err := entityValidateHelper(w, r, StagesRPC[2][2:], StagesRPC[2][1])
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
 		if err != nil {
-			log.Println("stage promoter not an entity: ", err)
-			entityBool = false
+			log.Println("Passed index not an integer, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
 		}
 
-		if !(recpBool && recp.U.Index != project.RecipientIndex) || !recp.U.Admin {
-			log.Println("not authorized to upgrade stages, quitting")
-			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+		stage, err := utils.ToInt(r.URL.Query()["stage"][0])
+		if err != nil {
+			log.Println("Passed stage not an integer, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
 			return
 		}
 
-		if invBool && !inv.U.Admin {
-			log.Println("investors are not allowed to perform stage migrations, exiting")
-			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+		status, err := core.RetrieveChecklist(index, stage)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
 			return
 		}
 
-		if !(entityBool && entity.U.Index != project.ContractorIndex &&
-			entity.U.Index != project.GuarantorIndex &&
-			entity.U.Index != project.MainDeveloperIndex) || !entity.U.Admin {
-			log.Println("you are not a registered entity related to the project")
-			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+		erpc.MarshalSend(w, status)
+	})
+}
+
+// tickActivity ticks an activity of a project's stage and attaches evidence (ipfsHash, document, comment) to it.
+// Only the parties responsible for the activity (and admins) can tick it
+func tickActivity() {
+	http.HandleFunc(StagesRPC[5][0], func(w http.ResponseWriter, r *http.Request) {
+		user, err := userValidateHelper(w, r, StagesRPC[5][2:], StagesRPC[5][1])
+		if err != nil {
 			return
 		}
 
-		// we check whether the person is actually associated with the project in question
-		err = core.StageXtoY(index)
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println("Passed index not an integer, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		stageNumber, err := utils.ToInt(r.FormValue("stage"))
+		if err != nil {
+			log.Println("Passed stage not an integer, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		activity, err := utils.ToInt(r.FormValue("activity"))
+		if err != nil {
+			log.Println("Passed activity not an integer, quitting!")
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		done := r.FormValue("done") == "true"
+
+		project, err := core.RetrieveProject(index)
 		if err != nil {
 			log.Println(err)
 			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
 			return
 		}
 
-		erpc.ResponseHandler(w, erpc.StatusOK)
+		stage, err := core.RetrieveStage(stageNumber)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		if !user.Admin && !project.ResponsibleFor(stage, activity, user.Index) {
+			log.Println("user: ", user.Index, " not responsible for activity: ", activity, " of stage: ", stageNumber)
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		entry, err := core.TickActivity(index, stageNumber, activity, user.Index, done,
+			r.FormValue("ipfsHash"), r.FormValue("document"), r.FormValue("comment"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, entry)
 	})
 }
@@ -0,0 +1,27 @@
+// +build all travis
+
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendBlockers(t *testing.T) {
+	w := httptest.NewRecorder()
+	sendBlockers(w, []string{"activity 0 not done"})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("blocked promotion answered with status %d", w.Code)
+	}
+	var response PromotionBlocked
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != http.StatusBadRequest || len(response.Blockers) != 1 {
+		t.Fatalf("blockers not sent: %+v", response)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
 
 	erpc "github.com/Varunram/essentials/rpc"
 	utils "github.com/Varunram/essentials/utils"
 	consts "github.com/YaleOpenLab/opensolar/consts"
 	core "github.com/YaleOpenLab/opensolar/core"
+	notif "github.com/YaleOpenLab/opensolar/notif"
 	openx "github.com/YaleOpenLab/openx/database"
 	// openxrpc "github.com/YaleOpenLab/openx/rpc"
 )
@@ -20,15 +22,27 @@ func setupUserRpcs() {
 	userInfo()
 	registerUser()
 	getUserRoles()
+	getNotifPrefs()
+	registerWebhook()
+	getWebhooks()
+	deleteWebhook()
+	getWebhookDeliveries()
+	redeliverWebhook()
 }
 
 // UserRPC is a collection of all user RPC endpoints and their required params
 var UserRPC = map[int][]string{
-	1: []string{"/update", "POST"},                                                  // POST
-	2: []string{"/user/report", "POST", "projIndex"},                                // POST
-	3: []string{"/user/info", "GET"},                                                // GET
-	4: []string{"/user/register", "POST", "email", "username", "pwhash", "seedpwd"}, // POST
-	5: []string{"/user/roles", "GET"},                                               // GET
+	1:  []string{"/update", "POST"},                                                  // POST, optionally notifprefs
+	2:  []string{"/user/report", "POST", "projIndex"},                                // POST
+	3:  []string{"/user/info", "GET"},                                                // GET
+	4:  []string{"/user/register", "POST", "email", "username", "pwhash", "seedpwd"}, // POST
+	5:  []string{"/user/roles", "GET"},                                               // GET
+	6:  []string{"/user/notifprefs", "GET"},                                          // GET
+	7:  []string{"/user/webhooks/register", "POST", "url"},                           // POST, optionally projIndex and events
+	8:  []string{"/user/webhooks", "GET"},                                            // GET
+	9:  []string{"/user/webhooks/delete", "POST", "index"},                           // POST
+	10: []string{"/user/webhooks/deliveries", "GET", "index"},                        // GET
+	11: []string{"/user/webhooks/redeliver", "POST", "index"},                        // POST
 }
 
 func userValidateHelper(w http.ResponseWriter, r *http.Request, options []string, method string) (openx.User, error) {
@@ -58,7 +72,8 @@ func userValidateHelper(w http.ResponseWriter, r *http.Request, options []string
 	return user, nil
 }
 
-// updateUser updates credentials of the user
+// updateUser updates credentials of the user. notifprefs is an optional event type: channel: digest json map
+// of the user's notification preferences, eg. {"payback": {"email": "immediate"}, "project": {"email": "weekly"}}
 func updateUser() {
 	http.HandleFunc(UserRPC[1][0], func(w http.ResponseWriter, r *http.Request) {
 		// updateUser must first call the openx rpc to update the user struct
@@ -78,6 +93,22 @@ func updateUser() {
 			return
 		}
 
+		var prefs map[string]map[string]string
+		if r.FormValue("notifprefs") != "" {
+			err = json.Unmarshal([]byte(r.FormValue("notifprefs")), &prefs)
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
+			err = notif.ValidatePreferences(prefs)
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
+		}
+
 		data, err := erpc.PostForm(body, r.Form)
 		if err != nil {
 			log.Println(err)
@@ -125,6 +156,14 @@ func updateUser() {
 					return
 				}
 			}
+			if prefs != nil {
+				_, err = notif.UpdatePreferences(user.Index, user.Email, prefs)
+				if err != nil {
+					log.Println("unable to save notification preferences: ", err)
+					erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+					return
+				}
+			}
 			erpc.MarshalSend(w, user)
 		} else {
 			log.Println("user not updated")
@@ -290,3 +329,184 @@ func getUserRoles() {
 		erpc.MarshalSend(w, ret)
 	})
 }
+
+// getNotifPrefs gets the notification preferences of the user. Notifications for event types without a
+// preference are delivered immediately
+func getNotifPrefs() {
+	http.HandleFunc(UserRPC[6][0], func(w http.ResponseWriter, r *http.Request) {
+		user, err := userValidateHelper(w, r, UserRPC[6][2:], UserRPC[6][1])
+		if err != nil {
+			return
+		}
+
+		prefs, err := notif.RetrievePreferences(user.Index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		prefs.Email = user.Email
+		erpc.MarshalSend(w, prefs)
+	})
+}
+
+// registerWebhook registers a webhook that receives the events of a project (projIndex) or of all the projects
+// the user is a party to. events is an optional comma separated list of the events to subscribe to. The secret
+// deliveries are signed with is only returned here
+func registerWebhook() {
+	http.HandleFunc(UserRPC[7][0], func(w http.ResponseWriter, r *http.Request) {
+		user, err := userValidateHelper(w, r, UserRPC[7][2:], UserRPC[7][1])
+		if err != nil {
+			return
+		}
+
+		projIndex := 0
+		if r.FormValue("projIndex") != "" {
+			projIndex, err = utils.ToInt(r.FormValue("projIndex"))
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
+
+			project, err := core.RetrieveProject(projIndex)
+			if err != nil {
+				log.Println(err)
+				erpc.ResponseHandler(w, erpc.StatusBadRequest)
+				return
+			}
+
+			allowed := user.Admin
+			for _, relation := range projectRelations(user, project) {
+				allowed = allowed || relation
+			}
+			if !allowed {
+				log.Println("user: ", user.Index, " isn't a party to project: ", projIndex)
+				erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+				return
+			}
+		}
+
+		var events []string
+		if r.FormValue("events") != "" {
+			events = strings.Split(r.FormValue("events"), ",")
+		}
+
+		webhook, err := core.RegisterWebhook(user.Index, projIndex, r.FormValue("url"), events)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		erpc.MarshalSend(w, webhook)
+	})
+}
+
+// getWebhooks gets the webhooks registered by the user
+func getWebhooks() {
+	http.HandleFunc(UserRPC[8][0], func(w http.ResponseWriter, r *http.Request) {
+		user, err := userValidateHelper(w, r, UserRPC[8][2:], UserRPC[8][1])
+		if err != nil {
+			return
+		}
+
+		webhooks, err := core.RetrieveUserWebhooks(user.Index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+		erpc.MarshalSend(w, webhooks)
+	})
+}
+
+// deleteWebhook deletes one of the user's webhooks
+func deleteWebhook() {
+	http.HandleFunc(UserRPC[9][0], func(w http.ResponseWriter, r *http.Request) {
+		user, err := userValidateHelper(w, r, UserRPC[9][2:], UserRPC[9][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		err = core.DeleteWebhook(user.Index, index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.ResponseHandler(w, erpc.StatusOK)
+	})
+}
+
+// getWebhookDeliveries gets the delivery log of one of the user's webhooks
+func getWebhookDeliveries() {
+	http.HandleFunc(UserRPC[10][0], func(w http.ResponseWriter, r *http.Request) {
+		user, err := userValidateHelper(w, r, UserRPC[10][2:], UserRPC[10][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.URL.Query()["index"][0])
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		webhook, err := core.RetrieveWebhook(index)
+		if err != nil || webhook.UserIndex != user.Index {
+			log.Println("webhook: ", index, " not registered by user: ", user.Index, err)
+			erpc.ResponseHandler(w, erpc.StatusUnauthorized)
+			return
+		}
+
+		deliveries, err := core.RetrieveWebhookDeliveries(index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, deliveries)
+	})
+}
+
+// redeliverWebhook attempts a delivery to one of the user's webhooks again
+func redeliverWebhook() {
+	http.HandleFunc(UserRPC[11][0], func(w http.ResponseWriter, r *http.Request) {
+		user, err := userValidateHelper(w, r, UserRPC[11][2:], UserRPC[11][1])
+		if err != nil {
+			return
+		}
+
+		index, err := utils.ToInt(r.FormValue("index"))
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+
+		delivery, err := core.RedeliverWebhook(user.Index, index)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusInternalServerError)
+			return
+		}
+
+		erpc.MarshalSend(w, delivery)
+	})
+}
@@ -0,0 +1,858 @@
+package simulate
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+	core "github.com/YaleOpenLab/opensolar/core"
+	notif "github.com/YaleOpenLab/opensolar/notif"
+	oracle "github.com/YaleOpenLab/opensolar/oracle"
+	openx "github.com/YaleOpenLab/openx/database"
+)
+
+// the simulation runs a project through all its stages by driving core: investments, paybacks and the payback
+// checks run by the scheduler go through the same functions the platform uses. Money moves on core's in-memory
+// ledger, users are kept in an in-memory user store, data is stored in a throwaway database, time moves on a
+// virtual clock and notifications are collected instead of being sent. Run swaps these into core while it runs,
+// so only one simulation can run at a time and not alongside the platform
+
+// the behaviours a recipient can have while paying back towards a project
+const (
+	// OnTime recipients pay what is due every period
+	OnTime = "ontime"
+	// Late recipients pay everything owed every Delay + 1 periods
+	Late = "late"
+	// Default recipients stop paying after DefaultAfter paybacks
+	Default = "default"
+	// EarlyPayoff recipients pay off the remaining balance with their PayoffAfter th payback
+	EarlyPayoff = "early"
+)
+
+// Investor is a synthetic investor taking part in the simulation
+type Investor struct {
+	Name   string
+	Amount float64
+	Seed   bool
+}
+
+// Scenario is the input to a simulation
+type Scenario struct {
+	// Project contains the parameters of the simulated project (TotalValue, InterestRate, PaybackPeriod, etc)
+	Project core.Project
+
+	// Tariff is the tariff schedule the recipient is billed with. The project's tariff is used if nil
+	Tariff *oracle.Schedule
+
+	// Investors are the investors who invest in the project. Seed investments are made in stage 1 and the rest
+	// in stage 4. Investments must add up to the project's total value
+	Investors []Investor
+
+	// Guarantee is the first loss guarantee posted by the project's guarantor
+	Guarantee float64
+
+	// Energy is the energy consumed by the recipient each payback period, repeated if shorter than the schedule
+	Energy []uint32
+
+	// Behaviour is the way the recipient pays back towards the project (ontime, late, default, early)
+	Behaviour string
+
+	// Delay is the number of periods a late recipient skips before paying everything owed
+	Delay int
+
+	// DefaultAfter is the number of paybacks a defaulting recipient makes before stopping
+	DefaultAfter int
+
+	// PayoffAfter is the payback with which an early recipient pays off the remaining balance
+	PayoffAfter int
+
+	// Start is the unix time at which the simulation starts
+	Start int64
+}
+
+// CashFlow is a transfer on the simulated ledger
+type CashFlow struct {
+	Time   int64
+	From   string
+	To     string
+	Asset  string
+	Amount float64
+	Memo   string
+}
+
+// Notification is a notification that would have been sent
+type Notification struct {
+	Time int64
+	Type string
+	To   string
+}
+
+// ReputationChange is a change in a party's reputation
+type ReputationChange struct {
+	Time   int64
+	Party  string
+	Stage  int
+	Change float64
+}
+
+// Result is the output of a simulation
+type Result struct {
+	Project       core.Project
+	Events        []core.ProjectEvent
+	CashFlows     []CashFlow
+	Notifications []Notification
+	Reputation    []ReputationChange
+	Balances      map[string]map[string]float64
+}
+
+// the parties and assets on the simulated ledger
+const (
+	stableAsset = "STABLEUSD"
+	external    = "external"
+	platform    = "platform"
+	escrowAcc   = "escrow"
+	issuer      = "issuer"
+	recipient   = "recipient"
+	guarantor   = "guarantor"
+	contractor  = "contractor"
+	originator  = "originator"
+	developer   = "developer"
+)
+
+// the index of the simulated project and the seedpwd of the simulated users
+const (
+	projIndex = 1
+	seedpwd   = "simulation"
+)
+
+// week is the number of seconds in a week, the interval at which core checks paybacks
+var week = int64(consts.OneWeekInSecond / time.Second)
+
+// FundingTimeout is the time core is given to fund the project once the raise completes
+var FundingTimeout = 10 * time.Second
+
+// Clock is a virtual clock that only moves forward when advanced
+type Clock struct {
+	now int64
+}
+
+// Now returns the current virtual unix time
+func (c *Clock) Now() int64 {
+	return c.now
+}
+
+// Advance moves the clock forward by the given number of seconds
+func (c *Clock) Advance(seconds int64) {
+	c.now += seconds
+}
+
+// outbox collects the notifications core sends during a simulation
+type outbox struct {
+	sync.Mutex
+	clock *Clock
+	sent  []Notification
+}
+
+// Name returns the name of the transport
+func (o *outbox) Name() string {
+	return "simulation"
+}
+
+// Send collects a notification. Simulated users' emails are their names
+func (o *outbox) Send(msg notif.Message) error {
+	o.Lock()
+	defer o.Unlock()
+	o.sent = append(o.sent, Notification{Time: o.clock.Now(), Type: msg.Template, To: msg.To})
+	return nil
+}
+
+// tariffs looks up the scenario's tariff by name and every other tariff with the provider in use
+type tariffs struct {
+	oracle.Provider
+	schedule oracle.Schedule
+}
+
+// Named returns the schedule with the given name
+func (t tariffs) Named(name string) (oracle.Schedule, error) {
+	if name == t.schedule.Name {
+		return t.schedule, nil
+	}
+	return t.Provider.Named(name)
+}
+
+// simulation holds the state of a running simulation
+type simulation struct {
+	scenario Scenario
+	clock    *Clock
+	ledger   *core.MemoryLedger
+	users    *core.MemoryUsers
+	outbox   *outbox
+	restore  func()
+
+	// names maps the publickeys on the ledger to the parties holding them
+	names map[string]string
+	// seeds maps the indices of the simulated users to their seeds
+	seeds map[int]string
+
+	recipient int
+	investors map[string]int
+	interval  int64
+	flows     int
+	result    Result
+
+	// reputations maps the indices of the simulated users to the reputation core last stored for them
+	reputations map[int]float64
+}
+
+// validate checks that a scenario can be simulated
+func (s Scenario) validate() error {
+	if s.Project.TotalValue <= 0 {
+		return errors.New("project total value must be positive, quitting")
+	}
+	if s.Project.PaybackPeriod < 0 {
+		return errors.New("payback period can't be negative, quitting")
+	}
+	if s.Guarantee < 0 {
+		return errors.New("first loss guarantee can't be negative, quitting")
+	}
+	if s.Delay < 0 {
+		return errors.New("delay can't be negative, quitting")
+	}
+	if s.DefaultAfter < 0 {
+		return errors.New("number of paybacks before defaulting can't be negative, quitting")
+	}
+
+	switch s.Behaviour {
+	case OnTime, Late, Default, "":
+	case EarlyPayoff:
+		if s.PayoffAfter < 1 {
+			return errors.New("early payoff must happen on a payback, quitting")
+		}
+	default:
+		return errors.New("unknown recipient behaviour: " + s.Behaviour)
+	}
+
+	var total float64
+	names := make(map[string]bool)
+	for _, investor := range s.Investors {
+		if investor.Name == "" {
+			return errors.New("investor doesn't have a name, quitting")
+		}
+		if names[investor.Name] {
+			return errors.New("investor " + investor.Name + " invests more than once, quitting")
+		}
+		switch investor.Name {
+		case external, platform, escrowAcc, issuer, recipient, guarantor, contractor, originator, developer:
+			return errors.New("investor can't be named " + investor.Name + ", quitting")
+		}
+		names[investor.Name] = true
+		if investor.Amount <= 0 {
+			return errors.New("investment of " + investor.Name + " must be positive, quitting")
+		}
+		total += investor.Amount
+	}
+	if math.Abs(total-s.Project.TotalValue) > 1e-9 {
+		return errors.New("investments don't add up to the project's total value, quitting")
+	}
+	return nil
+}
+
+// setup swaps the ledger, user store, clock, notification transport, tariff provider and database used by core
+// for simulated ones and sets restore to swap the originals back
+func (s *simulation) setup() error {
+	dir, err := ioutil.TempDir("", "opensolar-simulation")
+	if err != nil {
+		return errors.Wrap(err, "couldn't create simulation directory")
+	}
+
+	homeDir, dbDir, issuerDir, tariffDir := consts.HomeDir, consts.DbDir, consts.OpenSolarIssuerDir, consts.TariffDir
+	platformSeed, platformPubkey := consts.PlatformSeed, consts.PlatformPublicKey
+	stablecoinCode, stablecoinPubkey, mainnet := consts.StablecoinCode, consts.StablecoinPublicKey, consts.Mainnet
+	ledger, users, clock := core.CurrentLedger(), core.CurrentUserStore(), core.CurrentClock()
+	transport, provider := notif.CurrentTransport(), oracle.CurrentProvider()
+
+	s.restore = func() {
+		consts.HomeDir, consts.DbDir, consts.OpenSolarIssuerDir, consts.TariffDir = homeDir, dbDir, issuerDir, tariffDir
+		consts.PlatformSeed, consts.PlatformPublicKey = platformSeed, platformPubkey
+		consts.StablecoinCode, consts.StablecoinPublicKey, consts.Mainnet = stablecoinCode, stablecoinPubkey, mainnet
+		core.SetLedger(ledger)
+		core.SetUserStore(users)
+		core.SetClock(clock)
+		notif.SetTransport(transport)
+		oracle.SetProvider(provider)
+		os.RemoveAll(dir)
+	}
+
+	// tariffs are still read from the platform's tariff directory
+	if s.scenario.Tariff != nil {
+		schedule := *s.scenario.Tariff
+		if schedule.Name == "" {
+			schedule.Name = "simulation"
+		}
+		s.scenario.Project.TariffOverride = schedule.Name
+		oracle.SetProvider(tariffs{Provider: provider, schedule: schedule})
+	} else {
+		oracle.SetProvider(provider)
+	}
+
+	consts.HomeDir = dir
+	consts.DbDir = dir + "/database/"
+	consts.OpenSolarIssuerDir = dir + "/projects/"
+	consts.TariffDir = dir + "/tariffs/"
+	core.CreateHomeDir()
+
+	core.SetLedger(s.ledger)
+	core.SetUserStore(s.users)
+	core.SetClock(s.clock.Now)
+	notif.SetTransport(s.outbox)
+
+	consts.PlatformSeed, consts.PlatformPublicKey = s.ledger.NewAccount()
+	consts.StablecoinCode, consts.StablecoinPublicKey, consts.Mainnet = stableAsset, "", false
+	s.names[consts.PlatformPublicKey] = platform
+	return nil
+}
+
+// newUser creates a user with an account on the ledger. Simulated users' emails are their names
+func (s *simulation) newUser(name string) (openx.User, error) {
+	var user openx.User
+	seed, pubkey := s.ledger.NewAccount()
+	user.Index = len(s.users.Users) + 1
+	user.Name = name
+	user.Username = name
+	user.Email = name
+	user.Notification = true
+	user.StellarWallet.PublicKey = pubkey
+	user.StellarWallet.EncryptedSeed = []byte(seed) // the in-memory ledger doesn't encrypt seeds
+	s.names[pubkey] = name
+	s.seeds[user.Index] = seed
+	return user, s.users.Add(user)
+}
+
+// newEntity creates an entity
+func (s *simulation) newEntity(name string) (core.Entity, error) {
+	var entity core.Entity
+	user, err := s.newUser(name)
+	if err != nil {
+		return entity, err
+	}
+	entity.U = &user
+	switch name {
+	case guarantor:
+		entity.Guarantor = true
+	case contractor:
+		entity.Contractor = true
+	case originator:
+		entity.Originator = true
+	default:
+		entity.Developer = true
+	}
+	return entity, entity.Save()
+}
+
+// parties creates the recipient, investors and entities taking part in the simulation and the project
+func (s *simulation) parties() error {
+	user, err := s.newUser(recipient)
+	if err != nil {
+		return err
+	}
+	recp := core.Recipient{U: &user}
+	err = recp.Save()
+	if err != nil {
+		return errors.Wrap(err, "couldn't save recipient")
+	}
+	s.recipient = user.Index
+
+	for _, x := range s.scenario.Investors {
+		user, err := s.newUser(x.Name)
+		if err != nil {
+			return err
+		}
+		investor := core.Investor{U: &user}
+		err = investor.Save()
+		if err != nil {
+			return errors.Wrap(err, "couldn't save investor")
+		}
+		s.investors[x.Name] = user.Index
+	}
+
+	entity, err := s.newEntity(guarantor)
+	if err != nil {
+		return errors.Wrap(err, "couldn't save guarantor")
+	}
+	if s.scenario.Guarantee > 0 {
+		err = s.ledger.Mint(entity.U.StellarWallet.PublicKey, stableAsset, s.scenario.Guarantee)
+		if err != nil {
+			return err
+		}
+	}
+	err = entity.AddFirstLossGuarantee(seedpwd, s.scenario.Guarantee)
+	if err != nil {
+		return errors.Wrap(err, "couldn't add first loss guarantee")
+	}
+
+	project := s.scenario.Project
+	project.Index = projIndex
+	project.RecipientIndex = s.recipient
+	project.GuarantorIndex = entity.U.Index
+
+	entity, err = s.newEntity(contractor)
+	if err != nil {
+		return errors.Wrap(err, "couldn't save contractor")
+	}
+	project.ContractorIndex = entity.U.Index
+	entity, err = s.newEntity(originator)
+	if err != nil {
+		return errors.Wrap(err, "couldn't save originator")
+	}
+	project.OriginatorIndex = entity.U.Index
+	project.DeveloperIndices = nil
+	for range project.DeveloperFee {
+		entity, err = s.newEntity(developer)
+		if err != nil {
+			return errors.Wrap(err, "couldn't save developer")
+		}
+		project.DeveloperIndices = append(project.DeveloperIndices, entity.U.Index)
+	}
+
+	if project.InvestmentType == "" {
+		project.InvestmentType = "munibond"
+	}
+	if project.SeedInvestmentFactor == 0 {
+		project.SeedInvestmentFactor = 1
+	}
+	if project.PaybackPeriod == 0 {
+		project.PaybackPeriod = 4 // monthly paybacks if the payback period isn't set
+	}
+	if project.SeedInvestmentCap == 0 {
+		for _, x := range s.scenario.Investors {
+			if x.Seed {
+				project.SeedInvestmentCap = math.Max(project.SeedInvestmentCap, x.Amount)
+			}
+		}
+	}
+	project.Stage = 0
+	project.Chain = ""
+	project.MoneyRaised = 0
+	project.SeedMoneyRaised = 0
+	project.InvestorMap = make(map[string]float64)
+	project.SeedInvestorMap = make(map[string]float64)
+	project.InvestorIndices = nil
+	project.SeedInvestorIndices = nil
+	project.Schedule = nil
+	s.interval = int64(project.PaybackPeriod) * week
+	return project.Save()
+}
+
+// project retrieves the simulated project
+func (s *simulation) project() (core.Project, error) {
+	project, err := core.RetrieveProject(projIndex)
+	if err != nil {
+		return project, errors.Wrap(err, "couldn't retrieve project")
+	}
+	return project, nil
+}
+
+// collect converts the transfers made on the ledger since it was last called into cash flows at the current time
+func (s *simulation) collect() {
+	s.ledger.Lock()
+	defer s.ledger.Unlock()
+	name := func(pubkey string) string {
+		if pubkey == "" {
+			return external
+		}
+		if x, exists := s.names[pubkey]; exists {
+			return x
+		}
+		return pubkey
+	}
+	for _, x := range s.ledger.Transfers[s.flows:] {
+		s.result.CashFlows = append(s.result.CashFlows, CashFlow{Time: s.clock.Now(), From: name(x.From), To: name(x.To),
+			Asset: x.Asset, Amount: x.Amount, Memo: x.Memo})
+	}
+	s.flows = len(s.ledger.Transfers)
+}
+
+// promote ticks every activity of the project's current stage as the party responsible for it and promotes the
+// project to the next stage through core
+func (s *simulation) promote(stage int) error {
+	project, err := s.project()
+	if err != nil {
+		return err
+	}
+	if project.Stage != stage-1 {
+		return errors.New("can't promote project from stage " + strconv.Itoa(project.Stage) + " to stage " + strconv.Itoa(stage))
+	}
+
+	current, err := core.RetrieveStage(project.Stage)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve stage")
+	}
+	for i, activity := range current.Activities {
+		_, err = core.TickActivity(projIndex, current.Number, i, s.responsible(project, activity), true, "", "simulation", "")
+		if err != nil {
+			return errors.Wrap(err, "couldn't tick stage activity")
+		}
+	}
+
+	err = core.StageXtoY(projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't promote project")
+	}
+	return s.reputation(stage)
+}
+
+// responsible returns the index of the user responsible for a stage activity
+func (s *simulation) responsible(project core.Project, activity string) int {
+	for _, party := range core.ActivityParties(activity) {
+		switch party {
+		case core.PartyOriginator:
+			return project.OriginatorIndex
+		case core.PartyDeveloper:
+			if len(project.DeveloperIndices) != 0 {
+				return project.DeveloperIndices[0]
+			}
+		case core.PartyContractor:
+			return project.ContractorIndex
+		case core.PartyGuarantor:
+			return project.GuarantorIndex
+		case core.PartyInvestor:
+			if len(project.InvestorIndices) != 0 {
+				return project.InvestorIndices[0]
+			}
+		}
+	}
+	return s.recipient
+}
+
+// reputation records the changes in the reputation core stores for the simulated users since it was last called
+func (s *simulation) reputation(stage int) error {
+	s.users.Lock()
+	defer s.users.Unlock()
+
+	var indices []int
+	for index := range s.users.Users {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	for _, index := range indices {
+		user := s.users.Users[index]
+		change := user.Reputation - s.reputations[index]
+		if change == 0 {
+			continue
+		}
+		s.reputations[index] = user.Reputation
+		s.result.Reputation = append(s.result.Reputation, ReputationChange{Time: s.clock.Now(), Party: user.Name, Stage: stage, Change: change})
+	}
+	return nil
+}
+
+// invest makes all seed or regular investments through core
+func (s *simulation) invest(seed bool) error {
+	for _, x := range s.scenario.Investors {
+		if x.Seed != seed {
+			continue
+		}
+		index := s.investors[x.Name]
+		user, err := s.users.RetrieveUser(index)
+		if err != nil {
+			return err
+		}
+		err = s.ledger.Mint(user.StellarWallet.PublicKey, stableAsset, x.Amount)
+		if err != nil {
+			return err
+		}
+		err = core.Invest(projIndex, index, x.Amount, s.seeds[index])
+		if err != nil {
+			return errors.Wrap(err, "investment of "+x.Name+" failed")
+		}
+		s.collect()
+	}
+	return nil
+}
+
+// funded waits for core to fund the project, which it does in the background once the raise completes and the
+// recipient has unlocked the project
+func (s *simulation) funded() error {
+	for start := time.Now(); time.Since(start) < FundingTimeout; time.Sleep(10 * time.Millisecond) {
+		project, err := s.project()
+		if err != nil {
+			return err
+		}
+		if project.Stage < core.Stage5.Number {
+			continue
+		}
+		// payback checks are scheduled last
+		jobs, err := core.RetrieveAllJobs()
+		if err != nil {
+			return errors.Wrap(err, "couldn't retrieve jobs")
+		}
+		for _, job := range jobs {
+			if job.Type == core.JobPaybackCheck && job.ProjIndex == projIndex {
+				s.names[project.EscrowPubkey] = escrowAcc
+				pubkey, _, err := s.ledger.RetrieveIssuer(consts.OpenSolarIssuerDir, projIndex, consts.IssuerSeedPwd)
+				if err == nil {
+					s.names[pubkey] = issuer
+				}
+				return nil
+			}
+		}
+	}
+	return errors.New("core didn't fund the project, quitting")
+}
+
+// payment returns the amount the recipient pays at the end of a period given the amount due for the period
+// and the amount owed in total
+func (s *simulation) payment(project core.Project, period int, due float64, owed float64, paybacks int) float64 {
+	switch s.scenario.Behaviour {
+	case Late:
+		if (period+1)%(s.scenario.Delay+1) == 0 {
+			return owed
+		}
+		return 0
+	case Default:
+		if paybacks < s.scenario.DefaultAfter {
+			return due
+		}
+		return 0
+	case EarlyPayoff:
+		if paybacks+1 == s.scenario.PayoffAfter {
+			var interest float64
+			for _, x := range project.Schedule[:period+1] {
+				interest += x.Interest - x.InterestPaid
+			}
+			return project.BalLeft + interest
+		}
+		return due
+	default:
+		return due
+	}
+}
+
+// bill records the energy the recipient consumed during a period and returns what core bills for the energy
+// consumed since the recipient last paid
+func (s *simulation) bill(project core.Project, period int) (float64, error) {
+	if len(s.scenario.Energy) != 0 {
+		var reading core.EnergyReading
+		reading.ProjIndex = projIndex
+		reading.DeviceID = "simulation"
+		reading.Timestamp = s.clock.Now() - s.interval/2
+		reading.Consumed = float64(s.scenario.Energy[period%len(s.scenario.Energy)])
+		reading.Source = "simulation"
+		_, err := core.RecordReading(reading)
+		if err != nil {
+			return 0, errors.Wrap(err, "couldn't record energy reading")
+		}
+	}
+
+	end := s.clock.Now()
+	start := project.DateLastPaid
+	if start == 0 || start >= end {
+		start = end - s.interval
+	}
+	return project.MonthlyBill(project.EnergyConsumed(start, end, 0), start, end)
+}
+
+// Run runs a scenario and returns the result of the simulation
+func Run(scenario Scenario) (Result, error) {
+	var result Result
+	err := scenario.validate()
+	if err != nil {
+		return result, err
+	}
+
+	var s simulation
+	s.scenario = scenario
+	s.clock = &Clock{now: scenario.Start}
+	s.ledger = core.NewMemoryLedger()
+	s.users = core.NewMemoryUsers()
+	s.outbox = &outbox{clock: s.clock}
+	s.names = make(map[string]string)
+	s.seeds = make(map[int]string)
+	s.investors = make(map[string]int)
+	s.reputations = make(map[int]float64)
+
+	err = s.setup()
+	if err != nil {
+		return result, err
+	}
+	defer s.restore()
+
+	err = s.parties()
+	if err != nil {
+		return result, errors.Wrap(err, "couldn't set up simulation")
+	}
+	s.collect()
+
+	err = s.run()
+	s.collect()
+	if project, rerr := s.project(); rerr == nil {
+		s.result.Project = project
+	}
+	if events, rerr := core.RetrieveProjectEvents(projIndex); rerr == nil {
+		s.result.Events = events
+	}
+
+	s.outbox.Lock()
+	s.result.Notifications = s.outbox.sent
+	s.outbox.Unlock()
+
+	s.result.Balances = make(map[string]map[string]float64)
+	s.ledger.Lock()
+	for pubkey, balances := range s.ledger.Balances {
+		name, exists := s.names[pubkey]
+		if !exists {
+			name = pubkey
+		}
+		s.result.Balances[name] = make(map[string]float64)
+		for asset, balance := range balances {
+			s.result.Balances[name][asset] = balance
+		}
+	}
+	s.ledger.Unlock()
+	return s.result, err
+}
+
+// run runs the simulation from stage 0 to the end of the project
+func (s *simulation) run() error {
+	// stages 0 to 4, with seed investments in stage 1 and regular investments in stage 4
+	for stage := 1; stage <= 4; stage++ {
+		err := s.promote(stage)
+		if err != nil {
+			return err
+		}
+		if stage == 1 {
+			err = s.invest(true)
+			if err != nil {
+				return err
+			}
+		}
+		s.clock.Advance(s.interval)
+	}
+
+	// the recipient unlocks the project in advance so core funds it as soon as the raise completes
+	recp, err := core.RetrieveRecipient(s.recipient)
+	if err != nil {
+		return errors.Wrap(err, "couldn't retrieve recipient")
+	}
+	err = recp.SetOneTimeUnlock(projIndex, seedpwd)
+	if err != nil {
+		return errors.Wrap(err, "couldn't unlock project")
+	}
+
+	err = s.invest(false)
+	if err != nil {
+		return err
+	}
+	err = s.funded()
+	if err != nil {
+		return err
+	}
+	s.collect()
+
+	// construction is done within the first period
+	s.clock.Advance(s.interval / 2)
+	project, err := s.project()
+	if err != nil {
+		return err
+	}
+	err = core.RepInstalledProject(project.ContractorIndex, projIndex)
+	if err != nil {
+		return errors.Wrap(err, "couldn't record installation")
+	}
+	err = s.reputation(core.Stage5.Number)
+	if err != nil {
+		return err
+	}
+	err = s.promote(core.Stage6.Number)
+	if err != nil {
+		return err
+	}
+	s.clock.Advance(s.interval - s.interval/2)
+
+	project, err = s.project()
+	if err != nil {
+		return err
+	}
+
+	var owed float64
+	paybacks := 0
+	limit := len(project.Schedule) + s.scenario.Delay + core.DisconnectionThreshold + 1
+
+	for period := 0; period < limit; period++ {
+		bill, err := s.bill(project, period)
+		if err != nil {
+			return errors.Wrap(err, "couldn't compute recipient's bill")
+		}
+
+		var due float64
+		if period < len(project.Schedule) {
+			due = math.Max(project.Schedule[period].Payment, bill)
+			owed += due
+		}
+
+		amount := s.payment(project, period, due, owed, paybacks)
+		if amount > 0 {
+			amount = math.Max(amount, bill)
+			user, err := s.users.RetrieveUser(s.recipient)
+			if err != nil {
+				return err
+			}
+			err = s.ledger.Mint(user.StellarWallet.PublicKey, stableAsset, amount)
+			if err != nil {
+				return err
+			}
+			err = core.Payback(s.recipient, projIndex, project.DebtAssetCode, amount, s.seeds[s.recipient])
+			if err != nil {
+				return errors.Wrap(err, "payback failed")
+			}
+			s.collect()
+			owed = math.Max(owed-amount, 0)
+			paybacks++
+
+			project, err = s.project()
+			if err != nil {
+				return err
+			}
+			if project.Stage == core.Stage6.Number {
+				err = s.promote(core.Stage7.Number)
+				if err != nil {
+					return err
+				}
+			}
+			if project.Stage == core.Stage9.Number {
+				log.Println("simulated project paid off after ", paybacks, " paybacks")
+				return nil
+			}
+		}
+
+		// the scheduler checks paybacks every week
+		for elapsed := int64(0); elapsed < s.interval; elapsed += week {
+			s.clock.Advance(week)
+			err = core.RunDueJobs(s.clock.Now())
+			if err != nil {
+				return errors.Wrap(err, "couldn't run scheduled jobs")
+			}
+			s.collect()
+		}
+
+		project, err = s.project()
+		if err != nil {
+			return err
+		}
+		if project.Delinquency == core.DelinquencyDefaulted && s.scenario.Behaviour == Default {
+			log.Println("simulated recipient defaulted after ", paybacks, " paybacks")
+			return nil
+		}
+	}
+
+	return nil
+}
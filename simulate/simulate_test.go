@@ -0,0 +1,114 @@
+// +build all travis
+
+package simulate
+
+import (
+	"testing"
+
+	core "github.com/YaleOpenLab/opensolar/core"
+)
+
+func testScenario(behaviour string) Scenario {
+	var scenario Scenario
+	scenario.Project.TotalValue = 10000
+	scenario.Project.InterestRate = 0.05
+	scenario.Project.PaybackPeriod = 4
+	scenario.Project.EstimatedAcquisition = 2
+	scenario.Investors = []Investor{
+		{Name: "seed", Amount: 2000, Seed: true},
+		{Name: "alice", Amount: 5000},
+		{Name: "bob", Amount: 3000},
+	}
+	scenario.Guarantee = 1000
+	scenario.Energy = []uint32{100}
+	scenario.Behaviour = behaviour
+	scenario.Delay = 2
+	scenario.DefaultAfter = 3
+	scenario.PayoffAfter = 5
+	scenario.Start = 1500000000
+	return scenario
+}
+
+func TestRun(t *testing.T) {
+	for _, behaviour := range []string{OnTime, Late, EarlyPayoff} {
+		result, err := Run(testScenario(behaviour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Project.Stage != core.Stage9.Number || result.Project.BalLeft > 1e-6 {
+			t.Fatalf("%s: project not paid off, stage: %d, balance: %f", behaviour, result.Project.Stage, result.Project.BalLeft)
+		}
+		if result.Balances["alice"][stableAsset] <= 0 {
+			t.Fatalf("%s: investor didn't receive any payouts", behaviour)
+		}
+
+		var reputation float64
+		for _, change := range result.Reputation {
+			if change.Party == "alice" && change.Stage == core.Stage5.Number {
+				reputation += change.Change
+			}
+		}
+		if reputation != result.Project.TotalValue*core.InvestorWeight || len(result.Project.StageEvidence) == 0 {
+			t.Fatalf("%s: stored reputation not reported or stages not promoted through core: %v", behaviour, result.Reputation)
+		}
+	}
+
+	result, err := Run(testScenario(Default))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Project.Stage != core.Stage7.Number {
+		t.Fatalf("defaulting project moved to stage %d", result.Project.Stage)
+	}
+	var covered bool
+	for _, event := range result.Events {
+		if event.Type == core.EventFirstLossCovered && event.Amount > 0 {
+			covered = true
+		}
+	}
+	if !covered || result.Project.Delinquency != core.DelinquencyDefaulted {
+		t.Fatalf("guarantor didn't cover first loss")
+	}
+
+	for _, event := range result.Events {
+		if event.Type == core.EventInvestmentReceived && event.UserIndex == 0 {
+			t.Fatalf("investment recorded without the investor's index")
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	scenario := testScenario(OnTime)
+	scenario.Investors = scenario.Investors[1:]
+	_, err := Run(scenario)
+	if err == nil {
+		t.Fatalf("investments not adding up to the total value accepted")
+	}
+
+	scenario = testScenario(Late)
+	scenario.Delay = -2
+	_, err = Run(scenario)
+	if err == nil {
+		t.Fatalf("negative delay accepted")
+	}
+
+	scenario = testScenario(EarlyPayoff)
+	scenario.PayoffAfter = 0
+	_, err = Run(scenario)
+	if err == nil {
+		t.Fatalf("early payoff without a payback accepted")
+	}
+
+	scenario = testScenario("sometimes")
+	_, err = Run(scenario)
+	if err == nil {
+		t.Fatalf("unknown behaviour accepted")
+	}
+
+	scenario = testScenario(OnTime)
+	scenario.Investors[1].Name = "bob"
+	_, err = Run(scenario)
+	if err == nil {
+		t.Fatalf("investor investing twice accepted")
+	}
+}
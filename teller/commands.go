@@ -69,7 +69,7 @@ func ParseInput(input []string) {
 		}
 	case "display":
 		if len(input) < 2 {
-			fmt.Println("USAGE: display <balance, info>")
+			fmt.Println("USAGE: display <balance, info, queue>")
 			return
 		}
 		subcommand := input[1]
@@ -117,6 +117,9 @@ func ParseInput(input []string) {
 			fmt.Println("          Balance Left: ", LocalProject.BalLeft)
 			fmt.Println("          Date Initiated: ", LocalProject.DateInitiated)
 			fmt.Println("          Date Last Paid: ", LocalProject.DateLastPaid)
+			fmt.Println("          Queue Depth: ", queueDepth())
+		case "queue":
+			displayQueue()
 		default:
 			// handle defaults here
 			log.Println("Invalid command or need more parameters")
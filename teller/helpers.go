@@ -3,11 +3,15 @@ package main
 import (
 	"bufio"
 	//"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -95,10 +99,11 @@ func checkPayback() {
 		assetName := LocalProject.DebtAssetCode
 		amount := oracle.MonthlyBill() // TODO: consumption data must be accumulated from zigbee in the future
 
+		// paybacks that can't reach the platform stay queued and are made once connectivity returns, the
+		// failure email is only sent if the platform refuses the payback
 		err := projectPayback(assetName, amount)
 		if err != nil {
-			log.Println("Error while paying amount back", err)
-			sendDevicePaybackFailedEmail()
+			log.Println("Error while queueing payback", err)
 		}
 		time.Sleep(time.Duration(LocalProject.PaybackPeriod) * consts.OneWeekInSecond)
 	}
@@ -335,12 +340,65 @@ func checkDeviceID() error {
 			return errors.Wrap(err, "could not write device id to file")
 		}
 		file.Close()
-		err = setDeviceId(LocalRecipient.U.Username, deviceId)
+	}
+	return nil
+}
+
+// checkDeviceKey loads the key the teller signs readings with, generating one if the teller doesn't have one
+// yet, and registers it along with the device id on the remote platform. The platform refuses a new key
+// for a teller that has registered a different one until an admin resets it
+func checkDeviceKey(deviceId string) (ed25519.PrivateKey, error) {
+	path := consts.TellerHomeDir + "/devicekey.hex"
+	var key ed25519.PrivateKey
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not generate device key")
+		}
+		err = ioutil.WriteFile(path, []byte(hex.EncodeToString(key.Seed())), 0600)
 		if err != nil {
-			return errors.Wrap(err, "could not store device id in remote platform")
+			return nil, errors.Wrap(err, "could not write device key to file")
+		}
+		colorOutput("GENERATED DEVICE KEY: "+hex.EncodeToString(key.Public().(ed25519.PublicKey)), GreenColor)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not read device key")
+	} else {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, errors.New("device key file is corrupted, quitting")
 		}
+		key = ed25519.NewKeyFromSeed(seed)
 	}
-	return nil
+
+	err = setDeviceId(deviceId, hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not store device id and key in remote platform")
+	}
+	return key, nil
+}
+
+// nextSeq returns the sequence number of the next reading batch. The sequence number is persisted before it is
+// used so a batch is never signed twice with the same number, even if the teller restarts
+func nextSeq() (uint64, error) {
+	path := consts.TellerHomeDir + "/seq"
+	var seq uint64
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		seq, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "sequence number file is corrupted")
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, errors.Wrap(err, "could not read sequence number")
+	}
+
+	seq++
+	err = ioutil.WriteFile(path, []byte(strconv.FormatUint(seq, 10)), 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not write sequence number")
+	}
+	return seq, nil
 }
 
 // getDeviceID retrieves the deviceId from storage
@@ -507,11 +565,10 @@ func readEnergyData() {
 
 		// need to update remote with the energy data
 		log.Println("storing energy data on opensolar")
-		data, err := putEnergy(EnergyValue)
+		err = putEnergy(EnergyValue)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
-		log.Println(string(data))
 	}
 }
@@ -75,6 +75,17 @@ func StartTeller() error {
 		return errors.Wrap(err, "could not get device id from local storage")
 	}
 
+	DeviceKey, err = checkDeviceKey(DeviceId) // Stores DeviceKey
+	if err != nil {
+		return errors.Wrap(err, "could not check device key")
+	}
+
+	// load calls queued while the teller was offline so they're sent once the teller is back up
+	err = loadQueue()
+	if err != nil {
+		return errors.Wrap(err, "could not load queue")
+	}
+
 	err = storeStartTime()
 	if err != nil {
 		return errors.Wrap(err, "could not store start time locally")
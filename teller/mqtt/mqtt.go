@@ -40,29 +40,51 @@ func PublishMessage(mqttopts *mqtt.ClientOptions) error {
 	return nil
 }
 
-func SubscribeMessage(mqttopts *mqtt.ClientOptions, topic string, qos int, num int) error {
+// SubscribeMessage subscribes to a topic and waits for num messages, a num of zero keeps listening indefinitely.
+// The subscription is renewed whenever the client reconnects to the broker. handler (if not nil) is called with
+// the payload of each message received
+func SubscribeMessage(mqttopts *mqtt.ClientOptions, topic string, qos int, num int, handler func([]byte)) error {
 	receiveCount := 0
 	receiver := make(chan [2]string)
 	var messages []string
 
+	subscribed := make(chan error, 1)
+
 	mqttopts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
 		receiver <- [2]string{msg.Topic(), string(msg.Payload())}
 	})
+	mqttopts.SetAutoReconnect(true)
+	mqttopts.SetOnConnectHandler(func(client mqtt.Client) {
+		token := client.Subscribe(topic, byte(qos), nil)
+		token.Wait()
+		if token.Error() != nil {
+			log.Println("couldn't subscribe to topic: ", topic, token.Error())
+		}
+		select {
+		case subscribed <- token.Error():
+		default:
+		}
+	})
 
 	client := mqtt.NewClient(mqttopts)
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 
-	token := client.Subscribe(topic, byte(qos), nil)
-	if token.Wait() && token.Error() != nil {
-		return token.Error()
+	if err := <-subscribed; err != nil {
+		client.Disconnect(250)
+		return err
 	}
 
-	for receiveCount < num {
+	for num <= 0 || receiveCount < num {
 		incoming := <-receiver
-		messages = append(messages, incoming[1])
 		log.Printf("RECEIVED TOPIC: %s MESSAGE: %s\n", incoming[0], incoming[1])
+		if num > 0 {
+			messages = append(messages, incoming[1])
+		}
+		if handler != nil {
+			handler([]byte(incoming[1]))
+		}
 		receiveCount++
 	}
 
@@ -139,7 +161,7 @@ func test() {
 			log.Fatal(err)
 		}
 	} else {
-		err = SubscribeMessage(mqttopts, opts.Topic, opts.Qos, opts.Num)
+		err = SubscribeMessage(mqttopts, opts.Topic, opts.Qos, opts.Num, nil)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	utils "github.com/Varunram/essentials/utils"
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// calls to the platform that change its state (paybacks, energy batches and state hashes) are stored in a
+// durable local queue before they're sent, so they survive the teller losing connectivity or restarting. The
+// queue is drained in order and an entry is only removed once the platform has accepted it. Every entry has an
+// idempotency key, entries with a key that has been queued before are ignored and the platform ignores
+// paybacks and state hashes it has already received
+
+// the types of calls queued
+const (
+	queuePayback      = "payback"
+	queueEnergy       = "energy"
+	queueStateHistory = "statehistory"
+)
+
+// QueueEntry is a call to the platform waiting to be sent
+type QueueEntry struct {
+	// Key is the idempotency key of the call
+	Key string
+
+	// Type is the type of the call (payback, energy or statehistory)
+	Type string
+
+	// Params are the params the call is made with
+	Params map[string]string
+
+	// Created is the unix time at which the call was queued
+	Created int64
+
+	// Attempts is the number of times the platform refused the call
+	Attempts int
+
+	// LastError is the error returned by the last attempt
+	LastError string
+}
+
+// queueState is the queue as stored on disk
+type queueState struct {
+	// Entries are the calls waiting to be sent in the order they were queued
+	Entries []QueueEntry
+
+	// Sent are the idempotency keys of the last calls sent, so they aren't queued again
+	Sent []string
+}
+
+var (
+	// queue is the queue of calls waiting to be sent
+	queue queueState
+	// queueLock protects the queue
+	queueLock sync.Mutex
+	// drainLock makes sure the queue isn't drained twice at the same time
+	drainLock sync.Mutex
+	// queueDrainInterval is the interval at which the teller tries to drain the queue
+	queueDrainInterval = 30 * time.Second
+	// queueMaxAttempts is the number of times a call can be refused by the platform before it is dropped.
+	// Calls that don't reach the platform are never dropped
+	queueMaxAttempts = 10
+	// queueMaxSent is the number of idempotency keys of sent calls remembered
+	queueMaxSent = 1000
+	// sendQueued makes a queued call to the platform
+	sendQueued = sendEntry
+	// relogin logs on to the platform again once the teller's token is no longer accepted
+	relogin = func() error {
+		return login(loginUsername, loginPwhash)
+	}
+)
+
+// statusError is returned when the platform responds to a call with a status other than 200
+type statusError struct {
+	Code int
+}
+
+func (e statusError) Error() string {
+	return "platform responded with code: " + strconv.Itoa(e.Code)
+}
+
+// permanent returns true if the platform refused a call in a way that won't change if the call is retried.
+// Calls refused because the teller's token expired aren't, they're retried after logging on again
+func permanent(err error) bool {
+	x, ok := err.(statusError)
+	return ok && x.Code >= 400 && x.Code < 500 && x.Code != http.StatusUnauthorized
+}
+
+// unauthorized returns true if the platform refused a call because the teller's token expired or was revoked
+func unauthorized(err error) bool {
+	x, ok := err.(statusError)
+	return ok && x.Code == http.StatusUnauthorized
+}
+
+// queuePath returns the path of the file the queue is stored in
+func queuePath() string {
+	return consts.TellerHomeDir + "/queue.json"
+}
+
+// loadQueue loads the queue from disk
+func loadQueue() error {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+
+	data, err := ioutil.ReadFile(queuePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not read queue")
+	}
+	return json.Unmarshal(data, &queue)
+}
+
+// saveQueue writes the queue to disk. The queue is written to a temporary file first so a crash can't leave a
+// partially written queue behind. The lock must be held
+func saveQueue() error {
+	data, err := json.Marshal(queue)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal queue")
+	}
+
+	tmp := queuePath() + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "could not write queue")
+	}
+	return os.Rename(tmp, queuePath())
+}
+
+// queueDepth returns the number of calls waiting to be sent
+func queueDepth() int {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	return len(queue.Entries)
+}
+
+// queuedEntries returns a copy of the calls waiting to be sent
+func queuedEntries() []QueueEntry {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	return append([]QueueEntry(nil), queue.Entries...)
+}
+
+// enqueue queues a call to the platform and tries to drain the queue. Calls with an idempotency key that has
+// been queued before are ignored
+func enqueue(callType string, key string, params map[string]string) error {
+	queueLock.Lock()
+	for _, x := range queue.Sent {
+		if x == key {
+			queueLock.Unlock()
+			log.Println("call already sent: ", key)
+			return nil
+		}
+	}
+	for _, x := range queue.Entries {
+		if x.Key == key {
+			queueLock.Unlock()
+			log.Println("call already queued: ", key)
+			return nil
+		}
+	}
+
+	queue.Entries = append(queue.Entries, QueueEntry{Key: key, Type: callType, Params: params, Created: utils.Unix()})
+	err := saveQueue()
+	queueLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	drainQueue()
+	return nil
+}
+
+// sendEntry makes a queued call to the platform
+func sendEntry(entry QueueEntry) error {
+	switch entry.Type {
+	case queuePayback:
+		return sendPayback(entry.Params["assetName"], entry.Params["amount"], entry.Key)
+	case queueEnergy:
+		return sendEnergy(entry.Params["batch"], entry.Params["signature"])
+	case queueStateHistory:
+		return sendStateHistory(entry.Params["hash"])
+	}
+	return errors.New("unknown call type: " + entry.Type)
+}
+
+// drainQueue sends the queued calls to the platform in order. Draining stops at the first call that couldn't
+// be sent so calls never reach the platform out of order. The queue isn't locked while a call is being made so
+// it can still be displayed while the platform is unreachable
+func drainQueue() {
+	drainLock.Lock()
+	defer drainLock.Unlock()
+
+	for {
+		queueLock.Lock()
+		if len(queue.Entries) == 0 {
+			queueLock.Unlock()
+			return
+		}
+		entry := queue.Entries[0]
+		queueLock.Unlock()
+
+		err := sendQueued(entry)
+		if unauthorized(err) {
+			// log on again and retry the call with the new token. If the platform still doesn't accept the
+			// teller the call stays in the queue until it does
+			log.Println("token refused by the platform, logging on again")
+			err = relogin()
+			if err != nil {
+				err = errors.Wrap(err, "couldn't log on to the platform")
+			} else {
+				err = sendQueued(entry)
+			}
+		}
+
+		dropped := false
+		if err != nil {
+			entry.LastError = err.Error()
+			_, refused := err.(statusError)
+			if refused && !unauthorized(err) {
+				entry.Attempts++
+			}
+			dropped = permanent(err) || entry.Attempts >= queueMaxAttempts
+		}
+
+		queueLock.Lock()
+		if err != nil && !dropped {
+			// the platform is unreachable or couldn't process the call, try again later
+			log.Println("couldn't send queued call: ", entry.Key, err)
+			queue.Entries[0] = entry
+			saveQueue()
+			queueLock.Unlock()
+			return
+		}
+
+		queue.Entries = queue.Entries[1:]
+		queue.Sent = append(queue.Sent, entry.Key)
+		if len(queue.Sent) > queueMaxSent {
+			queue.Sent = queue.Sent[len(queue.Sent)-queueMaxSent:]
+		}
+		err = saveQueue()
+		queueLock.Unlock()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		if dropped {
+			log.Println("dropped queued call refused by the platform: ", entry.Key, entry.LastError)
+			colorOutput("DROPPED QUEUED CALL: "+entry.Key, RedColor)
+			if entry.Type == queuePayback {
+				sendDevicePaybackFailedEmail()
+			}
+		}
+	}
+}
+
+// drainRoutine drains the queue periodically so calls queued while the teller was offline are sent once
+// connectivity returns
+func drainRoutine() {
+	for {
+		time.Sleep(queueDrainInterval)
+		if queueDepth() != 0 {
+			drainQueue()
+		}
+	}
+}
+
+// displayQueue prints the calls waiting to be sent
+func displayQueue() {
+	entries := queuedEntries()
+	fmt.Println("          QUEUE DEPTH: ", len(entries))
+	for _, entry := range entries {
+		fmt.Println("          ", entry.Key, " queued at: ", entry.Created, " attempts: ", entry.Attempts,
+			" last error: ", entry.LastError)
+	}
+}
@@ -0,0 +1,155 @@
+// +build all travis
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	consts "github.com/YaleOpenLab/opensolar/consts"
+)
+
+// testQueue stores the queue in a temporary directory and sends queued calls with send. The returned function
+// restores the previous queue
+func testQueue(t *testing.T, send func(QueueEntry) error) func() {
+	dir, err := ioutil.TempDir("", "teller")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homeDir, oldQueue, oldSend, oldRelogin := consts.TellerHomeDir, queue, sendQueued, relogin
+	consts.TellerHomeDir = dir
+	queue = queueState{}
+	sendQueued = send
+	relogin = func() error { return errors.New("login not expected") }
+
+	return func() {
+		consts.TellerHomeDir, queue, sendQueued, relogin = homeDir, oldQueue, oldSend, oldRelogin
+		os.RemoveAll(dir)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	var sent []string
+	online := false
+	defer testQueue(t, func(entry QueueEntry) error {
+		if !online {
+			return errors.New("platform unreachable")
+		}
+		sent = append(sent, entry.Key)
+		return nil
+	})()
+
+	for _, key := range []string{"1", "2", "3"} {
+		err := enqueue(queueEnergy, key, map[string]string{"batch": key})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sent) != 0 || queueDepth() != 3 {
+		t.Fatalf("calls not queued while the platform is unreachable: %v %d", sent, queueDepth())
+	}
+
+	// the queue survives the teller restarting
+	queue = queueState{}
+	err := loadQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := queuedEntries()
+	if len(entries) != 3 || entries[0].Key != "1" || entries[2].Params["batch"] != "3" {
+		t.Fatalf("queue not loaded from disk: %+v", entries)
+	}
+
+	online = true
+	drainQueue()
+	if len(sent) != 3 || sent[0] != "1" || sent[1] != "2" || sent[2] != "3" || queueDepth() != 0 {
+		t.Fatalf("queued calls not sent in order: %v", sent)
+	}
+
+	// calls sent or queued before aren't queued again
+	err = enqueue(queueEnergy, "2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	online = false
+	for i := 0; i < 2; i++ {
+		err = enqueue(queueEnergy, "4", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sent) != 3 || queueDepth() != 1 {
+		t.Fatalf("duplicate calls queued: %v %d", sent, queueDepth())
+	}
+}
+
+func TestQueueRefused(t *testing.T) {
+	var sent []string
+	codes := map[string][]int{}
+	defer testQueue(t, func(entry QueueEntry) error {
+		if len(codes[entry.Key]) != 0 {
+			code := codes[entry.Key][0]
+			codes[entry.Key] = codes[entry.Key][1:]
+			return statusError{Code: code}
+		}
+		sent = append(sent, entry.Key)
+		return nil
+	})()
+
+	// calls refused because the token expired are sent again after logging on
+	logins := 0
+	relogin = func() error {
+		logins++
+		return nil
+	}
+	codes["1"] = []int{401}
+	err := enqueue(queueEnergy, "1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins != 1 || len(sent) != 1 || queueDepth() != 0 {
+		t.Fatalf("call not retried after logging on: %d %v", logins, sent)
+	}
+
+	// and kept if the teller can't log on
+	relogin = func() error { return errors.New("platform unreachable") }
+	codes["2"] = []int{401}
+	err = enqueue(queueEnergy, "2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := queuedEntries()
+	if len(entries) != 1 || entries[0].Attempts != 0 {
+		t.Fatalf("call refused with an expired token dropped: %+v", entries)
+	}
+	drainQueue()
+	if len(sent) != 2 || queueDepth() != 0 {
+		t.Fatalf("call refused with an expired token not sent: %v", sent)
+	}
+
+	// calls the platform can't process are dropped
+	codes["3"] = []int{400}
+	err = enqueue(queueEnergy, "3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || queueDepth() != 0 {
+		t.Fatalf("call refused by the platform not dropped: %v", sent)
+	}
+
+	// calls that fail on the platform are retried until they're refused too often
+	codes["4"] = []int{500, 500}
+	for i := 0; i < 3; i++ {
+		err = enqueue(queueEnergy, "4", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drainQueue()
+	}
+	if len(sent) != 3 || sent[2] != "4" {
+		t.Fatalf("call not retried: %v", sent)
+	}
+}
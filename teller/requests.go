@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -12,6 +14,7 @@ import (
 
 	erpc "github.com/Varunram/essentials/rpc"
 	utils "github.com/Varunram/essentials/utils"
+	consts "github.com/YaleOpenLab/opensolar/consts"
 	core "github.com/YaleOpenLab/opensolar/core"
 	opensolar "github.com/YaleOpenLab/opensolar/core"
 	rpc "github.com/YaleOpenLab/opensolar/rpc"
@@ -152,13 +155,47 @@ func login(username string, pwhash string) error {
 	return nil
 }
 
-// ProjectPayback pays back to the platform
+// postStatus posts data to a platform route and returns a statusError if the platform doesn't respond with 200
+func postStatus(route string, postdata url.Values) error {
+	data, err := erpc.HttpsPost(client, ApiUrl+route, postdata)
+	if err != nil {
+		return err
+	}
+
+	var x erpc.StatusResponse
+	err = json.Unmarshal(data, &x)
+	if err != nil {
+		log.Println(string(data), err)
+		return err
+	}
+	if x.Code != 200 {
+		return statusError{Code: x.Code}
+	}
+	return nil
+}
+
+// paybackKey returns the idempotency key of the payback for the current payback period, so a payback retried
+// or queued twice in the same period is only made once
+func paybackKey() string {
+	period := int64(time.Duration(LocalProject.PaybackPeriod) * consts.OneWeekInSecond / time.Second)
+	if period <= 0 {
+		period = int64(consts.OneWeekInSecond / time.Second)
+	}
+	return "payback-" + strconv.Itoa(LocalProject.Index) + "-" + strconv.FormatInt(utils.Unix()/period, 10)
+}
+
+// ProjectPayback queues a payback to the platform
 func projectPayback(assetName string, amountx float64) error {
 	amount, err := utils.ToString(amountx)
 	if err != nil {
 		return err
 	}
 
+	return enqueue(queuePayback, paybackKey(), map[string]string{"assetName": assetName, "amount": amount})
+}
+
+// sendPayback pays back to the platform
+func sendPayback(assetName string, amount string, key string) error {
 	projIndex, err := utils.ToString(LocalProject.Index)
 	if err != nil {
 		return err
@@ -169,29 +206,23 @@ func projectPayback(assetName string, amountx float64) error {
 	postdata.Set("assetName", assetName)
 	postdata.Set("seedpwd", LocalSeedPwd)
 	postdata.Set("amount", amount)
+	postdata.Set("idempotencykey", key)
 
-	data, err := erpc.HttpsPost(client, ApiUrl+rpc.RecpRPC[4][0], postdata)
+	err = postStatus(rpc.RecpRPC[4][0], postdata)
 	if err != nil {
 		return err
 	}
-
-	var x erpc.StatusResponse
-	err = json.Unmarshal(data, &x)
-	if err != nil {
-		log.Println(string(data), err)
-		return err
-	}
-	log.Println("PAYBACK RESPONSE: ", x)
-	if x.Code == 200 {
-		colorOutput("PAID!", GreenColor)
-		return nil
-	}
-	return errors.New("Errored out")
+	colorOutput("PAID!", GreenColor)
+	return nil
 }
 
-// SetDeviceId sets the device id of the teller
-func setDeviceId(username string, deviceId string) error {
-	data, err := httpsGet(rpc.RecpRPC[5], "&deviceid="+deviceId)
+// SetDeviceId sets the device id of the teller and registers the public key it signs readings with
+func setDeviceId(deviceId string, pubkey string) error {
+	postdata := basePostData()
+	postdata.Set("deviceId", deviceId)
+	postdata.Set("pubkey", pubkey)
+
+	data, err := erpc.HttpsPost(client, ApiUrl+rpc.RecpRPC[5][0], postdata)
 	if err != nil {
 		return err
 	}
@@ -202,7 +233,7 @@ func setDeviceId(username string, deviceId string) error {
 		return err
 	}
 	if x.Code == 200 {
-		colorOutput("PAID!", GreenColor)
+		colorOutput("REGISTERED DEVICE!", GreenColor)
 		return nil
 	}
 	return errors.New("Errored out, didn't receive 200")
@@ -364,29 +395,22 @@ func sendDevicePaybackFailedEmail() error {
 	return errors.New("Errored out, didn't receive 200")
 }
 
-// storeStateHistory stores state history in the data file
+// storeStateHistory queues a state hash to be stored on the platform
 func storeStateHistory(hash string) error {
+	return enqueue(queueStateHistory, "state-"+hash, map[string]string{"hash": hash})
+}
+
+// sendStateHistory stores a state hash on the platform
+func sendStateHistory(hash string) error {
 	postdata := basePostData()
 	postdata.Set("hash", hash)
 
-	data, err := erpc.HttpsPost(client, ApiUrl+rpc.RecpRPC[16][0], postdata)
+	err := postStatus(rpc.RecpRPC[16][0], postdata)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
-
-	var x erpc.StatusResponse
-	err = json.Unmarshal(data, &x)
-	if err != nil {
-		log.Println(string(data), err)
-		return err
-	}
-
-	if x.Code == 200 {
-		colorOutput("SENT FAILED PAYBACK EMAIL", RedColor)
-		return nil
-	}
-	return errors.New("Errored out, didn't receive 200")
+	colorOutput("STORED STATE HASH", GreenColor)
+	return nil
 }
 
 // testSwytch tests whether the swytch workflow works correctly
@@ -608,21 +632,37 @@ func getIpfsData(hash string) (string, error) {
 	return string(data), err
 }
 
-func putEnergy(energyx uint32) ([]byte, error) {
+// putEnergy queues the energy consumed since the last report to be reported to the platform in a batch signed
+// with the teller's device key. The batch is signed when it is queued so the readings in it keep their order
+func putEnergy(energyx uint32) error {
+	seq, err := nextSeq()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 
-	energy, err := utils.ToString(energyx)
+	batch := core.TellerBatch{DeviceID: DeviceId, ProjIndex: LocalProject.Index, Seq: seq,
+		Timestamp: utils.Unix(), Energy: energyx}
+	payload, signature, err := core.SignBatch(DeviceKey, batch)
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return err
 	}
 
+	key := "energy-" + DeviceId + "-" + strconv.FormatUint(seq, 10)
+	return enqueue(queueEnergy, key, map[string]string{"batch": string(payload), "signature": signature})
+}
+
+// sendEnergy reports a signed batch of readings to the platform
+func sendEnergy(payload string, signature string) error {
 	postdata := basePostData()
-	postdata.Set("energy", energy)
+	postdata.Set("batch", payload)
+	postdata.Set("signature", signature)
 
-	data, err := erpc.HttpsPost(client, ApiUrl+rpc.RecpRPC[23][0], postdata)
+	err := postStatus(rpc.RecpRPC[23][0], postdata)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return data, err
+	colorOutput("REPORTED ENERGY", GreenColor)
+	return nil
 }
@@ -31,9 +31,33 @@ func hashChainHeaderHandler() {
 	})
 }
 
+// QueueResponse defines the queue handler's response
+type QueueResponse struct {
+	Depth   int
+	Entries []QueueEntry
+}
+
+// queueHandler returns the calls to the platform waiting to be sent so the teller's connectivity can be
+// monitored from outside
+func queueHandler() {
+	http.HandleFunc("/queue", func(w http.ResponseWriter, r *http.Request) {
+		err := erpc.CheckGet(w, r)
+		if err != nil {
+			log.Println(err)
+			erpc.ResponseHandler(w, erpc.StatusBadRequest)
+			return
+		}
+		var x QueueResponse
+		x.Entries = queuedEntries()
+		x.Depth = len(x.Entries)
+		erpc.MarshalSend(w, x)
+	})
+}
+
 func setupRoutes() {
 	erpc.SetupDefaultHandler()
 	hashChainHeaderHandler()
+	queueHandler()
 }
 
 // curl https://localhost/ping --insecure {"Code":200,"Status":""}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"log"
 	"net/http"
 	"os"
@@ -55,6 +56,8 @@ var (
 	// STATE variables
 	// DeviceId contains the device's id
 	DeviceId string
+	// DeviceKey is the key the teller signs the readings it reports to the platform with
+	DeviceKey ed25519.PrivateKey
 	// DeviceLocation contains the device's location
 	DeviceLocation string
 	// DeviceInfo contains information on the user's device
@@ -103,6 +106,7 @@ func autoComplete() readline.AutoCompleter {
 				readline.PcItem("asset"),
 			),
 			readline.PcItem("info"),
+			readline.PcItem("queue"),
 		),
 	)
 }
@@ -259,6 +263,7 @@ func main() {
 	// run goroutines in the background to routinely check for payback, state updates and stuff
 	go checkPayback()
 	go updateState(true)
+	go drainRoutine()
 	// go storeDataLocal() archived, this works only with particle io
 
 	if opts.Daemon {